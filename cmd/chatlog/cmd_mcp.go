@@ -0,0 +1,65 @@
+package chatlog
+
+import (
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+
+	"github.com/sjzar/chatlog/internal/chatlog"
+)
+
+func init() {
+	rootCmd.AddCommand(mcpCmd)
+	mcpCmd.PersistentPreRun = initLog
+	mcpCmd.PersistentFlags().BoolVar(&Debug, "debug", false, "debug")
+	mcpCmd.Flags().StringVarP(&mcpPlatform, "platform", "p", "", "platform")
+	mcpCmd.Flags().IntVarP(&mcpVer, "version", "v", 0, "version")
+	mcpCmd.Flags().StringVarP(&mcpDataDir, "data-dir", "d", "", "data dir")
+	mcpCmd.Flags().StringVarP(&mcpImgKey, "img-key", "i", "", "img key")
+	mcpCmd.Flags().StringVarP(&mcpWorkDir, "work-dir", "w", "", "work dir")
+}
+
+var (
+	mcpDataDir  string
+	mcpImgKey   string
+	mcpWorkDir  string
+	mcpPlatform string
+	mcpVer      int
+)
+
+var mcpCmd = &cobra.Command{
+	Use:   "mcp",
+	Short: "Start MCP server over stdio",
+	Long: `Start an MCP server on stdin/stdout for clients that launch MCP servers as subprocesses.
+The decrypted work dir is opened directly, no HTTP server is started.`,
+	Example: `chatlog mcp --work-dir "D:\chatlog\wxid_xxx" --data-dir "E:\xwechat_files\wxid_xxx" --platform windows --version 4`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmdConf := getMCPConfig()
+		log.Info().Msgf("mcp cmd config: %+v", cmdConf)
+
+		m := chatlog.New()
+		if err := m.CommandMCPServer("", cmdConf); err != nil {
+			log.Err(err).Msg("failed to start mcp server")
+			return
+		}
+	},
+}
+
+func getMCPConfig() map[string]any {
+	cmdConf := make(map[string]any)
+	if len(mcpDataDir) != 0 {
+		cmdConf["data_dir"] = mcpDataDir
+	}
+	if len(mcpImgKey) != 0 {
+		cmdConf["img_key"] = mcpImgKey
+	}
+	if len(mcpWorkDir) != 0 {
+		cmdConf["work_dir"] = mcpWorkDir
+	}
+	if len(mcpPlatform) != 0 {
+		cmdConf["platform"] = mcpPlatform
+	}
+	if mcpVer != 0 {
+		cmdConf["version"] = mcpVer
+	}
+	return cmdConf
+}
@@ -0,0 +1,193 @@
+package chatlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+
+	"github.com/sjzar/chatlog/internal/chatlog"
+	"github.com/sjzar/chatlog/internal/chatlog/export"
+	"github.com/sjzar/chatlog/pkg/util"
+	"github.com/sjzar/chatlog/pkg/util/silk"
+)
+
+func init() {
+	rootCmd.AddCommand(mediaCmd)
+	mediaCmd.AddCommand(mediaExportCmd)
+	mediaCmd.AddCommand(mediaReportCmd)
+	mediaCmd.AddCommand(mediaStoreCmd)
+	mediaCmd.PersistentPreRun = initLog
+	mediaCmd.PersistentFlags().BoolVar(&Debug, "debug", false, "debug")
+	mediaCmd.PersistentFlags().StringVarP(&mediaPlatform, "platform", "p", "", "platform")
+	mediaCmd.PersistentFlags().IntVarP(&mediaVer, "version", "v", 0, "version")
+	mediaCmd.PersistentFlags().StringVarP(&mediaWorkDir, "work-dir", "w", "", "work dir")
+	mediaCmd.PersistentFlags().StringVarP(&mediaDataDir, "data-dir", "d", "", "data dir")
+	mediaCmd.PersistentFlags().StringVar(&mediaImgKey, "img-key", "", "image key")
+	mediaCmd.PersistentFlags().StringVarP(&mediaTalker, "talker", "t", "", "会话 ID，多个以英文逗号分隔，默认为全部会话")
+	mediaCmd.PersistentFlags().StringVar(&mediaTime, "time", "", "时间范围，如 2024 或 2024-01-01~2024-06-30，默认为全部时间")
+	mediaCmd.PersistentFlags().StringVar(&mediaKinds, "kind", "", "媒体类型 (image/video/file/voice)，多个以英文逗号分隔，默认为全部")
+	mediaExportCmd.Flags().StringVarP(&mediaOutput, "output", "o", "", "导出目录")
+	mediaExportCmd.Flags().StringVar(&mediaVoiceFormat, "voice-format", silk.FormatMP3, "语音格式 (mp3/wav/ogg/silk)")
+	mediaExportCmd.MarkFlagRequired("output")
+	mediaReportCmd.Flags().StringVarP(&mediaReportOutput, "output", "o", "", "输出文件，默认输出到标准输出")
+	mediaReportCmd.Flags().StringVarP(&mediaFormat, "format", "f", "text", "输出格式 (text/json/csv)")
+	mediaReportCmd.Flags().BoolVar(&mediaDetail, "detail", false, "列出全部无法解码与缺失的媒体")
+	mediaStoreCmd.Flags().BoolVar(&mediaGC, "gc", false, "写入后删除没有被引用的文件")
+}
+
+var (
+	mediaWorkDir     string
+	mediaDataDir     string
+	mediaImgKey      string
+	mediaPlatform    string
+	mediaVer         int
+	mediaTalker      string
+	mediaTime        string
+	mediaOutput      string
+	mediaKinds       string
+	mediaVoiceFormat string
+
+	mediaReportOutput string
+	mediaFormat       string
+	mediaDetail       bool
+
+	mediaGC bool
+)
+
+var mediaCmd = &cobra.Command{
+	Use:   "media",
+	Short: "Media tools",
+}
+
+var mediaExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export media of messages into an organized directory",
+	Long: `Export images, videos, files and voices of a talker or time range into <output>/<talker>/<yyyy-mm>/,
+named by time, sender and original filename. Images are decrypted, voices are transcoded, videos and files
+are copied. A manifest.csv maps every message ID to its exported file and lists missing and expired media.`,
+	Example: `chatlog media export --work-dir "D:\chatlog\wxid_xxx" --data-dir "D:\xwechat_files\wxid_xxx" --talker 123456789@chatroom --time 2024 -o D:\export`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmdConf := mediaCmdConf()
+
+		kinds, ok := mediaKindList()
+		if !ok {
+			return
+		}
+		switch mediaVoiceFormat {
+		case silk.FormatMP3, silk.FormatWAV, silk.FormatOGG, silk.FormatSILK:
+		default:
+			log.Error().Msgf("invalid voice format: %s", mediaVoiceFormat)
+			return
+		}
+
+		m := chatlog.New()
+		summary, err := m.CommandMediaExport("", cmdConf, mediaTime, export.Options{
+			Talker:      mediaTalker,
+			Kinds:       kinds,
+			OutDir:      mediaOutput,
+			VoiceFormat: mediaVoiceFormat,
+		})
+		if err != nil {
+			log.Err(err).Msg("failed to export media")
+			return
+		}
+		b, _ := json.MarshalIndent(summary, "", "  ")
+		fmt.Println(string(b))
+	},
+}
+
+var mediaReportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Report which media of messages are available locally",
+	Long: `Count image, video, file and voice messages per talker and classify each as decodable,
+present but undecodable (wrong key or broken format) or missing (never downloaded or cleaned by WeChat),
+so you know what an archive actually contains before deleting the source.`,
+	Example: `chatlog media report --work-dir "D:\chatlog\wxid_xxx" --data-dir "D:\xwechat_files\wxid_xxx" --time 2024 --format csv -o media.csv`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmdConf := mediaCmdConf()
+
+		kinds, ok := mediaKindList()
+		if !ok {
+			return
+		}
+
+		var w io.Writer = os.Stdout
+		if len(mediaReportOutput) != 0 {
+			f, err := os.Create(mediaReportOutput)
+			if err != nil {
+				log.Err(err).Msg("failed to create output file")
+				return
+			}
+			defer f.Close()
+			w = f
+		}
+
+		m := chatlog.New()
+		opts := export.Options{Talker: mediaTalker, Kinds: kinds}
+		if err := m.CommandMediaReport("", cmdConf, mediaTime, opts, mediaDetail, mediaFormat, w); err != nil {
+			log.Err(err).Msg("failed to generate media report")
+			return
+		}
+	},
+}
+
+var mediaStoreCmd = &cobra.Command{
+	Use:   "store",
+	Short: "Store decoded media into a content-addressed store in the work dir",
+	Long: `Decode images, videos and files of a talker or time range into <work-dir>/media/, keyed by the sha256
+of the decoded content. Media forwarded across talkers is written once and referenced from every message,
+so the store can answer where else a file was shared. Unchanged sources are not decoded again.
+Enable media_store in the config to let the HTTP server save decoded images into the same store.`,
+	Example: `chatlog media store --work-dir "D:\chatlog\wxid_xxx" --data-dir "D:\xwechat_files\wxid_xxx" --time 2024 --gc`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmdConf := mediaCmdConf()
+
+		m := chatlog.New()
+		ret, gc, err := m.CommandMediaStore("", cmdConf, mediaTime, export.Options{Talker: mediaTalker}, mediaGC)
+		if err != nil {
+			log.Err(err).Msg("failed to store media")
+			return
+		}
+		b, _ := json.MarshalIndent(map[string]any{"store": ret, "gc": gc}, "", "  ")
+		fmt.Println(string(b))
+	},
+}
+
+func mediaKindList() ([]string, bool) {
+	kinds := util.Str2List(strings.ToLower(mediaKinds), ",")
+	for _, kind := range kinds {
+		switch kind {
+		case export.KindImage, export.KindVideo, export.KindFile, export.KindVoice:
+		default:
+			log.Error().Msgf("invalid kind: %s", kind)
+			return nil, false
+		}
+	}
+	return kinds, true
+}
+
+func mediaCmdConf() map[string]any {
+	cmdConf := make(map[string]any)
+	if len(mediaWorkDir) != 0 {
+		cmdConf["work_dir"] = mediaWorkDir
+	}
+	if len(mediaDataDir) != 0 {
+		cmdConf["data_dir"] = mediaDataDir
+	}
+	if len(mediaImgKey) != 0 {
+		cmdConf["img_key"] = mediaImgKey
+	}
+	if len(mediaPlatform) != 0 {
+		cmdConf["platform"] = mediaPlatform
+	}
+	if mediaVer != 0 {
+		cmdConf["version"] = mediaVer
+	}
+	log.Info().Msgf("media cmd config: %+v", cmdConf)
+	return cmdConf
+}
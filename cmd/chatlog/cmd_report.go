@@ -0,0 +1,72 @@
+package chatlog
+
+import (
+	"io"
+	"os"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+
+	"github.com/sjzar/chatlog/internal/chatlog"
+)
+
+func init() {
+	rootCmd.AddCommand(reportCmd)
+	reportCmd.PersistentPreRun = initLog
+	reportCmd.PersistentFlags().BoolVar(&Debug, "debug", false, "debug")
+	reportCmd.Flags().StringVarP(&reportPlatform, "platform", "p", "", "platform")
+	reportCmd.Flags().IntVarP(&reportVer, "version", "v", 0, "version")
+	reportCmd.Flags().StringVarP(&reportWorkDir, "work-dir", "w", "", "work dir")
+	reportCmd.Flags().StringVarP(&reportTime, "time", "t", "", "时间范围，如 2024 或 2024-01-01~2024-06-30，默认为今年")
+	reportCmd.Flags().IntVar(&reportTop, "top", 0, "排行榜条目数")
+	reportCmd.Flags().StringVarP(&reportFormat, "format", "f", "json", "输出格式 (json/html)")
+	reportCmd.Flags().StringVarP(&reportOutput, "output", "o", "", "输出文件，默认输出到标准输出")
+}
+
+var (
+	reportWorkDir  string
+	reportPlatform string
+	reportVer      int
+	reportTime     string
+	reportTop      int
+	reportFormat   string
+	reportOutput   string
+)
+
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Generate a year in review report",
+	Long: `Generate an account-wide review report (top contacts and groups, busiest days, late-night activity,
+shared links and files, transfers and red envelopes, new contacts and groups) from the decrypted work dir.`,
+	Example: `chatlog report --work-dir "D:\chatlog\wxid_xxx" --time 2024 --format html -o report.html`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmdConf := make(map[string]any)
+		if len(reportWorkDir) != 0 {
+			cmdConf["work_dir"] = reportWorkDir
+		}
+		if len(reportPlatform) != 0 {
+			cmdConf["platform"] = reportPlatform
+		}
+		if reportVer != 0 {
+			cmdConf["version"] = reportVer
+		}
+		log.Info().Msgf("report cmd config: %+v", cmdConf)
+
+		var w io.Writer = os.Stdout
+		if len(reportOutput) != 0 {
+			f, err := os.Create(reportOutput)
+			if err != nil {
+				log.Err(err).Msg("failed to create output file")
+				return
+			}
+			defer f.Close()
+			w = f
+		}
+
+		m := chatlog.New()
+		if err := m.CommandReport("", cmdConf, reportTime, reportTop, reportFormat, w); err != nil {
+			log.Err(err).Msg("failed to generate report")
+			return
+		}
+	},
+}
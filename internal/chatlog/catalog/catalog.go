@@ -0,0 +1,430 @@
+package catalog
+
+import (
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
+	"github.com/sjzar/chatlog/internal/wechatdb"
+	"github.com/sjzar/chatlog/pkg/util"
+)
+
+// 链接类型
+const (
+	KindLink        = "link"
+	KindMiniProgram = "miniprogram"
+	KindChannel     = "channel"
+)
+
+// Source 构建目录所需的数据接口，由 database.Service 实现
+type Source interface {
+	GetSessions(key string, limit, offset int) (*wechatdb.GetSessionsResp, error)
+	GetMessagesByType(start, end time.Time, talker string, types ...int64) ([]*model.Message, error)
+	GetMedia(_type string, key string) (*model.Media, error)
+}
+
+// Item 目录条目的公共字段，定位到具体的消息
+type Item struct {
+	Seq        int64     `json:"seq"`
+	Time       time.Time `json:"time"`
+	Talker     string    `json:"talker"`
+	TalkerName string    `json:"talkerName,omitempty"`
+	Sender     string    `json:"sender"`
+	SenderName string    `json:"senderName,omitempty"`
+	IsSelf     bool      `json:"isSelf,omitempty"`
+}
+
+// Link 分享的链接、小程序或视频号卡片
+type Link struct {
+	Item
+	Kind   string `json:"kind"`
+	URL    string `json:"url"`
+	Title  string `json:"title"`
+	Desc   string `json:"desc,omitempty"`
+	Domain string `json:"domain,omitempty"`
+}
+
+// File 分享的文件，Path 为 hardlink 数据库中记录的本地相对路径，未下载的文件为空
+type File struct {
+	Item
+	Name string `json:"name"`
+	Ext  string `json:"ext,omitempty"`
+	MD5  string `json:"md5,omitempty"`
+	Size int64  `json:"size,omitempty"`
+	Path string `json:"path,omitempty"`
+
+	// URL 通过 /file/*key 下载文件的地址，需要 host 时才会填充
+	URL string `json:"url,omitempty"`
+}
+
+// Query 目录查询条件，Talker 与 Sender 多个以英文逗号分隔，可以是 ID 或名称
+type Query struct {
+	Start  time.Time
+	End    time.Time
+	Talker string
+	Sender string
+
+	// Keyword 为正则表达式，链接匹配标题、描述与地址，文件匹配文件名
+	Keyword string
+
+	// Domain 仅对链接生效，匹配域名本身及其子域名
+	Domain string
+
+	// Kind 仅对链接生效，为 link/miniprogram/channel
+	Kind string
+
+	// Ext 仅对文件生效，多个以英文逗号分隔，不区分大小写，可带或不带 "."
+	Ext string
+
+	Limit  int
+	Offset int
+}
+
+// Catalog 全部会话中分享的链接与文件索引
+// 首次查询时全量构建，之后在消息数据库变更时只扫描有新消息的会话
+type Catalog struct {
+	src Source
+
+	// refreshMu 保证同一时间只有一次更新，扫描期间不持有 mutex，查询继续使用旧索引
+	refreshMu sync.Mutex
+
+	mutex   sync.Mutex
+	links   []*Link
+	files   []*File
+	seen    map[string]bool
+	built   bool
+	dirty   bool
+	indexed time.Time
+	gen     int
+
+	// failed 上次更新中读取失败的会话及其扫描起点，下次更新时从该起点重新扫描
+	failed map[string]time.Time
+}
+
+func New(src Source) *Catalog {
+	return &Catalog{
+		src:    src,
+		seen:   make(map[string]bool),
+		failed: make(map[string]time.Time),
+	}
+}
+
+// Invalidate 标记索引需要更新，在消息数据库变更时调用
+func (c *Catalog) Invalidate() {
+	c.mutex.Lock()
+	c.dirty = true
+	c.mutex.Unlock()
+}
+
+// Reset 清空索引，下次查询时全量重建，在数据库重启或切换账号时调用
+// 正在进行的更新结果会被丢弃
+func (c *Catalog) Reset() {
+	c.mutex.Lock()
+	c.links, c.files = nil, nil
+	c.seen = make(map[string]bool)
+	c.failed = make(map[string]time.Time)
+	c.built, c.dirty = false, false
+	c.indexed = time.Time{}
+	c.gen++
+	c.mutex.Unlock()
+}
+
+// Links 查询链接，返回当前页与符合条件的总数，按时间倒序
+func (c *Catalog) Links(q Query) ([]*Link, int, error) {
+	if err := c.refresh(); err != nil {
+		return nil, 0, err
+	}
+	m, err := newMatcher(q)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	c.mutex.Lock()
+	ret := make([]*Link, 0)
+	for i := len(c.links) - 1; i >= 0; i-- {
+		link := c.links[i]
+		if !m.item(&link.Item) || !m.link(link) {
+			continue
+		}
+		ret = append(ret, link)
+	}
+	c.mutex.Unlock()
+
+	total := len(ret)
+	return page(ret, q.Limit, q.Offset), total, nil
+}
+
+// Files 查询文件，返回当前页与符合条件的总数，按时间倒序
+// 当前页的文件会通过 hardlink 数据库补充本地路径
+func (c *Catalog) Files(q Query) ([]*File, int, error) {
+	if err := c.refresh(); err != nil {
+		return nil, 0, err
+	}
+	m, err := newMatcher(q)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	c.mutex.Lock()
+	ret := make([]*File, 0)
+	for i := len(c.files) - 1; i >= 0; i-- {
+		file := c.files[i]
+		if !m.item(&file.Item) || !m.file(file) {
+			continue
+		}
+		ret = append(ret, file)
+	}
+	c.mutex.Unlock()
+
+	total := len(ret)
+	ret = page(ret, q.Limit, q.Offset)
+	for i, file := range ret {
+		f := *file
+		if f.Path == "" && f.MD5 != "" {
+			if media, err := c.src.GetMedia("file", f.MD5); err == nil {
+				f.Path = media.Path
+				if f.Size == 0 {
+					f.Size = media.Size
+				}
+			}
+		}
+		ret[i] = &f
+	}
+	return ret, total, nil
+}
+
+func (c *Catalog) refresh() error {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	c.mutex.Lock()
+	if c.built && !c.dirty {
+		c.mutex.Unlock()
+		return nil
+	}
+	// 扫描期间的变更会重新标记 dirty，由下一次查询继续更新
+	built, start, gen := c.built, c.indexed, c.gen
+	idx := &index{seen: c.seen}
+	retry := c.failed
+	c.dirty = false
+	c.mutex.Unlock()
+
+	end := time.Now()
+	sessions, err := c.src.GetSessions("", 0, 0)
+	if err != nil {
+		c.Invalidate()
+		return err
+	}
+	failed := make(map[string]time.Time)
+	for _, session := range sessions.Items {
+		if session.UserName == "" {
+			continue
+		}
+		from, ok := retry[session.UserName]
+		if !ok {
+			if built && session.NTime.Before(start) {
+				continue
+			}
+			from = start
+		}
+		messages, err := c.src.GetMessagesByType(from, end, session.UserName, model.MessageTypeShare)
+		if err != nil {
+			log.Debug().Err(err).Msgf("get messages failed: %s", session.UserName)
+			failed[session.UserName] = from
+			continue
+		}
+		for _, msg := range messages {
+			idx.add(msg)
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if gen != c.gen {
+		return nil
+	}
+	c.links = append(c.links, idx.links...)
+	c.files = append(c.files, idx.files...)
+	sort.SliceStable(c.links, func(i, j int) bool { return c.links[i].Time.Before(c.links[j].Time) })
+	sort.SliceStable(c.files, func(i, j int) bool { return c.files[i].Time.Before(c.files[j].Time) })
+	// 相邻两次更新之间的消息可能在 end 之后才写入，保留一分钟的重叠，由 seen 去重
+	c.indexed = end.Add(-time.Minute)
+	c.built = true
+	// 读取失败的会话不随 indexed 前进，保持 dirty 由下一次查询重试
+	c.failed = failed
+	if len(failed) > 0 {
+		c.dirty = true
+	}
+	return nil
+}
+
+// index 一次更新中新增的条目，seen 只在持有 refreshMu 时访问
+type index struct {
+	links []*Link
+	files []*File
+	seen  map[string]bool
+}
+
+func (c *index) add(msg *model.Message) {
+	key := msg.Talker + ":" + strconv.FormatInt(msg.Seq, 10)
+	if c.seen[key] {
+		return
+	}
+
+	item := Item{
+		Seq:        msg.Seq,
+		Time:       msg.Time,
+		Talker:     msg.Talker,
+		TalkerName: msg.TalkerName,
+		Sender:     msg.Sender,
+		SenderName: msg.SenderName,
+		IsSelf:     msg.IsSelf,
+	}
+	title, _ := msg.Contents["title"].(string)
+	desc, _ := msg.Contents["desc"].(string)
+	link, _ := msg.Contents["url"].(string)
+
+	switch msg.SubType {
+	case model.MessageSubTypeLink, model.MessageSubTypeLink2:
+		c.addLink(item, KindLink, link, title, desc)
+	case model.MessageSubTypeMiniProgram, model.MessageSubTypeMiniProgram2:
+		c.addLink(item, KindMiniProgram, link, title, desc)
+	case model.MessageSubTypeChannel, model.MessageSubTypeChannelLive:
+		c.addLink(item, KindChannel, link, title, desc)
+	case model.MessageSubTypeFile:
+		file := &File{Item: item, Name: title}
+		file.MD5, _ = msg.Contents["md5"].(string)
+		file.Size, _ = msg.Contents["size"].(int64)
+		file.Ext, _ = msg.Contents["ext"].(string)
+		if file.Ext == "" {
+			file.Ext = strings.TrimPrefix(filepath.Ext(title), ".")
+		}
+		file.Ext = strings.ToLower(file.Ext)
+		c.files = append(c.files, file)
+	default:
+		return
+	}
+	c.seen[key] = true
+}
+
+func (c *index) addLink(item Item, kind, link, title, desc string) {
+	if link == "" && title == "" {
+		return
+	}
+	l := &Link{Item: item, Kind: kind, URL: link, Title: title, Desc: desc}
+	if u, err := url.Parse(link); err == nil && u.Host != "" {
+		l.Domain = strings.ToLower(u.Hostname())
+	}
+	c.links = append(c.links, l)
+}
+
+type matcher struct {
+	q       Query
+	talkers []string
+	senders []string
+	exts    map[string]bool
+	keyword *regexp.Regexp
+	domain  string
+}
+
+func newMatcher(q Query) (*matcher, error) {
+	m := &matcher{
+		q:       q,
+		talkers: util.Str2List(q.Talker, ","),
+		senders: util.Str2List(q.Sender, ","),
+		domain:  strings.TrimPrefix(strings.ToLower(q.Domain), "."),
+	}
+	if q.Keyword != "" {
+		re, err := regexp.Compile(q.Keyword)
+		if err != nil {
+			return nil, errors.InvalidArg("keyword")
+		}
+		m.keyword = re
+	}
+	if exts := util.Str2List(q.Ext, ","); len(exts) > 0 {
+		m.exts = make(map[string]bool, len(exts))
+		for _, ext := range exts {
+			m.exts[strings.TrimPrefix(strings.ToLower(ext), ".")] = true
+		}
+	}
+	return m, nil
+}
+
+func (m *matcher) item(item *Item) bool {
+	if !m.q.Start.IsZero() && item.Time.Before(m.q.Start) {
+		return false
+	}
+	if !m.q.End.IsZero() && item.Time.After(m.q.End) {
+		return false
+	}
+	if len(m.talkers) > 0 && !matchAny(m.talkers, item.Talker, item.TalkerName) {
+		return false
+	}
+	if len(m.senders) > 0 && !matchAny(m.senders, item.Sender, item.SenderName) {
+		return false
+	}
+	return true
+}
+
+func (m *matcher) link(link *Link) bool {
+	if m.q.Kind != "" && link.Kind != m.q.Kind {
+		return false
+	}
+	if m.domain != "" && link.Domain != m.domain && !strings.HasSuffix(link.Domain, "."+m.domain) {
+		return false
+	}
+	if m.keyword != nil && !matchRegexp(m.keyword, link.Title, link.Desc, link.URL) {
+		return false
+	}
+	return true
+}
+
+func (m *matcher) file(file *File) bool {
+	if m.exts != nil && !m.exts[file.Ext] {
+		return false
+	}
+	if m.keyword != nil && !matchRegexp(m.keyword, file.Name) {
+		return false
+	}
+	return true
+}
+
+func matchAny(list []string, values ...string) bool {
+	for _, v := range list {
+		for _, value := range values {
+			if value != "" && v == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchRegexp(re *regexp.Regexp, values ...string) bool {
+	for _, value := range values {
+		if re.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func page[T any](items []T, limit, offset int) []T {
+	if offset >= len(items) {
+		return items[:0]
+	}
+	items = items[offset:]
+	if limit > 0 && limit < len(items) {
+		items = items[:limit]
+	}
+	return items
+}
@@ -0,0 +1,19 @@
+package conf
+
+// OCR 图片文字识别配置，Provider 为空时不启用
+type OCR struct {
+	// Provider 识别引擎，目前支持 tesseract
+	Provider string `mapstructure:"provider" json:"provider"`
+
+	// Bin 引擎可执行文件，默认为 tesseract
+	Bin string `mapstructure:"bin" json:"bin"`
+
+	// Language 识别语言，默认 chi_sim+eng
+	Language string `mapstructure:"language" json:"language"`
+
+	// TimeoutSec 单张图片的识别超时，默认 60 秒
+	TimeoutSec int `mapstructure:"timeout_sec" json:"timeout_sec"`
+
+	// Auto 数据库启动后在后台识别全部图片消息，已识别的图片会跳过
+	Auto bool `mapstructure:"auto" json:"auto"`
+}
@@ -19,6 +19,9 @@ type ServerConfig struct {
 	AutoDecryptDebounce int     `mapstructure:"auto_decrypt_debounce"`
 	SaveDecryptedMedia bool     `mapstructure:"save_decrypted_media"`
 	Webhook            *Webhook `mapstructure:"webhook"`
+	Transcribe         *Transcribe `mapstructure:"transcribe"`
+	OCR                *OCR        `mapstructure:"ocr"`
+	MediaStore         bool        `mapstructure:"media_store"`
 }
 
 var ServerDefaults = map[string]any{
@@ -72,6 +75,18 @@ func (c *ServerConfig) GetWebhook() *Webhook {
 	return c.Webhook
 }
 
+func (c *ServerConfig) GetTranscribe() *Transcribe {
+	return c.Transcribe
+}
+
+func (c *ServerConfig) GetOCR() *OCR {
+	return c.OCR
+}
+
 func (c *ServerConfig) GetSaveDecryptedMedia() bool {
 	return c.SaveDecryptedMedia
 }
+
+func (c *ServerConfig) GetMediaStore() bool {
+	return c.MediaStore
+}
@@ -0,0 +1,22 @@
+package conf
+
+// Transcribe 离线语音转写配置，Provider 为空时不启用
+type Transcribe struct {
+	// Provider 转写引擎：whisper（whisper.cpp）或 vosk
+	Provider string `mapstructure:"provider" json:"provider"`
+
+	// Bin 引擎可执行文件，默认 whisper 为 whisper-cli，vosk 为 vosk-transcriber
+	Bin string `mapstructure:"bin" json:"bin"`
+
+	// Model whisper 的模型文件或 vosk 的模型目录
+	Model string `mapstructure:"model" json:"model"`
+
+	// Language 识别语言，默认 zh
+	Language string `mapstructure:"language" json:"language"`
+
+	// Threads CPU 线程数，为 0 时由引擎决定
+	Threads int `mapstructure:"threads" json:"threads"`
+
+	// TimeoutSec 单条语音的转写超时，默认 120 秒
+	TimeoutSec int `mapstructure:"timeout_sec" json:"timeout_sec"`
+}
@@ -5,6 +5,9 @@ type TUIConfig struct {
 	LastAccount string          `mapstructure:"last_account" json:"last_account"`
 	History     []ProcessConfig `mapstructure:"history" json:"history"`
 	Webhook     *Webhook        `mapstructure:"webhook" json:"webhook"`
+	Transcribe  *Transcribe     `mapstructure:"transcribe" json:"transcribe"`
+	OCR         *OCR            `mapstructure:"ocr" json:"ocr"`
+	MediaStore  bool            `mapstructure:"media_store" json:"media_store"`
 }
 
 var TUIDefaults = map[string]any{}
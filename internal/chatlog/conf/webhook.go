@@ -13,4 +13,7 @@ type WebhookItem struct {
 	Sender   string `mapstructure:"sender"`
 	Keyword  string `mapstructure:"keyword"`
 	Disabled bool   `mapstructure:"disabled"`
+
+	// Mention 仅推送 @ 了该用户（含 @所有人）的消息，me 表示当前账号
+	Mention string `mapstructure:"mention"`
 }
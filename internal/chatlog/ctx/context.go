@@ -211,6 +211,18 @@ func (c *Context) GetWebhook() *conf.Webhook {
 	return c.conf.Webhook
 }
 
+func (c *Context) GetTranscribe() *conf.Transcribe {
+	return c.conf.Transcribe
+}
+
+func (c *Context) GetOCR() *conf.OCR {
+	return c.conf.OCR
+}
+
+func (c *Context) GetMediaStore() bool {
+	return c.conf.MediaStore
+}
+
 func (c *Context) GetSaveDecryptedMedia() bool {
 	// Default to true for now, can be made configurable later
 	return true
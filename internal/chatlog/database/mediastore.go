@@ -0,0 +1,192 @@
+package database
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/chatlog/export"
+	"github.com/sjzar/chatlog/internal/chatlog/mediastore"
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/pkg/util/silk"
+)
+
+// MediaStore 内容寻址的媒体存储，未启用或打开失败时返回 nil
+func (s *Service) MediaStore() *mediastore.Store {
+	if !s.conf.GetMediaStore() {
+		return nil
+	}
+	store, err := s.getMediaStore()
+	if err != nil {
+		log.Debug().Err(err).Msg("open media store failed")
+		return nil
+	}
+	return store
+}
+
+// StoreMedia 将时间范围内的图片、视频与文件解码后写入媒体存储，并记录引用媒体的消息
+// talker 多个以英文逗号分隔，为空时遍历全部会话，原始文件没有变化的媒体不会重复解码
+func (s *Service) StoreMedia(ctx context.Context, start, end time.Time, talker string) (*mediastore.IngestResult, error) {
+	store, err := s.getMediaStore()
+	if err != nil {
+		return nil, err
+	}
+	messages, err := export.Messages(s, start, end, talker)
+	if err != nil {
+		return nil, err
+	}
+
+	dataDir := s.conf.GetDataDir()
+	ret := &mediastore.IngestResult{}
+	for _, msg := range messages {
+		if err := ctx.Err(); err != nil {
+			return ret, err
+		}
+		// 语音保存在数据库中，不需要去重
+		item := export.Resolve(s, dataDir, msg)
+		if item == nil || item.Kind == export.KindVoice {
+			continue
+		}
+		ret.Total++
+		if item.Status != export.StatusOK {
+			ret.Missing++
+			continue
+		}
+
+		blob, created, err := storeItem(store, dataDir, item)
+		if err != nil {
+			ret.Failed++
+			log.Debug().Err(err).Msgf("store media failed: %s %d", item.Talker, item.Seq)
+			continue
+		}
+		switch hash, _ := store.Linked(item.Talker, item.Seq); {
+		case hash == blob.Hash:
+			ret.Unchanged++
+		case created:
+			ret.Stored++
+			ret.Bytes += blob.Size
+		default:
+			ret.Deduplicated++
+			ret.Saved += blob.Size
+		}
+
+		if err := store.Link(&mediastore.Ref{
+			Talker: item.Talker,
+			Seq:    item.Seq,
+			Kind:   item.Kind,
+			Key:    item.Key,
+			Name:   item.Name,
+			Hash:   blob.Hash,
+			Sender: item.Sender,
+			Time:   item.Time,
+		}); err != nil {
+			return ret, err
+		}
+	}
+	return ret, nil
+}
+
+// MediaRefs 引用同一媒体文件的全部消息，依次按 hash、媒体 key（md5）、talker 与 seq 查找媒体文件
+func (s *Service) MediaRefs(hash, key, talker string, seq int64) (*mediastore.Blob, []*mediastore.Ref, error) {
+	store, err := s.getMediaStore()
+	if err != nil {
+		return nil, nil, err
+	}
+	switch {
+	case hash != "":
+	case key != "":
+		hash, err = store.HashOf(key)
+	case talker != "" && seq > 0:
+		hash, err = store.Linked(talker, seq)
+	default:
+		return nil, nil, errors.InvalidArg("hash")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	blob, err := store.Blob(hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	if blob == nil {
+		return nil, nil, errors.ErrMediaNotFound
+	}
+	refs, err := store.Refs(blob.Hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	return blob, refs, nil
+}
+
+// MediaStoreStats 媒体存储统计
+func (s *Service) MediaStoreStats() (*mediastore.Stats, error) {
+	store, err := s.getMediaStore()
+	if err != nil {
+		return nil, err
+	}
+	return store.Stats()
+}
+
+// MediaGC 删除媒体存储中没有被引用的文件
+func (s *Service) MediaGC() (*mediastore.GCResult, error) {
+	store, err := s.getMediaStore()
+	if err != nil {
+		return nil, err
+	}
+	return store.GC()
+}
+
+// storeItem 写入单个媒体，原始文件没有变化时直接返回已有的媒体文件
+func storeItem(store *mediastore.Store, dataDir string, item *export.Item) (*mediastore.Blob, bool, error) {
+	info, err := os.Stat(filepath.Join(dataDir, item.Source))
+	if err != nil {
+		return nil, false, errors.ReadFileFailed(item.Source, err)
+	}
+	blob, err := store.Source(item.Source, info.Size(), info.ModTime().UnixNano())
+	if err != nil || blob != nil {
+		return blob, false, err
+	}
+
+	data, ext, err := item.Decode(dataDir, silk.FormatMP3)
+	if err != nil {
+		return nil, false, err
+	}
+	blob, created, err := store.Put(data, ext)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := store.SetSource(item.Source, info.Size(), info.ModTime().UnixNano(), blob.Hash); err != nil {
+		return nil, false, err
+	}
+	return blob, created, nil
+}
+
+func (s *Service) getMediaStore() (*mediastore.Store, error) {
+	if !s.conf.GetMediaStore() {
+		return nil, errors.ErrMediaStoreDisabled
+	}
+	s.mediaStoreMu.Lock()
+	defer s.mediaStoreMu.Unlock()
+	if s.mediaStore != nil {
+		return s.mediaStore, nil
+	}
+	store, err := mediastore.New(s.conf.GetWorkDir())
+	if err != nil {
+		return nil, err
+	}
+	s.mediaStore = store
+	return store, nil
+}
+
+func (s *Service) closeMediaStore() {
+	s.mediaStoreMu.Lock()
+	defer s.mediaStoreMu.Unlock()
+	if s.mediaStore != nil {
+		s.mediaStore.Close()
+		s.mediaStore = nil
+	}
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/sjzar/chatlog/internal/model"
+	"github.com/sjzar/chatlog/pkg/util"
+)
+
+// mediaTextHit 语音转写或图片识别文本命中的消息
+type mediaTextHit struct {
+	talker string
+	seq    int64
+	sender string
+}
+
+// attachMediaText 为语音消息附带转写文本、为图片消息附带识别文本
+func (s *Service) attachMediaText(messages ...*model.Message) {
+	if len(messages) == 0 {
+		return
+	}
+	s.attachTranscripts(messages...)
+	s.attachOCR(messages...)
+}
+
+// mediaTextHits 转写与识别文本中命中关键词的消息，sender 多个以英文逗号分隔
+func (s *Service) mediaTextHits(start, end time.Time, talker, sender, keyword string) []mediaTextHit {
+	hits := make([]mediaTextHit, 0)
+	if transcripts, err := s.SearchTranscripts(start, end, talker, keyword); err == nil {
+		for _, tr := range transcripts {
+			hits = append(hits, mediaTextHit{talker: tr.Talker, seq: tr.Seq, sender: tr.Sender})
+		}
+	}
+	if results, err := s.SearchOCR(start, end, talker, keyword); err == nil {
+		for _, res := range results {
+			hits = append(hits, mediaTextHit{talker: res.Talker, seq: res.Seq, sender: res.Sender})
+		}
+	}
+	senders := util.Str2List(sender, ",")
+	if len(senders) == 0 {
+		return hits
+	}
+	filtered := hits[:0]
+	for _, hit := range hits {
+		if slices.Contains(senders, hit.sender) {
+			filtered = append(filtered, hit)
+		}
+	}
+	return filtered
+}
+
+// mergeMediaTextHits 将命中的消息合并到查询结果中，按时间排序，由调用方分页
+func (s *Service) mergeMediaTextHits(messages []*model.Message, hits []mediaTextHit) []*model.Message {
+	exists := make(map[string]bool, len(messages))
+	for _, msg := range messages {
+		exists[msg.Talker+":"+strconv.FormatInt(msg.Seq, 10)] = true
+	}
+	for _, hit := range hits {
+		key := hit.talker + ":" + strconv.FormatInt(hit.seq, 10)
+		if exists[key] {
+			continue
+		}
+		msg, err := s.db.GetMessage(hit.talker, hit.seq)
+		if err != nil {
+			continue
+		}
+		exists[key] = true
+		messages = append(messages, msg)
+	}
+
+	sort.SliceStable(messages, func(i, j int) bool {
+		if !messages[i].Time.Equal(messages[j].Time) {
+			return messages[i].Time.Before(messages[j].Time)
+		}
+		return messages[i].Seq < messages[j].Seq
+	})
+	return messages
+}
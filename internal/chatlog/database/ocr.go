@@ -0,0 +1,269 @@
+package database
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/chatlog/ocr"
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
+	"github.com/sjzar/chatlog/internal/wechatdb"
+	"github.com/sjzar/chatlog/pkg/util"
+	"github.com/sjzar/chatlog/pkg/util/dat2img"
+)
+
+// RecognizeImage 识别单条图片消息中的文字，已有识别结果且 force 为 false 时直接返回结果
+func (s *Service) RecognizeImage(ctx context.Context, talker string, seq int64, force bool) (*ocr.Result, error) {
+	r, err := s.getRecognizer()
+	if err != nil {
+		return nil, err
+	}
+	msg, err := s.db.GetMessage(talker, seq)
+	if err != nil {
+		return nil, err
+	}
+	res, _, err := s.recognize(ctx, s.db, r, msg, force)
+	return res, err
+}
+
+// SearchOCR 按关键词（正则表达式）搜索图片识别文本，talker 多个以英文逗号分隔
+func (s *Service) SearchOCR(start, end time.Time, talker, keyword string) ([]*ocr.Result, error) {
+	r, err := s.getRecognizer()
+	if err != nil {
+		return nil, err
+	}
+	var re *regexp.Regexp
+	if keyword != "" {
+		if re, err = regexp.Compile(keyword); err != nil {
+			return nil, errors.InvalidArg("keyword")
+		}
+	}
+	return r.Store().Search(re, util.Str2List(talker, ","), start, end)
+}
+
+// StartOCRJob 在后台识别时间范围内的全部图片消息，同一时间只运行一个任务
+func (s *Service) StartOCRJob(start, end time.Time, talker string, force bool) (ocr.JobStatus, error) {
+	r, err := s.getRecognizer()
+	if err != nil {
+		return ocr.JobStatus{}, err
+	}
+	if !r.Enabled() {
+		return ocr.JobStatus{}, errors.ErrOCRDisabled
+	}
+
+	s.ocrJobMu.Lock()
+	defer s.ocrJobMu.Unlock()
+	if s.ocrJob.Running {
+		return s.ocrJob, errors.ErrOCRJobRunning
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.ocrJob = ocr.JobStatus{
+		Running:   true,
+		Talker:    talker,
+		Start:     start,
+		End:       end,
+		StartedAt: time.Now(),
+	}
+	s.ocrJobCancel = cancel
+	s.ocrJobWg.Add(1)
+	go func() {
+		defer s.ocrJobWg.Done()
+		s.runOCRJob(ctx, s.db, r, start, end, talker, force)
+	}()
+	return s.ocrJob, nil
+}
+
+// OCRJobStatus 当前或最近一次批量识别任务的进度
+func (s *Service) OCRJobStatus() ocr.JobStatus {
+	s.ocrJobMu.Lock()
+	defer s.ocrJobMu.Unlock()
+	return s.ocrJob
+}
+
+// StopOCRJob 取消正在运行的批量识别任务，不等待任务退出
+func (s *Service) StopOCRJob() {
+	s.ocrJobMu.Lock()
+	defer s.ocrJobMu.Unlock()
+	if s.ocrJobCancel != nil {
+		s.ocrJobCancel()
+		s.ocrJobCancel = nil
+	}
+}
+
+func (s *Service) runOCRJob(ctx context.Context, db *wechatdb.DB, r *ocr.Recognizer, start, end time.Time, talker string, force bool) {
+	update := func(f func(job *ocr.JobStatus)) {
+		s.ocrJobMu.Lock()
+		defer s.ocrJobMu.Unlock()
+		f(&s.ocrJob)
+	}
+	defer update(func(job *ocr.JobStatus) {
+		job.Running = false
+		job.FinishedAt = time.Now()
+		log.Info().Msgf("OCR 任务结束，共 %d 张图片，识别 %d 张，跳过 %d 张，失败 %d 张", job.Total, job.Done, job.Skipped, job.Failed)
+	})
+
+	messages, err := imageMessages(db, start, end, talker)
+	if err != nil {
+		update(func(job *ocr.JobStatus) { job.Error = err.Error() })
+		return
+	}
+	update(func(job *ocr.JobStatus) { job.Total = len(messages) })
+
+	for _, msg := range messages {
+		if err := ctx.Err(); err != nil {
+			update(func(job *ocr.JobStatus) { job.Error = err.Error() })
+			return
+		}
+		_, cached, err := s.recognize(ctx, db, r, msg, force)
+		update(func(job *ocr.JobStatus) {
+			switch {
+			case err != nil:
+				job.Failed++
+			case cached:
+				job.Skipped++
+			default:
+				job.Done++
+			}
+		})
+		if err != nil {
+			log.Debug().Err(err).Msgf("ocr image failed: %s %d", msg.Talker, msg.Seq)
+		}
+	}
+}
+
+// imageMessages 时间范围内的图片消息，talker 为空时遍历全部会话
+func imageMessages(db *wechatdb.DB, start, end time.Time, talker string) ([]*model.Message, error) {
+	if talker != "" {
+		return db.GetMessagesByType(start, end, talker, model.MessageTypeImage)
+	}
+	sessions, err := db.GetSessions("", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]*model.Message, 0)
+	for _, session := range sessions.Items {
+		if session.UserName == "" || session.NTime.Before(start) {
+			continue
+		}
+		list, err := db.GetMessagesByType(start, end, session.UserName, model.MessageTypeImage)
+		if err != nil {
+			log.Debug().Err(err).Msgf("get image messages failed: %s", session.UserName)
+			continue
+		}
+		messages = append(messages, list...)
+	}
+	return messages, nil
+}
+
+// recognize 识别图片消息，cached 表示使用了已有的识别结果
+func (s *Service) recognize(ctx context.Context, db *wechatdb.DB, r *ocr.Recognizer, msg *model.Message, force bool) (*ocr.Result, bool, error) {
+	if msg.Type != model.MessageTypeImage {
+		return nil, false, errors.ErrNotImageMessage
+	}
+	key := ocr.ImageKey(msg)
+	if key == "" {
+		return nil, false, errors.ErrMediaNotFound
+	}
+
+	if !force {
+		if results, err := r.Store().Get(key); err == nil {
+			if res, ok := results[key]; ok {
+				r.Store().Link(key, msg)
+				return res, true, nil
+			}
+		}
+	}
+	if !r.Enabled() {
+		return nil, false, errors.ErrOCRDisabled
+	}
+
+	image, ext, err := s.readImage(db, key)
+	if err != nil {
+		return nil, false, err
+	}
+	res, err := r.Recognize(ctx, key, image, ext)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := r.Store().Link(key, msg); err != nil {
+		log.Debug().Err(err).Msgf("link ocr result failed: %s", key)
+	}
+	return res, false, nil
+}
+
+// ReadImage 读取图片消息的图片并解密 dat 文件，返回图片数据与格式（jpg、png 等）
+func (s *Service) ReadImage(key string) ([]byte, string, error) {
+	return s.readImage(s.db, key)
+}
+
+func (s *Service) readImage(db *wechatdb.DB, key string) ([]byte, string, error) {
+	media, err := db.GetMedia("image", key)
+	if err != nil {
+		return nil, "", err
+	}
+	path := filepath.Join(s.conf.GetDataDir(), media.Path)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", errors.ReadFileFailed(path, err)
+	}
+	if strings.EqualFold(filepath.Ext(path), ".dat") {
+		return dat2img.Dat2Image(b)
+	}
+	return b, strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), "."), nil
+}
+
+// attachOCR 为图片消息附带识别文本，没有识别结果时不做处理
+func (s *Service) attachOCR(messages ...*model.Message) {
+	r, err := s.getRecognizer()
+	if err != nil {
+		return
+	}
+	r.Attach(messages...)
+}
+
+// initOCR 配置了自动识别时，在数据库启动后于后台识别全部图片消息
+func (s *Service) initOCR() {
+	c := s.conf.GetOCR()
+	if c == nil || !c.Auto {
+		return
+	}
+	start, end, _ := util.TimeRangeOf("all")
+	if _, err := s.StartOCRJob(start, end, "", false); err != nil {
+		log.Error().Err(err).Msg("start ocr job failed")
+	}
+}
+
+func (s *Service) getRecognizer() (*ocr.Recognizer, error) {
+	s.recognizerMu.Lock()
+	defer s.recognizerMu.Unlock()
+	if s.recognizer != nil {
+		return s.recognizer, nil
+	}
+	// 没有配置引擎且还没有识别结果时不创建存储文件
+	if c := s.conf.GetOCR(); c == nil || c.Provider == "" {
+		if _, err := os.Stat(filepath.Join(s.conf.GetWorkDir(), ocr.StoreFile)); err != nil {
+			return nil, errors.ErrOCRDisabled
+		}
+	}
+	r, err := ocr.New(s.conf.GetWorkDir(), s.conf.GetOCR())
+	if err != nil {
+		return nil, err
+	}
+	s.recognizer = r
+	return r, nil
+}
+
+func (s *Service) closeRecognizer() {
+	s.recognizerMu.Lock()
+	defer s.recognizerMu.Unlock()
+	if s.recognizer != nil {
+		s.recognizer.Close()
+		s.recognizer = nil
+	}
+}
@@ -2,14 +2,25 @@ package database
 
 import (
 	"context"
+	"encoding/hex"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/rs/zerolog/log"
 
 	"github.com/sjzar/chatlog/internal/chatlog/conf"
+	"github.com/sjzar/chatlog/internal/chatlog/mediastore"
+	"github.com/sjzar/chatlog/internal/chatlog/ocr"
+	"github.com/sjzar/chatlog/internal/chatlog/recall"
+	"github.com/sjzar/chatlog/internal/chatlog/transcribe"
 	"github.com/sjzar/chatlog/internal/chatlog/webhook"
+	"github.com/sjzar/chatlog/internal/errors"
 	"github.com/sjzar/chatlog/internal/model"
 	"github.com/sjzar/chatlog/internal/wechatdb"
+	"github.com/sjzar/chatlog/pkg/util/video"
 )
 
 const (
@@ -26,6 +37,37 @@ type Service struct {
 	db            *wechatdb.DB
 	webhook       *webhook.Service
 	webhookCancel context.CancelFunc
+
+	// 外部注册的文件变更回调，数据库每次启动时重新挂载
+	callbacks   map[string][]func(event fsnotify.Event) error
+	callbacksMu sync.Mutex
+
+	// 数据库停止时调用，用于清空依赖当前账号数据的索引
+	stopHooks []func()
+
+	// 撤回消息跟踪，由自动解密在更新消息数据库后驱动，与数据库是否启动无关
+	recall   *recall.Tracker
+	recallMu sync.Mutex
+
+	// 语音转写，首次使用时打开转写记录
+	transcriber   *transcribe.Transcriber
+	transcriberMu sync.Mutex
+
+	// 图片文字识别，首次使用时打开识别结果
+	recognizer   *ocr.Recognizer
+	recognizerMu sync.Mutex
+	ocrJob       ocr.JobStatus
+	ocrJobCancel context.CancelFunc
+	ocrJobMu     sync.Mutex
+	ocrJobWg     sync.WaitGroup
+
+	// 内容寻址的媒体存储，启用时首次使用打开
+	mediaStore   *mediastore.Store
+	mediaStoreMu sync.Mutex
+
+	// 视频文件信息缓存
+	videoInfo   map[string]*video.Info
+	videoInfoMu sync.Mutex
 }
 
 type Config interface {
@@ -34,6 +76,10 @@ type Config interface {
 	GetVersion() int
 	GetWebhook() *conf.Webhook
 	GetWalEnabled() bool
+	GetTranscribe() *conf.Transcribe
+	GetOCR() *conf.OCR
+	GetMediaStore() bool
+	GetDataDir() string
 }
 
 func NewService(conf Config) *Service {
@@ -50,11 +96,33 @@ func (s *Service) Start() error {
 	}
 	s.SetReady()
 	s.db = db
+	s.initSelfUserName()
 	s.initWebhook()
+	s.initCallbacks()
+	s.initOCR()
 	return nil
 }
 
+// initSelfUserName 从数据目录名读取当前账号的用户名，v4 的账号目录为 用户名_4位后缀
+// 目录名无法识别或不在联系人中时，由 GetSelfUserName 从自己发送的消息中推断
+func (s *Service) initSelfUserName() {
+	name := filepath.Base(filepath.Clean(s.conf.GetDataDir()))
+	i := strings.LastIndex(name, "_")
+	if i <= 0 || len(name)-i-1 != 4 {
+		return
+	}
+	if _, err := hex.DecodeString(name[i+1:]); err != nil {
+		return
+	}
+	if contact, err := s.db.GetContact(name[:i]); err == nil && contact != nil && contact.UserName == name[:i] {
+		s.db.SetSelfUserName(contact.UserName)
+	}
+}
+
 func (s *Service) Stop() error {
+	// 识别任务仍在使用数据库与识别结果存储，等待任务退出后再关闭
+	s.StopOCRJob()
+	s.ocrJobWg.Wait()
 	if s.db != nil {
 		s.db.Close()
 	}
@@ -64,9 +132,27 @@ func (s *Service) Stop() error {
 		s.webhookCancel()
 		s.webhookCancel = nil
 	}
+	s.closeRecallTracker()
+	s.closeTranscriber()
+	s.closeRecognizer()
+	s.closeMediaStore()
+
+	s.callbacksMu.Lock()
+	hooks := s.stopHooks
+	s.callbacksMu.Unlock()
+	for _, hook := range hooks {
+		hook()
+	}
 	return nil
 }
 
+// AddStopHook 注册数据库停止时的回调，数据库重启或切换账号后旧数据不再有效
+func (s *Service) AddStopHook(hook func()) {
+	s.callbacksMu.Lock()
+	defer s.callbacksMu.Unlock()
+	s.stopHooks = append(s.stopHooks, hook)
+}
+
 func (s *Service) SetInit() {
 	s.State = StateInit
 }
@@ -88,12 +174,83 @@ func (s *Service) GetDB() *wechatdb.DB {
 	return s.db
 }
 
+// GetMessages 查询消息，语音与图片消息附带转写与识别文本，关键词同时匹配这些文本
 func (s *Service) GetMessages(start, end time.Time, talker string, sender string, keyword string, limit, offset int) ([]*model.Message, error) {
-	return s.db.GetMessages(start, end, talker, sender, keyword, limit, offset)
+	var hits []mediaTextHit
+	if keyword != "" {
+		hits = s.mediaTextHits(start, end, talker, sender, keyword)
+	}
+	if len(hits) == 0 {
+		messages, err := s.db.GetMessages(start, end, talker, sender, keyword, limit, offset)
+		if err != nil {
+			return nil, err
+		}
+		s.attachMediaText(messages...)
+		return messages, nil
+	}
+
+	// 命中的语音与图片消息可能落在任意一页，先取出前 offset+limit 条消息合并排序后再分页
+	fetch := 0
+	if limit > 0 {
+		fetch = offset + limit
+	}
+	messages, err := s.db.GetMessages(start, end, talker, sender, keyword, fetch, 0)
+	if err != nil {
+		return nil, err
+	}
+	messages = s.mergeMediaTextHits(messages, hits)
+	if offset >= len(messages) {
+		return []*model.Message{}, nil
+	}
+	messages = messages[offset:]
+	if limit > 0 && len(messages) > limit {
+		messages = messages[:limit]
+	}
+	s.attachMediaText(messages...)
+	return messages, nil
 }
 
 func (s *Service) GetMessage(talker string, seq int64) (*model.Message, error) {
-	return s.db.GetMessage(talker, seq)
+	msg, err := s.db.GetMessage(talker, seq)
+	if err != nil {
+		return nil, err
+	}
+	s.attachMediaText(msg)
+	return msg, nil
+}
+
+func (s *Service) GetMessageContext(talker string, seq int64, before, after int) ([]*model.Message, error) {
+	messages, err := s.db.GetMessageContext(talker, seq, before, after)
+	if err != nil {
+		return nil, err
+	}
+	s.attachMediaText(messages...)
+	return messages, nil
+}
+
+func (s *Service) GetMessagesByType(start, end time.Time, talker string, types ...int64) ([]*model.Message, error) {
+	return s.db.GetMessagesByType(start, end, talker, types...)
+}
+
+func (s *Service) GetMentions(start, end time.Time, talker, user string, unanswered bool) ([]*model.Message, error) {
+	return s.db.GetMentions(start, end, talker, user, unanswered)
+}
+
+func (s *Service) GetThread(talker string, seq int64) (*model.ThreadNode, error) {
+	return s.db.GetThread(talker, seq)
+}
+
+func (s *Service) ResolveThreads(messages []*model.Message) {
+	s.db.ResolveThreads(messages)
+}
+
+// GetSelfUserName 获取当前账号的用户名
+func (s *Service) GetSelfUserName() (string, error) {
+	return s.db.GetSelfUserName()
+}
+
+func (s *Service) GetChatStats(start, end time.Time, talker string) (*model.ChatStats, error) {
+	return s.db.GetChatStats(start, end, talker)
 }
 
 func (s *Service) GetContacts(key string, limit, offset int) (*wechatdb.GetContactsResp, error) {
@@ -121,6 +278,11 @@ func (s *Service) GetMedia(_type string, key string) (*model.Media, error) {
 	return s.db.GetMedia(_type, key)
 }
 
+// GetStickers 获取表情数据库中的自定义表情与商店表情
+func (s *Service) GetStickers() ([]*model.Sticker, error) {
+	return s.db.GetStickers()
+}
+
 func (s *Service) GetDecryptedDBs() (map[string][]string, error) {
 	if s.db == nil {
 		return nil, nil
@@ -166,6 +328,33 @@ func (s *Service) initWebhook() error {
 	return nil
 }
 
+// AddCallback 注册数据库文件变更回调
+// 数据库尚未启动时只做记录，在 Start 时统一挂载；重启后会自动重新挂载
+func (s *Service) AddCallback(group string, callback func(event fsnotify.Event) error) error {
+	s.callbacksMu.Lock()
+	defer s.callbacksMu.Unlock()
+	if s.callbacks == nil {
+		s.callbacks = make(map[string][]func(event fsnotify.Event) error)
+	}
+	s.callbacks[group] = append(s.callbacks[group], callback)
+	if s.db == nil {
+		return nil
+	}
+	return s.db.SetCallback(group, callback)
+}
+
+func (s *Service) initCallbacks() {
+	s.callbacksMu.Lock()
+	defer s.callbacksMu.Unlock()
+	for group, callbacks := range s.callbacks {
+		for _, callback := range callbacks {
+			if err := s.db.SetCallback(group, callback); err != nil {
+				log.Error().Err(err).Msgf("set callback for group %s failed", group)
+			}
+		}
+	}
+}
+
 // Close closes the database connection
 func (s *Service) Close() {
 	// Add cleanup code if needed
@@ -177,11 +366,19 @@ func (s *Service) Close() {
 }
 
 // GetSNSTimeline 获取朋友圈时间线数据
-func (s *Service) GetSNSTimeline(username string, limit, offset int) ([]map[string]interface{}, error) {
+func (s *Service) GetSNSTimeline(username string, start, end time.Time, limit, offset int) ([]*model.SNSPost, error) {
 	if s.db == nil {
 		return nil, nil
 	}
-	return s.db.GetSNSTimeline(username, limit, offset)
+	return s.db.GetSNSTimeline(username, start, end, limit, offset)
+}
+
+// GetSNSPost 获取单条朋友圈
+func (s *Service) GetSNSPost(tid int64) (*model.SNSPost, error) {
+	if s.db == nil {
+		return nil, errors.ErrSNSNotFound
+	}
+	return s.db.GetSNSPost(tid)
 }
 
 // GetSNSCount 获取朋友圈数量统计
@@ -191,3 +388,73 @@ func (s *Service) GetSNSCount(username string) (int, error) {
 	}
 	return s.db.GetSNSCount(username)
 }
+
+// TrackRecalls 在自动解密更新消息数据库后调用，检测被撤回的消息
+func (s *Service) TrackRecalls(output string) {
+	if s.conf.GetVersion() != 4 {
+		return
+	}
+	tracker, err := s.recallTracker()
+	if err != nil {
+		log.Error().Err(err).Msg("open recall tracker failed")
+		return
+	}
+	recalls, err := tracker.Track(output)
+	if err != nil {
+		log.Error().Err(err).Msgf("track recalls failed: %s", output)
+		return
+	}
+	if len(recalls) == 0 {
+		return
+	}
+	// 推送时按当前配置创建 webhook，配置修改后无需重启即可生效
+	for _, hook := range webhook.New(s.conf).GetRecallHooks(s.db) {
+		hook.Do(recalls)
+	}
+}
+
+// GetRecalls 查询时间范围内被撤回的消息
+func (s *Service) GetRecalls(start, end time.Time, talker string, limit, offset int) ([]*recall.Recall, error) {
+	tracker, err := s.recallTracker()
+	if err != nil {
+		return nil, err
+	}
+	recalls, err := tracker.List(start, end, talker, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	if s.db != nil {
+		for _, r := range recalls {
+			if contact, _ := s.db.GetContact(r.Message.Talker); contact != nil {
+				r.Message.TalkerName = contact.DisplayName()
+			}
+			if contact, _ := s.db.GetContact(r.Message.Sender); contact != nil {
+				r.Message.SenderName = contact.DisplayName()
+			}
+		}
+	}
+	return recalls, nil
+}
+
+func (s *Service) recallTracker() (*recall.Tracker, error) {
+	s.recallMu.Lock()
+	defer s.recallMu.Unlock()
+	if s.recall != nil {
+		return s.recall, nil
+	}
+	tracker, err := recall.New(s.conf.GetWorkDir())
+	if err != nil {
+		return nil, err
+	}
+	s.recall = tracker
+	return tracker, nil
+}
+
+func (s *Service) closeRecallTracker() {
+	s.recallMu.Lock()
+	defer s.recallMu.Unlock()
+	if s.recall != nil {
+		s.recall.Close()
+		s.recall = nil
+	}
+}
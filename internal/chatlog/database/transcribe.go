@@ -0,0 +1,136 @@
+package database
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/chatlog/transcribe"
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
+	"github.com/sjzar/chatlog/pkg/util"
+)
+
+// TranscribeVoice 转写单条语音消息，已有转写记录且 force 为 false 时直接返回记录
+func (s *Service) TranscribeVoice(ctx context.Context, talker string, seq int64, force bool) (*transcribe.Transcript, error) {
+	t, err := s.getTranscriber()
+	if err != nil {
+		return nil, err
+	}
+	msg, err := s.db.GetMessage(talker, seq)
+	if err != nil {
+		return nil, err
+	}
+	return s.transcribe(ctx, t, msg, force)
+}
+
+// TranscribeVoices 批量转写时间范围内的语音消息，单条失败只记录日志，返回成功的转写结果
+func (s *Service) TranscribeVoices(ctx context.Context, start, end time.Time, talker string, force bool) ([]*transcribe.Transcript, error) {
+	t, err := s.getTranscriber()
+	if err != nil {
+		return nil, err
+	}
+	if !t.Enabled() {
+		return nil, errors.ErrTranscribeDisabled
+	}
+	messages, err := s.db.GetMessagesByType(start, end, talker, model.MessageTypeVoice)
+	if err != nil {
+		return nil, err
+	}
+
+	transcripts := make([]*transcribe.Transcript, 0, len(messages))
+	for _, msg := range messages {
+		if err := ctx.Err(); err != nil {
+			return transcripts, err
+		}
+		tr, err := s.transcribe(ctx, t, msg, force)
+		if err != nil {
+			log.Debug().Err(err).Msgf("transcribe voice failed: %s %d", msg.Talker, msg.Seq)
+			continue
+		}
+		transcripts = append(transcripts, tr)
+	}
+	return transcripts, nil
+}
+
+// SearchTranscripts 按关键词（正则表达式）搜索转写文本，talker 多个以英文逗号分隔
+func (s *Service) SearchTranscripts(start, end time.Time, talker, keyword string) ([]*transcribe.Transcript, error) {
+	t, err := s.getTranscriber()
+	if err != nil {
+		return nil, err
+	}
+	var re *regexp.Regexp
+	if keyword != "" {
+		if re, err = regexp.Compile(keyword); err != nil {
+			return nil, errors.InvalidArg("keyword")
+		}
+	}
+	return t.Store().Search(re, util.Str2List(talker, ","), start, end)
+}
+
+func (s *Service) transcribe(ctx context.Context, t *transcribe.Transcriber, msg *model.Message, force bool) (*transcribe.Transcript, error) {
+	if msg.Type != model.MessageTypeVoice {
+		return nil, errors.ErrNotVoiceMessage
+	}
+	id, _ := msg.Contents["voice"].(string)
+	if !force {
+		if transcripts, err := t.Store().Get(id); err == nil {
+			if tr, ok := transcripts[id]; ok {
+				return tr, nil
+			}
+		}
+	}
+	if !t.Enabled() {
+		return nil, errors.ErrTranscribeDisabled
+	}
+	media, err := s.db.GetMedia("voice", id)
+	if err != nil {
+		return nil, err
+	}
+	return t.Transcribe(ctx, msg, media.Data)
+}
+
+// attachTranscripts 为语音消息附带转写文本，没有转写记录时不做处理
+func (s *Service) attachTranscripts(messages ...*model.Message) {
+	if len(messages) == 0 {
+		return
+	}
+	t, err := s.getTranscriber()
+	if err != nil {
+		return
+	}
+	t.Attach(messages...)
+}
+
+func (s *Service) getTranscriber() (*transcribe.Transcriber, error) {
+	s.transcriberMu.Lock()
+	defer s.transcriberMu.Unlock()
+	if s.transcriber != nil {
+		return s.transcriber, nil
+	}
+	// 没有配置引擎且还没有转写记录时不创建存储文件
+	if c := s.conf.GetTranscribe(); c == nil || c.Provider == "" {
+		if _, err := os.Stat(filepath.Join(s.conf.GetWorkDir(), transcribe.StoreFile)); err != nil {
+			return nil, errors.ErrTranscribeDisabled
+		}
+	}
+	t, err := transcribe.New(s.conf.GetWorkDir(), s.conf.GetTranscribe())
+	if err != nil {
+		return nil, err
+	}
+	s.transcriber = t
+	return t, nil
+}
+
+func (s *Service) closeTranscriber() {
+	s.transcriberMu.Lock()
+	defer s.transcriberMu.Unlock()
+	if s.transcriber != nil {
+		s.transcriber.Close()
+		s.transcriber = nil
+	}
+}
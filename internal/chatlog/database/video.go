@@ -0,0 +1,83 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/chatlog/export"
+	"github.com/sjzar/chatlog/internal/model"
+	"github.com/sjzar/chatlog/pkg/util/video"
+)
+
+// maxVideoInfoCache 视频信息缓存的条目上限，超过后清空重建
+const maxVideoInfoCache = 4096
+
+// VideoInfo 读取本地视频文件的时长、分辨率与编码，path 为数据目录下的相对路径
+// 结果按路径、大小与修改时间缓存，视频文件不变时不会重复读取
+func (s *Service) VideoInfo(path string) (*video.Info, error) {
+	absolutePath := filepath.Join(s.conf.GetDataDir(), path)
+	stat, err := os.Stat(absolutePath)
+	if err != nil {
+		return nil, err
+	}
+	key := path + "|" + strconv.FormatInt(stat.Size(), 10) + "|" + strconv.FormatInt(stat.ModTime().UnixNano(), 10)
+
+	s.videoInfoMu.Lock()
+	info, ok := s.videoInfo[key]
+	s.videoInfoMu.Unlock()
+	if ok {
+		return info, nil
+	}
+
+	if info, err = video.Probe(absolutePath); err != nil {
+		return nil, err
+	}
+
+	s.videoInfoMu.Lock()
+	if s.videoInfo == nil || len(s.videoInfo) >= maxVideoInfoCache {
+		s.videoInfo = make(map[string]*video.Info)
+	}
+	s.videoInfo[key] = info
+	s.videoInfoMu.Unlock()
+	return info, nil
+}
+
+// AttachVideoInfo 为本地存在的视频消息附带时长、分辨率、编码与文件大小
+// 需要逐个解析视频文件，不在通用的消息读取路径中调用，由需要的输出显式启用
+func (s *Service) AttachVideoInfo(messages ...*model.Message) {
+	if s.conf.GetDataDir() == "" {
+		return
+	}
+	for _, msg := range messages {
+		if msg.Type != model.MessageTypeVideo {
+			continue
+		}
+		item := export.Resolve(s, s.conf.GetDataDir(), msg)
+		if item == nil || item.Status != export.StatusOK {
+			continue
+		}
+		info, err := s.VideoInfo(item.Source)
+		if err != nil {
+			log.Debug().Err(err).Msgf("read video info failed: %s", item.Source)
+			continue
+		}
+		if msg.Contents == nil {
+			msg.Contents = make(map[string]interface{})
+		}
+		msg.Contents["duration"] = info.Duration
+		msg.Contents["size"] = info.Size
+		if info.Width > 0 && info.Height > 0 {
+			msg.Contents["width"] = info.Width
+			msg.Contents["height"] = info.Height
+		}
+		if info.VideoCodec != "" {
+			msg.Contents["videocodec"] = info.VideoCodec
+		}
+		if info.AudioCodec != "" {
+			msg.Contents["audiocodec"] = info.AudioCodec
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package emoji
+
+import (
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/model"
+)
+
+// CacheDirs 数据目录下缓存表情文件的目录，支持通配符，文件以表情 md5 命名，可能带有 _thumb 等后缀
+// cache 下按月份分目录，只遍历其中的表情目录，不遍历图片、视频等其他缓存
+var CacheDirs = []string{
+	filepath.Join("business", "emoticon"),
+	filepath.Join("cache", "*", "Emoticon"),
+	filepath.Join("cache", "*", "CustomEmotion"),
+	filepath.Join("cache", "*", "emoji"),
+}
+
+// RefreshInterval 查找不到表情时重建索引、重新读取表情数据库的最小间隔，避免频繁遍历缓存目录
+const RefreshInterval = time.Minute
+
+// Index 本地表情文件索引，首次查找时遍历缓存目录建立，同时缓存表情数据库中的表情
+type Index struct {
+	dataDir string
+
+	mu       sync.Mutex
+	files    map[string]string
+	builtAt  time.Time
+	stickers map[string]*model.Sticker
+	loadedAt time.Time
+
+	// 重建索引与读取表情数据库不持有 mu，同一时间只有一个在进行
+	buildMu sync.Mutex
+	loadMu  sync.Mutex
+}
+
+func NewIndex(dataDir string) *Index {
+	return &Index{dataDir: dataDir}
+}
+
+// DataDir 索引对应的数据目录
+func (x *Index) DataDir() string {
+	return x.dataDir
+}
+
+// Find 按 md5 查找本地表情文件，返回绝对路径，找不到时返回空字符串
+func (x *Index) Find(md5 string) string {
+	md5 = strings.ToLower(md5)
+	x.mu.Lock()
+	path := x.files[md5]
+	stale := x.files == nil || (path == "" && time.Since(x.builtAt) > RefreshInterval)
+	x.mu.Unlock()
+
+	if stale {
+		x.rebuild()
+		x.mu.Lock()
+		path = x.files[md5]
+		x.mu.Unlock()
+	}
+	if path != "" {
+		if _, err := os.Stat(path); err != nil {
+			x.mu.Lock()
+			delete(x.files, md5)
+			x.mu.Unlock()
+			return ""
+		}
+	}
+	return path
+}
+
+// Len 索引中的表情数量
+func (x *Index) Len() int {
+	x.mu.Lock()
+	built := x.files != nil
+	x.mu.Unlock()
+	if !built {
+		x.rebuild()
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return len(x.files)
+}
+
+// Lookup 表情数据库中的表情，不存在时返回 nil
+// 表情列表在首次查找时读取并缓存，查找不到且超过刷新间隔时重新读取
+func (x *Index) Lookup(src Source, md5 string) *model.Sticker {
+	md5 = strings.ToLower(md5)
+	x.mu.Lock()
+	sticker := x.stickers[md5]
+	stale := x.stickers == nil || (sticker == nil && time.Since(x.loadedAt) > RefreshInterval)
+	x.mu.Unlock()
+	if !stale {
+		return sticker
+	}
+
+	x.loadMu.Lock()
+	defer x.loadMu.Unlock()
+	x.mu.Lock()
+	reloaded := x.stickers != nil && time.Since(x.loadedAt) <= RefreshInterval
+	sticker = x.stickers[md5]
+	x.mu.Unlock()
+	if reloaded {
+		return sticker
+	}
+
+	stickers := make(map[string]*model.Sticker)
+	list, err := src.GetStickers()
+	if err != nil {
+		log.Debug().Err(err).Msg("get stickers failed")
+	}
+	for _, s := range list {
+		stickers[strings.ToLower(s.MD5)] = s
+	}
+	x.mu.Lock()
+	x.stickers = stickers
+	x.loadedAt = time.Now()
+	x.mu.Unlock()
+	return stickers[md5]
+}
+
+// rebuild 在锁外遍历缓存目录后替换索引，等待期间已被其他查找重建时直接返回
+func (x *Index) rebuild() {
+	x.buildMu.Lock()
+	defer x.buildMu.Unlock()
+	x.mu.Lock()
+	fresh := x.files != nil && time.Since(x.builtAt) <= RefreshInterval
+	x.mu.Unlock()
+	if fresh {
+		return
+	}
+
+	files := x.build()
+	x.mu.Lock()
+	x.files = files
+	x.builtAt = time.Now()
+	x.mu.Unlock()
+}
+
+// build 遍历缓存目录，同一 md5 有多个文件时优先使用不带后缀的原图，其次使用较大的文件
+func (x *Index) build() map[string]string {
+	files := make(map[string]string)
+	sizes := make(map[string]int64)
+	plain := make(map[string]bool)
+	for _, pattern := range CacheDirs {
+		roots, _ := filepath.Glob(filepath.Join(x.dataDir, pattern))
+		for _, root := range roots {
+			filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+				if err != nil || d.IsDir() {
+					return nil
+				}
+				md5, suffix, ok := parseName(d.Name())
+				if !ok {
+					return nil
+				}
+				info, err := d.Info()
+				if err != nil || info.Size() == 0 {
+					return nil
+				}
+				isPlain := suffix == ""
+				if _, exists := files[md5]; exists {
+					if plain[md5] && !isPlain {
+						return nil
+					}
+					if plain[md5] == isPlain && sizes[md5] >= info.Size() {
+						return nil
+					}
+				}
+				files[md5] = path
+				sizes[md5] = info.Size()
+				plain[md5] = isPlain
+				return nil
+			})
+		}
+	}
+	return files
+}
+
+// parseName 从文件名中解析表情 md5，suffix 为 md5 之后、扩展名之前的部分
+func parseName(name string) (md5, suffix string, ok bool) {
+	name = strings.ToLower(strings.TrimSuffix(name, filepath.Ext(name)))
+	if len(name) < 32 {
+		return "", "", false
+	}
+	if _, err := hex.DecodeString(name[:32]); err != nil {
+		return "", "", false
+	}
+	return name[:32], name[32:], true
+}
@@ -0,0 +1,92 @@
+package emoji
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sjzar/chatlog/internal/model"
+	"github.com/sjzar/chatlog/internal/wechatdb"
+)
+
+func TestIndexFind(t *testing.T) {
+	dataDir := t.TempDir()
+	write := func(rel string, size int) string {
+		path := filepath.Join(dataDir, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	const (
+		md5A = "0123456789abcdef0123456789abcdef"
+		md5B = "fedcba9876543210fedcba9876543210"
+		md5C = "00000000000000000000000000000000"
+	)
+	plainA := write(filepath.Join("cache", "2024-01", "Emoticon", md5A+".dat"), 10)
+	write(filepath.Join("cache", "2024-01", "Emoticon", md5A+"_thumb.dat"), 100)
+	thumbB := write(filepath.Join("business", "emoticon", "sub", md5B+"_thumb"), 10)
+	write(filepath.Join("cache", "2024-01", "Img", md5C+".dat"), 10)
+	write(filepath.Join("cache", "2024-01", "Emoticon", "not-an-md5.dat"), 10)
+
+	tests := []struct {
+		name string
+		md5  string
+		want string
+	}{
+		{name: "plain file preferred over larger thumb", md5: md5A, want: plainA},
+		{name: "upper case md5", md5: "0123456789ABCDEF0123456789ABCDEF", want: plainA},
+		{name: "thumb only in business dir", md5: md5B, want: thumbB},
+		{name: "other cache dirs are not indexed", md5: md5C, want: ""},
+	}
+
+	x := NewIndex(dataDir)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := x.Find(tt.md5); got != tt.want {
+				t.Errorf("Find(%q) = %q, want %q", tt.md5, got, tt.want)
+			}
+		})
+	}
+	if got := x.Len(); got != 2 {
+		t.Errorf("Len() = %d, want 2", got)
+	}
+}
+
+type stickerSource struct {
+	stickers []*model.Sticker
+	calls    int
+}
+
+func (s *stickerSource) GetSessions(key string, limit, offset int) (*wechatdb.GetSessionsResp, error) {
+	return &wechatdb.GetSessionsResp{}, nil
+}
+
+func (s *stickerSource) GetMessagesByType(start, end time.Time, talker string, types ...int64) ([]*model.Message, error) {
+	return nil, nil
+}
+
+func (s *stickerSource) GetStickers() ([]*model.Sticker, error) {
+	s.calls++
+	return s.stickers, nil
+}
+
+func TestIndexLookup(t *testing.T) {
+	src := &stickerSource{stickers: []*model.Sticker{{MD5: "0123456789abcdef0123456789abcdef"}}}
+	x := NewIndex(t.TempDir())
+
+	if got := x.Lookup(src, "0123456789ABCDEF0123456789ABCDEF"); got == nil {
+		t.Fatal("Lookup() = nil, want sticker")
+	}
+	if got := x.Lookup(src, "fedcba9876543210fedcba9876543210"); got != nil {
+		t.Errorf("Lookup() = %v, want nil", got)
+	}
+	if src.calls != 1 {
+		t.Errorf("GetStickers called %d times, want 1", src.calls)
+	}
+}
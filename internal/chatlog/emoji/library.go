@@ -0,0 +1,147 @@
+package emoji
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/model"
+	"github.com/sjzar/chatlog/internal/wechatdb"
+)
+
+// Source 表情库所需的数据接口，由 database.Service 实现
+type Source interface {
+	GetSessions(key string, limit, offset int) (*wechatdb.GetSessionsResp, error)
+	GetMessagesByType(start, end time.Time, talker string, types ...int64) ([]*model.Message, error)
+	GetStickers() ([]*model.Sticker, error)
+}
+
+// Entry 表情库中的一个表情，Count 为时间范围内的使用次数，SelfCount 为其中自己发送的次数
+// Saved 表示表情在表情数据库中（收藏或来自表情商店），Local 表示本地缓存中有表情文件
+type Entry struct {
+	MD5       string    `json:"md5"`
+	Count     int       `json:"count"`
+	SelfCount int       `json:"selfCount"`
+	Talkers   int       `json:"talkers"`
+	First     time.Time `json:"first,omitempty"`
+	Last      time.Time `json:"last,omitempty"`
+	Saved     bool      `json:"saved"`
+	Local     bool      `json:"local"`
+	CdnURL    string    `json:"cdnUrl,omitempty"`
+	*model.Sticker
+
+	talkers map[string]bool
+}
+
+// Library 账号的表情库，按使用次数倒序，没有使用过的收藏表情排在最后
+type Library struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Total int       `json:"total"`
+	Local int       `json:"local"`
+	Items []*Entry  `json:"items"`
+}
+
+// Build 统计时间范围内动画表情消息的使用次数，并合并表情数据库中的表情
+// talker 多个以英文逗号分隔，为空时统计全部会话
+func Build(ctx context.Context, src Source, index *Index, start, end time.Time, talker string) (*Library, error) {
+	messages, err := messages(src, start, end, talker)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(map[string]*Entry)
+	lib := &Library{Start: start, End: end, Items: make([]*Entry, 0)}
+	get := func(md5 string) *Entry {
+		e, ok := entries[md5]
+		if !ok {
+			e = &Entry{MD5: md5, talkers: make(map[string]bool)}
+			entries[md5] = e
+			lib.Items = append(lib.Items, e)
+		}
+		return e
+	}
+
+	for _, msg := range messages {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		md5, _ := msg.Contents["md5"].(string)
+		if md5 == "" {
+			continue
+		}
+		e := get(strings.ToLower(md5))
+		e.Count++
+		if msg.IsSelf {
+			e.SelfCount++
+		}
+		e.talkers[msg.Talker] = true
+		if e.First.IsZero() || msg.Time.Before(e.First) {
+			e.First = msg.Time
+		}
+		if msg.Time.After(e.Last) {
+			e.Last = msg.Time
+			if cdnURL, _ := msg.Contents["cdnurl"].(string); cdnURL != "" {
+				e.CdnURL = cdnURL
+			}
+		}
+	}
+
+	// 表情数据库不存在（旧版本或未解密）时只统计消息
+	stickers, err := src.GetStickers()
+	if err != nil {
+		log.Debug().Err(err).Msg("get stickers failed")
+	}
+	for _, sticker := range stickers {
+		e := get(sticker.MD5)
+		e.Saved = true
+		e.Sticker = sticker
+		if e.CdnURL == "" {
+			e.CdnURL = sticker.CdnURL
+		}
+	}
+
+	for _, e := range lib.Items {
+		e.Talkers = len(e.talkers)
+		if index != nil && index.Find(e.MD5) != "" {
+			e.Local = true
+			lib.Local++
+		}
+	}
+	lib.Total = len(lib.Items)
+
+	sort.SliceStable(lib.Items, func(i, j int) bool {
+		a, b := lib.Items[i], lib.Items[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Last.After(b.Last)
+	})
+	return lib, nil
+}
+
+// messages 时间范围内的动画表情消息，talker 为空时遍历全部会话
+func messages(src Source, start, end time.Time, talker string) ([]*model.Message, error) {
+	if talker != "" {
+		return src.GetMessagesByType(start, end, talker, model.MessageTypeAnimation)
+	}
+	sessions, err := src.GetSessions("", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]*model.Message, 0)
+	for _, session := range sessions.Items {
+		if session.UserName == "" || session.NTime.Before(start) {
+			continue
+		}
+		list, err := src.GetMessagesByType(start, end, session.UserName, model.MessageTypeAnimation)
+		if err != nil {
+			continue
+		}
+		messages = append(messages, list...)
+	}
+	return messages, nil
+}
@@ -0,0 +1,237 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sjzar/chatlog/pkg/util/dat2img"
+)
+
+// Counts 媒体可用性统计，Missing 包括从未下载与已被清理的媒体，Expired 为其中已被清理的数量
+type Counts struct {
+	Total       int `json:"total"`
+	Decodable   int `json:"decodable"`
+	Undecodable int `json:"undecodable"`
+	Missing     int `json:"missing"`
+	Expired     int `json:"expired"`
+}
+
+func (c *Counts) add(item *Item) {
+	c.Total++
+	switch item.Status {
+	case StatusOK:
+		c.Decodable++
+	case StatusFailed:
+		c.Undecodable++
+	case StatusExpired:
+		c.Missing++
+		c.Expired++
+	default:
+		c.Missing++
+	}
+}
+
+// TalkerAvailability 单个会话的媒体可用性
+type TalkerAvailability struct {
+	Talker     string             `json:"talker"`
+	TalkerName string             `json:"talkerName,omitempty"`
+	Kinds      map[string]*Counts `json:"kinds"`
+	Counts
+}
+
+// Availability 媒体可用性报告，会话按缺失与无法解码的数量倒序
+// Items 仅在需要明细时填充，包含全部无法解码与缺失的媒体
+type Availability struct {
+	Start   time.Time             `json:"start"`
+	End     time.Time             `json:"end"`
+	Kinds   map[string]*Counts    `json:"kinds"`
+	Talkers []*TalkerAvailability `json:"talkers"`
+	Items   []*Item               `json:"items,omitempty"`
+	Counts
+}
+
+// Scan 统计时间范围内媒体消息的可用性：本地存在且可解码、本地存在但无法解码（密钥错误或格式损坏）、缺失
+// 只读取数据目录，不写入任何文件
+func Scan(ctx context.Context, src Source, opts Options, detail bool) (*Availability, error) {
+	messages, err := Messages(src, opts.Start, opts.End, opts.Talker)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &Availability{
+		Start: opts.Start,
+		End:   opts.End,
+		Kinds: make(map[string]*Counts),
+	}
+	talkers := make(map[string]*TalkerAvailability)
+	for _, msg := range messages {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		item := Resolve(src, opts.DataDir, msg)
+		if item == nil || (len(opts.Kinds) > 0 && !slices.Contains(opts.Kinds, item.Kind)) {
+			continue
+		}
+		item.Check(opts.DataDir)
+
+		t, ok := talkers[item.Talker]
+		if !ok {
+			t = &TalkerAvailability{Talker: item.Talker, TalkerName: item.TalkerName, Kinds: make(map[string]*Counts)}
+			talkers[item.Talker] = t
+			ret.Talkers = append(ret.Talkers, t)
+		}
+		for _, m := range []map[string]*Counts{t.Kinds, ret.Kinds} {
+			if m[item.Kind] == nil {
+				m[item.Kind] = &Counts{}
+			}
+			m[item.Kind].add(item)
+		}
+		t.add(item)
+		ret.add(item)
+
+		if detail && item.Status != StatusOK {
+			ret.Items = append(ret.Items, item)
+		}
+	}
+
+	sort.SliceStable(ret.Talkers, func(i, j int) bool {
+		a, b := ret.Talkers[i], ret.Talkers[j]
+		if a.Missing+a.Undecodable != b.Missing+b.Undecodable {
+			return a.Missing+a.Undecodable > b.Missing+b.Undecodable
+		}
+		return a.Total > b.Total
+	})
+	return ret, nil
+}
+
+// Check 检查本地存在的媒体能否解码，无法解码时状态改为 StatusFailed
+// 图片需要能够解密，视频需要是 MP4，语音需要是 SILK，文件只要求不为空
+func (i *Item) Check(dataDir string) {
+	if i.Status != StatusOK {
+		return
+	}
+	var reason string
+	switch i.Kind {
+	case KindImage:
+		data, err := i.Data(dataDir)
+		if err != nil {
+			reason = err.Error()
+			break
+		}
+		if strings.HasSuffix(strings.ToLower(i.Source), ".dat") {
+			if _, _, err := dat2img.Dat2Image(data); err != nil {
+				reason = err.Error()
+			}
+		}
+	case KindVideo:
+		head, err := readHead(filepath.Join(dataDir, i.Source), 12)
+		if err != nil {
+			reason = err.Error()
+		} else if len(head) < 12 || !bytes.Equal(head[4:8], []byte("ftyp")) {
+			reason = "invalid mp4 header"
+		}
+	case KindVoice:
+		if !bytes.Contains(i.data[:min(len(i.data), 16)], []byte("#!SILK")) {
+			reason = "invalid silk header"
+		}
+	case KindFile:
+		head, err := readHead(filepath.Join(dataDir, i.Source), 1)
+		if err != nil {
+			reason = err.Error()
+		} else if len(head) == 0 {
+			reason = "empty file"
+		}
+	}
+	if reason != "" {
+		i.Status = StatusFailed
+		i.Error = reason
+	}
+}
+
+// WriteCSV 每个会话的每种媒体一行，有明细时在后面追加无法解码与缺失的媒体
+func (a *Availability) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"Talker", "TalkerName", "Kind", "Total", "Decodable", "Undecodable", "Missing", "Expired"})
+	for _, t := range a.Talkers {
+		for _, kind := range sortedKinds(t.Kinds) {
+			n := t.Kinds[kind]
+			cw.Write([]string{t.Talker, t.TalkerName, kind,
+				strconv.Itoa(n.Total), strconv.Itoa(n.Decodable), strconv.Itoa(n.Undecodable), strconv.Itoa(n.Missing), strconv.Itoa(n.Expired)})
+		}
+	}
+	if len(a.Items) > 0 {
+		cw.Write(nil)
+		cw.Write([]string{"MessageID", "Time", "Talker", "Sender", "Kind", "Key", "Status", "Source", "Error"})
+		for _, item := range a.Items {
+			cw.Write([]string{strconv.FormatInt(item.Seq, 10), item.Time.Format(time.DateTime), item.Talker, item.Sender,
+				item.Kind, item.Key, item.Status, item.Source, item.Error})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteText 按会话输出可读的统计
+func (a *Availability) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "%s ~ %s\n", a.Start.Format(time.DateTime), a.End.Format(time.DateTime))
+	fmt.Fprintf(w, "全部: %s\n", countsText(&a.Counts))
+	for _, kind := range sortedKinds(a.Kinds) {
+		fmt.Fprintf(w, "  %s: %s\n", kind, countsText(a.Kinds[kind]))
+	}
+	for _, t := range a.Talkers {
+		fmt.Fprintf(w, "\n%s: %s\n", talkerName(t), countsText(&t.Counts))
+		for _, kind := range sortedKinds(t.Kinds) {
+			fmt.Fprintf(w, "  %s: %s\n", kind, countsText(t.Kinds[kind]))
+		}
+	}
+	if len(a.Items) > 0 {
+		fmt.Fprintln(w)
+		for _, item := range a.Items {
+			fmt.Fprintf(w, "%s [%d] %s %s %s %s %s\n", item.Talker, item.Seq, item.Time.Format(time.DateTime), item.Kind, item.Status, item.Key, item.Error)
+		}
+	}
+}
+
+func countsText(n *Counts) string {
+	return fmt.Sprintf("共 %d，可解码 %d，无法解码 %d，缺失 %d（已清理 %d）", n.Total, n.Decodable, n.Undecodable, n.Missing, n.Expired)
+}
+
+func sortedKinds(kinds map[string]*Counts) []string {
+	keys := make([]string, 0, len(kinds))
+	for kind := range kinds {
+		keys = append(keys, kind)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func talkerName(t *TalkerAvailability) string {
+	if t.TalkerName == "" {
+		return t.Talker
+	}
+	return t.TalkerName + "(" + t.Talker + ")"
+}
+
+func readHead(path string, n int) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	buf := make([]byte, n)
+	n, err = io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	return buf[:n], nil
+}
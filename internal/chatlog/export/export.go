@@ -0,0 +1,222 @@
+package export
+
+import (
+	"context"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/pkg/util"
+	"github.com/sjzar/chatlog/pkg/util/silk"
+)
+
+// ManifestFile 导出目录下的清单文件，每条媒体消息一行，包括缺失与过期的媒体
+const ManifestFile = "manifest.csv"
+
+// Options 导出参数，Talker 多个以英文逗号分隔，为空时导出全部会话
+// Kinds 为空时导出全部媒体类型，VoiceFormat 为空时转码为 mp3
+type Options struct {
+	Start       time.Time
+	End         time.Time
+	Talker      string
+	Kinds       []string
+	DataDir     string
+	OutDir      string
+	VoiceFormat string
+}
+
+// Summary 导出结果统计
+type Summary struct {
+	Total    int            `json:"total"`
+	Exported int            `json:"exported"`
+	Skipped  int            `json:"skipped"`
+	Missing  int            `json:"missing"`
+	Expired  int            `json:"expired"`
+	Failed   int            `json:"failed"`
+	Kinds    map[string]int `json:"kinds"`
+	Manifest string         `json:"manifest"`
+}
+
+// Export 将媒体消息整理导出到 out/<talker>/<yyyy-mm>/ 下，文件名包含时间、发送者与原始文件名
+// 图片解密为原始格式，语音转码，视频与文件直接复制，已存在的文件不会重复导出
+func Export(ctx context.Context, src Source, opts Options) (*Summary, error) {
+	if opts.OutDir == "" {
+		return nil, errors.InvalidArg("out")
+	}
+	if opts.VoiceFormat == "" {
+		opts.VoiceFormat = silk.FormatMP3
+	}
+	if err := util.PrepareDir(opts.OutDir); err != nil {
+		return nil, err
+	}
+
+	messages, err := Messages(src, opts.Start, opts.End, opts.Talker)
+	if err != nil {
+		return nil, err
+	}
+
+	manifestPath := filepath.Join(opts.OutDir, ManifestFile)
+	f, err := os.Create(manifestPath)
+	if err != nil {
+		return nil, errors.OpenFileFailed(manifestPath, err)
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	w.Write([]string{"MessageID", "Time", "Talker", "TalkerName", "Sender", "SenderName", "Kind", "Key", "Name", "Size", "Status", "File", "Source", "Error"})
+
+	summary := &Summary{Kinds: make(map[string]int), Manifest: manifestPath}
+	for _, msg := range messages {
+		if err := ctx.Err(); err != nil {
+			w.Flush()
+			return summary, err
+		}
+		item := Resolve(src, opts.DataDir, msg)
+		if item == nil || (len(opts.Kinds) > 0 && !slices.Contains(opts.Kinds, item.Kind)) {
+			continue
+		}
+		summary.Total++
+		summary.Kinds[item.Kind]++
+
+		if item.Status == StatusOK {
+			skipped, err := save(item, opts)
+			switch {
+			case err != nil:
+				item.Status = StatusFailed
+				item.Error = err.Error()
+				log.Debug().Err(err).Msgf("export media failed: %s %d", item.Talker, item.Seq)
+			case skipped:
+				summary.Skipped++
+			default:
+				summary.Exported++
+			}
+		}
+		switch item.Status {
+		case StatusMissing:
+			summary.Missing++
+		case StatusExpired:
+			summary.Expired++
+		case StatusFailed:
+			summary.Failed++
+		}
+
+		w.Write([]string{
+			strconv.FormatInt(item.Seq, 10),
+			item.Time.Format(time.DateTime),
+			item.Talker,
+			item.TalkerName,
+			item.Sender,
+			item.SenderName,
+			item.Kind,
+			item.Key,
+			item.Name,
+			strconv.FormatInt(item.Size, 10),
+			item.Status,
+			filepath.ToSlash(item.File),
+			filepath.ToSlash(item.Source),
+			item.Error,
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return summary, errors.WriteOutputFailed(err)
+	}
+	return summary, nil
+}
+
+// save 解码并写入媒体文件，目标文件已存在时跳过
+func save(item *Item, opts Options) (bool, error) {
+	if file, ok := exported(item, opts); ok {
+		item.File = file
+		return true, nil
+	}
+
+	data, ext, err := item.Decode(opts.DataDir, opts.VoiceFormat)
+	if err != nil {
+		return false, err
+	}
+
+	item.File = fileName(item, ext)
+	path := filepath.Join(opts.OutDir, item.File)
+	if err := util.PrepareDir(filepath.Dir(path)); err != nil {
+		return false, err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return false, errors.WriteOutputFailed(err)
+	}
+	if !item.Time.IsZero() {
+		os.Chtimes(path, item.Time, item.Time)
+	}
+	return false, nil
+}
+
+// exported 在解码前查找已导出的目标文件，返回相对路径
+// 视频与文件按来源扩展名确定文件名并比较大小，图片解码后才能确定格式，按不含扩展名的文件名匹配
+func exported(item *Item, opts Options) (string, bool) {
+	switch item.Kind {
+	case KindImage:
+		stem := fileName(item, "")
+		entries, err := os.ReadDir(filepath.Join(opts.OutDir, filepath.Dir(stem)))
+		if err != nil {
+			return "", false
+		}
+		prefix := filepath.Base(stem) + "."
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.Type().IsRegular() && strings.HasPrefix(name, prefix) && !strings.Contains(name[len(prefix):], ".") {
+				return filepath.Join(filepath.Dir(stem), name), true
+			}
+		}
+		return "", false
+	case KindVoice:
+		file := fileName(item, opts.VoiceFormat)
+		if info, err := os.Stat(filepath.Join(opts.OutDir, file)); err == nil && info.Size() > 0 {
+			return file, true
+		}
+		return "", false
+	default:
+		file := fileName(item, strings.TrimPrefix(filepath.Ext(item.Source), "."))
+		src, err := os.Stat(filepath.Join(opts.DataDir, item.Source))
+		if err != nil {
+			return "", false
+		}
+		if info, err := os.Stat(filepath.Join(opts.OutDir, file)); err == nil && info.Size() == src.Size() {
+			return file, true
+		}
+		return "", false
+	}
+}
+
+// fileName 导出文件的相对路径 <talker>/<yyyy-mm>/<yyyymmdd_hhmmss>_<sender>_<name>
+// 没有原始文件名的媒体以消息 ID 命名，文件名带消息 ID 避免同名文件互相覆盖
+func fileName(item *Item, ext string) string {
+	sender := item.SenderName
+	if sender == "" {
+		sender = item.Sender
+	}
+	if item.IsSelf {
+		sender = "我"
+	}
+	sender = util.SafeFileName(sender, 40)
+
+	name := strconv.FormatInt(item.Seq, 10)
+	if item.Kind == KindFile && item.Name != "" {
+		stem := strings.TrimSuffix(item.Name, filepath.Ext(item.Name))
+		name = util.SafeFileName(stem, 120) + "_" + name
+		if e := strings.TrimPrefix(filepath.Ext(item.Name), "."); e != "" {
+			ext = e
+		}
+	}
+
+	base := util.SafeFileName(item.Time.Format("20060102_150405")+"_"+sender+"_"+name, 200)
+	if ext != "" {
+		base += "." + strings.ToLower(util.SafeFileName(ext, 16))
+	}
+	return filepath.Join(util.SafeFileName(item.Talker, 100), item.Time.Format("2006-01"), base)
+}
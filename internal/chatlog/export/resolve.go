@@ -0,0 +1,245 @@
+package export
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
+	"github.com/sjzar/chatlog/internal/wechatdb"
+	"github.com/sjzar/chatlog/pkg/util/dat2img"
+	"github.com/sjzar/chatlog/pkg/util/silk"
+)
+
+// 媒体类型
+const (
+	KindImage = "image"
+	KindVideo = "video"
+	KindFile  = "file"
+	KindVoice = "voice"
+)
+
+// 媒体状态
+const (
+	// StatusOK 本地文件存在
+	StatusOK = "ok"
+	// StatusMissing hardlink 数据库中没有记录，通常是从未下载过
+	StatusMissing = "missing"
+	// StatusExpired hardlink 数据库中有记录但本地文件已被清理，通常是过期后被微信删除
+	StatusExpired = "expired"
+	// StatusFailed 解密、转码或复制失败
+	StatusFailed = "failed"
+)
+
+// Source 解析媒体所需的数据接口，由 database.Service 实现
+type Source interface {
+	GetSessions(key string, limit, offset int) (*wechatdb.GetSessionsResp, error)
+	GetMessagesByType(start, end time.Time, talker string, types ...int64) ([]*model.Message, error)
+	GetMedia(_type string, key string) (*model.Media, error)
+}
+
+// Item 一条媒体消息的解析结果，Source 为数据目录下的相对路径，File 为导出目录下的相对路径
+type Item struct {
+	Seq        int64     `json:"seq"`
+	Time       time.Time `json:"time"`
+	Talker     string    `json:"talker"`
+	TalkerName string    `json:"talkerName,omitempty"`
+	Sender     string    `json:"sender"`
+	SenderName string    `json:"senderName,omitempty"`
+	IsSelf     bool      `json:"isSelf,omitempty"`
+
+	Kind   string `json:"kind"`
+	Key    string `json:"key"`
+	Name   string `json:"name,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+	Source string `json:"source,omitempty"`
+	File   string `json:"file,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+
+	// data 语音数据，语音保存在数据库中而不是文件
+	data []byte
+}
+
+// MediaTypes 包含媒体的消息类型，分享消息中只有文件是媒体
+var MediaTypes = []int64{model.MessageTypeImage, model.MessageTypeVideo, model.MessageTypeVoice, model.MessageTypeShare}
+
+// Resolve 通过 hardlink 数据库定位消息中的媒体，不是媒体消息时返回 nil
+func Resolve(src Source, dataDir string, msg *model.Message) *Item {
+	item := &Item{
+		Seq:        msg.Seq,
+		Time:       msg.Time,
+		Talker:     msg.Talker,
+		TalkerName: msg.TalkerName,
+		Sender:     msg.Sender,
+		SenderName: msg.SenderName,
+		IsSelf:     msg.IsSelf,
+		Status:     StatusMissing,
+	}
+
+	// keys 依次尝试的媒体 key，fallback 为消息中记录的路径（不含后缀），suffixes 为可能的后缀
+	var keys []string
+	var fallback string
+	var suffixes []string
+	switch {
+	case msg.Type == model.MessageTypeImage:
+		item.Kind = KindImage
+		keys = contentStrings(msg, "md5")
+		fallback, _ = msg.Contents["path"].(string)
+		suffixes = []string{"_h.dat", ".dat", "_t.dat"}
+	case msg.Type == model.MessageTypeVideo:
+		item.Kind = KindVideo
+		keys = contentStrings(msg, "md5", "rawmd5")
+		fallback, _ = msg.Contents["path"].(string)
+		suffixes = []string{".mp4"}
+	case msg.Type == model.MessageTypeVoice:
+		item.Kind = KindVoice
+		keys = contentStrings(msg, "voice")
+	case msg.Type == model.MessageTypeShare && msg.SubType == model.MessageSubTypeFile:
+		item.Kind = KindFile
+		keys = contentStrings(msg, "md5")
+		item.Name, _ = msg.Contents["title"].(string)
+		item.Size, _ = msg.Contents["size"].(int64)
+	default:
+		return nil
+	}
+	if len(keys) > 0 {
+		item.Key = keys[0]
+	}
+
+	var err error
+	for _, key := range keys {
+		var media *model.Media
+		if media, err = src.GetMedia(item.Kind, key); err != nil {
+			continue
+		}
+		item.Key = key
+		if item.Kind == KindVoice {
+			if len(media.Data) > 0 {
+				item.data = media.Data
+				item.Size = int64(len(media.Data))
+				item.Status = StatusOK
+			}
+			return item
+		}
+		item.Source = media.Path
+		if media.Size > 0 {
+			item.Size = media.Size
+		}
+		if item.Kind == KindFile && item.Name == "" {
+			item.Name = media.Name
+		}
+		if exists(filepath.Join(dataDir, media.Path)) {
+			item.Status = StatusOK
+			return item
+		}
+		item.Status = StatusExpired
+	}
+
+	// hardlink 数据库中没有记录或文件不存在时，按消息中记录的路径查找
+	if fallback != "" {
+		for _, suffix := range suffixes {
+			if exists(filepath.Join(dataDir, fallback+suffix)) {
+				item.Source = fallback + suffix
+				item.Status = StatusOK
+				return item
+			}
+		}
+	}
+	if item.Status == StatusMissing && err != nil && !errors.Is(err, errors.ErrMediaNotFound) {
+		item.Error = err.Error()
+	}
+	return item
+}
+
+// Data 读取媒体的原始数据，语音返回 silk 数据
+func (i *Item) Data(dataDir string) ([]byte, error) {
+	if i.Kind == KindVoice {
+		if len(i.data) == 0 {
+			return nil, errors.ErrMediaNotFound
+		}
+		return i.data, nil
+	}
+	if i.Source == "" {
+		return nil, errors.ErrMediaNotFound
+	}
+	path := filepath.Join(dataDir, i.Source)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.ReadFileFailed(path, err)
+	}
+	return b, nil
+}
+
+// Decode 读取并解码媒体，图片解密为原始格式，语音转码为 voiceFormat，视频与文件返回原始数据
+// 语音转码失败时返回原始 silk 数据，并在 Error 中记录原因
+func (i *Item) Decode(dataDir, voiceFormat string) ([]byte, string, error) {
+	data, err := i.Data(dataDir)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ext := ""
+	switch i.Kind {
+	case KindImage:
+		if strings.HasSuffix(strings.ToLower(i.Source), ".dat") {
+			if data, ext, err = dat2img.Dat2Image(data); err != nil {
+				return nil, "", err
+			}
+		} else {
+			ext = strings.TrimPrefix(filepath.Ext(i.Source), ".")
+		}
+	case KindVoice:
+		ext = voiceFormat
+		out, err := silk.Convert(data, ext, 0)
+		if err != nil {
+			i.Error = err.Error()
+			ext = silk.FormatSILK
+		} else {
+			data = out
+		}
+	default:
+		ext = strings.TrimPrefix(filepath.Ext(i.Source), ".")
+	}
+	return data, ext, nil
+}
+
+// Messages 时间范围内的媒体消息，talker 多个以英文逗号分隔，为空时遍历全部会话
+func Messages(src Source, start, end time.Time, talker string) ([]*model.Message, error) {
+	if talker != "" {
+		return src.GetMessagesByType(start, end, talker, MediaTypes...)
+	}
+	sessions, err := src.GetSessions("", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]*model.Message, 0)
+	for _, session := range sessions.Items {
+		if session.UserName == "" || session.NTime.Before(start) {
+			continue
+		}
+		list, err := src.GetMessagesByType(start, end, session.UserName, MediaTypes...)
+		if err != nil {
+			continue
+		}
+		messages = append(messages, list...)
+	}
+	return messages, nil
+}
+
+func contentStrings(msg *model.Message, keys ...string) []string {
+	ret := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if v, _ := msg.Contents[key].(string); strings.TrimSpace(v) != "" {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+func exists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
@@ -0,0 +1,67 @@
+package geo
+
+import (
+	"math"
+	"testing"
+)
+
+func TestGCJ02ToWGS84(t *testing.T) {
+	tests := []struct {
+		name    string
+		lat     float64
+		lon     float64
+		wantLat float64
+		wantLon float64
+		tol     float64
+	}{
+		// 国内坐标，与公开的 WGS-84 参考值误差在米级
+		{
+			name:    "beijing tiananmen",
+			lat:     39.908823,
+			lon:     116.397470,
+			wantLat: 39.907420,
+			wantLon: 116.391230,
+			tol:     1e-4,
+		},
+		{
+			name:    "shanghai",
+			lat:     31.230416,
+			lon:     121.473701,
+			wantLat: 31.232416,
+			wantLon: 121.469161,
+			tol:     1e-4,
+		},
+
+		// 境外坐标保持不变
+		{
+			name:    "new york",
+			lat:     40.712776,
+			lon:     -74.005974,
+			wantLat: 40.712776,
+			wantLon: -74.005974,
+		},
+		{
+			name:    "tokyo",
+			lat:     35.689487,
+			lon:     139.691711,
+			wantLat: 35.689487,
+			wantLon: 139.691711,
+		},
+		{
+			name:    "zero",
+			lat:     0,
+			lon:     0,
+			wantLat: 0,
+			wantLon: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lat, lon := GCJ02ToWGS84(tt.lat, tt.lon)
+			if math.Abs(lat-tt.wantLat) > tt.tol || math.Abs(lon-tt.wantLon) > tt.tol {
+				t.Errorf("GCJ02ToWGS84(%v, %v) = (%v, %v), want (%v, %v)", tt.lat, tt.lon, lat, lon, tt.wantLat, tt.wantLon)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package geo
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Feature GeoJSON 要素，坐标顺序为 [经度, 纬度]
+type Feature struct {
+	Type       string                 `json:"type"`
+	Geometry   Geometry               `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type Geometry struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+type FeatureCollection struct {
+	Type     string     `json:"type"`
+	Features []*Feature `json:"features"`
+}
+
+// GeoJSON 将位置转换为 GeoJSON FeatureCollection
+func GeoJSON(places []*Place) *FeatureCollection {
+	fc := &FeatureCollection{
+		Type:     "FeatureCollection",
+		Features: make([]*Feature, 0, len(places)),
+	}
+	for _, p := range places {
+		fc.Features = append(fc.Features, &Feature{
+			Type: "Feature",
+			Geometry: Geometry{
+				Type:        "Point",
+				Coordinates: [2]float64{p.Longitude, p.Latitude},
+			},
+			Properties: p.properties(),
+		})
+	}
+	return fc
+}
+
+// WriteGeoJSON 以 GeoJSON 格式输出位置
+func WriteGeoJSON(w io.Writer, places []*Place) error {
+	return json.NewEncoder(w).Encode(GeoJSON(places))
+}
+
+// properties 要素属性，只保留有值的字段，便于在 QGIS 等工具中显示
+func (p *Place) properties() map[string]interface{} {
+	props := map[string]interface{}{
+		"source": p.Source,
+		"name":   p.Name(),
+		"time":   p.Time.Format(time.RFC3339),
+	}
+	set := func(key, value string) {
+		if value != "" {
+			props[key] = value
+		}
+	}
+	set("label", p.Label)
+	set("address", p.Address)
+	set("city", p.City)
+	set("talker", p.Talker)
+	set("talkerName", p.TalkerName)
+	set("sender", p.Sender)
+	set("senderName", p.SenderName)
+	set("content", p.Content)
+	if p.Seq != 0 {
+		props["seq"] = p.Seq
+	}
+	if p.TID != 0 {
+		props["tid"] = strconv.FormatInt(p.TID, 10)
+	}
+	return props
+}
+
+// Name 位置名称，依次使用地点名称、地址与城市
+func (p *Place) Name() string {
+	switch {
+	case p.Label != "":
+		return p.Label
+	case p.Address != "":
+		return p.Address
+	case p.City != "":
+		return p.City
+	default:
+		return fmt.Sprintf("%.6f,%.6f", p.Latitude, p.Longitude)
+	}
+}
+
+type kml struct {
+	XMLName  xml.Name    `xml:"kml"`
+	Xmlns    string      `xml:"xmlns,attr"`
+	Document kmlDocument `xml:"Document"`
+}
+
+type kmlDocument struct {
+	Name       string          `xml:"name"`
+	Placemarks []*kmlPlacemark `xml:"Placemark"`
+}
+
+type kmlPlacemark struct {
+	Name         string       `xml:"name"`
+	Description  string       `xml:"description,omitempty"`
+	TimeStamp    kmlTimeStamp `xml:"TimeStamp"`
+	ExtendedData []kmlData    `xml:"ExtendedData>Data"`
+	Point        kmlPoint     `xml:"Point"`
+}
+
+type kmlTimeStamp struct {
+	When string `xml:"when"`
+}
+
+type kmlData struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value"`
+}
+
+type kmlPoint struct {
+	Coordinates string `xml:"coordinates"`
+}
+
+// WriteKML 以 KML 格式输出位置，时间写入 TimeStamp，其余属性写入 ExtendedData
+func WriteKML(w io.Writer, name string, places []*Place) error {
+	doc := kml{
+		Xmlns: "http://www.opengis.net/kml/2.2",
+		Document: kmlDocument{
+			Name:       name,
+			Placemarks: make([]*kmlPlacemark, 0, len(places)),
+		},
+	}
+	for _, p := range places {
+		pm := &kmlPlacemark{
+			Name:        p.Name(),
+			Description: p.Content,
+			TimeStamp:   kmlTimeStamp{When: p.Time.Format(time.RFC3339)},
+			Point:       kmlPoint{Coordinates: fmt.Sprintf("%.6f,%.6f", p.Longitude, p.Latitude)},
+		}
+		props := p.properties()
+		for _, key := range []string{"source", "label", "address", "city", "talker", "talkerName", "sender", "senderName", "seq", "tid"} {
+			if value, ok := props[key]; ok {
+				pm.ExtendedData = append(pm.ExtendedData, kmlData{Name: key, Value: fmt.Sprint(value)})
+			}
+		}
+		doc.Document.Placemarks = append(doc.Document.Placemarks, pm)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,186 @@
+package geo
+
+import (
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
+	"github.com/sjzar/chatlog/internal/wechatdb"
+	"github.com/sjzar/chatlog/pkg/util"
+)
+
+// 位置来源
+const (
+	SourceMessage = "message"
+	SourceSNS     = "sns"
+)
+
+// 坐标系，微信记录的是 GCJ-02 坐标，QGIS 等工具通常需要 WGS-84
+const (
+	CoordWGS84 = "wgs84"
+	CoordGCJ02 = "gcj02"
+)
+
+// Source 收集位置所需的数据接口，由 database.Service 实现
+type Source interface {
+	GetSessions(key string, limit, offset int) (*wechatdb.GetSessionsResp, error)
+	GetMessagesByType(start, end time.Time, talker string, types ...int64) ([]*model.Message, error)
+	GetSNSTimeline(username string, start, end time.Time, limit, offset int) ([]*model.SNSPost, error)
+}
+
+// Place 聊天中分享的位置或朋友圈的定位
+type Place struct {
+	Source    string    `json:"source"`
+	Latitude  float64   `json:"latitude"`
+	Longitude float64   `json:"longitude"`
+	Label     string    `json:"label,omitempty"`
+	Address   string    `json:"address,omitempty"`
+	City      string    `json:"city,omitempty"`
+	Time      time.Time `json:"time"`
+
+	// 聊天位置消息
+	Talker     string `json:"talker,omitempty"`
+	TalkerName string `json:"talkerName,omitempty"`
+	Sender     string `json:"sender,omitempty"`
+	SenderName string `json:"senderName,omitempty"`
+	Seq        int64  `json:"seq,omitempty"`
+
+	// 朋友圈定位，Sender 为发布人
+	TID     int64  `json:"tid,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+// Query 收集条件，Talker 多个以英文逗号分隔，可以是 ID 或名称，对朋友圈匹配发布人
+type Query struct {
+	Start  time.Time
+	End    time.Time
+	Talker string
+
+	// Source 为 message 或 sns，为空时两者都收集
+	Source string
+
+	// Coord 输出坐标系，默认 wgs84
+	Coord string
+}
+
+// Collect 收集时间范围内的位置消息与朋友圈定位，按时间排序，没有坐标的记录会被忽略
+func Collect(src Source, q Query) ([]*Place, error) {
+	switch q.Source {
+	case "", SourceMessage, SourceSNS:
+	default:
+		return nil, errors.InvalidArg("source")
+	}
+	switch q.Coord {
+	case "":
+		q.Coord = CoordWGS84
+	case CoordWGS84, CoordGCJ02:
+	default:
+		return nil, errors.InvalidArg("coord")
+	}
+	talkers := util.Str2List(q.Talker, ",")
+
+	places := make([]*Place, 0)
+	if q.Source != SourceSNS {
+		sessions, err := src.GetSessions("", 0, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, session := range sessions.Items {
+			if session.UserName == "" || session.NTime.Before(q.Start) {
+				continue
+			}
+			if len(talkers) > 0 && !slices.Contains(talkers, session.UserName) && !slices.Contains(talkers, session.NickName) {
+				continue
+			}
+			messages, err := src.GetMessagesByType(q.Start, q.End, session.UserName, model.MessageTypeLocation)
+			if err != nil {
+				log.Debug().Err(err).Msgf("get messages failed: %s", session.UserName)
+				continue
+			}
+			for _, msg := range messages {
+				if p := fromMessage(msg); p != nil {
+					places = append(places, p)
+				}
+			}
+		}
+	}
+
+	if q.Source != SourceMessage {
+		posts, err := src.GetSNSTimeline("", q.Start, q.End, 0, 0)
+		if err != nil {
+			// 未解密朋友圈数据库时只返回聊天中的位置
+			if q.Source == SourceSNS {
+				return nil, err
+			}
+			log.Debug().Err(err).Msg("get sns timeline failed")
+		}
+		for _, post := range posts {
+			p := fromSNS(post)
+			if p == nil {
+				continue
+			}
+			if len(talkers) > 0 && !slices.Contains(talkers, p.Sender) && !slices.Contains(talkers, p.SenderName) {
+				continue
+			}
+			places = append(places, p)
+		}
+	}
+
+	if q.Coord == CoordWGS84 {
+		for _, p := range places {
+			p.Latitude, p.Longitude = GCJ02ToWGS84(p.Latitude, p.Longitude)
+		}
+	}
+	sort.SliceStable(places, func(i, j int) bool { return places[i].Time.Before(places[j].Time) })
+	return places, nil
+}
+
+// fromMessage 位置消息中 x 为纬度，y 为经度
+func fromMessage(msg *model.Message) *Place {
+	x, _ := msg.Contents["x"].(string)
+	y, _ := msg.Contents["y"].(string)
+	lat, err1 := strconv.ParseFloat(x, 64)
+	lon, err2 := strconv.ParseFloat(y, 64)
+	if err1 != nil || err2 != nil || (lat == 0 && lon == 0) {
+		return nil
+	}
+	p := &Place{
+		Source:     SourceMessage,
+		Latitude:   lat,
+		Longitude:  lon,
+		Time:       msg.Time,
+		Talker:     msg.Talker,
+		TalkerName: msg.TalkerName,
+		Sender:     msg.Sender,
+		SenderName: msg.SenderName,
+		Seq:        msg.Seq,
+	}
+	p.Label, _ = msg.Contents["label"].(string)
+	p.City, _ = msg.Contents["cityname"].(string)
+	return p
+}
+
+func fromSNS(post *model.SNSPost) *Place {
+	loc := post.Location
+	if loc == nil || (loc.Latitude == 0 && loc.Longitude == 0) {
+		return nil
+	}
+	return &Place{
+		Source:     SourceSNS,
+		Latitude:   loc.Latitude,
+		Longitude:  loc.Longitude,
+		Label:      loc.POIName,
+		Address:    loc.POIAddress,
+		City:       loc.City,
+		Time:       post.Time(),
+		Sender:     post.UserName,
+		SenderName: post.NickName,
+		TID:        post.TID,
+		Content:    post.ContentDesc,
+	}
+}
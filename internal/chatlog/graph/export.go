@@ -0,0 +1,210 @@
+package graph
+
+import (
+	"encoding/xml"
+	"io"
+	"strconv"
+)
+
+// 导出时附带的边属性，顺序即 GraphML key 与 GEXF attribute 的顺序
+var edgeAttrs = []struct {
+	name  string
+	value func(e *Edge) int64
+}{
+	{"sharedGroups", func(e *Edge) int64 { return e.SharedGroups }},
+	{"directMessages", func(e *Edge) int64 { return e.DirectMessages }},
+	{"replies", func(e *Edge) int64 { return e.Replies }},
+	{"mentions", func(e *Edge) int64 { return e.Mentions }},
+	{"pats", func(e *Edge) int64 { return e.Pats }},
+}
+
+type graphML struct {
+	XMLName xml.Name     `xml:"graphml"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLGraph struct {
+	ID          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+// WriteGraphML 导出为 GraphML 格式
+func (g *Graph) WriteGraphML(w io.Writer) error {
+	doc := graphML{
+		Xmlns: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []graphMLKey{
+			{ID: "name", For: "node", Name: "name", Type: "string"},
+			{ID: "isSelf", For: "node", Name: "isSelf", Type: "boolean"},
+			{ID: "groups", For: "node", Name: "groups", Type: "int"},
+			{ID: "weight", For: "edge", Name: "weight", Type: "double"},
+		},
+		Graph: graphMLGraph{
+			ID:          "chatlog",
+			EdgeDefault: "undirected",
+			Nodes:       make([]graphMLNode, 0, len(g.Nodes)),
+			Edges:       make([]graphMLEdge, 0, len(g.Edges)),
+		},
+	}
+	for _, attr := range edgeAttrs {
+		doc.Keys = append(doc.Keys, graphMLKey{ID: attr.name, For: "edge", Name: attr.name, Type: "long"})
+	}
+
+	for _, n := range g.Nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{
+			ID: n.ID,
+			Data: []graphMLData{
+				{Key: "name", Value: n.Label()},
+				{Key: "isSelf", Value: strconv.FormatBool(n.IsSelf)},
+				{Key: "groups", Value: strconv.Itoa(n.Groups)},
+			},
+		})
+	}
+	for _, e := range g.Edges {
+		data := []graphMLData{{Key: "weight", Value: formatWeight(e.Weight)}}
+		for _, attr := range edgeAttrs {
+			data = append(data, graphMLData{Key: attr.name, Value: strconv.FormatInt(attr.value(e), 10)})
+		}
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{Source: e.Source, Target: e.Target, Data: data})
+	}
+
+	return writeXML(w, doc)
+}
+
+type gexf struct {
+	XMLName xml.Name  `xml:"gexf"`
+	Xmlns   string    `xml:"xmlns,attr"`
+	Version string    `xml:"version,attr"`
+	Graph   gexfGraph `xml:"graph"`
+}
+
+type gexfGraph struct {
+	DefaultEdgeType string           `xml:"defaultedgetype,attr"`
+	Mode            string           `xml:"mode,attr"`
+	Attributes      []gexfAttributes `xml:"attributes"`
+	Nodes           []gexfNode       `xml:"nodes>node"`
+	Edges           []gexfEdge       `xml:"edges>edge"`
+}
+
+type gexfAttributes struct {
+	Class      string          `xml:"class,attr"`
+	Attributes []gexfAttribute `xml:"attribute"`
+}
+
+type gexfAttribute struct {
+	ID    string `xml:"id,attr"`
+	Title string `xml:"title,attr"`
+	Type  string `xml:"type,attr"`
+}
+
+type gexfNode struct {
+	ID        string         `xml:"id,attr"`
+	Label     string         `xml:"label,attr"`
+	AttValues []gexfAttValue `xml:"attvalues>attvalue"`
+}
+
+type gexfEdge struct {
+	ID        string         `xml:"id,attr"`
+	Source    string         `xml:"source,attr"`
+	Target    string         `xml:"target,attr"`
+	Weight    string         `xml:"weight,attr"`
+	AttValues []gexfAttValue `xml:"attvalues>attvalue"`
+}
+
+type gexfAttValue struct {
+	For   string `xml:"for,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// WriteGEXF 导出为 GEXF 1.3 格式，可直接导入 Gephi
+func (g *Graph) WriteGEXF(w io.Writer) error {
+	doc := gexf{
+		Xmlns:   "http://gexf.net/1.3",
+		Version: "1.3",
+		Graph: gexfGraph{
+			DefaultEdgeType: "undirected",
+			Mode:            "static",
+			Attributes: []gexfAttributes{
+				{Class: "node", Attributes: []gexfAttribute{
+					{ID: "isSelf", Title: "isSelf", Type: "boolean"},
+					{ID: "groups", Title: "groups", Type: "integer"},
+				}},
+				{Class: "edge"},
+			},
+			Nodes: make([]gexfNode, 0, len(g.Nodes)),
+			Edges: make([]gexfEdge, 0, len(g.Edges)),
+		},
+	}
+	for _, attr := range edgeAttrs {
+		doc.Graph.Attributes[1].Attributes = append(doc.Graph.Attributes[1].Attributes,
+			gexfAttribute{ID: attr.name, Title: attr.name, Type: "long"})
+	}
+
+	for _, n := range g.Nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, gexfNode{
+			ID:    n.ID,
+			Label: n.Label(),
+			AttValues: []gexfAttValue{
+				{For: "isSelf", Value: strconv.FormatBool(n.IsSelf)},
+				{For: "groups", Value: strconv.Itoa(n.Groups)},
+			},
+		})
+	}
+	for i, e := range g.Edges {
+		values := make([]gexfAttValue, 0, len(edgeAttrs))
+		for _, attr := range edgeAttrs {
+			values = append(values, gexfAttValue{For: attr.name, Value: strconv.FormatInt(attr.value(e), 10)})
+		}
+		doc.Graph.Edges = append(doc.Graph.Edges, gexfEdge{
+			ID:        strconv.Itoa(i),
+			Source:    e.Source,
+			Target:    e.Target,
+			Weight:    formatWeight(e.Weight),
+			AttValues: values,
+		})
+	}
+
+	return writeXML(w, doc)
+}
+
+func writeXML(w io.Writer, v interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+func formatWeight(v float64) string {
+	return strconv.FormatFloat(v, 'f', 4, 64)
+}
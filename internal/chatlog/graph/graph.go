@@ -0,0 +1,363 @@
+package graph
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/model"
+	"github.com/sjzar/chatlog/internal/wechatdb"
+	"github.com/sjzar/chatlog/pkg/util"
+)
+
+const (
+	// DefaultMaxGroupSize 超过该人数的群聊不生成共同群边，避免边数量随人数平方增长
+	DefaultMaxGroupSize = 100
+
+	// 边权重：共同群聊与每次互动计 1，私聊消息数取对数后计入，避免高频私聊淹没其他关系
+	weightSharedGroup   = 1.0
+	weightInteraction   = 1.0
+	weightDirectMessage = 2.0
+)
+
+// Source 构建关系图所需的数据接口，由 database.Service 实现
+type Source interface {
+	GetContacts(key string, limit, offset int) (*wechatdb.GetContactsResp, error)
+	GetChatRooms(key string, limit, offset int) (*wechatdb.GetChatRoomsResp, error)
+	GetSessions(key string, limit, offset int) (*wechatdb.GetSessionsResp, error)
+	GetChatStats(start, end time.Time, talker string) (*model.ChatStats, error)
+	GetMessages(start, end time.Time, talker string, sender string, keyword string, limit, offset int) ([]*model.Message, error)
+	GetMessagesByType(start, end time.Time, talker string, types ...int64) ([]*model.Message, error)
+}
+
+// Options 关系图构建参数
+type Options struct {
+	Start time.Time
+	End   time.Time
+
+	// Talker 限定参与统计的会话，多个以英文逗号分隔，为空时统计全部会话
+	Talker string
+
+	// MaxGroupSize 生成共同群边的群聊人数上限
+	MaxGroupSize int
+}
+
+// Graph 以联系人为节点、以共同群聊与互动为边的无向加权关系图
+type Graph struct {
+	Self  string  `json:"self,omitempty"`
+	Nodes []*Node `json:"nodes"`
+	Edges []*Edge `json:"edges"`
+
+	nodes      map[string]*Node
+	edges      map[[2]string]*Edge
+	adj        map[string][]*Edge
+	groups     map[string][]string // 成员 → 所在群聊
+	groupNames map[string]string
+}
+
+type Node struct {
+	ID     string `json:"id"`
+	Name   string `json:"name,omitempty"`
+	IsSelf bool   `json:"isSelf,omitempty"`
+
+	// Groups 所在群聊数量
+	Groups int `json:"groups"`
+}
+
+// Label 返回用于展示的名称
+func (n *Node) Label() string {
+	if n.Name != "" {
+		return n.Name
+	}
+	return n.ID
+}
+
+// Edge 两人之间的关系，互动次数不区分方向
+type Edge struct {
+	Source         string  `json:"source"`
+	Target         string  `json:"target"`
+	Weight         float64 `json:"weight"`
+	SharedGroups   int64   `json:"sharedGroups"`
+	DirectMessages int64   `json:"directMessages"`
+	Replies        int64   `json:"replies"`
+	Mentions       int64   `json:"mentions"`
+	Pats           int64   `json:"pats"`
+}
+
+// Other 返回边的另一端
+func (e *Edge) Other(id string) string {
+	if e.Source == id {
+		return e.Target
+	}
+	return e.Source
+}
+
+func (e *Edge) updateWeight() {
+	e.Weight = float64(e.SharedGroups)*weightSharedGroup +
+		float64(e.Replies+e.Mentions+e.Pats)*weightInteraction +
+		math.Log2(1+float64(e.DirectMessages))*weightDirectMessage
+}
+
+// Node 按 ID 获取节点
+func (g *Graph) Node(id string) *Node {
+	return g.nodes[id]
+}
+
+// Build 构建关系图
+func Build(src Source, opts Options) (*Graph, error) {
+	if opts.MaxGroupSize <= 0 {
+		opts.MaxGroupSize = DefaultMaxGroupSize
+	}
+
+	b := &builder{
+		src:  src,
+		opts: opts,
+		g: &Graph{
+			nodes:      make(map[string]*Node),
+			edges:      make(map[[2]string]*Edge),
+			adj:        make(map[string][]*Edge),
+			groups:     make(map[string][]string),
+			groupNames: make(map[string]string),
+		},
+		names:   make(map[string]string),
+		talkers: make(map[string]bool),
+		dm:      make(map[string]int64),
+	}
+	for _, talker := range util.Str2List(opts.Talker, ",") {
+		b.talkers[talker] = true
+	}
+
+	if err := b.loadContacts(); err != nil {
+		return nil, err
+	}
+	rooms, err := b.loadChatRooms()
+	if err != nil {
+		return nil, err
+	}
+	if err := b.loadSessions(rooms); err != nil {
+		return nil, err
+	}
+	b.addDirectMessages()
+
+	return b.g.finish(), nil
+}
+
+type builder struct {
+	src     Source
+	opts    Options
+	g       *Graph
+	names   map[string]string
+	talkers map[string]bool
+	self    string
+	dm      map[string]int64 // 私聊对象 → 消息数
+}
+
+func (b *builder) selected(talker string) bool {
+	return len(b.talkers) == 0 || b.talkers[talker]
+}
+
+func (b *builder) loadContacts() error {
+	contacts, err := b.src.GetContacts("", 0, 0)
+	if err != nil {
+		return err
+	}
+	for _, contact := range contacts.Items {
+		b.names[contact.UserName] = contact.DisplayName()
+	}
+	return nil
+}
+
+// loadChatRooms 记录群成员关系，并为人数不超过上限的群聊生成共同群边
+func (b *builder) loadChatRooms() (map[string]*model.ChatRoom, error) {
+	chatRooms, err := b.src.GetChatRooms("", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	rooms := make(map[string]*model.ChatRoom)
+	for _, room := range chatRooms.Items {
+		if !b.selected(room.Name) {
+			continue
+		}
+		rooms[room.Name] = room
+		b.g.groupNames[room.Name] = room.DisplayName()
+
+		for _, user := range room.Users {
+			b.node(user.UserName)
+			b.g.groups[user.UserName] = append(b.g.groups[user.UserName], room.Name)
+		}
+		if len(room.Users) > b.opts.MaxGroupSize {
+			continue
+		}
+		for i := 0; i < len(room.Users); i++ {
+			for j := i + 1; j < len(room.Users); j++ {
+				if e := b.edge(room.Users[i].UserName, room.Users[j].UserName); e != nil {
+					e.SharedGroups++
+				}
+			}
+		}
+	}
+	return rooms, nil
+}
+
+// loadSessions 统计时间范围内有消息的会话：私聊累计消息数，群聊解析引用、拍一拍与 @
+func (b *builder) loadSessions(rooms map[string]*model.ChatRoom) error {
+	sessions, err := b.src.GetSessions("", 0, 0)
+	if err != nil {
+		return err
+	}
+
+	for _, session := range sessions.Items {
+		talker := session.UserName
+		if !b.selected(talker) || session.NTime.Before(b.opts.Start) || ignoreTalker(talker) {
+			continue
+		}
+		if room, ok := rooms[talker]; ok {
+			b.collectChatRoom(room)
+			continue
+		}
+		if strings.HasSuffix(talker, "@chatroom") {
+			continue
+		}
+
+		stats, err := b.src.GetChatStats(b.opts.Start, b.opts.End, talker)
+		if err != nil {
+			log.Debug().Err(err).Msgf("get chat stats failed: %s", talker)
+			continue
+		}
+		if stats.Total == 0 {
+			continue
+		}
+		b.dm[talker] += stats.Total
+		if b.names[talker] == "" {
+			b.names[talker] = session.NickName
+		}
+		// 私聊中除对方与系统消息外的发送者即为当前账号
+		for _, sender := range stats.Senders {
+			if b.self == "" && sender.Sender != talker && sender.Sender != "系统消息" && sender.Sender != "" {
+				b.self = sender.Sender
+			}
+		}
+	}
+	return nil
+}
+
+func (b *builder) collectChatRoom(room *model.ChatRoom) {
+	messages, err := b.src.GetMessagesByType(b.opts.Start, b.opts.End, room.Name, model.MessageTypeShare)
+	if err != nil {
+		log.Debug().Err(err).Msgf("get messages failed: %s", room.Name)
+		return
+	}
+	for _, msg := range messages {
+		b.detectSelf(msg)
+		switch msg.SubType {
+		case model.MessageSubTypeQuote:
+			if refer, ok := msg.Contents["refer"].(*model.Message); ok {
+				if e := b.edge(msg.Sender, refer.Sender); e != nil {
+					e.Replies++
+				}
+			}
+		case model.MessageSubTypePat:
+			patted, _ := msg.Contents["patted"].(string)
+			if e := b.edge(msg.Sender, patted); e != nil {
+				e.Pats++
+			}
+		}
+	}
+
+	messages, err = b.src.GetMessages(b.opts.Start, b.opts.End, room.Name, "", "@", 0, 0)
+	if err != nil {
+		log.Debug().Err(err).Msgf("get messages failed: %s", room.Name)
+		return
+	}
+	for _, msg := range messages {
+		b.detectSelf(msg)
+		for _, user := range msg.Mentions {
+			if e := b.edge(msg.Sender, user); e != nil {
+				e.Mentions++
+			}
+		}
+	}
+}
+
+func (b *builder) detectSelf(msg *model.Message) {
+	if b.self == "" && msg.IsSelf && msg.Sender != "" {
+		b.self = msg.Sender
+	}
+}
+
+func (b *builder) addDirectMessages() {
+	if b.self == "" {
+		return
+	}
+	b.g.Self = b.self
+	b.node(b.self).IsSelf = true
+	for talker, count := range b.dm {
+		if e := b.edge(b.self, talker); e != nil {
+			e.DirectMessages += count
+		}
+	}
+}
+
+func (b *builder) node(id string) *Node {
+	n, ok := b.g.nodes[id]
+	if !ok {
+		n = &Node{ID: id, Name: b.names[id]}
+		b.g.nodes[id] = n
+	}
+	return n
+}
+
+// edge 获取两人之间的边，同一人或 ID 为空时返回 nil
+func (b *builder) edge(a, c string) *Edge {
+	if a == "" || c == "" || a == c || a == "系统消息" || c == "系统消息" {
+		return nil
+	}
+	if a > c {
+		a, c = c, a
+	}
+	key := [2]string{a, c}
+	e, ok := b.g.edges[key]
+	if !ok {
+		b.node(a)
+		b.node(c)
+		e = &Edge{Source: a, Target: c}
+		b.g.edges[key] = e
+	}
+	return e
+}
+
+// finish 计算权重并生成有序的节点与边列表
+func (g *Graph) finish() *Graph {
+	g.Nodes = make([]*Node, 0, len(g.nodes))
+	for id, n := range g.nodes {
+		n.Groups = len(g.groups[id])
+		g.Nodes = append(g.Nodes, n)
+	}
+	sort.Slice(g.Nodes, func(i, j int) bool { return g.Nodes[i].ID < g.Nodes[j].ID })
+
+	g.Edges = make([]*Edge, 0, len(g.edges))
+	for _, e := range g.edges {
+		e.updateWeight()
+		g.Edges = append(g.Edges, e)
+		g.adj[e.Source] = append(g.adj[e.Source], e)
+		g.adj[e.Target] = append(g.adj[e.Target], e)
+	}
+	sort.Slice(g.Edges, func(i, j int) bool {
+		if g.Edges[i].Weight != g.Edges[j].Weight {
+			return g.Edges[i].Weight > g.Edges[j].Weight
+		}
+		if g.Edges[i].Source != g.Edges[j].Source {
+			return g.Edges[i].Source < g.Edges[j].Source
+		}
+		return g.Edges[i].Target < g.Edges[j].Target
+	})
+	return g
+}
+
+func ignoreTalker(talker string) bool {
+	return talker == "" || talker == "filehelper" || strings.HasPrefix(talker, "@") || strings.HasPrefix(talker, "gh_")
+}
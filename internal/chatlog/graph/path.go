@@ -0,0 +1,154 @@
+package graph
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// Connection 两人之间的关系说明，用于回答 "谁能把我介绍给 X"
+type Connection struct {
+	Source *Node `json:"source"`
+	Target *Node `json:"target"`
+
+	// Direct 两人之间的直接关系，没有时为空
+	Direct *Edge `json:"direct,omitempty"`
+
+	// SharedGroups 共同所在的群聊名称
+	SharedGroups []string `json:"sharedGroups"`
+
+	// Intermediaries 与双方都有关系的人，按关系强度降序
+	Intermediaries []*Intermediary `json:"intermediaries"`
+
+	// Path 关系最强的路径（包含两端），不可达时为空
+	Path []*Node `json:"path"`
+}
+
+// Intermediary 中间人，Strength 取其与双方关系权重的较小值
+type Intermediary struct {
+	Node     *Node   `json:"node"`
+	Strength float64 `json:"strength"`
+	ToSource *Edge   `json:"toSource"`
+	ToTarget *Edge   `json:"toTarget"`
+}
+
+// Connect 查找 source 与 target 之间的关系，limit 限制返回的中间人数量
+func (g *Graph) Connect(source, target string, limit int) *Connection {
+	c := &Connection{
+		Source:         g.nodes[source],
+		Target:         g.nodes[target],
+		SharedGroups:   make([]string, 0),
+		Intermediaries: make([]*Intermediary, 0),
+		Path:           make([]*Node, 0),
+	}
+	if c.Source == nil || c.Target == nil {
+		return c
+	}
+
+	groups := make(map[string]bool)
+	for _, group := range g.groups[source] {
+		groups[group] = true
+	}
+	for _, group := range g.groups[target] {
+		if groups[group] {
+			name := g.groupNames[group]
+			if name == "" {
+				name = group
+			}
+			c.SharedGroups = append(c.SharedGroups, name)
+		}
+	}
+	sort.Strings(c.SharedGroups)
+
+	toSource := make(map[string]*Edge)
+	for _, e := range g.adj[source] {
+		toSource[e.Other(source)] = e
+	}
+	for _, e := range g.adj[target] {
+		other := e.Other(target)
+		if other == source {
+			c.Direct = e
+			continue
+		}
+		if se, ok := toSource[other]; ok {
+			c.Intermediaries = append(c.Intermediaries, &Intermediary{
+				Node:     g.nodes[other],
+				Strength: min(se.Weight, e.Weight),
+				ToSource: se,
+				ToTarget: e,
+			})
+		}
+	}
+	sort.Slice(c.Intermediaries, func(i, j int) bool {
+		if c.Intermediaries[i].Strength != c.Intermediaries[j].Strength {
+			return c.Intermediaries[i].Strength > c.Intermediaries[j].Strength
+		}
+		return c.Intermediaries[i].Node.ID < c.Intermediaries[j].Node.ID
+	})
+	if limit > 0 && len(c.Intermediaries) > limit {
+		c.Intermediaries = c.Intermediaries[:limit]
+	}
+
+	for _, id := range g.strongestPath(source, target) {
+		c.Path = append(c.Path, g.nodes[id])
+	}
+	return c
+}
+
+// strongestPath 以 1/weight 为代价求最短路径，即关系整体最强的路径
+func (g *Graph) strongestPath(source, target string) []string {
+	dist := map[string]float64{source: 0}
+	prev := make(map[string]string)
+	pq := &pathQueue{{id: source}}
+	for pq.Len() > 0 {
+		item := heap.Pop(pq).(pathItem)
+		if item.id == target {
+			break
+		}
+		if item.cost > dist[item.id] {
+			continue
+		}
+		for _, e := range g.adj[item.id] {
+			if e.Weight <= 0 {
+				continue
+			}
+			next := e.Other(item.id)
+			cost := item.cost + 1/e.Weight
+			if d, ok := dist[next]; !ok || cost < d {
+				dist[next] = cost
+				prev[next] = item.id
+				heap.Push(pq, pathItem{id: next, cost: cost})
+			}
+		}
+	}
+
+	if _, ok := dist[target]; !ok {
+		return nil
+	}
+	path := []string{target}
+	for id := target; id != source; {
+		id = prev[id]
+		path = append(path, id)
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+type pathItem struct {
+	id   string
+	cost float64
+}
+
+type pathQueue []pathItem
+
+func (q pathQueue) Len() int            { return len(q) }
+func (q pathQueue) Less(i, j int) bool  { return q[i].cost < q[j].cost }
+func (q pathQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *pathQueue) Push(x interface{}) { *q = append(*q, x.(pathItem)) }
+func (q *pathQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
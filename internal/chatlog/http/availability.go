@@ -0,0 +1,61 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sjzar/chatlog/internal/chatlog/export"
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/pkg/util"
+)
+
+// handleMediaAvailability 统计媒体消息的可用性，time 默认为全部时间，talker 为空时统计全部会话
+// kind 可选 image/video/file/voice，detail=1 时返回全部无法解码与缺失的媒体
+func (s *Service) handleMediaAvailability(c *gin.Context) {
+	q := struct {
+		Time   string `form:"time"`
+		Talker string `form:"talker"`
+		Kind   string `form:"kind"`
+		Detail bool   `form:"detail"`
+		Format string `form:"format"`
+	}{}
+
+	if err := c.BindQuery(&q); err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	if q.Time == "" {
+		q.Time = "all"
+	}
+	start, end, ok := util.TimeRangeOf(q.Time)
+	if !ok {
+		errors.Err(c, errors.InvalidArg("time"))
+		return
+	}
+
+	report, err := export.Scan(c.Request.Context(), s.db, export.Options{
+		Start:   start,
+		End:     end,
+		Talker:  q.Talker,
+		Kinds:   util.Str2List(strings.ToLower(q.Kind), ","),
+		DataDir: s.conf.GetDataDir(),
+	}, q.Detail)
+	if err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	switch strings.ToLower(q.Format) {
+	case "csv":
+		c.Writer.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		report.WriteCSV(c.Writer)
+	case "text":
+		c.Writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		report.WriteText(c.Writer)
+	default:
+		c.JSON(http.StatusOK, report)
+	}
+}
@@ -0,0 +1,153 @@
+package http
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/chatlog/catalog"
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/pkg/util"
+)
+
+func (s *Service) initCatalog() {
+	err := s.db.AddCallback("message", func(event fsnotify.Event) error {
+		if event.Op.Has(fsnotify.Create) || event.Op.Has(fsnotify.Write) {
+			s.catalog.Invalidate()
+		}
+		return nil
+	})
+	if err != nil {
+		log.Debug().Err(err).Msg("set catalog callback failed")
+	}
+	s.db.AddStopHook(s.catalog.Reset)
+}
+
+// catalogQuery 链接与文件接口共用的查询参数，time 默认为全部时间
+type catalogQuery struct {
+	Time    string `form:"time"`
+	Talker  string `form:"talker"`
+	Sender  string `form:"sender"`
+	Keyword string `form:"keyword"`
+	Domain  string `form:"domain"`
+	Kind    string `form:"kind"`
+	Ext     string `form:"ext"`
+	Limit   int    `form:"limit"`
+	Offset  int    `form:"offset"`
+	Format  string `form:"format"`
+}
+
+func (s *Service) bindCatalogQuery(c *gin.Context) (*catalogQuery, catalog.Query, bool) {
+	var q catalogQuery
+	if err := c.BindQuery(&q); err != nil {
+		errors.Err(c, err)
+		return nil, catalog.Query{}, false
+	}
+	if q.Time == "" {
+		q.Time = "all"
+	}
+	start, end, ok := util.TimeRangeOf(q.Time)
+	if !ok {
+		errors.Err(c, errors.InvalidArg("time"))
+		return nil, catalog.Query{}, false
+	}
+	return &q, catalog.Query{
+		Start:   start,
+		End:     end,
+		Talker:  q.Talker,
+		Sender:  q.Sender,
+		Keyword: q.Keyword,
+		Domain:  q.Domain,
+		Kind:    strings.ToLower(q.Kind),
+		Ext:     q.Ext,
+		Limit:   max(q.Limit, 0),
+		Offset:  max(q.Offset, 0),
+	}, true
+}
+
+// handleLinks 查询全部会话中分享的链接、小程序与视频号卡片
+func (s *Service) handleLinks(c *gin.Context) {
+	q, query, ok := s.bindCatalogQuery(c)
+	if !ok {
+		return
+	}
+
+	links, total, err := s.catalog.Links(query)
+	if err != nil {
+		errors.Err(c, err)
+		return
+	}
+	c.Header("X-Total-Count", strconv.Itoa(total))
+
+	switch strings.ToLower(q.Format) {
+	case "csv":
+		c.Writer.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w := csv.NewWriter(c.Writer)
+		w.Write([]string{"Seq", "Time", "Talker", "TalkerName", "Sender", "SenderName", "Kind", "Title", "Desc", "URL", "Domain"})
+		for _, l := range links {
+			w.Write([]string{strconv.FormatInt(l.Seq, 10), l.Time.Format("2006-01-02 15:04:05"), l.Talker, l.TalkerName, l.Sender, l.SenderName, l.Kind, l.Title, l.Desc, l.URL, l.Domain})
+		}
+		w.Flush()
+	case "text":
+		c.Writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, l := range links {
+			c.Writer.WriteString(fmt.Sprintf("[%d] %s %s %s\n%s\n", l.Seq, l.Time.Format("2006-01-02 15:04"), displayName(l.TalkerName, l.Talker), displayName(l.SenderName, l.Sender), l.Title))
+			if l.URL != "" {
+				c.Writer.WriteString(l.URL + "\n")
+			}
+		}
+	default:
+		c.JSON(http.StatusOK, gin.H{"total": total, "items": links})
+	}
+}
+
+// handleFiles 查询全部会话中分享的文件，url 字段可通过 /file/*key 下载本地已保存的文件
+func (s *Service) handleFiles(c *gin.Context) {
+	q, query, ok := s.bindCatalogQuery(c)
+	if !ok {
+		return
+	}
+
+	files, total, err := s.catalog.Files(query)
+	if err != nil {
+		errors.Err(c, err)
+		return
+	}
+	for _, f := range files {
+		if f.MD5 != "" {
+			f.URL = fmt.Sprintf("http://%s/file/%s", c.Request.Host, f.MD5)
+		}
+	}
+	c.Header("X-Total-Count", strconv.Itoa(total))
+
+	switch strings.ToLower(q.Format) {
+	case "csv":
+		c.Writer.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w := csv.NewWriter(c.Writer)
+		w.Write([]string{"Seq", "Time", "Talker", "TalkerName", "Sender", "SenderName", "Name", "Ext", "Size", "MD5", "Path", "URL"})
+		for _, f := range files {
+			w.Write([]string{strconv.FormatInt(f.Seq, 10), f.Time.Format("2006-01-02 15:04:05"), f.Talker, f.TalkerName, f.Sender, f.SenderName, f.Name, f.Ext, strconv.FormatInt(f.Size, 10), f.MD5, f.Path, f.URL})
+		}
+		w.Flush()
+	case "text":
+		c.Writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, f := range files {
+			c.Writer.WriteString(fmt.Sprintf("[%d] %s %s %s\n%s (%s)\n", f.Seq, f.Time.Format("2006-01-02 15:04"), displayName(f.TalkerName, f.Talker), displayName(f.SenderName, f.Sender), f.Name, util.ByteCountSI(f.Size)))
+		}
+	default:
+		c.JSON(http.StatusOK, gin.H{"total": total, "items": files})
+	}
+}
+
+func displayName(name, id string) string {
+	if name == "" {
+		return id
+	}
+	return name + "(" + id + ")"
+}
@@ -0,0 +1,166 @@
+package http
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/chatlog/emoji"
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/pkg/util"
+	"github.com/sjzar/chatlog/pkg/util/dat2img"
+)
+
+// emojiCacheExts 解码后的表情缓存可能的格式，wxgf 动画在没有 ffmpeg 时转为 mp4
+var emojiCacheExts = []string{dat2img.GIF.Ext, dat2img.WEBP.Ext, dat2img.PNG.Ext, dat2img.JPG.Ext, dat2img.ExtHEIC, dat2img.ExtMP4}
+
+// handleEmoji 按 md5 返回本地缓存的表情，解码结果缓存在工作目录的 cache/emoji 下
+// 本地没有表情文件时重定向到表情数据库中记录的 CDN 地址，info=1 时返回表情信息
+func (s *Service) handleEmoji(c *gin.Context) {
+	md5 := strings.ToLower(strings.TrimPrefix(c.Param("md5"), "/"))
+	md5 = strings.TrimSuffix(md5, filepath.Ext(md5))
+	if _, err := hex.DecodeString(md5); err != nil || len(md5) != 32 {
+		errors.Err(c, errors.InvalidArg("md5"))
+		return
+	}
+
+	index := s.getEmojiIndex()
+	if c.Query("info") != "" {
+		path := index.Find(md5)
+		if rel, err := filepath.Rel(s.conf.GetDataDir(), path); err == nil && path != "" {
+			path = rel
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"md5":     md5,
+			"path":    filepath.ToSlash(path),
+			"sticker": index.Lookup(s.db, md5),
+		})
+		return
+	}
+
+	// 表情按内容的 md5 命名，内容不会变化
+	etag := `"` + md5 + `"`
+	if match := c.GetHeader("If-None-Match"); match != "" && strings.Contains(match, etag) {
+		c.Header("ETag", etag)
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	data, ext, err := s.loadEmoji(index, md5)
+	if err != nil {
+		log.Debug().Err(err).Msgf("load emoji failed: %s", md5)
+		if sticker := index.Lookup(s.db, md5); sticker != nil && sticker.CdnURL != "" {
+			c.Redirect(http.StatusFound, sticker.CdnURL)
+			return
+		}
+		errors.Err(c, errors.ErrMediaNotFound)
+		return
+	}
+
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "private, max-age=86400")
+	contentType := imageContentType(ext)
+	if ext == dat2img.ExtMP4 {
+		contentType = "video/mp4"
+	}
+	c.Data(http.StatusOK, contentType, data)
+}
+
+// handleEmojiLibrary 账号的表情库，统计动画表情的使用次数并合并表情数据库中的收藏表情
+// time 默认为全部时间，talker 为空时统计全部会话，format 可选 json/csv
+func (s *Service) handleEmojiLibrary(c *gin.Context) {
+	q := struct {
+		Time   string `form:"time"`
+		Talker string `form:"talker"`
+		Format string `form:"format"`
+	}{}
+
+	if err := c.BindQuery(&q); err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	if q.Time == "" {
+		q.Time = "all"
+	}
+	start, end, ok := util.TimeRangeOf(q.Time)
+	if !ok {
+		errors.Err(c, errors.InvalidArg("time"))
+		return
+	}
+
+	lib, err := emoji.Build(c.Request.Context(), s.db, s.getEmojiIndex(), start, end, q.Talker)
+	if err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	switch strings.ToLower(q.Format) {
+	case "csv":
+		c.Writer.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w := csv.NewWriter(c.Writer)
+		w.Write([]string{"MD5", "Count", "SelfCount", "Talkers", "First", "Last", "Saved", "Local", "URL"})
+		for _, e := range lib.Items {
+			first, last := "", ""
+			if e.Count > 0 {
+				first, last = e.First.Format(time.DateTime), e.Last.Format(time.DateTime)
+			}
+			w.Write([]string{e.MD5, strconv.Itoa(e.Count), strconv.Itoa(e.SelfCount), strconv.Itoa(e.Talkers),
+				first, last, strconv.FormatBool(e.Saved), strconv.FormatBool(e.Local), "http://" + c.Request.Host + "/emoji/" + e.MD5})
+		}
+		w.Flush()
+	default:
+		c.JSON(http.StatusOK, lib)
+	}
+}
+
+// loadEmoji 读取缓存的表情，缓存不存在时解码本地表情文件并写入缓存
+func (s *Service) loadEmoji(index *emoji.Index, md5 string) ([]byte, string, error) {
+	cacheDir := ""
+	if workDir := s.conf.GetWorkDir(); workDir != "" {
+		cacheDir = filepath.Join(workDir, "cache", "emoji")
+		for _, ext := range emojiCacheExts {
+			if b, err := os.ReadFile(filepath.Join(cacheDir, md5+"."+ext)); err == nil && len(b) > 0 {
+				return b, ext, nil
+			}
+		}
+	}
+
+	path := index.Find(md5)
+	if path == "" {
+		return nil, "", errors.ErrMediaNotFound
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", errors.ReadFileFailed(path, err)
+	}
+	out, ext, err := dat2img.Emoji2Image(b)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if cacheDir != "" {
+		if err := util.WriteFileAtomic(filepath.Join(cacheDir, md5+"."+ext), out); err != nil {
+			log.Debug().Err(err).Msgf("write emoji cache failed: %s", md5)
+		}
+	}
+	return out, ext, nil
+}
+
+// getEmojiIndex 当前数据目录的表情索引，数据目录变化时重新建立
+func (s *Service) getEmojiIndex() *emoji.Index {
+	s.emojiIndexMu.Lock()
+	defer s.emojiIndexMu.Unlock()
+	if s.emojiIndex == nil || s.emojiIndex.DataDir() != s.conf.GetDataDir() {
+		s.emojiIndex = emoji.NewIndex(s.conf.GetDataDir())
+	}
+	return s.emojiIndex
+}
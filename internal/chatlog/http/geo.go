@@ -0,0 +1,62 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sjzar/chatlog/internal/chatlog/geo"
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/pkg/util"
+)
+
+// handleLocations 导出聊天中分享的位置与朋友圈定位，time 默认为全部时间
+// format 为 geojson（默认）、kml 或 json，coord 为 wgs84（默认）或 gcj02
+func (s *Service) handleLocations(c *gin.Context) {
+	q := struct {
+		Time   string `form:"time"`
+		Talker string `form:"talker"`
+		Source string `form:"source"`
+		Coord  string `form:"coord"`
+		Format string `form:"format"`
+	}{}
+
+	if err := c.BindQuery(&q); err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	if q.Time == "" {
+		q.Time = "all"
+	}
+	start, end, ok := util.TimeRangeOf(q.Time)
+	if !ok {
+		errors.Err(c, errors.InvalidArg("time"))
+		return
+	}
+
+	places, err := geo.Collect(s.db, geo.Query{
+		Start:  start,
+		End:    end,
+		Talker: q.Talker,
+		Source: strings.ToLower(q.Source),
+		Coord:  strings.ToLower(q.Coord),
+	})
+	if err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	switch strings.ToLower(q.Format) {
+	case "kml":
+		c.Writer.Header().Set("Content-Type", "application/vnd.google-earth.kml+xml; charset=utf-8")
+		c.Writer.Header().Set("Content-Disposition", "attachment; filename=locations.kml")
+		geo.WriteKML(c.Writer, "chatlog locations", places)
+	case "json":
+		c.JSON(http.StatusOK, places)
+	default:
+		c.Writer.Header().Set("Content-Type", "application/geo+json; charset=utf-8")
+		geo.WriteGeoJSON(c.Writer, places)
+	}
+}
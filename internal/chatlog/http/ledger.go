@@ -0,0 +1,65 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sjzar/chatlog/internal/chatlog/ledger"
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/pkg/util"
+)
+
+// handleLedger 转账与红包账本，time 默认为今年
+// format=csv 时默认输出明细，group=contact/period 输出按联系人或周期的汇总
+func (s *Service) handleLedger(c *gin.Context) {
+	q := struct {
+		Time   string `form:"time"`
+		Talker string `form:"talker"`
+		Period string `form:"period"`
+		Group  string `form:"group"`
+		Format string `form:"format"`
+	}{}
+
+	if err := c.BindQuery(&q); err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	if q.Time == "" {
+		q.Time = "this-year"
+	}
+	start, end, ok := util.TimeRangeOf(q.Time)
+	if !ok {
+		errors.Err(c, errors.InvalidArg("time"))
+		return
+	}
+
+	l, err := ledger.Build(s.db, ledger.Options{
+		Start:  start,
+		End:    end,
+		Talker: q.Talker,
+		Period: strings.ToLower(q.Period),
+	})
+	if err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	switch strings.ToLower(q.Format) {
+	case "csv":
+		c.Writer.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		switch strings.ToLower(q.Group) {
+		case "contact", "period":
+			l.WriteTotalsCSV(c.Writer, strings.ToLower(q.Group))
+		default:
+			l.WriteCSV(c.Writer)
+		}
+	case "text":
+		c.Writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		c.Writer.WriteString(l.PlainText())
+	default:
+		c.JSON(http.StatusOK, l)
+	}
+}
@@ -7,9 +7,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"os"
-	"path/filepath"
-	"sort"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -18,17 +17,21 @@ import (
 	"github.com/rs/zerolog/log"
 
 	"github.com/sjzar/chatlog/internal/chatlog/conf"
+	"github.com/sjzar/chatlog/internal/chatlog/catalog"
+	"github.com/sjzar/chatlog/internal/chatlog/ledger"
+	"github.com/sjzar/chatlog/internal/chatlog/graph"
 	"github.com/sjzar/chatlog/internal/errors"
 	"github.com/sjzar/chatlog/internal/model"
 	"github.com/sjzar/chatlog/pkg/util"
-	"github.com/sjzar/chatlog/pkg/util/dat2img"
 	"github.com/sjzar/chatlog/pkg/util/silk"
 	"github.com/sjzar/chatlog/pkg/version"
 )
 
 func (s *Service) initMCPServer() {
+	// mcp-go 不处理 resources/subscribe 请求，不声明订阅能力；
+	// 数据库文件变更时 notifyLoop 向所有客户端发送 resources/list_changed 通知
 	s.mcpServer = server.NewMCPServer(conf.AppName, version.Version,
-		server.WithResourceCapabilities(false, false),
+		server.WithResourceCapabilities(false, true),
 		server.WithToolCapabilities(true),
 		server.WithPromptCapabilities(true),
 	)
@@ -36,16 +39,26 @@ func (s *Service) initMCPServer() {
 	s.mcpServer.AddTool(ChatRoomTool, s.handleMCPChatRoom)
 	s.mcpServer.AddTool(RecentChatTool, s.handleMCPRecentChat)
 	s.mcpServer.AddTool(ChatLogTool, s.handleMCPChatLog)
+	s.mcpServer.AddTool(MessageContextTool, s.handleMCPMessageContext)
+	s.mcpServer.AddTool(ThreadTool, s.handleMCPThread)
+	s.mcpServer.AddTool(SearchMessagesTool, s.handleMCPSearchMessages)
 	s.mcpServer.AddTool(CurrentTimeTool, s.handleMCPCurrentTime)
 	s.mcpServer.AddTool(GetMediaContentTool, s.handleMCPGetMediaContent)
 	s.mcpServer.AddTool(OCRImageMessageTool, s.handleMCPOCRImageMessage)
+	s.mcpServer.AddTool(TranscribeVoiceTool, s.handleMCPTranscribeVoice)
 	s.mcpServer.AddTool(SendWebhookNotificationTool, s.handleMCPSendWebhookNotification)
 	s.mcpServer.AddTool(AnalyzeChatActivityTool, s.handleMCPAnalyzeChatActivity)
+	s.mcpServer.AddTool(ChatStatsTool, s.handleMCPChatStats)
+	s.mcpServer.AddTool(FindConnectionsTool, s.handleMCPFindConnections)
+	s.mcpServer.AddTool(MentionsTool, s.handleMCPMentions)
+	s.mcpServer.AddTool(RecallsTool, s.handleMCPRecalls)
+	s.mcpServer.AddTool(LedgerTool, s.handleMCPLedger)
 	s.mcpServer.AddTool(GetUserProfileTool, s.handleMCPGetUserProfile)
 	s.mcpServer.AddTool(SearchSharedFilesTool, s.handleMCPSearchSharedFiles)
 	s.mcpServer.AddPrompt(ChatSummaryDailyPrompt, s.handleMCPChatSummaryDaily)
 	s.mcpServer.AddPrompt(ConflictDetectorPrompt, s.handleMCPConflictDetector)
 	s.mcpServer.AddPrompt(RelationshipMilestonesPrompt, s.handleMCPRelationshipMilestones)
+	s.initMCPResources()
 	s.mcpSSEServer = server.NewSSEServer(s.mcpServer,
 		server.WithSSEEndpoint("/sse"),
 		server.WithMessageEndpoint("/message"),
@@ -75,8 +88,8 @@ var RelationshipMilestonesPrompt = mcp.NewPrompt(
 var SearchSharedFilesTool = mcp.NewTool(
 	"search_shared_files",
 	mcp.WithDescription(`专门搜索聊天记录中发送的文件元数据。当用户想找某个特定的共享文件时使用。`),
-	mcp.WithString("talker", mcp.Description("对话方 ID"), mcp.Required()),
-	mcp.WithString("keyword", mcp.Description("文件名搜索关键词")),
+	mcp.WithString("talker", mcp.Description("对话方 ID 或名称，多个用\",\"分隔，为空时搜索全部会话")),
+	mcp.WithString("keyword", mcp.Description("文件名搜索关键词，支持正则表达式")),
 )
 
 var AnalyzeChatActivityTool = mcp.NewTool(
@@ -86,6 +99,46 @@ var AnalyzeChatActivityTool = mcp.NewTool(
 	mcp.WithString("talker", mcp.Description("对话方 ID"), mcp.Required()),
 )
 
+var ChatStatsTool = mcp.NewTool(
+	"get_chat_stats",
+	mcp.WithDescription(`获取对话的详细统计数据（JSON），包括按天、按星期小时、按消息类型的消息数，发送者的消息数、首末条消息时间、平均回复间隔与媒体数量，以及链接分享的域名统计。适合需要精确数字的分析类问题。`),
+	mcp.WithString("talker", mcp.Description("对话方 ID、昵称或备注名，多个对话方用\",\"分隔"), mcp.Required()),
+	mcp.WithString("time", mcp.Description("时间范围，格式同 query_chat_log，默认为全部时间")),
+)
+
+var FindConnectionsTool = mcp.NewTool(
+	"find_connections",
+	mcp.WithDescription(`查询两人之间的关系，回答"谁能把我介绍给某人"之类的问题。基于共同群聊、私聊、引用回复、@ 与拍一拍构建关系图，返回直接关系、共同群聊、与双方都有联系的中间人以及关系最强的路径。`),
+	mcp.WithString("target", mcp.Description("目标联系人的 ID、昵称或备注名"), mcp.Required()),
+	mcp.WithString("source", mcp.Description("起点联系人，默认为当前账号")),
+	mcp.WithString("time", mcp.Description("统计互动的时间范围，格式同 query_chat_log，默认为全部时间")),
+	mcp.WithNumber("limit", mcp.Description("返回的中间人数量，默认 10")),
+)
+
+var MentionsTool = mcp.NewTool(
+	"get_mentions",
+	mcp.WithDescription(`查询群聊中 @ 我（或指定用户）的消息，包括 @所有人。unanswered=true 时只返回之后我还没有在该群发言的消息，适合检查免打扰群里错过的提醒。`),
+	mcp.WithString("time", mcp.Description("时间范围，格式同 query_chat_log，默认为最近 7 天 (last-7d)")),
+	mcp.WithString("talker", mcp.Description("限定群聊，多个用\",\"分隔，默认为全部群聊")),
+	mcp.WithString("user", mcp.Description("被 @ 的用户 ID 或名称，默认为当前账号")),
+	mcp.WithBoolean("unanswered", mcp.Description("是否只返回尚未回复的 @")),
+)
+
+var RecallsTool = mcp.NewTool(
+	"get_recalls",
+	mcp.WithDescription(`查询被撤回的消息及其撤回前的原始内容。仅包含开启自动解密后、撤回前已被解密保存的消息。`),
+	mcp.WithString("time", mcp.Description("时间范围，格式同 query_chat_log，默认为最近 7 天 (last-7d)")),
+	mcp.WithString("talker", mcp.Description("限定会话，多个用\",\"分隔，默认为全部会话")),
+)
+
+var LedgerTool = mcp.NewTool(
+	"get_ledger",
+	mcp.WithDescription(`查询转账与红包账本，包括收付方向、金额、备注、对方、状态（已发起/已收款/已退还），以及按联系人和周期的收支汇总。红包金额不随消息下发，记为 0。`),
+	mcp.WithString("time", mcp.Description("时间范围，格式同 query_chat_log，默认为今年 (this-year)")),
+	mcp.WithString("talker", mcp.Description("限定会话，多个用\",\"分隔，默认为全部会话")),
+	mcp.WithString("period", mcp.Description("汇总周期，day/month/year，默认为 month")),
+)
+
 var GetUserProfileTool = mcp.NewTool(
 	"get_user_profile",
 	mcp.WithDescription(`获取联系人或群组的详细资料，包括备注、属性、群成员（如果是群组）等背景信息。用于更深入地了解对话方。`),
@@ -102,9 +155,17 @@ var SendWebhookNotificationTool = mcp.NewTool(
 
 var OCRImageMessageTool = mcp.NewTool(
 	"ocr_image_message",
-	mcp.WithDescription(`对特定图片消息进行 OCR 解析以提取其中的文字。`),
+	mcp.WithDescription(`对特定图片消息进行 OCR 解析以提取其中的文字。配置了本地识别引擎或已有识别结果时直接返回识别出的文字，否则返回图片数据由你自行识别。`),
+	mcp.WithString("talker", mcp.Description("消息所在的对话方（联系人 ID 或群 ID）"), mcp.Required()),
+	mcp.WithNumber("message_id", mcp.Description("消息的唯一 ID (Seq)"), mcp.Required()),
+)
+
+var TranscribeVoiceTool = mcp.NewTool(
+	"transcribe_voice",
+	mcp.WithDescription(`将语音消息转写为文字。已转写过的语音直接返回保存的结果，转写后的文字会显示在聊天记录中并可被 search_messages 搜索。`),
 	mcp.WithString("talker", mcp.Description("消息所在的对话方（联系人 ID 或群 ID）"), mcp.Required()),
 	mcp.WithNumber("message_id", mcp.Description("消息的唯一 ID (Seq)"), mcp.Required()),
+	mcp.WithBoolean("force", mcp.Description("忽略已保存的结果重新转写，默认 false")),
 )
 
 var GetMediaContentTool = mcp.NewTool(
@@ -112,6 +173,8 @@ var GetMediaContentTool = mcp.NewTool(
 	mcp.WithDescription(`根据消息 ID 获取解码后的媒体文件内容（图片或语音）。当聊天记录中显示 [图片] 或 [语音] 且用户需要查看具体内容或进行分析时使用此工具。`),
 	mcp.WithString("talker", mcp.Description("消息所在的对话方（联系人 ID 或群 ID）"), mcp.Required()),
 	mcp.WithNumber("message_id", mcp.Description("消息的唯一 ID (Seq)"), mcp.Required()),
+	mcp.WithString("format", mcp.Description("语音输出格式：mp3（默认）、wav、ogg（opus）或 silk（原始数据）")),
+	mcp.WithNumber("sample_rate", mcp.Description("wav 的采样率，默认 16000")),
 )
 
 var ContactTool = mcp.NewTool(
@@ -135,54 +198,13 @@ var ChatLogTool = mcp.NewTool(
 	"query_chat_log",
 	mcp.WithDescription(`检索历史聊天记录，可根据时间、对话方、发送者和关键词等条件进行精确查询。当用户需要查找特定信息或想了解与某人/某群的历史交流时使用此工具。
 
-【强制多步查询流程!】
-当查询特定话题或特定发送者发言时，必须严格按照以下流程使用，任何偏离都会导致错误的结果：
-
-步骤1: 初步定位相关消息
-- 使用keyword参数查找特定话题
-- 使用sender参数查找特定发送者的消息
-- 使用较宽时间范围初步查询
-
-步骤2: 【必须执行】针对每个关键结果点分别获取上下文
-- 必须对步骤1返回的每个时间点T1, T2, T3...分别执行独立查询（时间范围接近的消息可以合并为一个查询）
-- 每次独立查询必须移除keyword参数
-- 每次独立查询必须移除sender参数
-- 每次独立查询使用"Tn前后15-30分钟"的窄范围
-- 每次独立查询仅保留talker参数
-
-步骤3: 【必须执行】综合分析所有上下文
-- 必须等待所有步骤2的查询结果返回后再进行分析
-- 必须综合考虑所有上下文信息后再回答用户
-
-【严格执行规则！】
-- 禁止仅凭步骤1的结果直接回答用户
-- 禁止在步骤2使用过大的时间范围一次性查询所有上下文
-- 禁止跳过步骤2或步骤3
-- 必须对每个关键结果点分别执行独立的上下文查询
-
-【执行示例】
-正确流程示例:
-1. 步骤1: chatlog(time="2023-04-01~2023-04-30", talker="工作群", keyword="项目进度")
-返回结果: 4月5日、4月12日、4月20日有相关消息
-2. 步骤2:
-- 查询1: chatlog(time="2023-04-05/09:30~2023-04-05/10:30", talker="工作群") // 注意没有keyword
-- 查询2: chatlog(time="2023-04-12/14:00~2023-04-12/15:00", talker="工作群") // 注意没有keyword
-- 查询3: chatlog(time="2023-04-20/16:00~2023-04-20/17:00", talker="工作群") // 注意没有keyword
-3. 步骤3: 综合分析所有上下文后回答用户
-
-错误流程示例:
-- 仅执行步骤1后直接回答
-- 步骤2使用time="2023-04-01~2023-04-30"一次性查询
-- 步骤2仍然保留keyword或sender参数
-
-【自我检查】回答用户前必须自问:
-- 我是否对每个关键时间点都执行了独立的上下文查询?
-- 我是否在上下文查询中移除了keyword和sender参数?
-- 我是否分析了所有上下文后再回答?
-- 如果上述任一问题答案为"否"，则必须纠正流程
+获取上下文：
+- 需要查看某条消息前后的对话时，使用 get_message_context 工具并传入对应的 [MessageID]
+- 查询特定话题或特定发送者的发言时，优先使用 search_messages 工具，它会为每个命中附带上下文
 
 返回格式："昵称(ID) [MessageID] 时间\n消息内容\n昵称(ID) [MessageID] 时间\n消息内容"
-当消息内容包含 [图片] 或 [语音] 时，可以使用 get_media_content 或 ocr_image_message 工具，并传入对应的 [MessageID] 来获取具体内容。
+结果超出 max_chars 或 max_messages 时会在末尾说明已省略的数量，并给出用于获取下一页的 cursor。
+当消息内容包含 [图片] 或 [语音] 时，可以使用 get_media_content 或 ocr_image_message 工具，并传入对应的 [MessageID] 来获取具体内容；未附带文字的 [语音] 可以使用 transcribe_voice 转写。
 当查询多个Talker时，返回格式为："昵称(ID)\n[TalkerName(Talker)] [MessageID] 时间\n消息内容"
 
 重要提示：
@@ -210,24 +232,47 @@ var ChatLogTool = mcp.NewTool(
 - 月份："2023-04"或"202304"`), mcp.Required()),
 	mcp.WithString("talker", mcp.Description(`指定对话方（联系人或群组）
 - 可使用ID、昵称或备注名
-- 多个对话方用","分隔，如："张三,李四,工作群"
-- 【重要】这是多步查询中唯一应保留的参数`), mcp.Required()),
+- 多个对话方用","分隔，如："张三,李四,工作群"`), mcp.Required()),
 	mcp.WithString("sender", mcp.Description(`指定群聊中的发送者
 - 仅在查询群聊记录时有效
 - 多个发送者用","分隔，如："张三,李四"
-- 可使用ID、昵称或备注名
-【重要】查询特定发送者的消息时：
-1. 第一步：使用sender参数初步定位多个相关消息时间点
-2. 后续步骤：必须移除sender参数，分别查询每个时间点前后的完整对话
-3. 错误示例：对所有找到的消息一次性查询大范围上下文
-4. 正确示例：对每个时间点T分别执行查询"T前后15-30分钟"（不带sender）`)),
+- 可使用ID、昵称或备注名`)),
 	mcp.WithString("keyword", mcp.Description(`搜索内容中的关键词
-- 支持正则表达式匹配
-- 【重要】查询特定话题时：
-1. 第一步：使用keyword参数初步定位多个相关消息时间点
-2. 后续步骤：必须移除keyword参数，分别查询每个时间点前后的完整对话
-3. 错误示例：对所有找到的关键词消息一次性查询大范围上下文
-4. 正确示例：对每个时间点T分别执行查询"T前后15-30分钟"（不带keyword）`)),
+- 支持正则表达式匹配`)),
+	mcp.WithNumber("max_chars", mcp.Description("单次返回的最大字符数，默认 20000，0 表示不限制。超出部分可通过 cursor 继续获取")),
+	mcp.WithNumber("max_messages", mcp.Description("单次返回的最大消息条数，默认不限制")),
+	mcp.WithString("cursor", mcp.Description("续页标记。上一次返回结果末尾提示剩余消息时，使用相同参数并传入该标记获取下一页")),
+	mcp.WithBoolean("compact", mcp.Description("紧凑模式：合并同一发送者的连续表头、折叠连续媒体消息、截断长链接，适合长时间范围的摘要类查询")),
+)
+
+var MessageContextTool = mcp.NewTool(
+	"get_message_context",
+	mcp.WithDescription(`获取指定消息前后的若干条消息（上下文）。当通过 query_chat_log 或 search_messages 定位到某条消息后，需要了解其前后对话时使用此工具，无需再自行推算时间范围。
+返回格式与 query_chat_log 相同，目标消息前会标注 ">>>"。`),
+	mcp.WithString("talker", mcp.Description("消息所在的对话方（联系人或群组的 ID、昵称或备注名）"), mcp.Required()),
+	mcp.WithNumber("message_id", mcp.Description("目标消息的 MessageID (Seq)"), mcp.Required()),
+	mcp.WithNumber("before", mcp.Description("目标消息之前的消息条数，默认 10")),
+	mcp.WithNumber("after", mcp.Description("目标消息之后的消息条数，默认 10")),
+)
+
+var ThreadTool = mcp.NewTool(
+	"get_thread",
+	mcp.WithDescription(`获取指定消息所在的完整引用回复链。从目标消息沿引用关系向上找到最初被引用的消息，再列出之后所有引用了链上消息的回复，按回复层级缩进输出。适合理解群聊中"回复了谁、在讨论哪条消息"。`),
+	mcp.WithString("talker", mcp.Description("消息所在的对话方（联系人或群组的 ID、昵称或备注名）"), mcp.Required()),
+	mcp.WithNumber("message_id", mcp.Description("回复链中任意一条消息的 MessageID (Seq)"), mcp.Required()),
+)
+
+var SearchMessagesTool = mcp.NewTool(
+	"search_messages",
+	mcp.WithDescription(`按关键词搜索聊天记录，并为每条命中消息附带前后若干条上下文，一次调用即可获得完整语境。
+查询特定话题或特定发送者的发言时，优先使用此工具代替 query_chat_log 的多步查询流程。
+返回格式：每个命中以 "=== 命中 n ===" 分隔，命中消息前会标注 ">>>"；上下文重叠的命中会合并显示。`),
+	mcp.WithString("talker", mcp.Description("指定对话方（联系人或群组），多个对话方用\",\"分隔"), mcp.Required()),
+	mcp.WithString("keyword", mcp.Description("搜索内容中的关键词，支持正则表达式"), mcp.Required()),
+	mcp.WithString("time", mcp.Description("时间范围，格式同 query_chat_log，默认为全部时间")),
+	mcp.WithString("sender", mcp.Description("指定群聊中的发送者，多个发送者用\",\"分隔")),
+	mcp.WithNumber("limit", mcp.Description("返回的命中数量上限，默认 10")),
+	mcp.WithNumber("context", mcp.Description("每条命中前后附带的消息条数，默认 3")),
 )
 
 var CurrentTimeTool = mcp.NewTool(
@@ -346,13 +391,17 @@ func (s *Service) handleMCPRecentChat(ctx context.Context, request mcp.CallToolR
 }
 
 type ChatLogRequest struct {
-	Time    string `form:"time"`
-	Talker  string `form:"talker"`
-	Sender  string `form:"sender"`
-	Keyword string `form:"keyword"`
-	Limit   int    `form:"limit"`
-	Offset  int    `form:"offset"`
-	Format  string `form:"format"`
+	Time        string `form:"time"`
+	Talker      string `form:"talker"`
+	Sender      string `form:"sender"`
+	Keyword     string `form:"keyword"`
+	Limit       int    `form:"limit"`
+	Offset      int    `form:"offset"`
+	Format      string `form:"format"`
+	MaxChars    *int   `form:"max_chars" json:"max_chars"`
+	MaxMessages int    `form:"max_messages" json:"max_messages"`
+	Cursor      string `form:"cursor" json:"cursor"`
+	Compact     bool   `form:"compact" json:"compact"`
 }
 
 func (s *Service) handleMCPChatLog(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
@@ -367,8 +416,7 @@ func (s *Service) handleMCPChatLog(ctx context.Context, request mcp.CallToolRequ
 	var err error
 	start, end, ok := util.TimeRangeOf(req.Time)
 	if !ok {
-		log.Error().Err(err).Msg("Failed to get messages")
-		return errors.ErrMCPTool(err), nil
+		return errors.ErrMCPTool(errors.InvalidArg("time")), nil
 	}
 	if req.Limit < 0 {
 		req.Limit = 0
@@ -378,19 +426,51 @@ func (s *Service) handleMCPChatLog(ctx context.Context, request mcp.CallToolRequ
 		req.Offset = 0
 	}
 
+	// 续页标记绑定查询条件，条件变化后旧标记失效
+	query := []string{req.Time, req.Talker, req.Sender, req.Keyword, strconv.Itoa(req.Limit), strconv.Itoa(req.Offset)}
+	cursor := 0
+	if req.Cursor != "" {
+		if cursor, err = decodeCursor(req.Cursor, query...); err != nil {
+			return errors.ErrMCPTool(err), nil
+		}
+	}
+	maxChars := defaultMaxChars
+	if req.MaxChars != nil {
+		maxChars = max(*req.MaxChars, 0)
+	}
+
 	messages, err := s.db.GetMessages(start, end, req.Talker, req.Sender, req.Keyword, req.Limit, req.Offset)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to get messages")
 		return errors.ErrMCPTool(err), nil
 	}
 
+	// 消息被删除等原因导致结果变少时，旧标记可能越界
+	if cursor > len(messages) {
+		return errors.ErrMCPTool(errors.InvalidArg("cursor")), nil
+	}
+
 	buf := &bytes.Buffer{}
 	if len(messages) == 0 {
 		buf.WriteString("未找到符合查询条件的聊天记录")
 	}
-	for _, m := range messages {
-		buf.WriteString(m.PlainText(strings.Contains(req.Talker, ","), util.PerfectTimeFormat(start, end), ""))
-		buf.WriteString("\n")
+
+	page := renderChatLogPage(messages, cursor, chatLogPageOptions{
+		MaxChars:     maxChars,
+		MaxMessages:  max(req.MaxMessages, 0),
+		Compact:      req.Compact,
+		ShowChatRoom: strings.Contains(req.Talker, ","),
+		TimeFormat:   util.PerfectTimeFormat(start, end),
+	})
+	buf.WriteString(page.Text)
+
+	// 说明本页范围与剩余数量，便于客户端继续翻页
+	if page.Start > 0 || page.End < page.Total {
+		buf.WriteString(fmt.Sprintf("\n[已显示第 %d-%d 条，共 %d 条", page.Start+1, page.End, page.Total))
+		if omitted := page.Total - page.End; omitted > 0 {
+			buf.WriteString(fmt.Sprintf("，剩余 %d 条未显示。继续获取请使用相同参数并传入 cursor=\"%s\"", omitted, encodeCursor(page.End, query...)))
+		}
+		buf.WriteString("]\n")
 	}
 
 	return &mcp.CallToolResult{
@@ -403,6 +483,180 @@ func (s *Service) handleMCPChatLog(ctx context.Context, request mcp.CallToolRequ
 	}, nil
 }
 
+const (
+	// 单侧上下文消息条数上限
+	maxMessageContext = 100
+)
+
+type MessageContextRequest struct {
+	Talker    string `json:"talker"`
+	MessageID int64  `json:"message_id"`
+}
+
+func (s *Service) handleMCPMessageContext(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	var req MessageContextRequest
+	if err := request.BindArguments(&req); err != nil {
+		return errors.ErrMCPTool(err), nil
+	}
+	before := min(max(request.GetInt("before", 10), 0), maxMessageContext)
+	after := min(max(request.GetInt("after", 10), 0), maxMessageContext)
+
+	messages, err := s.db.GetMessageContext(req.Talker, req.MessageID, before, after)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get message context")
+		return errors.ErrMCPTool(err), nil
+	}
+
+	buf := &bytes.Buffer{}
+	writeMessageWindow(buf, messages, map[int64]bool{req.MessageID: true})
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: buf.String(),
+			},
+		},
+	}, nil
+}
+
+type ThreadRequest struct {
+	Talker    string `json:"talker"`
+	MessageID int64  `json:"message_id"`
+}
+
+func (s *Service) handleMCPThread(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	var req ThreadRequest
+	if err := request.BindArguments(&req); err != nil {
+		return errors.ErrMCPTool(err), nil
+	}
+
+	thread, err := s.db.GetThread(req.Talker, req.MessageID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get thread")
+		return errors.ErrMCPTool(err), nil
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(fmt.Sprintf("回复链共 %d 条消息\n", thread.Count()))
+	buf.WriteString(thread.PlainText("2006-01-02 15:04:05", ""))
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: buf.String(),
+			},
+		},
+	}, nil
+}
+
+type SearchMessagesRequest struct {
+	Time    string `json:"time"`
+	Talker  string `json:"talker"`
+	Sender  string `json:"sender"`
+	Keyword string `json:"keyword"`
+}
+
+func (s *Service) handleMCPSearchMessages(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	var req SearchMessagesRequest
+	if err := request.BindArguments(&req); err != nil {
+		return errors.ErrMCPTool(err), nil
+	}
+	if req.Keyword == "" {
+		return errors.ErrMCPTool(errors.InvalidArg("keyword")), nil
+	}
+	if req.Time == "" {
+		req.Time = "all"
+	}
+	start, end, ok := util.TimeRangeOf(req.Time)
+	if !ok {
+		return errors.ErrMCPTool(errors.InvalidArg("time")), nil
+	}
+	limit := min(max(request.GetInt("limit", 10), 1), maxMessageContext)
+	n := min(max(request.GetInt("context", 3), 0), maxMessageContext)
+
+	hits, err := s.db.GetMessages(start, end, req.Talker, req.Sender, req.Keyword, limit, 0)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to search messages")
+		return errors.ErrMCPTool(err), nil
+	}
+	if len(hits) == 0 {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				mcp.TextContent{
+					Type: "text",
+					Text: "未找到符合查询条件的聊天记录",
+				},
+			},
+		}, nil
+	}
+
+	// 上下文重叠的命中合并为同一个窗口
+	type window struct {
+		talker   string
+		messages []*model.Message
+		hits     map[int64]bool
+	}
+	windows := make([]*window, 0, len(hits))
+	for _, hit := range hits {
+		messages, err := s.db.GetMessageContext(hit.Talker, hit.Seq, n, n)
+		if err != nil {
+			log.Debug().Err(err).Msgf("Failed to get context of message %d", hit.Seq)
+			messages = []*model.Message{hit}
+		}
+		if len(windows) > 0 {
+			last := windows[len(windows)-1]
+			lastSeq := last.messages[len(last.messages)-1].Seq
+			if last.talker == hit.Talker && messages[0].Seq <= lastSeq {
+				last.hits[hit.Seq] = true
+				for _, m := range messages {
+					if m.Seq > lastSeq {
+						last.messages = append(last.messages, m)
+					}
+				}
+				continue
+			}
+		}
+		windows = append(windows, &window{
+			talker:   hit.Talker,
+			messages: messages,
+			hits:     map[int64]bool{hit.Seq: true},
+		})
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(fmt.Sprintf("共找到 %d 条命中消息\n", len(hits)))
+	for i, w := range windows {
+		buf.WriteString(fmt.Sprintf("\n=== 命中 %d ===\n", i+1))
+		writeMessageWindow(buf, w.messages, w.hits)
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: buf.String(),
+			},
+		},
+	}, nil
+}
+
+// writeMessageWindow 输出一段连续的消息，并用 ">>>" 标注目标消息
+func writeMessageWindow(buf *bytes.Buffer, messages []*model.Message, marks map[int64]bool) {
+	if len(messages) == 0 {
+		return
+	}
+	timeFormat := util.PerfectTimeFormat(messages[0].Time, messages[len(messages)-1].Time)
+	for _, m := range messages {
+		if marks[m.Seq] {
+			buf.WriteString(">>> ")
+		}
+		buf.WriteString(m.PlainText(false, timeFormat, ""))
+		buf.WriteString("\n")
+	}
+}
+
 func (s *Service) handleMCPCurrentTime(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	return &mcp.CallToolResult{
 		Content: []mcp.Content{
@@ -415,8 +669,10 @@ func (s *Service) handleMCPCurrentTime(ctx context.Context, request mcp.CallTool
 }
 
 type GetMediaContentRequest struct {
-	Talker    string `json:"talker"`
-	MessageID int64  `json:"message_id"`
+	Talker     string `json:"talker"`
+	MessageID  int64  `json:"message_id"`
+	Format     string `json:"format"`
+	SampleRate int    `json:"sample_rate"`
 }
 
 func (s *Service) handleMCPGetMediaContent(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
@@ -435,7 +691,7 @@ func (s *Service) handleMCPGetMediaContent(ctx context.Context, request mcp.Call
 	case model.MessageTypeImage:
 		return s.handleMCPGetImage(ctx, msg)
 	case model.MessageTypeVoice:
-		return s.handleMCPGetVoice(ctx, msg)
+		return s.handleMCPGetVoice(ctx, msg, req.Format, req.SampleRate)
 	default:
 		return &mcp.CallToolResult{
 			Content: []mcp.Content{
@@ -476,11 +732,23 @@ func (s *Service) handleMCPOCRImageMessage(ctx context.Context, request mcp.Call
 		return result, err
 	}
 
+	// 优先使用本地识别结果，未配置识别引擎或识别失败时由模型分析图片
+	hint := "已提取图片数据，请直接分析该图片内容并提取文字 (OCR)。"
+	res, err := s.db.RecognizeImage(ctx, msg.Talker, msg.Seq, false)
+	switch {
+	case err == nil && res.Text != "":
+		hint = fmt.Sprintf("本地 OCR (%s) 识别结果：\n%s", res.Provider, res.Text)
+	case err == nil:
+		hint = "本地 OCR 未识别出文字，如有需要请直接分析该图片内容。"
+	case !errors.Is(err, errors.ErrOCRDisabled):
+		log.Debug().Err(err).Msg("Failed to recognize image")
+	}
+
 	// 在结果中添加一条提示信息
 	result.Content = append([]mcp.Content{
 		mcp.TextContent{
 			Type: "text",
-			Text: "已提取图片数据，请直接分析该图片内容并提取文字 (OCR)。",
+			Text: hint,
 		},
 	}, result.Content...)
 
@@ -505,49 +773,27 @@ func (s *Service) handleMCPGetImage(ctx context.Context, msg *model.Message) (*m
 		}, nil
 	}
 
-	media, err := s.db.GetMedia("image", key)
+	data, mimeType, err := s.readImage(key)
 	if err != nil {
 		return errors.ErrMCPTool(err), nil
 	}
 
-	absolutePath := filepath.Join(s.conf.GetDataDir(), media.Path)
-	b, err := os.ReadFile(absolutePath)
-	if err != nil {
-		return errors.ErrMCPTool(err), nil
-	}
-
-	var data []byte
-	var mimeType string
-
-	if strings.HasSuffix(strings.ToLower(media.Path), ".dat") {
-		out, ext, err := dat2img.Dat2Image(b)
-		if err != nil {
-			return errors.ErrMCPTool(err), nil
-		}
-		data = out
-		switch ext {
-		case "png":
-			mimeType = "image/png"
-		case "gif":
-			mimeType = "image/gif"
-		case "bmp":
-			mimeType = "image/bmp"
-		default:
-			mimeType = "image/jpeg"
-		}
-	} else {
-		data = b
-		ext := strings.ToLower(filepath.Ext(media.Path))
-		switch ext {
-		case ".png":
-			mimeType = "image/png"
-		case ".gif":
-			mimeType = "image/gif"
-		case ".bmp":
-			mimeType = "image/bmp"
-		default:
-			mimeType = "image/jpeg"
-		}
+	// ImageContent 仅支持 png/jpeg/gif/webp，实况图等解码为 heic/mp4 时以资源形式返回
+	if !mcpImageTypes[mimeType] {
+		uri := "chatlog://media/" + escapeResourceVar(msg.Talker) + "/" + strconv.FormatInt(msg.Seq, 10)
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				mcp.TextContent{
+					Type: "text",
+					Text: fmt.Sprintf("该图片为 %s 格式，无法直接作为图片返回，已作为资源附上；也可通过 HTTP 接口 /image/%s 获取。", mimeType, key),
+				},
+				mcp.NewEmbeddedResource(mcp.BlobResourceContents{
+					URI:      uri,
+					MIMEType: mimeType,
+					Blob:     base64.StdEncoding.EncodeToString(data),
+				}),
+			},
+		}, nil
 	}
 
 	return &mcp.CallToolResult{
@@ -561,7 +807,24 @@ func (s *Service) handleMCPGetImage(ctx context.Context, msg *model.Message) (*m
 	}, nil
 }
 
-func (s *Service) handleMCPGetVoice(ctx context.Context, msg *model.Message) (*mcp.CallToolResult, error) {
+// mcpImageTypes MCP ImageContent 支持的图片类型
+var mcpImageTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// readImage 读取并解码图片，返回图片数据与 MIME 类型
+func (s *Service) readImage(key string) ([]byte, string, error) {
+	data, ext, err := s.db.ReadImage(key)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, imageContentType(ext), nil
+}
+
+func (s *Service) handleMCPGetVoice(ctx context.Context, msg *model.Message, format string, sampleRate int) (*mcp.CallToolResult, error) {
 	key, ok := msg.Contents["voice"].(string)
 	if !ok {
 		return &mcp.CallToolResult{
@@ -574,19 +837,32 @@ func (s *Service) handleMCPGetVoice(ctx context.Context, msg *model.Message) (*m
 		}, nil
 	}
 
+	format, err := voiceFormat(format)
+	if err != nil {
+		return errors.ErrMCPTool(err), nil
+	}
+	if sampleRate != 0 && !slices.Contains(silk.SampleRates, sampleRate) {
+		return errors.ErrMCPTool(errors.InvalidArg("sample_rate")), nil
+	}
+
 	media, err := s.db.GetMedia("voice", key)
 	if err != nil {
 		return errors.ErrMCPTool(err), nil
 	}
 
-	out, err := silk.Silk2MP3(media.Data)
+	duration := ""
+	if length, ok := msg.Contents["voicelength"].(int64); ok {
+		duration = fmt.Sprintf("，时长 %.1f 秒", float64(length)/1000)
+	}
+
+	out, err := s.convertVoice(key, media.Data, format, sampleRate)
 	if err != nil {
 		// 如果转换失败，返回 base64 编码的原始数据
 		return &mcp.CallToolResult{
 			Content: []mcp.Content{
 				mcp.TextContent{
 					Type: "text",
-					Text: fmt.Sprintf("语音转换失败: %v。原始语音数据(base64): %s", err, base64.StdEncoding.EncodeToString(media.Data)),
+					Text: fmt.Sprintf("语音转换失败: %v%s。原始语音数据(base64): %s", err, duration, base64.StdEncoding.EncodeToString(media.Data)),
 				},
 			},
 		}, nil
@@ -596,7 +872,34 @@ func (s *Service) handleMCPGetVoice(ctx context.Context, msg *model.Message) (*m
 		Content: []mcp.Content{
 			mcp.TextContent{
 				Type: "text",
-				Text: fmt.Sprintf("语音已转换为 MP3 格式。数据(base64): %s", base64.StdEncoding.EncodeToString(out)),
+				Text: fmt.Sprintf("语音已转换为 %s 格式%s。数据(base64): %s", strings.ToUpper(format), duration, base64.StdEncoding.EncodeToString(out)),
+			},
+		},
+	}, nil
+}
+
+type TranscribeVoiceRequest struct {
+	Talker    string `json:"talker"`
+	MessageID int64  `json:"message_id"`
+	Force     bool   `json:"force"`
+}
+
+func (s *Service) handleMCPTranscribeVoice(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	var req TranscribeVoiceRequest
+	if err := request.BindArguments(&req); err != nil {
+		return errors.ErrMCPTool(err), nil
+	}
+
+	tr, err := s.db.TranscribeVoice(ctx, req.Talker, req.MessageID, req.Force)
+	if err != nil {
+		return errors.ErrMCPTool(err), nil
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: transcriptText(tr),
 			},
 		},
 	}, nil
@@ -669,12 +972,12 @@ func (s *Service) handleMCPAnalyzeChatActivity(ctx context.Context, request mcp.
 		return errors.ErrMCPTool(fmt.Errorf("invalid time format")), nil
 	}
 
-	messages, err := s.db.GetMessages(start, end, req.Talker, "", "", 0, 0)
+	stats, err := s.db.GetChatStats(start, end, req.Talker)
 	if err != nil {
 		return errors.ErrMCPTool(err), nil
 	}
 
-	if len(messages) == 0 {
+	if stats.Total == 0 {
 		return &mcp.CallToolResult{
 			Content: []mcp.Content{
 				mcp.TextContent{
@@ -685,20 +988,14 @@ func (s *Service) handleMCPAnalyzeChatActivity(ctx context.Context, request mcp.
 		}, nil
 	}
 
-	// 统计逻辑
-	totalCount := len(messages)
-	senderStats := make(map[string]int)
+	totalCount := int(stats.Total)
 	hourStats := make(map[int]int)
-	typeStats := make(map[int64]int)
-
-	for _, m := range messages {
-		sender := m.SenderName
-		if sender == "" {
-			sender = m.Sender
+	for _, hours := range stats.ByHourOfWeek {
+		for h, count := range hours {
+			if count > 0 {
+				hourStats[h] += int(count)
+			}
 		}
-		senderStats[sender]++
-		hourStats[m.Time.Hour()]++
-		typeStats[m.Type]++
 	}
 
 	buf := &bytes.Buffer{}
@@ -706,21 +1003,16 @@ func (s *Service) handleMCPAnalyzeChatActivity(ctx context.Context, request mcp.
 	buf.WriteString(fmt.Sprintf("总消息数: %d\n\n", totalCount))
 
 	buf.WriteString("发言频率排行:\n")
-	type senderStat struct {
-		Name  string
-		Count int
-	}
-	ss := make([]senderStat, 0, len(senderStats))
-	for name, count := range senderStats {
-		ss = append(ss, senderStat{name, count})
-	}
-	sort.Slice(ss, func(i, j int) bool { return ss[i].Count > ss[j].Count })
-	for i, s := range ss {
+	for i, s := range stats.Senders {
 		if i >= 10 {
 			break
 		} // 只显示前 10
+		name := s.SenderName
+		if name == "" {
+			name = s.Sender
+		}
 		percentage := float64(s.Count) / float64(totalCount) * 100
-		buf.WriteString(fmt.Sprintf("- %s: %d (%.1f%%)\n", s.Name, s.Count, percentage))
+		buf.WriteString(fmt.Sprintf("- %s: %d (%.1f%%)\n", name, s.Count, percentage))
 	}
 
 	buf.WriteString("\n活跃时段分布:\n")
@@ -740,6 +1032,280 @@ func (s *Service) handleMCPAnalyzeChatActivity(ctx context.Context, request mcp.
 	}, nil
 }
 
+type ChatStatsRequest struct {
+	Time   string `json:"time"`
+	Talker string `json:"talker"`
+}
+
+func (s *Service) handleMCPChatStats(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	var req ChatStatsRequest
+	if err := request.BindArguments(&req); err != nil {
+		return errors.ErrMCPTool(err), nil
+	}
+
+	if req.Time == "" {
+		req.Time = "all"
+	}
+	start, end, ok := util.TimeRangeOf(req.Time)
+	if !ok {
+		return errors.ErrMCPTool(errors.InvalidArg("time")), nil
+	}
+
+	stats, err := s.db.GetChatStats(start, end, req.Talker)
+	if err != nil {
+		return errors.ErrMCPTool(err), nil
+	}
+
+	b, err := json.Marshal(stats)
+	if err != nil {
+		return errors.ErrMCPTool(err), nil
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: string(b),
+			},
+		},
+	}, nil
+}
+
+type FindConnectionsRequest struct {
+	Target string `json:"target"`
+	Source string `json:"source"`
+	Time   string `json:"time"`
+	Limit  int    `json:"limit"`
+}
+
+func (s *Service) handleMCPFindConnections(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	var req FindConnectionsRequest
+	if err := request.BindArguments(&req); err != nil {
+		return errors.ErrMCPTool(err), nil
+	}
+
+	if req.Time == "" {
+		req.Time = "all"
+	}
+	start, end, ok := util.TimeRangeOf(req.Time)
+	if !ok {
+		return errors.ErrMCPTool(errors.InvalidArg("time")), nil
+	}
+	if req.Limit <= 0 {
+		req.Limit = 10
+	}
+
+	g, err := graph.Build(s.db, graph.Options{Start: start, End: end})
+	if err != nil {
+		return errors.ErrMCPTool(err), nil
+	}
+
+	source := g.Self
+	if req.Source != "" {
+		source = s.resolveGraphNode(g, req.Source)
+	}
+	target := s.resolveGraphNode(g, req.Target)
+	if source == "" {
+		return errors.ErrMCPTool(errors.InvalidArg("source")), nil
+	}
+	if target == "" {
+		return errors.ErrMCPTool(errors.InvalidArg("target")), nil
+	}
+
+	conn := g.Connect(source, target, req.Limit)
+	buf := &bytes.Buffer{}
+	buf.WriteString(fmt.Sprintf("%s 与 %s 的关系：\n", conn.Source.Label(), conn.Target.Label()))
+	if conn.Direct != nil {
+		buf.WriteString(fmt.Sprintf("直接关系：权重 %.2f（共同群聊 %d，私聊消息 %d，引用回复 %d，@ %d，拍一拍 %d）\n",
+			conn.Direct.Weight, conn.Direct.SharedGroups, conn.Direct.DirectMessages, conn.Direct.Replies, conn.Direct.Mentions, conn.Direct.Pats))
+	} else {
+		buf.WriteString("直接关系：无\n")
+	}
+	if len(conn.SharedGroups) > 0 {
+		buf.WriteString(fmt.Sprintf("共同群聊（%d）：%s\n", len(conn.SharedGroups), strings.Join(conn.SharedGroups, "、")))
+	}
+	if len(conn.Intermediaries) > 0 {
+		buf.WriteString("中间人（按关系强度排序）：\n")
+		for _, item := range conn.Intermediaries {
+			buf.WriteString(fmt.Sprintf("- %s(%s) 强度 %.2f，与 %s 权重 %.2f，与 %s 权重 %.2f\n",
+				item.Node.Label(), item.Node.ID, item.Strength,
+				conn.Source.Label(), item.ToSource.Weight, conn.Target.Label(), item.ToTarget.Weight))
+		}
+	}
+	if len(conn.Path) > 0 {
+		names := make([]string, 0, len(conn.Path))
+		for _, n := range conn.Path {
+			names = append(names, n.Label())
+		}
+		buf.WriteString("关系最强的路径：" + strings.Join(names, " → ") + "\n")
+	} else {
+		buf.WriteString("关系图中两人之间没有路径\n")
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: buf.String(),
+			},
+		},
+	}, nil
+}
+
+// resolveGraphNode 将 ID、昵称或备注名解析为关系图中的节点 ID
+func (s *Service) resolveGraphNode(g *graph.Graph, key string) string {
+	if g.Node(key) != nil {
+		return key
+	}
+	if contact, _ := s.db.GetContact(key); contact != nil && g.Node(contact.UserName) != nil {
+		return contact.UserName
+	}
+	for _, n := range g.Nodes {
+		if n.Name == key {
+			return n.ID
+		}
+	}
+	return ""
+}
+
+type MentionsRequest struct {
+	Time       string `json:"time"`
+	Talker     string `json:"talker"`
+	User       string `json:"user"`
+	Unanswered bool   `json:"unanswered"`
+}
+
+func (s *Service) handleMCPMentions(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	var req MentionsRequest
+	if err := request.BindArguments(&req); err != nil {
+		return errors.ErrMCPTool(err), nil
+	}
+
+	if req.Time == "" {
+		req.Time = "last-7d"
+	}
+	start, end, ok := util.TimeRangeOf(req.Time)
+	if !ok {
+		return errors.ErrMCPTool(errors.InvalidArg("time")), nil
+	}
+
+	messages, err := s.db.GetMentions(start, end, req.Talker, req.User, req.Unanswered)
+	if err != nil {
+		return errors.ErrMCPTool(err), nil
+	}
+
+	buf := &bytes.Buffer{}
+	if len(messages) == 0 {
+		buf.WriteString("没有找到相关的 @ 消息\n")
+	}
+	for _, m := range messages {
+		buf.WriteString(m.PlainText(true, util.PerfectTimeFormat(start, end), ""))
+		buf.WriteString("\n")
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: buf.String(),
+			},
+		},
+	}, nil
+}
+
+type RecallsRequest struct {
+	Time   string `json:"time"`
+	Talker string `json:"talker"`
+}
+
+func (s *Service) handleMCPRecalls(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	var req RecallsRequest
+	if err := request.BindArguments(&req); err != nil {
+		return errors.ErrMCPTool(err), nil
+	}
+
+	if req.Time == "" {
+		req.Time = "last-7d"
+	}
+	start, end, ok := util.TimeRangeOf(req.Time)
+	if !ok {
+		return errors.ErrMCPTool(errors.InvalidArg("time")), nil
+	}
+
+	recalls, err := s.db.GetRecalls(start, end, req.Talker, 0, 0)
+	if err != nil {
+		return errors.ErrMCPTool(err), nil
+	}
+
+	buf := &bytes.Buffer{}
+	if len(recalls) == 0 {
+		buf.WriteString("没有找到撤回的消息\n")
+	}
+	for _, r := range recalls {
+		buf.WriteString(recallText(r, util.PerfectTimeFormat(start, end), ""))
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: buf.String(),
+			},
+		},
+	}, nil
+}
+
+type LedgerRequest struct {
+	Time   string `json:"time"`
+	Talker string `json:"talker"`
+	Period string `json:"period"`
+}
+
+func (s *Service) handleMCPLedger(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	var req LedgerRequest
+	if err := request.BindArguments(&req); err != nil {
+		return errors.ErrMCPTool(err), nil
+	}
+
+	if req.Time == "" {
+		req.Time = "this-year"
+	}
+	start, end, ok := util.TimeRangeOf(req.Time)
+	if !ok {
+		return errors.ErrMCPTool(errors.InvalidArg("time")), nil
+	}
+
+	l, err := ledger.Build(s.db, ledger.Options{
+		Start:  start,
+		End:    end,
+		Talker: req.Talker,
+		Period: strings.ToLower(req.Period),
+	})
+	if err != nil {
+		return errors.ErrMCPTool(err), nil
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(l.PlainText())
+	if len(l.ByContact) > 0 {
+		buf.WriteString("\n按联系人汇总:\n")
+		l.WriteTotalsCSV(buf, "contact")
+	}
+	if len(l.ByPeriod) > 0 {
+		buf.WriteString("\n按周期汇总:\n")
+		l.WriteTotalsCSV(buf, "period")
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: buf.String(),
+			},
+		},
+	}, nil
+}
+
 type GetUserProfileRequest struct {
 	Key string `json:"key"`
 }
@@ -815,20 +1381,22 @@ func (s *Service) handleMCPSearchSharedFiles(ctx context.Context, request mcp.Ca
 		return errors.ErrMCPTool(err), nil
 	}
 
-	// 查找 MessageTypeShare (49) 且 MessageSubTypeFile (6)
-	messages, err := s.db.GetMessages(time.Time{}, time.Now(), req.Talker, "", req.Keyword, 50, 0)
+	files, total, err := s.catalog.Files(catalog.Query{Talker: req.Talker, Keyword: req.Keyword, Limit: 50})
 	if err != nil {
 		return errors.ErrMCPTool(err), nil
 	}
 
 	buf := &bytes.Buffer{}
-	count := 0
-	for _, m := range messages {
-		if m.Type == model.MessageTypeShare && m.SubType == model.MessageSubTypeFile {
-			title, _ := m.Contents["title"].(string)
-			buf.WriteString(fmt.Sprintf("[%d] %s - %s\n", m.Seq, m.Time.Format("2006-01-02 15:04"), title))
-			count++
+	count := len(files)
+	for _, f := range files {
+		buf.WriteString(fmt.Sprintf("[%d] %s - %s", f.Seq, f.Time.Format("2006-01-02 15:04"), f.Name))
+		if f.Size > 0 {
+			buf.WriteString(fmt.Sprintf(" (%s)", util.ByteCountSI(f.Size)))
 		}
+		buf.WriteString("\n")
+	}
+	if total > count {
+		buf.WriteString(fmt.Sprintf("仅显示最近 %d 个，共 %d 个\n", count, total))
 	}
 
 	if count == 0 {
@@ -894,5 +1462,3 @@ func (s *Service) handleMCPRelationshipMilestones(ctx context.Context, request m
 		},
 	), nil
 }
-
-
@@ -0,0 +1,237 @@
+package http
+
+import (
+	"encoding/base64"
+	"fmt"
+	"hash/crc32"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
+)
+
+const (
+	// 默认单次返回的字符预算
+	defaultMaxChars = 20000
+
+	// 紧凑模式下同一发送者连续发言合并表头的最大时间间隔
+	compactHeaderGap = 5 * time.Minute
+
+	// 紧凑模式下链接的最大显示长度
+	compactLinkMaxLen = 64
+)
+
+var linkRegexp = regexp.MustCompile(`https?://[^\s)\]]+`)
+
+// chatLogPageOptions 聊天记录分页渲染参数
+type chatLogPageOptions struct {
+	MaxChars     int
+	MaxMessages  int
+	Compact      bool
+	ShowChatRoom bool
+	TimeFormat   string
+}
+
+// chatLogPage 按预算切分后的一页聊天记录
+type chatLogPage struct {
+	Text  string
+	Start int // 本页第一条消息的下标
+	End   int // 本页最后一条消息之后的下标，即下一页的起点
+	Total int
+}
+
+// renderChatLogPage 从 offset 开始渲染消息，直到达到字符或消息数预算
+// 切分结果只取决于消息列表与参数，相同查询重复调用得到相同的分页
+func renderChatLogPage(messages []*model.Message, offset int, opts chatLogPageOptions) chatLogPage {
+	page := chatLogPage{Start: offset, End: offset, Total: len(messages)}
+	if offset >= len(messages) {
+		return page
+	}
+
+	buf := strings.Builder{}
+	chars := 0
+	var prev *model.Message
+	for i := offset; i < len(messages); {
+		if opts.MaxMessages > 0 && i-offset >= opts.MaxMessages {
+			break
+		}
+
+		var text string
+		n := 1
+		if opts.Compact {
+			n = compactRun(messages[i:], opts.MaxMessages-(i-offset))
+			text = compactBlock(messages[i:i+n], prev, opts)
+		} else {
+			text = messages[i].PlainText(opts.ShowChatRoom, opts.TimeFormat, "") + "\n"
+		}
+
+		size := utf8.RuneCountInString(text)
+		if opts.MaxChars > 0 && chars+size > opts.MaxChars {
+			if i > offset {
+				break
+			}
+			// 单条消息超出预算时截断输出，保证分页可以继续推进
+			text = truncateRunes(text, opts.MaxChars) + "…(已截断)\n"
+			size = utf8.RuneCountInString(text)
+		}
+
+		buf.WriteString(text)
+		chars += size
+		prev = messages[i+n-1]
+		i += n
+		page.End = i
+	}
+	page.Text = buf.String()
+	return page
+}
+
+// compactRun 计算可以合并显示的连续媒体消息数量（同一发送者、同一类型）
+func compactRun(messages []*model.Message, limit int) int {
+	first := messages[0]
+	if !isCollapsibleMedia(first) {
+		return 1
+	}
+	n := 1
+	for n < len(messages) && (limit <= 0 || n < limit) {
+		m := messages[n]
+		if m.Sender != first.Sender || m.Talker != first.Talker || m.Type != first.Type || m.SubType != first.SubType {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+func isCollapsibleMedia(m *model.Message) bool {
+	switch m.Type {
+	case model.MessageTypeVoice:
+		// 已转写的语音按文本显示
+		transcript, _ := m.Contents["transcript"].(string)
+		return transcript == ""
+	case model.MessageTypeImage:
+		// 已识别出文字的图片按文本显示
+		ocr, _ := m.Contents["ocr"].(string)
+		return ocr == ""
+	case model.MessageTypeVideo, model.MessageTypeAnimation:
+		return true
+	case model.MessageTypeShare:
+		return m.SubType == model.MessageSubTypeGIF
+	}
+	return false
+}
+
+// compactBlock 紧凑渲染：相邻的同一发送者省略表头，媒体消息折叠为占位符，长链接截断
+func compactBlock(messages []*model.Message, prev *model.Message, opts chatLogPageOptions) string {
+	m := messages[0]
+	buf := strings.Builder{}
+
+	if prev == nil || prev.Sender != m.Sender || prev.Talker != m.Talker || m.Time.Sub(prev.Time) > compactHeaderGap {
+		sender := m.Sender
+		if m.IsSelf {
+			sender = "我"
+		}
+		if m.SenderName != "" {
+			sender = m.SenderName + "(" + sender + ")"
+		}
+		buf.WriteString(sender)
+		if m.IsChatRoom && opts.ShowChatRoom {
+			buf.WriteString(" [")
+			if m.TalkerName != "" {
+				buf.WriteString(m.TalkerName + "(" + m.Talker + ")")
+			} else {
+				buf.WriteString(m.Talker)
+			}
+			buf.WriteString("]")
+		}
+		timeFormat := opts.TimeFormat
+		if timeFormat == "" {
+			timeFormat = "01-02 15:04:05"
+		}
+		buf.WriteString(" ")
+		buf.WriteString(m.Time.Format(timeFormat))
+		buf.WriteString("\n")
+	}
+
+	buf.WriteString(fmt.Sprintf("[%d] ", m.Seq))
+	if len(messages) > 1 || isCollapsibleMedia(m) {
+		buf.WriteString(mediaPlaceholder(m, len(messages)))
+	} else {
+		m.SetContent("host", "")
+		buf.WriteString(truncateLinks(m.PlainTextContent()))
+	}
+	buf.WriteString("\n")
+	return buf.String()
+}
+
+func mediaPlaceholder(m *model.Message, count int) string {
+	var name string
+	switch m.Type {
+	case model.MessageTypeImage:
+		name = "图片"
+	case model.MessageTypeVoice:
+		name = "语音"
+	case model.MessageTypeVideo:
+		name = "视频"
+	case model.MessageTypeAnimation:
+		name = "动画表情"
+	default:
+		name = "GIF表情"
+	}
+	if count > 1 {
+		return fmt.Sprintf("[%s×%d]", name, count)
+	}
+	return "[" + name + "]"
+}
+
+func truncateLinks(s string) string {
+	return linkRegexp.ReplaceAllStringFunc(s, func(link string) string {
+		if utf8.RuneCountInString(link) <= compactLinkMaxLen {
+			return link
+		}
+		return truncateRunes(link, compactLinkMaxLen) + "…"
+	})
+}
+
+func truncateRunes(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	for i := range s {
+		if n == 0 {
+			return s[:i]
+		}
+		n--
+	}
+	return s
+}
+
+// encodeCursor 生成续页标记，包含下一页起点与查询条件指纹
+func encodeCursor(offset int, query ...string) string {
+	sum := crc32.ChecksumIEEE([]byte(strings.Join(query, "\x00")))
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d.%08x", offset, sum)))
+}
+
+// decodeCursor 解析续页标记，查询条件与生成时不一致时返回错误
+func decodeCursor(cursor string, query ...string) (int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, errors.InvalidArg("cursor")
+	}
+	parts := strings.SplitN(string(b), ".", 2)
+	if len(parts) != 2 {
+		return 0, errors.InvalidArg("cursor")
+	}
+	offset, err := strconv.Atoi(parts[0])
+	if err != nil || offset < 0 {
+		return 0, errors.InvalidArg("cursor")
+	}
+	sum := crc32.ChecksumIEEE([]byte(strings.Join(query, "\x00")))
+	if parts[1] != fmt.Sprintf("%08x", sum) {
+		return 0, errors.InvalidArg("cursor (query changed)")
+	}
+	return offset, nil
+}
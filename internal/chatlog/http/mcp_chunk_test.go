@@ -0,0 +1,243 @@
+package http
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sjzar/chatlog/internal/model"
+)
+
+func chunkMsg(seq int64, sender string, typ int64, content string) *model.Message {
+	return &model.Message{
+		Seq:      seq,
+		Time:     time.Unix(1700000000+seq, 0),
+		Talker:   "wxid_a",
+		Sender:   sender,
+		Type:     typ,
+		Content:  content,
+		Contents: map[string]interface{}{},
+	}
+}
+
+func TestRenderChatLogPage(t *testing.T) {
+	text := func(n int) []*model.Message {
+		ret := make([]*model.Message, 0, n)
+		for i := 1; i <= n; i++ {
+			ret = append(ret, chunkMsg(int64(i), "wxid_a", model.MessageTypeText, strings.Repeat("x", 20)))
+		}
+		return ret
+	}
+	lineSize := utf8.RuneCountInString(text(1)[0].PlainText(false, "", "") + "\n")
+
+	tests := []struct {
+		name      string
+		messages  []*model.Message
+		offset    int
+		opts      chatLogPageOptions
+		wantStart int
+		wantEnd   int
+		wantText  []string
+	}{
+		{
+			name:      "no budget",
+			messages:  text(5),
+			opts:      chatLogPageOptions{},
+			wantStart: 0,
+			wantEnd:   5,
+		},
+		{
+			name:      "char budget cut-off",
+			messages:  text(5),
+			opts:      chatLogPageOptions{MaxChars: lineSize*2 + lineSize/2},
+			wantStart: 0,
+			wantEnd:   2,
+		},
+		{
+			name:      "char budget from offset",
+			messages:  text(5),
+			offset:    3,
+			opts:      chatLogPageOptions{MaxChars: lineSize * 3},
+			wantStart: 3,
+			wantEnd:   5,
+		},
+		{
+			name:      "message budget",
+			messages:  text(5),
+			opts:      chatLogPageOptions{MaxMessages: 3},
+			wantStart: 0,
+			wantEnd:   3,
+		},
+		{
+			name:      "single message over budget is truncated",
+			messages:  text(3),
+			offset:    1,
+			opts:      chatLogPageOptions{MaxChars: 10},
+			wantStart: 1,
+			wantEnd:   2,
+			wantText:  []string{"…(已截断)\n"},
+		},
+		{
+			name:      "offset past end",
+			messages:  text(2),
+			offset:    2,
+			opts:      chatLogPageOptions{MaxChars: 10},
+			wantStart: 2,
+			wantEnd:   2,
+		},
+		{
+			name: "compact collapses media runs",
+			messages: []*model.Message{
+				chunkMsg(1, "wxid_a", model.MessageTypeImage, ""),
+				chunkMsg(2, "wxid_a", model.MessageTypeImage, ""),
+				chunkMsg(3, "wxid_a", model.MessageTypeImage, ""),
+				chunkMsg(4, "wxid_a", model.MessageTypeText, "hello"),
+				chunkMsg(5, "wxid_b", model.MessageTypeVoice, ""),
+			},
+			opts:      chatLogPageOptions{Compact: true},
+			wantStart: 0,
+			wantEnd:   5,
+			wantText:  []string{"[1] [图片×3]\n[4] hello\n", "[5] [语音]\n"},
+		},
+		{
+			name: "compact run respects message budget",
+			messages: []*model.Message{
+				chunkMsg(1, "wxid_a", model.MessageTypeImage, ""),
+				chunkMsg(2, "wxid_a", model.MessageTypeImage, ""),
+				chunkMsg(3, "wxid_a", model.MessageTypeImage, ""),
+			},
+			opts:      chatLogPageOptions{Compact: true, MaxMessages: 2},
+			wantStart: 0,
+			wantEnd:   2,
+			wantText:  []string{"[1] [图片×2]\n"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page := renderChatLogPage(tt.messages, tt.offset, tt.opts)
+			if page.Start != tt.wantStart || page.End != tt.wantEnd || page.Total != len(tt.messages) {
+				t.Errorf("renderChatLogPage() = {%d %d %d}, want {%d %d %d}",
+					page.Start, page.End, page.Total, tt.wantStart, tt.wantEnd, len(tt.messages))
+			}
+			if tt.opts.MaxChars > 0 && tt.wantEnd > tt.wantStart {
+				if size := utf8.RuneCountInString(page.Text); size > tt.opts.MaxChars+utf8.RuneCountInString("…(已截断)\n") {
+					t.Errorf("renderChatLogPage() text size %d exceeds budget %d", size, tt.opts.MaxChars)
+				}
+			}
+			for _, want := range tt.wantText {
+				if !strings.Contains(page.Text, want) {
+					t.Errorf("renderChatLogPage() text = %q, want containing %q", page.Text, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCompactRun(t *testing.T) {
+	image := func(seq int64, sender string) *model.Message {
+		return chunkMsg(seq, sender, model.MessageTypeImage, "")
+	}
+	ocr := image(3, "wxid_a")
+	ocr.Contents["ocr"] = "text"
+
+	tests := []struct {
+		name     string
+		messages []*model.Message
+		limit    int
+		want     int
+	}{
+		{
+			name:     "text is not collapsed",
+			messages: []*model.Message{chunkMsg(1, "wxid_a", model.MessageTypeText, "a"), chunkMsg(2, "wxid_a", model.MessageTypeText, "b")},
+			want:     1,
+		},
+		{
+			name:     "same sender images",
+			messages: []*model.Message{image(1, "wxid_a"), image(2, "wxid_a"), image(3, "wxid_a")},
+			want:     3,
+		},
+		{
+			name:     "limit",
+			messages: []*model.Message{image(1, "wxid_a"), image(2, "wxid_a"), image(3, "wxid_a")},
+			limit:    2,
+			want:     2,
+		},
+		{
+			name:     "sender change",
+			messages: []*model.Message{image(1, "wxid_a"), image(2, "wxid_b")},
+			want:     1,
+		},
+		{
+			name:     "type change",
+			messages: []*model.Message{image(1, "wxid_a"), chunkMsg(2, "wxid_a", model.MessageTypeVideo, "")},
+			want:     1,
+		},
+		{
+			name:     "recognized image is shown as text",
+			messages: []*model.Message{ocr, image(4, "wxid_a")},
+			want:     1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := compactRun(tt.messages, tt.limit); got != tt.want {
+				t.Errorf("compactRun() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompactBlock(t *testing.T) {
+	first := chunkMsg(1, "wxid_a", model.MessageTypeText, "see https://example.com/"+strings.Repeat("a", 100))
+	first.SenderName = "Alice"
+	next := chunkMsg(2, "wxid_a", model.MessageTypeText, "hi")
+	late := chunkMsg(2, "wxid_a", model.MessageTypeText, "hi")
+	late.Time = first.Time.Add(compactHeaderGap + time.Second)
+
+	got := compactBlock([]*model.Message{first}, nil, chatLogPageOptions{})
+	if !strings.HasPrefix(got, "Alice(wxid_a) ") {
+		t.Errorf("compactBlock() = %q, want header", got)
+	}
+	if !strings.Contains(got, "…") || strings.Contains(got, strings.Repeat("a", 100)) {
+		t.Errorf("compactBlock() = %q, want truncated link", got)
+	}
+
+	if got := compactBlock([]*model.Message{next}, first, chatLogPageOptions{}); got != "[2] hi\n" {
+		t.Errorf("compactBlock() = %q, want %q", got, "[2] hi\n")
+	}
+	if got := compactBlock([]*model.Message{late}, first, chatLogPageOptions{}); !strings.HasPrefix(got, "wxid_a ") {
+		t.Errorf("compactBlock() = %q, want header after gap", got)
+	}
+}
+
+func TestCursor(t *testing.T) {
+	query := []string{"2024-01-01", "wxid_a", "", "", "0", "0"}
+
+	cursor := encodeCursor(42, query...)
+	offset, err := decodeCursor(cursor, query...)
+	if err != nil || offset != 42 {
+		t.Fatalf("decodeCursor() = %d, %v, want 42", offset, err)
+	}
+
+	tests := []struct {
+		name   string
+		cursor string
+		query  []string
+	}{
+		{name: "query changed", cursor: cursor, query: []string{"2024-01-02", "wxid_a", "", "", "0", "0"}},
+		{name: "invalid base64", cursor: "!!", query: query},
+		{name: "missing checksum", cursor: "NDI", query: query},
+		{name: "negative offset", cursor: encodeCursor(-1, query...), query: query},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeCursor(tt.cursor, tt.query...); err == nil {
+				t.Errorf("decodeCursor() error = nil, want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,443 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/chatlog/database"
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
+	"github.com/sjzar/chatlog/pkg/util"
+	"github.com/sjzar/chatlog/pkg/util/silk"
+)
+
+const (
+	ResourceContacts  = "chatlog://contacts"
+	ResourceChatRooms = "chatlog://chatrooms"
+	ResourceSessions  = "chatlog://sessions"
+
+	// 最近会话以资源形式列出的数量上限
+	recentChatResourceLimit = 20
+
+	// 文件变更事件的合并等待时间
+	resourceNotifyDelay = 500 * time.Millisecond
+)
+
+// URI 模板中的变量需按 RFC 6570 进行百分号编码，例如 "123@chatroom" 应写作 "123%40chatroom"
+var (
+	ContactResourceTemplate = mcp.NewResourceTemplate(
+		"chatlog://contact/{id}",
+		"联系人资料",
+		mcp.WithTemplateDescription("单个联系人的详细资料，id 为联系人 ID、微信号、备注名或昵称"),
+		mcp.WithTemplateMIMEType("application/json"),
+	)
+
+	ChatRoomResourceTemplate = mcp.NewResourceTemplate(
+		"chatlog://chatroom/{id}",
+		"群聊资料",
+		mcp.WithTemplateDescription("单个群聊的详细资料，id 为群 ID、群名称或备注名"),
+		mcp.WithTemplateMIMEType("application/json"),
+	)
+
+	ChatRoomMembersResourceTemplate = mcp.NewResourceTemplate(
+		"chatlog://chatroom/{id}/members",
+		"群成员列表",
+		mcp.WithTemplateDescription("群聊成员列表，包含成员 ID 与群昵称"),
+		mcp.WithTemplateMIMEType("text/csv"),
+	)
+
+	ChatResourceTemplate = mcp.NewResourceTemplate(
+		"chatlog://chat/{talker}/{date}",
+		"聊天记录",
+		mcp.WithTemplateDescription("指定对话方在指定时间范围内的聊天记录，date 支持 query_chat_log 的所有时间格式，如 2023-04-18、2023-04-01~2023-04-18、last-7d"),
+		mcp.WithTemplateMIMEType("text/plain"),
+	)
+
+	MediaResourceTemplate = mcp.NewResourceTemplate(
+		"chatlog://media/{talker}/{seq}",
+		"消息媒体",
+		mcp.WithTemplateDescription("图片或语音消息解码后的媒体内容，seq 为消息的 MessageID；语音转换为 MP3"),
+	)
+)
+
+// resourceNotifier 将数据库文件变更事件转换为 MCP 资源列表变更通知
+type resourceNotifier struct {
+	ch chan string
+
+	// 串行化资源列表的重新生成
+	mutex sync.Mutex
+}
+
+func (s *Service) initMCPResources() {
+	s.mcpServer.AddResourceTemplate(ContactResourceTemplate, s.handleMCPContactResource)
+	s.mcpServer.AddResourceTemplate(ChatRoomResourceTemplate, s.handleMCPChatRoomResource)
+	s.mcpServer.AddResourceTemplate(ChatRoomMembersResourceTemplate, s.handleMCPChatRoomMembersResource)
+	s.mcpServer.AddResourceTemplate(ChatResourceTemplate, s.handleMCPChatResource)
+	s.mcpServer.AddResourceTemplate(MediaResourceTemplate, s.handleMCPMediaResource)
+	s.notifier = &resourceNotifier{
+		ch: make(chan string, 8),
+	}
+	s.mcpServer.SetResources(s.staticResources()...)
+	s.refreshResources()
+	for _, group := range []string{"contact", "session", "message"} {
+		if err := s.db.AddCallback(group, s.resourceCallback(group)); err != nil {
+			log.Debug().Err(err).Msgf("set resource callback for group %s failed", group)
+		}
+	}
+	go s.notifyLoop()
+}
+
+func (s *Service) staticResources() []server.ServerResource {
+	return []server.ServerResource{
+		{
+			Resource: mcp.NewResource(ResourceContacts, "联系人列表",
+				mcp.WithResourceDescription("全部联系人，包含 ID、微信号、备注名和昵称"),
+				mcp.WithMIMEType("text/csv"),
+			),
+			Handler: s.handleMCPContactsResource,
+		},
+		{
+			Resource: mcp.NewResource(ResourceChatRooms, "群聊列表",
+				mcp.WithResourceDescription("全部群聊，包含群 ID、备注名、群名称、群主和成员数"),
+				mcp.WithMIMEType("text/csv"),
+			),
+			Handler: s.handleMCPChatRoomsResource,
+		},
+		{
+			Resource: mcp.NewResource(ResourceSessions, "最近会话",
+				mcp.WithResourceDescription("最近会话列表，按最后消息时间排序"),
+				mcp.WithMIMEType("text/plain"),
+			),
+			Handler: s.handleMCPSessionsResource,
+		},
+	}
+}
+
+// refreshResources 重新生成资源列表，将最近会话的最后一天聊天记录作为资源列出
+// 返回 false 表示数据库未就绪，资源列表没有变化
+func (s *Service) refreshResources() bool {
+	if s.db.State != database.StateReady {
+		return false
+	}
+	sessions, err := s.db.GetSessions("", recentChatResourceLimit, 0)
+	if err != nil {
+		log.Debug().Err(err).Msg("Failed to get sessions for resources")
+		return false
+	}
+
+	s.notifier.mutex.Lock()
+	defer s.notifier.mutex.Unlock()
+
+	resources := s.staticResources()
+	for _, session := range sessions.Items {
+		talker, date := session.UserName, session.NTime.Format(time.DateOnly)
+		uri := "chatlog://chat/" + escapeResourceVar(talker) + "/" + date
+		name := session.NickName
+		if name == "" {
+			name = session.UserName
+		}
+		resources = append(resources, server.ServerResource{
+			Resource: mcp.NewResource(uri, fmt.Sprintf("%s %s", name, date),
+				mcp.WithResourceDescription(fmt.Sprintf("与 %s 最近一天的聊天记录", name)),
+				mcp.WithMIMEType("text/plain"),
+			),
+			// 直接注册的资源不经过模板匹配，需要手动填充参数
+			Handler: func(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+				request.Params.Arguments = map[string]any{"talker": talker, "date": date}
+				return s.handleMCPChatResource(ctx, request)
+			},
+		})
+	}
+
+	// SetResources 会向所有客户端发送 list_changed 通知
+	s.mcpServer.SetResources(resources...)
+	return true
+}
+
+func (s *Service) resourceCallback(group string) func(event fsnotify.Event) error {
+	return func(event fsnotify.Event) error {
+		// 解密后的文件通过重新创建的方式写入，仅关注 Create 事件
+		if !event.Op.Has(fsnotify.Create) {
+			return nil
+		}
+		select {
+		case s.notifier.ch <- group:
+		default:
+		}
+		return nil
+	}
+}
+
+func (s *Service) notifyLoop() {
+	for group := range s.notifier.ch {
+		// 合并短时间内的连续变更
+		time.Sleep(resourceNotifyDelay)
+		groups := map[string]bool{group: true}
+	drain:
+		for {
+			select {
+			case g := <-s.notifier.ch:
+				groups[g] = true
+			default:
+				break drain
+			}
+		}
+
+		// 没有实现资源订阅，resources/updated 只能发给订阅了该资源的客户端，
+		// 因此只发送 list_changed，由客户端重新读取资源列表与内容
+		if (groups["session"] || groups["message"]) && s.refreshResources() {
+			continue
+		}
+		s.mcpServer.SendNotificationToAllClients(mcp.MethodNotificationResourcesListChanged, nil)
+	}
+}
+
+func (s *Service) checkMCPDBState() error {
+	switch s.db.State {
+	case database.StateInit:
+		return fmt.Errorf("database is not ready")
+	case database.StateDecrypting:
+		return fmt.Errorf("database is decrypting, please wait")
+	case database.StateError:
+		return fmt.Errorf("database is error: %s", s.db.StateMsg)
+	}
+	return nil
+}
+
+func (s *Service) handleMCPContactsResource(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+	if err := s.checkMCPDBState(); err != nil {
+		return nil, err
+	}
+	list, err := s.db.GetContacts("", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	w := csv.NewWriter(buf)
+	w.Write([]string{"UserName", "Alias", "Remark", "NickName"})
+	for _, contact := range list.Items {
+		w.Write([]string{contact.UserName, contact.Alias, contact.Remark, contact.NickName})
+	}
+	w.Flush()
+	return textResource(request.Params.URI, "text/csv", buf.String()), nil
+}
+
+func (s *Service) handleMCPChatRoomsResource(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+	if err := s.checkMCPDBState(); err != nil {
+		return nil, err
+	}
+	list, err := s.db.GetChatRooms("", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	w := csv.NewWriter(buf)
+	w.Write([]string{"Name", "Remark", "NickName", "Owner", "UserCount"})
+	for _, chatRoom := range list.Items {
+		w.Write([]string{chatRoom.Name, chatRoom.Remark, chatRoom.NickName, chatRoom.Owner, strconv.Itoa(len(chatRoom.Users))})
+	}
+	w.Flush()
+	return textResource(request.Params.URI, "text/csv", buf.String()), nil
+}
+
+func (s *Service) handleMCPSessionsResource(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+	if err := s.checkMCPDBState(); err != nil {
+		return nil, err
+	}
+	data, err := s.db.GetSessions("", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	for _, session := range data.Items {
+		buf.WriteString(session.PlainText(120))
+		buf.WriteString("\n")
+	}
+	return textResource(request.Params.URI, "text/plain", buf.String()), nil
+}
+
+func (s *Service) handleMCPContactResource(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+	if err := s.checkMCPDBState(); err != nil {
+		return nil, err
+	}
+	id := resourceArgument(request, "id")
+	if id == "" {
+		return nil, errors.ErrKeyEmpty
+	}
+	contact, err := s.db.GetContact(id)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(contact)
+	if err != nil {
+		return nil, err
+	}
+	return textResource(request.Params.URI, "application/json", string(b)), nil
+}
+
+func (s *Service) handleMCPChatRoomResource(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+	if err := s.checkMCPDBState(); err != nil {
+		return nil, err
+	}
+	id := resourceArgument(request, "id")
+	if id == "" {
+		return nil, errors.ErrKeyEmpty
+	}
+	chatRoom, err := s.db.GetChatRoom(id)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(chatRoom)
+	if err != nil {
+		return nil, err
+	}
+	return textResource(request.Params.URI, "application/json", string(b)), nil
+}
+
+func (s *Service) handleMCPChatRoomMembersResource(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+	if err := s.checkMCPDBState(); err != nil {
+		return nil, err
+	}
+	id := resourceArgument(request, "id")
+	if id == "" {
+		return nil, errors.ErrKeyEmpty
+	}
+	chatRoom, err := s.db.GetChatRoom(id)
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	w := csv.NewWriter(buf)
+	w.Write([]string{"UserName", "DisplayName", "IsOwner"})
+	for _, user := range chatRoom.Users {
+		w.Write([]string{user.UserName, chatRoom.User2DisplayName[user.UserName], strconv.FormatBool(user.UserName == chatRoom.Owner)})
+	}
+	w.Flush()
+	return textResource(request.Params.URI, "text/csv", buf.String()), nil
+}
+
+func (s *Service) handleMCPChatResource(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+	if err := s.checkMCPDBState(); err != nil {
+		return nil, err
+	}
+	talker := resourceArgument(request, "talker")
+	date := resourceArgument(request, "date")
+	if talker == "" {
+		return nil, errors.ErrTalkerEmpty
+	}
+	start, end, ok := util.TimeRangeOf(date)
+	if !ok {
+		return nil, errors.InvalidArg("date")
+	}
+
+	messages, err := s.db.GetMessages(start, end, talker, "", "", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	for _, m := range messages {
+		buf.WriteString(m.PlainText(strings.Contains(talker, ","), util.PerfectTimeFormat(start, end), ""))
+		buf.WriteString("\n")
+	}
+	return textResource(request.Params.URI, "text/plain", buf.String()), nil
+}
+
+func (s *Service) handleMCPMediaResource(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+	if err := s.checkMCPDBState(); err != nil {
+		return nil, err
+	}
+	talker := resourceArgument(request, "talker")
+	if talker == "" {
+		return nil, errors.ErrTalkerEmpty
+	}
+	seq, err := strconv.ParseInt(resourceArgument(request, "seq"), 10, 64)
+	if err != nil {
+		return nil, errors.InvalidArg("seq")
+	}
+
+	msg, err := s.db.GetMessage(talker, seq)
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	var mimeType string
+	switch msg.Type {
+	case model.MessageTypeImage:
+		key, ok := msg.Contents["md5"].(string)
+		if !ok {
+			key, _ = msg.Contents["path"].(string)
+		}
+		if key == "" {
+			return nil, errors.ErrMediaNotFound
+		}
+		if data, mimeType, err = s.readImage(key); err != nil {
+			return nil, err
+		}
+	case model.MessageTypeVoice:
+		key, _ := msg.Contents["voice"].(string)
+		if key == "" {
+			return nil, errors.ErrMediaNotFound
+		}
+		media, err := s.db.GetMedia("voice", key)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = s.convertVoice(key, media.Data, silk.FormatMP3, 0); err != nil {
+			return nil, err
+		}
+		mimeType = silk.ContentType(silk.FormatMP3)
+	default:
+		return nil, fmt.Errorf("暂不支持的消息类型: %d", msg.Type)
+	}
+
+	return []mcp.ResourceContents{
+		mcp.BlobResourceContents{
+			URI:      request.Params.URI,
+			MIMEType: mimeType,
+			Blob:     base64.StdEncoding.EncodeToString(data),
+		},
+	}, nil
+}
+
+func textResource(uri, mimeType, text string) []mcp.ResourceContents {
+	return []mcp.ResourceContents{
+		mcp.TextResourceContents{
+			URI:      uri,
+			MIMEType: mimeType,
+			Text:     text,
+		},
+	}
+}
+
+// resourceArgument 读取 URI 模板变量，mcp-go 按模板匹配时以 []string 填充，直接注册的资源手动填充为 string
+func resourceArgument(request mcp.ReadResourceRequest, name string) string {
+	switch v := request.Params.Arguments[name].(type) {
+	case []string:
+		if len(v) > 0 {
+			return v[0]
+		}
+	case string:
+		return v
+	}
+	return ""
+}
+
+// escapeResourceVar 按 RFC 6570 简单展开规则编码 URI 变量，如 "@" 编码为 "%40"
+func escapeResourceVar(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
@@ -0,0 +1,80 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+func TestResourceArgument(t *testing.T) {
+	tests := []struct {
+		name     string
+		template mcp.ResourceTemplate
+		uri      string
+		want     map[string]string
+	}{
+		{
+			name:     "contact",
+			template: ContactResourceTemplate,
+			uri:      "chatlog://contact/wxid_abc",
+			want:     map[string]string{"id": "wxid_abc"},
+		},
+		{
+			name:     "chatroom members",
+			template: ChatRoomMembersResourceTemplate,
+			uri:      "chatlog://chatroom/" + escapeResourceVar("123@chatroom") + "/members",
+			want:     map[string]string{"id": "123@chatroom"},
+		},
+		{
+			name:     "chat",
+			template: ChatResourceTemplate,
+			uri:      "chatlog://chat/wxid_abc/2023-04-18",
+			want:     map[string]string{"talker": "wxid_abc", "date": "2023-04-18"},
+		},
+		{
+			name:     "media",
+			template: MediaResourceTemplate,
+			uri:      "chatlog://media/wxid_abc/1681800000000001",
+			want:     map[string]string{"talker": "wxid_abc", "seq": "1681800000000001"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make(map[string]string)
+			s := server.NewMCPServer("test", "1.0", server.WithResourceCapabilities(false, true))
+			s.AddResourceTemplate(tt.template, func(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+				for name := range tt.want {
+					got[name] = resourceArgument(request, name)
+				}
+				return textResource(request.Params.URI, "text/plain", ""), nil
+			})
+
+			req, _ := json.Marshal(map[string]any{
+				"jsonrpc": mcp.JSONRPC_VERSION,
+				"id":      1,
+				"method":  mcp.MethodResourcesRead,
+				"params":  map[string]any{"uri": tt.uri},
+			})
+			resp := s.HandleMessage(context.Background(), req)
+			if e, ok := resp.(mcp.JSONRPCError); ok {
+				t.Fatalf("read resource %s failed: %v", tt.uri, e.Error)
+			}
+			for name, want := range tt.want {
+				if got[name] != want {
+					t.Errorf("resourceArgument(%q) = %q, want %q", name, got[name], want)
+				}
+			}
+		})
+	}
+
+	// 直接注册的资源手动填充 string 参数
+	request := mcp.ReadResourceRequest{}
+	request.Params.Arguments = map[string]any{"talker": "wxid_abc"}
+	if got := resourceArgument(request, "talker"); got != "wxid_abc" {
+		t.Errorf("resourceArgument(string) = %q, want %q", got, "wxid_abc")
+	}
+}
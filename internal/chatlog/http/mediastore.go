@@ -0,0 +1,144 @@
+package http
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/chatlog/mediastore"
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/pkg/util"
+	"github.com/sjzar/chatlog/pkg/util/dat2img"
+)
+
+// handleMediaRefs 查找引用同一媒体文件的全部消息，可按 hash、md5 或 talker 与 seq 指定媒体
+func (s *Service) handleMediaRefs(c *gin.Context) {
+	q := struct {
+		Hash   string `form:"hash"`
+		MD5    string `form:"md5"`
+		Talker string `form:"talker"`
+		Seq    int64  `form:"seq"`
+	}{}
+
+	if err := c.BindQuery(&q); err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	blob, refs, err := s.db.MediaRefs(q.Hash, q.MD5, q.Talker, q.Seq)
+	if err != nil {
+		errors.Err(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"blob": blob,
+		"refs": refs,
+	})
+}
+
+// handleMediaStore 将时间范围内的媒体写入媒体存储，time 默认为全部时间，talker 为空时处理全部会话
+func (s *Service) handleMediaStore(c *gin.Context) {
+	q := struct {
+		Time   string `form:"time"`
+		Talker string `form:"talker"`
+	}{}
+
+	if err := c.BindQuery(&q); err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	if q.Time == "" {
+		q.Time = "all"
+	}
+	start, end, ok := util.TimeRangeOf(q.Time)
+	if !ok {
+		errors.Err(c, errors.InvalidArg("time"))
+		return
+	}
+
+	ret, err := s.db.StoreMedia(c.Request.Context(), start, end, q.Talker)
+	if err != nil {
+		errors.Err(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, ret)
+}
+
+// handleMediaStoreStats 媒体存储统计
+func (s *Service) handleMediaStoreStats(c *gin.Context) {
+	stats, err := s.db.MediaStoreStats()
+	if err != nil {
+		errors.Err(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+// handleMediaGC 删除媒体存储中没有被引用的文件
+func (s *Service) handleMediaGC(c *gin.Context) {
+	ret, err := s.db.MediaGC()
+	if err != nil {
+		errors.Err(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, ret)
+}
+
+// serveStoredImage 从媒体存储返回解码后的图片，存储中没有时解码并写入存储
+// 返回 false 表示无法解码，由调用方按原有方式处理
+func (s *Service) serveStoredImage(c *gin.Context, store *mediastore.Store, absolutePath string) bool {
+	info, err := os.Stat(absolutePath)
+	if err != nil {
+		return false
+	}
+	blob, err := store.Source(s.dataRelativePath(absolutePath), info.Size(), info.ModTime().UnixNano())
+	if err != nil {
+		log.Debug().Err(err).Msgf("read media store failed: %s", absolutePath)
+		return false
+	}
+	if blob == nil {
+		b, err := os.ReadFile(absolutePath)
+		if err != nil {
+			return false
+		}
+		out, ext, err := dat2img.Dat2Image(b)
+		if err != nil {
+			return false
+		}
+		if blob, err = s.storeDecryptedFile(store, absolutePath, info, out, ext); err != nil {
+			log.Debug().Err(err).Msgf("write media store failed: %s", absolutePath)
+			return false
+		}
+	}
+
+	if blob.Ext == "heic" {
+		// 标准库没有 heic 的 MIME 类型
+		c.Header("Content-Type", "image/heic")
+	}
+	c.File(store.Path(blob))
+	return true
+}
+
+// storeDecryptedFile 将解码后的媒体写入媒体存储，并记录原始文件对应的媒体文件
+func (s *Service) storeDecryptedFile(store *mediastore.Store, datPath string, info os.FileInfo, data []byte, ext string) (*mediastore.Blob, error) {
+	blob, _, err := store.Put(data, ext)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.SetSource(s.dataRelativePath(datPath), info.Size(), info.ModTime().UnixNano(), blob.Hash); err != nil {
+		return nil, err
+	}
+	return blob, nil
+}
+
+// dataRelativePath 数据目录下的相对路径，与媒体存储中记录的原始文件路径一致
+func (s *Service) dataRelativePath(absolutePath string) string {
+	if rel, err := filepath.Rel(s.conf.GetDataDir(), absolutePath); err == nil {
+		return rel
+	}
+	return absolutePath
+}
@@ -0,0 +1,112 @@
+package http
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sjzar/chatlog/internal/chatlog/ocr"
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/pkg/util"
+)
+
+// handleOCR 查询已保存的图片识别文本，keyword 支持正则表达式，time 默认为全部时间
+func (s *Service) handleOCR(c *gin.Context) {
+	q := struct {
+		Time    string `form:"time"`
+		Talker  string `form:"talker"`
+		Keyword string `form:"keyword"`
+		Format  string `form:"format"`
+	}{}
+
+	if err := c.BindQuery(&q); err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	if q.Time == "" {
+		q.Time = "all"
+	}
+	start, end, ok := util.TimeRangeOf(q.Time)
+	if !ok {
+		errors.Err(c, errors.InvalidArg("time"))
+		return
+	}
+
+	results, err := s.db.SearchOCR(start, end, q.Talker, q.Keyword)
+	if err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	switch strings.ToLower(q.Format) {
+	case "csv":
+		c.Writer.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w := csv.NewWriter(c.Writer)
+		w.Write([]string{"MessageID", "Time", "Talker", "Sender", "MD5", "Provider", "Text"})
+		for _, res := range results {
+			w.Write([]string{
+				strconv.FormatInt(res.Seq, 10),
+				res.Time.Format(time.DateTime),
+				res.Talker,
+				res.Sender,
+				res.MD5,
+				res.Provider,
+				res.Text,
+			})
+		}
+		w.Flush()
+	case "text":
+		c.Writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, res := range results {
+			c.Writer.WriteString(ocrText(res))
+		}
+	default:
+		c.JSON(http.StatusOK, results)
+	}
+}
+
+// handleStartOCRJob 在后台识别 time（默认全部时间）内的图片消息，talker 为空时识别全部对话
+// force=1 时忽略已有的识别结果重新识别，任务进度通过 GET /api/v1/ocr/jobs 查询
+func (s *Service) handleStartOCRJob(c *gin.Context) {
+	q := struct {
+		Talker string `form:"talker"`
+		Time   string `form:"time"`
+		Force  bool   `form:"force"`
+	}{}
+
+	if err := c.BindQuery(&q); err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	if q.Time == "" {
+		q.Time = "all"
+	}
+	start, end, ok := util.TimeRangeOf(q.Time)
+	if !ok {
+		errors.Err(c, errors.InvalidArg("time"))
+		return
+	}
+
+	job, err := s.db.StartOCRJob(start, end, q.Talker, q.Force)
+	if err != nil {
+		errors.Err(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, job)
+}
+
+// handleOCRJob 当前或最近一次批量识别任务的进度
+func (s *Service) handleOCRJob(c *gin.Context) {
+	c.JSON(http.StatusOK, s.db.OCRJobStatus())
+}
+
+func ocrText(res *ocr.Result) string {
+	return fmt.Sprintf("%s [%d] %s %s\n%s\n", res.Talker, res.Seq, res.Time.Format(time.DateTime), res.Sender, res.Text)
+}
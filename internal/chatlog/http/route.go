@@ -10,16 +10,19 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog/log"
 	"github.com/xuri/excelize/v2"
 
+	"github.com/sjzar/chatlog/internal/chatlog/graph"
+	"github.com/sjzar/chatlog/internal/chatlog/recall"
+	"github.com/sjzar/chatlog/internal/chatlog/report"
 	"github.com/sjzar/chatlog/internal/errors"
 	"github.com/sjzar/chatlog/internal/model"
 	"github.com/sjzar/chatlog/pkg/util"
 	"github.com/sjzar/chatlog/pkg/util/dat2img"
-	"github.com/sjzar/chatlog/pkg/util/silk"
 )
 
 // EFS holds embedded file system data for static assets.
@@ -53,6 +56,8 @@ func (s *Service) initMediaRouter() {
 	s.router.GET("/video/*key", func(c *gin.Context) { s.handleMedia(c, "video") })
 	s.router.GET("/file/*key", func(c *gin.Context) { s.handleMedia(c, "file") })
 	s.router.GET("/voice/*key", func(c *gin.Context) { s.handleMedia(c, "voice") })
+	s.router.GET("/sns/*key", s.handleSNSMedia)
+	s.router.GET("/emoji/*md5", s.handleEmoji)
 	s.router.GET("/data/*path", s.handleMediaData)
 }
 
@@ -64,6 +69,27 @@ func (s *Service) initAPIRouter() {
 		api.GET("/chatroom", s.handleChatRooms)
 		api.GET("/session", s.handleSessions)
 		api.GET("/sns", s.handleSNS)
+		api.GET("/stats", s.handleStats)
+		api.GET("/report", s.handleReport)
+		api.GET("/graph", s.handleGraph)
+		api.GET("/mentions", s.handleMentions)
+		api.GET("/recalls", s.handleRecalls)
+		api.GET("/thread", s.handleThread)
+		api.GET("/links", s.handleLinks)
+		api.GET("/files", s.handleFiles)
+		api.GET("/media/availability", s.handleMediaAvailability)
+		api.GET("/emoji", s.handleEmojiLibrary)
+		api.GET("/media/refs", s.handleMediaRefs)
+		api.GET("/media/store", s.handleMediaStoreStats)
+		api.POST("/media/store", s.handleMediaStore)
+		api.POST("/media/gc", s.handleMediaGC)
+		api.GET("/ledger", s.handleLedger)
+		api.GET("/locations", s.handleLocations)
+		api.GET("/transcripts", s.handleTranscripts)
+		api.POST("/transcribe", s.handleTranscribe)
+		api.GET("/ocr", s.handleOCR)
+		api.GET("/ocr/jobs", s.handleOCRJob)
+		api.POST("/ocr/jobs", s.handleStartOCRJob)
 		api.GET("/db", s.handleGetDBs)
 		api.GET("/db/tables", s.handleGetDBTables)
 		api.GET("/db/data", s.handleGetDBTableData)
@@ -107,6 +133,8 @@ func (s *Service) handleChatlog(c *gin.Context) {
 		Limit   int    `form:"limit"`
 		Offset  int    `form:"offset"`
 		Format  string `form:"format"`
+		// 读取本地视频文件的时长、分辨率与编码，只用于 json 输出
+		VideoInfo bool `form:"video_info"`
 	}{}
 
 	if err := c.BindQuery(&q); err != nil {
@@ -201,6 +229,10 @@ func (s *Service) handleChatlog(c *gin.Context) {
 		}
 	case "json":
 		// json
+		s.db.ResolveThreads(messages)
+		if q.VideoInfo {
+			s.db.AttachVideoInfo(messages...)
+		}
 		for _, m := range messages {
 			if m.Content == "" {
 				m.Content = m.PlainTextContent()
@@ -223,6 +255,266 @@ func (s *Service) handleChatlog(c *gin.Context) {
 	}
 }
 
+func (s *Service) handleStats(c *gin.Context) {
+	q := struct {
+		Time   string `form:"time"`
+		Talker string `form:"talker"`
+	}{}
+
+	if err := c.BindQuery(&q); err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	if q.Time == "" {
+		q.Time = "all"
+	}
+	start, end, ok := util.TimeRangeOf(q.Time)
+	if !ok {
+		errors.Err(c, errors.InvalidArg("time"))
+		return
+	}
+
+	stats, err := s.db.GetChatStats(start, end, q.Talker)
+	if err != nil {
+		errors.Err(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+// handleReport 生成账号维度的年度回顾报告，time 默认为今年
+func (s *Service) handleReport(c *gin.Context) {
+	q := struct {
+		Time   string `form:"time"`
+		Top    int    `form:"top"`
+		Format string `form:"format"`
+	}{}
+
+	if err := c.BindQuery(&q); err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	if q.Time == "" {
+		q.Time = time.Now().Format("2006")
+	}
+	start, end, ok := util.TimeRangeOf(q.Time)
+	if !ok {
+		errors.Err(c, errors.InvalidArg("time"))
+		return
+	}
+
+	r, err := report.Build(s.db, start, end, q.Top)
+	if err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	switch strings.ToLower(q.Format) {
+	case "html":
+		c.Writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := r.WriteHTML(c.Writer); err != nil {
+			log.Err(err).Msg("render report failed")
+		}
+	default:
+		c.JSON(http.StatusOK, r)
+	}
+}
+
+// handleGraph 导出联系人关系图，format 支持 json、graphml、gexf
+func (s *Service) handleGraph(c *gin.Context) {
+	q := struct {
+		Time         string `form:"time"`
+		Talker       string `form:"talker"`
+		MaxGroupSize int    `form:"max_group_size"`
+		Format       string `form:"format"`
+	}{}
+
+	if err := c.BindQuery(&q); err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	if q.Time == "" {
+		q.Time = "all"
+	}
+	start, end, ok := util.TimeRangeOf(q.Time)
+	if !ok {
+		errors.Err(c, errors.InvalidArg("time"))
+		return
+	}
+
+	g, err := graph.Build(s.db, graph.Options{Start: start, End: end, Talker: q.Talker, MaxGroupSize: q.MaxGroupSize})
+	if err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	switch strings.ToLower(q.Format) {
+	case "graphml":
+		c.Writer.Header().Set("Content-Type", "application/graphml+xml; charset=utf-8")
+		c.Writer.Header().Set("Content-Disposition", "attachment; filename=chatlog.graphml")
+		err = g.WriteGraphML(c.Writer)
+	case "gexf":
+		c.Writer.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		c.Writer.Header().Set("Content-Disposition", "attachment; filename=chatlog.gexf")
+		err = g.WriteGEXF(c.Writer)
+	default:
+		c.JSON(http.StatusOK, g)
+	}
+	if err != nil {
+		log.Err(err).Msg("write graph failed")
+	}
+}
+
+// handleMentions 查询 @ 指定用户的群聊消息，user 默认为当前账号，time 默认为最近 7 天
+func (s *Service) handleMentions(c *gin.Context) {
+	q := struct {
+		Time       string `form:"time"`
+		Talker     string `form:"talker"`
+		User       string `form:"user"`
+		Unanswered bool   `form:"unanswered"`
+		Format     string `form:"format"`
+	}{}
+
+	if err := c.BindQuery(&q); err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	if q.Time == "" {
+		q.Time = "last-7d"
+	}
+	start, end, ok := util.TimeRangeOf(q.Time)
+	if !ok {
+		errors.Err(c, errors.InvalidArg("time"))
+		return
+	}
+
+	messages, err := s.db.GetMentions(start, end, q.Talker, q.User, q.Unanswered)
+	if err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	switch strings.ToLower(q.Format) {
+	case "json":
+		for _, m := range messages {
+			if m.Content == "" {
+				m.Content = m.PlainTextContent()
+			}
+		}
+		c.JSON(http.StatusOK, messages)
+	default:
+		c.Writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, m := range messages {
+			c.Writer.WriteString(m.PlainText(true, util.PerfectTimeFormat(start, end), ""))
+			c.Writer.WriteString("\n")
+		}
+	}
+}
+
+// handleRecalls 查询自动解密期间检测到的撤回消息及其原始内容，time 默认为最近 7 天
+func (s *Service) handleRecalls(c *gin.Context) {
+	q := struct {
+		Time   string `form:"time"`
+		Talker string `form:"talker"`
+		Limit  int    `form:"limit"`
+		Offset int    `form:"offset"`
+		Format string `form:"format"`
+	}{}
+
+	if err := c.BindQuery(&q); err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	if q.Time == "" {
+		q.Time = "last-7d"
+	}
+	start, end, ok := util.TimeRangeOf(q.Time)
+	if !ok {
+		errors.Err(c, errors.InvalidArg("time"))
+		return
+	}
+	if q.Limit < 0 {
+		q.Limit = 0
+	}
+	if q.Offset < 0 {
+		q.Offset = 0
+	}
+
+	recalls, err := s.db.GetRecalls(start, end, q.Talker, q.Limit, q.Offset)
+	if err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	switch strings.ToLower(q.Format) {
+	case "json":
+		for _, r := range recalls {
+			r.Message.SetContent("host", c.Request.Host)
+			r.Message.Content = r.Message.PlainTextContent()
+		}
+		c.JSON(http.StatusOK, recalls)
+	default:
+		c.Writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, r := range recalls {
+			c.Writer.WriteString(recallText(r, util.PerfectTimeFormat(start, end), c.Request.Host))
+		}
+	}
+}
+
+// handleThread 获取指定消息所在的完整回复链，沿引用向上找到根消息后构建回复树
+func (s *Service) handleThread(c *gin.Context) {
+	q := struct {
+		Talker string `form:"talker"`
+		Seq    int64  `form:"seq"`
+		Format string `form:"format"`
+	}{}
+
+	if err := c.BindQuery(&q); err != nil {
+		errors.Err(c, err)
+		return
+	}
+	if q.Talker == "" {
+		errors.Err(c, errors.InvalidArg("talker"))
+		return
+	}
+	if q.Seq <= 0 {
+		errors.Err(c, errors.InvalidArg("seq"))
+		return
+	}
+
+	thread, err := s.db.GetThread(q.Talker, q.Seq)
+	if err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	switch strings.ToLower(q.Format) {
+	case "json":
+		c.JSON(http.StatusOK, thread)
+	default:
+		c.Writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		c.Writer.WriteString(thread.PlainText("2006-01-02 15:04:05", ""))
+	}
+}
+
+// recallText 以纯文本输出撤回消息，前缀为撤回原因与检测时间
+func recallText(r *recall.Recall, timeFormat, host string) string {
+	reason := "已撤回"
+	if r.Reason == recall.ReasonDeleted {
+		reason = "已删除"
+	}
+	text := fmt.Sprintf("[%s %s] %s\n", reason, r.DetectedAt.Format(time.DateTime), r.Message.PlainText(true, timeFormat, host))
+	if r.Notice != "" {
+		text += "> " + r.Notice + "\n"
+	}
+	return text
+}
+
 func (s *Service) handleContacts(c *gin.Context) {
 
 	q := struct {
@@ -470,6 +762,14 @@ func (s *Service) handleMedia(c *gin.Context, _type string) {
 	for _, k := range keys {
 		if strings.Contains(k, "/") {
 			if absolutePath, err := s.findPath(_type, k); err == nil {
+				if _type == "image" && wantThumbnail(c) {
+					s.handleImageFile(c, filepath.Join(s.conf.GetDataDir(), absolutePath))
+					return
+				}
+				if _type == "video" {
+					s.handleVideoFile(c, filepath.Join(s.conf.GetDataDir(), absolutePath))
+					return
+				}
 				c.Redirect(http.StatusFound, "/data/"+absolutePath)
 				return
 			}
@@ -502,16 +802,23 @@ func (s *Service) handleMedia(c *gin.Context, _type string) {
 			continue
 		}
 		if c.Query("info") != "" {
+			if media.Type == "video" {
+				c.JSON(http.StatusOK, s.videoInfo(media))
+				return
+			}
 			c.JSON(http.StatusOK, media)
 			return
 		}
 		switch media.Type {
 		case "voice":
-			s.HandleVoice(c, media.Data)
+			s.HandleVoice(c, media.Key, media.Data)
 			return
 		case "image":
 			s.handleImageFile(c, filepath.Join(s.conf.GetDataDir(), media.Path))
 			return
+		case "video":
+			s.handleVideoFile(c, filepath.Join(s.conf.GetDataDir(), media.Path))
+			return
 		default:
 			// For other types, keep the old redirect logic
 			c.Redirect(http.StatusFound, "/data/"+media.Path)
@@ -683,6 +990,11 @@ func (s *Service) populateMD5PathCache(messages []*model.Message) {
 
 // handleImageFile processes an image file, handling decryption if it's a .dat file or file without extension
 func (s *Service) handleImageFile(c *gin.Context, absolutePath string) {
+	// Serve a resized copy when w or h is given, fallback to the full-size image if it can't be generated
+	if wantThumbnail(c) && s.handleThumbnail(c, absolutePath) {
+		return
+	}
+
 	// Check if the file needs decryption (either .dat extension or no extension)
 	needsDecryption := strings.HasSuffix(strings.ToLower(absolutePath), ".dat") ||
 		filepath.Ext(absolutePath) == ""
@@ -695,6 +1007,11 @@ func (s *Service) handleImageFile(c *gin.Context, absolutePath string) {
 		return
 	}
 
+	// 启用媒体存储时从存储中返回解码后的图片，不在 .dat 旁边写入文件
+	if store := s.db.MediaStore(); store != nil && s.serveStoredImage(c, store, absolutePath) {
+		return
+	}
+
 	// Determine the base path for converted files
 	var outputPath string
 	if filepath.Ext(absolutePath) == "" {
@@ -710,7 +1027,7 @@ func (s *Service) handleImageFile(c *gin.Context, absolutePath string) {
 	relativePathBase = strings.TrimPrefix(relativePathBase, string(filepath.Separator))
 
 	// Check if a converted file already exists
-	for _, ext := range []string{".jpg", ".png", ".gif", ".jpeg", ".bmp"} {
+	for _, ext := range []string{".jpg", ".png", ".gif", ".jpeg", ".bmp", ".heic", ".mp4"} {
 		if _, err := os.Stat(outputPath + ext); err == nil {
 			newRelativePath = relativePathBase + ext
 			break
@@ -767,6 +1084,12 @@ func (s *Service) handleMediaData(c *gin.Context) {
 	case ext == ".dat", ext == "":
 		// Try to decrypt .dat files or files without extension
 		s.HandleDatFile(c, absolutePath)
+	case ext == ".heic":
+		// 标准库没有 heic 的 MIME 类型
+		c.Header("Content-Type", "image/heic")
+		c.File(absolutePath)
+	case ext == ".mp4":
+		s.serveVideo(c, absolutePath)
 	default:
 		// 直接返回文件
 		c.File(absolutePath)
@@ -806,34 +1129,23 @@ func (s *Service) HandleDatFile(c *gin.Context, path string) {
 		s.saveDecryptedFile(path, out, ext)
 	}
 
-	switch ext {
-	case "jpg", "jpeg":
-		c.Data(http.StatusOK, "image/jpeg", out)
-	case "png":
-		c.Data(http.StatusOK, "image/png", out)
-	case "gif":
-		c.Data(http.StatusOK, "image/gif", out)
-	case "bmp":
-		c.Data(http.StatusOK, "image/bmp", out)
-	case "mp4":
-		c.Data(http.StatusOK, "video/mp4", out)
-	default:
-		c.Data(http.StatusOK, "image/jpg", out)
-		// c.File(path)
-	}
+	c.Data(http.StatusOK, imageContentType(ext), out)
 }
 
-func (s *Service) HandleVoice(c *gin.Context, data []byte) {
-	out, err := silk.Silk2MP3(data)
-	if err != nil {
-		c.Data(http.StatusOK, "audio/silk", data)
+// saveDecryptedFile saves the decrypted media file to local disk
+func (s *Service) saveDecryptedFile(datPath string, data []byte, ext string) {
+	// 启用媒体存储时写入存储，相同内容只保存一份
+	if store := s.db.MediaStore(); store != nil {
+		info, err := os.Stat(datPath)
+		if err == nil {
+			_, err = s.storeDecryptedFile(store, datPath, info, data, ext)
+		}
+		if err != nil {
+			log.Error().Err(err).Str("dat_path", datPath).Msg("Failed to store decrypted file")
+		}
 		return
 	}
-	c.Data(http.StatusOK, "audio/mp3", out)
-}
 
-// saveDecryptedFile saves the decrypted media file to local disk
-func (s *Service) saveDecryptedFile(datPath string, data []byte, ext string) {
 	// Generate target file path: replace .dat with actual extension
 	outputPath := strings.TrimSuffix(datPath, filepath.Ext(datPath)) + "." + ext
 
@@ -1081,6 +1393,7 @@ func (s *Service) exportData(c *gin.Context, data []map[string]interface{}, form
 func (s *Service) handleSNS(c *gin.Context) {
 	q := struct {
 		Username string `form:"username"`
+		Time     string `form:"time"`
 		Limit    int    `form:"limit"`
 		Offset   int    `form:"offset"`
 		Format   string `form:"format"`
@@ -1106,82 +1419,40 @@ func (s *Service) handleSNS(c *gin.Context) {
 		return
 	}
 
-	data, err := s.db.GetSNSTimeline(q.Username, q.Limit, q.Offset)
+	// 未指定时间范围时不限制
+	var start, end time.Time
+	if q.Time != "" {
+		var ok bool
+		start, end, ok = util.TimeRangeOf(q.Time)
+		if !ok {
+			errors.Err(c, errors.InvalidArg("time"))
+			return
+		}
+	}
+
+	posts, err := s.db.GetSNSTimeline(q.Username, start, end, q.Limit, q.Offset)
 	if err != nil {
 		errors.Err(c, err)
 		return
 	}
+	s.fillSNSMedia(c.Request.Host, posts)
+	for _, post := range posts {
+		post.XMLContent = ""
+	}
 
 	switch format {
 	case "csv", "xlsx", "excel":
-		s.exportData(c, data, format, "sns_timeline")
+		s.exportData(c, snsRows(posts), format, "sns_timeline")
 	case "json":
-		c.JSON(http.StatusOK, data)
+		c.JSON(http.StatusOK, posts)
 	default:
 		c.Writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		c.Writer.Header().Set("Cache-Control", "no-cache")
 		c.Writer.Header().Set("Connection", "keep-alive")
 		c.Writer.Flush()
 
-		for _, item := range data {
-			// 格式化输出
-			if createTimeStr, ok := item["create_time_str"].(string); ok {
-				c.Writer.WriteString(fmt.Sprintf("📅 %s\n", createTimeStr))
-			}
-			if nickname, ok := item["nickname"].(string); ok && nickname != "" {
-				c.Writer.WriteString(fmt.Sprintf("👤 %s\n", nickname))
-			}
-			if contentDesc, ok := item["content_desc"].(string); ok && contentDesc != "" {
-				c.Writer.WriteString(fmt.Sprintf("💬 %s\n", contentDesc))
-			}
-			if location, ok := item["location"].(map[string]interface{}); ok {
-				c.Writer.WriteString("📍 ")
-				if poiName, ok := location["poi_name"].(string); ok && poiName != "" {
-					c.Writer.WriteString(poiName)
-					if poiAddress, ok := location["poi_address"].(string); ok && poiAddress != "" {
-						c.Writer.WriteString(fmt.Sprintf(" (%s)", poiAddress))
-					}
-				} else if city, ok := location["city"].(string); ok && city != "" {
-					c.Writer.WriteString(city)
-				}
-				c.Writer.WriteString("\n")
-			}
-			if contentType, ok := item["content_type"].(string); ok {
-				switch contentType {
-				case "image":
-					if mediaList, ok := item["media_list"].([]interface{}); ok {
-						c.Writer.WriteString(fmt.Sprintf("🖼️ 图片 (%d张)\n", len(mediaList)))
-					}
-				case "video":
-					if mediaList, ok := item["media_list"].([]interface{}); ok && len(mediaList) > 0 {
-						if media, ok := mediaList[0].(map[string]interface{}); ok {
-							if duration, ok := media["duration"].(string); ok && duration != "" {
-								c.Writer.WriteString(fmt.Sprintf("🎬 视频 (%s)\n", duration))
-							} else {
-								c.Writer.WriteString("🎬 视频\n")
-							}
-						}
-					}
-				case "article":
-					if article, ok := item["article"].(map[string]interface{}); ok {
-						if title, ok := article["title"].(string); ok {
-							c.Writer.WriteString(fmt.Sprintf("📰 文章: %s\n", title))
-						}
-						if url, ok := article["url"].(string); ok {
-							c.Writer.WriteString(fmt.Sprintf("   %s\n", url))
-						}
-					}
-				case "finder":
-					if feed, ok := item["finder_feed"].(map[string]interface{}); ok {
-						if nickname, ok := feed["nickname"].(string); ok {
-							c.Writer.WriteString(fmt.Sprintf("📺 视频号: %s\n", nickname))
-						}
-						if desc, ok := feed["desc"].(string); ok && desc != "" {
-							c.Writer.WriteString(fmt.Sprintf("   %s\n", desc))
-						}
-					}
-				}
-			}
+		for _, post := range posts {
+			c.Writer.WriteString(post.FormatAsText())
 			c.Writer.WriteString(strings.Repeat("=", 80) + "\n\n")
 			c.Writer.Flush()
 		}
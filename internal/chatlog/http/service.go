@@ -2,6 +2,7 @@ package http
 
 import (
 	"context"
+	stdlog "log"
 	"net/http"
 	"sync"
 	"time"
@@ -10,7 +11,9 @@ import (
 	"github.com/mark3labs/mcp-go/server"
 	"github.com/rs/zerolog/log"
 
+	"github.com/sjzar/chatlog/internal/chatlog/catalog"
 	"github.com/sjzar/chatlog/internal/chatlog/database"
+	"github.com/sjzar/chatlog/internal/chatlog/emoji"
 	"github.com/sjzar/chatlog/internal/errors"
 )
 
@@ -24,6 +27,17 @@ type Service struct {
 	mcpServer           *server.MCPServer
 	mcpSSEServer        *server.SSEServer
 	mcpStreamableServer *server.StreamableHTTPServer
+	notifier            *resourceNotifier
+
+	// 链接与文件目录，消息数据库变更时增量更新
+	catalog *catalog.Catalog
+
+	// 朋友圈媒体本地缓存索引
+	snsCache snsCache
+
+	// 本地表情文件索引，首次请求表情时建立
+	emojiIndex   *emoji.Index
+	emojiIndexMu sync.Mutex
 
 	// md5 到 path 的缓存（用于图片、视频等媒体文件）
 	md5PathCache map[string]string
@@ -33,6 +47,7 @@ type Service struct {
 type Config interface {
 	GetHTTPAddr() string
 	GetDataDir() string
+	GetWorkDir() string
 	GetSaveDecryptedMedia() bool
 }
 
@@ -58,8 +73,10 @@ func NewService(conf Config, db *database.Service) *Service {
 		db:           db,
 		router:       router,
 		md5PathCache: make(map[string]string),
+		catalog:      catalog.New(db),
 	}
 
+	s.initCatalog()
 	s.initMCPServer()
 	s.initRouter()
 	return s
@@ -95,6 +112,14 @@ func (s *Service) ListenAndServe() error {
 	return s.server.ListenAndServe()
 }
 
+// ServeStdio 通过 stdin/stdout 提供 MCP 服务，阻塞直到输入流关闭或收到退出信号
+func (s *Service) ServeStdio() error {
+	log.Info().Msg("Starting MCP server on stdio")
+	return server.ServeStdio(s.mcpServer,
+		server.WithErrorLogger(stdlog.New(log.Logger, "", 0)),
+	)
+}
+
 func (s *Service) Stop() error {
 
 	if s.server == nil {
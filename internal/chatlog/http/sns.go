@@ -0,0 +1,229 @@
+package http
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
+)
+
+// snsIndexInterval 本地缓存索引未命中时，两次重建之间的最小间隔
+const snsIndexInterval = time.Minute
+
+// snsCache 朋友圈媒体本地缓存索引，微信将浏览过的朋友圈图片和视频缓存在 cache/<月份>/Sns 目录下，
+// 文件名为 md5 或媒体 ID，图片与聊天图片一样为加密的 dat 格式
+// 索引按数据目录建立，切换账号后重新建立；遍历目录时不持有 mutex，重建后整体替换 files
+type snsCache struct {
+	mutex   sync.Mutex
+	dataDir string
+	files   map[string]string
+	builtAt time.Time
+
+	// 同一时间只有一个重建在进行
+	buildMu sync.Mutex
+}
+
+// handleSNSMedia 获取朋友圈媒体，key 为 <tid>/<序号>，thumb=1 时返回缩略图
+// 优先使用本地缓存，没有缓存且 CDN 上的内容未加密时跳转到原始地址
+func (s *Service) handleSNSMedia(c *gin.Context) {
+	parts := strings.Split(strings.Trim(c.Param("key"), "/"), "/")
+	if len(parts) != 2 {
+		errors.Err(c, errors.InvalidArg("key"))
+		return
+	}
+	tid, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		errors.Err(c, errors.InvalidArg("tid"))
+		return
+	}
+	index, err := strconv.Atoi(parts[1])
+	if err != nil {
+		errors.Err(c, errors.InvalidArg("index"))
+		return
+	}
+
+	post, err := s.db.GetSNSPost(tid)
+	if err != nil {
+		errors.Err(c, err)
+		return
+	}
+	if index < 0 || index >= len(post.MediaList) {
+		errors.Err(c, errors.ErrMediaNotFound)
+		return
+	}
+	media := post.MediaList[index]
+	thumb := c.Query("thumb") != ""
+
+	if path := s.snsLocalPath(media, thumb, true); path != "" {
+		c.Redirect(http.StatusFound, "/data/"+filepath.ToSlash(path))
+		return
+	}
+
+	link, token := media.URL, media.Token
+	if thumb || link == "" {
+		link, token = media.ThumbURL, media.ThumbToken
+	}
+	if link == "" || (media.EncIdx != "" && media.EncIdx != "0") {
+		errors.Err(c, errors.ErrMediaNotFound)
+		return
+	}
+	if token != "" {
+		if u, err := url.Parse(link); err == nil && u.Query().Get("token") == "" {
+			q := u.Query()
+			q.Set("token", token)
+			q.Set("idx", "1")
+			u.RawQuery = q.Encode()
+			link = u.String()
+		}
+	}
+	c.Redirect(http.StatusFound, link)
+}
+
+// fillSNSMedia 补充媒体的访问地址与本地缓存路径
+func (s *Service) fillSNSMedia(host string, posts []*model.SNSPost) {
+	for _, post := range posts {
+		for i := range post.MediaList {
+			media := &post.MediaList[i]
+			media.MediaURL = fmt.Sprintf("http://%s/sns/%d/%d", host, post.TID, i)
+			media.LocalPath = s.snsLocalPath(*media, false, false)
+		}
+	}
+}
+
+// snsLocalPath 返回媒体在数据目录下的相对路径，rebuild 为 true 时未命中会重建索引
+func (s *Service) snsLocalPath(media model.SNSMedia, thumb bool, rebuild bool) string {
+	keys := make([]string, 0, 4)
+	link := media.URL
+	if thumb {
+		link = media.ThumbURL
+	} else if media.MD5 != "" {
+		keys = append(keys, strings.ToLower(media.MD5))
+	}
+	if media.ID != "" && !thumb {
+		keys = append(keys, media.ID)
+	}
+	if link != "" {
+		sum := md5.Sum([]byte(link))
+		keys = append(keys, hex.EncodeToString(sum[:]))
+	}
+	if len(keys) == 0 {
+		return ""
+	}
+
+	dataDir := s.conf.GetDataDir()
+	for attempt := 0; attempt < 2; attempt++ {
+		files, builtAt := s.snsCache.get(dataDir)
+		if files != nil {
+			for _, key := range keys {
+				if path, ok := files[key]; ok {
+					return path
+				}
+			}
+		}
+		if files != nil && (!rebuild || time.Since(builtAt) < snsIndexInterval) {
+			return ""
+		}
+		s.snsCache.rebuild(dataDir)
+	}
+	return ""
+}
+
+// get 返回数据目录的索引，数据目录变化时清空旧索引
+func (c *snsCache) get(dataDir string) (map[string]string, time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.dataDir != dataDir {
+		c.dataDir, c.files, c.builtAt = dataDir, nil, time.Time{}
+	}
+	return c.files, c.builtAt
+}
+
+// rebuild 在锁外遍历缓存目录后替换索引，等待期间已被其他请求重建时直接返回
+func (c *snsCache) rebuild(dataDir string) {
+	c.buildMu.Lock()
+	defer c.buildMu.Unlock()
+	if files, builtAt := c.get(dataDir); files != nil && time.Since(builtAt) < snsIndexInterval {
+		return
+	}
+
+	files := buildSNSIndex(dataDir)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.dataDir == dataDir {
+		c.files, c.builtAt = files, time.Now()
+	}
+}
+
+// buildSNSIndex 遍历 cache/*/Sns 目录，以去掉扩展名与 _t/_h 后缀的小写文件名为键
+func buildSNSIndex(dataDir string) map[string]string {
+	files := make(map[string]string)
+	dirs, _ := filepath.Glob(filepath.Join(dataDir, "cache", "*", "Sns"))
+	for _, dir := range dirs {
+		filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			name := strings.ToLower(d.Name())
+			name = strings.TrimSuffix(name, filepath.Ext(name))
+			name = strings.TrimSuffix(strings.TrimSuffix(name, "_t"), "_h")
+			rel, err := filepath.Rel(dataDir, path)
+			if err != nil {
+				return nil
+			}
+			if _, ok := files[name]; !ok {
+				files[name] = rel
+			}
+			return nil
+		})
+	}
+	return files
+}
+
+// snsRows 将朋友圈转换为 CSV/Excel 导出的行
+func snsRows(posts []*model.SNSPost) []map[string]interface{} {
+	rows := make([]map[string]interface{}, 0, len(posts))
+	for _, post := range posts {
+		location := ""
+		if post.Location != nil {
+			location = strings.TrimSpace(strings.Join([]string{post.Location.City, post.Location.POIName, post.Location.POIAddress}, " "))
+		}
+		media := make([]string, 0, len(post.MediaList))
+		for _, m := range post.MediaList {
+			media = append(media, m.MediaURL)
+		}
+		likes := make([]string, 0, len(post.Likes))
+		for _, like := range post.Likes {
+			likes = append(likes, like.NickName+"("+like.UserName+")")
+		}
+		comments := make([]string, 0, len(post.Comments))
+		for _, comment := range post.Comments {
+			comments = append(comments, fmt.Sprintf("%s(%s): %s", comment.NickName, comment.UserName, comment.Content))
+		}
+		rows = append(rows, map[string]interface{}{
+			"tid":             strconv.FormatInt(post.TID, 10),
+			"user_name":       post.UserName,
+			"nickname":        post.NickName,
+			"create_time_str": post.CreateTimeStr,
+			"content_type":    post.ContentType,
+			"content_desc":    post.ContentDesc,
+			"private":         post.Private,
+			"location":        location,
+			"media":           strings.Join(media, "\n"),
+			"likes":           strings.Join(likes, "\n"),
+			"comments":        strings.Join(comments, "\n"),
+		})
+	}
+	return rows
+}
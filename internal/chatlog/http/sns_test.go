@@ -0,0 +1,50 @@
+package http
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSNSCache(t *testing.T) {
+	write := func(dataDir, rel string) {
+		path := filepath.Join(dataDir, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte{1}, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dirA, dirB := t.TempDir(), t.TempDir()
+	write(dirA, filepath.Join("cache", "2024-01", "Sns", "Img", "ABCDEF_t"))
+	write(dirA, filepath.Join("cache", "2024-01", "Img", "123456"))
+	write(dirB, filepath.Join("cache", "2024-02", "Sns", "Video", "654321.mp4"))
+
+	tests := []struct {
+		name    string
+		dataDir string
+		key     string
+		want    string
+	}{
+		{name: "thumb suffix trimmed", dataDir: dirA, key: "abcdef", want: filepath.Join("cache", "2024-01", "Sns", "Img", "ABCDEF_t")},
+		{name: "non sns cache ignored", dataDir: dirA, key: "123456", want: ""},
+		{name: "other account not visible", dataDir: dirA, key: "654321", want: ""},
+		{name: "switch data dir", dataDir: dirB, key: "654321", want: filepath.Join("cache", "2024-02", "Sns", "Video", "654321.mp4")},
+		{name: "old data dir dropped", dataDir: dirB, key: "abcdef", want: ""},
+	}
+
+	var c snsCache
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, _ := c.get(tt.dataDir)
+			if files == nil {
+				c.rebuild(tt.dataDir)
+				files, _ = c.get(tt.dataDir)
+			}
+			if got := files[tt.key]; got != tt.want {
+				t.Errorf("files[%q] = %q, want %q", tt.key, got, tt.want)
+			}
+		})
+	}
+}
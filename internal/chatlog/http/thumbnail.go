@@ -0,0 +1,204 @@
+package http
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/pkg/util"
+	"github.com/sjzar/chatlog/pkg/util/dat2img"
+)
+
+// MaxThumbnailSize 缩略图宽高上限
+const MaxThumbnailSize = 4096
+
+// thumbnailOptions 缩略图参数，w、h 至少指定一个，只指定一个时保持宽高比
+type thumbnailOptions struct {
+	Width  int
+	Height int
+	Fit    string
+	Format string
+}
+
+// wantThumbnail 请求是否带有缩略图参数
+func wantThumbnail(c *gin.Context) bool {
+	return c.Query("w") != "" || c.Query("h") != ""
+}
+
+// parseThumbnailOptions 解析 w、h、fit（contain、cover、fill，默认 contain）与 format（jpg、webp，默认 jpg）
+func parseThumbnailOptions(c *gin.Context) (*thumbnailOptions, error) {
+	opts := &thumbnailOptions{}
+	for _, p := range []struct {
+		name  string
+		value *int
+	}{{"w", &opts.Width}, {"h", &opts.Height}} {
+		v := c.Query(p.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > MaxThumbnailSize {
+			return nil, errors.InvalidArg(p.name)
+		}
+		*p.value = n
+	}
+	if opts.Width == 0 && opts.Height == 0 {
+		return nil, errors.InvalidArg("w")
+	}
+
+	switch fit := strings.ToLower(c.Query("fit")); fit {
+	case "", dat2img.FitContain:
+		opts.Fit = dat2img.FitContain
+	case dat2img.FitCover, dat2img.FitFill:
+		opts.Fit = fit
+	default:
+		return nil, errors.InvalidArg("fit")
+	}
+
+	switch format := strings.ToLower(c.Query("format")); format {
+	case "", dat2img.ThumbJPEG, "jpeg":
+		opts.Format = dat2img.ThumbJPEG
+	case dat2img.ThumbWebP:
+		opts.Format = dat2img.ThumbWebP
+	default:
+		return nil, errors.InvalidArg("format")
+	}
+	return opts, nil
+}
+
+// handleThumbnail 返回图片的缩略图，缩略图缓存在工作目录的 cache/thumb 下
+// 返回 false 表示无法生成缩略图，由调用方返回原图
+func (s *Service) handleThumbnail(c *gin.Context, absolutePath string) bool {
+	opts, err := parseThumbnailOptions(c)
+	if err != nil {
+		errors.Err(c, err)
+		return true
+	}
+
+	source, info := s.thumbnailSource(absolutePath, opts)
+	if source == "" {
+		return false
+	}
+
+	// 原图不变时缩略图不变，ETag 由原图路径、大小、修改时间与参数计算
+	modTime := info.ModTime().Truncate(time.Second)
+	key := thumbnailKey(source, info, opts)
+	etag := `"` + key + "." + opts.Format + `"`
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "private, max-age=86400")
+	if match := c.GetHeader("If-None-Match"); match != "" && strings.Contains(match, etag) {
+		c.Status(http.StatusNotModified)
+		return true
+	}
+
+	data, format, err := s.thumbnail(source, key, opts)
+	if err != nil {
+		log.Debug().Err(err).Msgf("generate thumbnail failed: %s", source)
+		return false
+	}
+
+	contentType := "image/jpeg"
+	if format == dat2img.ThumbWebP {
+		contentType = "image/webp"
+	}
+	c.Header("Content-Type", contentType)
+	http.ServeContent(c.Writer, c.Request, filepath.Base(source)+"."+format, modTime, bytes.NewReader(data))
+	return true
+}
+
+// thumbnailSource 选择生成缩略图的原图，缩略图 _t.dat 足够大时优先使用，否则依次使用 _h.dat、.dat
+func (s *Service) thumbnailSource(absolutePath string, opts *thumbnailOptions) (string, os.FileInfo) {
+	lower := strings.ToLower(absolutePath)
+	if !strings.HasSuffix(lower, ".dat") && filepath.Ext(absolutePath) != "" {
+		if info, err := os.Stat(absolutePath); err == nil {
+			return absolutePath, info
+		}
+		return "", nil
+	}
+
+	base := absolutePath
+	for _, suffix := range []string{"_h.dat", "_t.dat", ".dat"} {
+		if strings.HasSuffix(lower, suffix) {
+			base = absolutePath[:len(absolutePath)-len(suffix)]
+			break
+		}
+	}
+
+	if info, err := os.Stat(base + "_t.dat"); err == nil {
+		if b, err := os.ReadFile(base + "_t.dat"); err == nil {
+			if out, _, err := dat2img.Dat2Image(b); err == nil {
+				if w, h, err := dat2img.ImageSize(out); err == nil && dat2img.Covers(w, h, opts.Width, opts.Height, opts.Fit) {
+					return base + "_t.dat", info
+				}
+			}
+		}
+	}
+
+	for _, path := range []string{base + "_h.dat", base + ".dat", absolutePath} {
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path, info
+		}
+	}
+	return "", nil
+}
+
+// thumbnail 读取缓存的缩略图，缓存不存在时生成并写入缓存，webp 编码失败时改用 jpg
+func (s *Service) thumbnail(source, key string, opts *thumbnailOptions) ([]byte, string, error) {
+	cacheDir := ""
+	if workDir := s.conf.GetWorkDir(); workDir != "" {
+		cacheDir = filepath.Join(workDir, "cache", "thumb")
+		for _, format := range []string{opts.Format, dat2img.ThumbJPEG} {
+			if b, err := os.ReadFile(filepath.Join(cacheDir, key+"."+format)); err == nil && len(b) > 0 {
+				return b, format, nil
+			}
+		}
+	}
+
+	b, err := os.ReadFile(source)
+	if err != nil {
+		return nil, "", errors.ReadFileFailed(source, err)
+	}
+	if strings.HasSuffix(strings.ToLower(source), ".dat") {
+		if b, _, err = dat2img.Dat2Image(b); err != nil {
+			return nil, "", err
+		}
+	}
+
+	img, err := dat2img.Thumbnail(b, opts.Width, opts.Height, opts.Fit)
+	if err != nil {
+		return nil, "", err
+	}
+	format := opts.Format
+	out, err := dat2img.EncodeThumbnail(img, format)
+	if err != nil && format != dat2img.ThumbJPEG {
+		log.Debug().Err(err).Msg("encode webp thumbnail failed, fallback to jpg")
+		format = dat2img.ThumbJPEG
+		out, err = dat2img.EncodeThumbnail(img, format)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	if cacheDir != "" {
+		if err := util.WriteFileAtomic(filepath.Join(cacheDir, key+"."+format), out); err != nil {
+			log.Debug().Err(err).Msgf("write thumbnail cache failed: %s", key)
+		}
+	}
+	return out, format, nil
+}
+
+// thumbnailKey 缩略图缓存文件名
+func thumbnailKey(source string, info os.FileInfo, opts *thumbnailOptions) string {
+	sum := md5.Sum([]byte(source + "|" + strconv.FormatInt(info.Size(), 10) + "|" + strconv.FormatInt(info.ModTime().UnixNano(), 10)))
+	return hex.EncodeToString(sum[:8]) + "_" + strconv.Itoa(opts.Width) + "x" + strconv.Itoa(opts.Height) + "_" + opts.Fit
+}
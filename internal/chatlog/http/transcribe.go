@@ -0,0 +1,125 @@
+package http
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sjzar/chatlog/internal/chatlog/transcribe"
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/pkg/util"
+)
+
+// handleTranscripts 查询已保存的语音转写，keyword 支持正则表达式，time 默认为全部时间
+func (s *Service) handleTranscripts(c *gin.Context) {
+	q := struct {
+		Time    string `form:"time"`
+		Talker  string `form:"talker"`
+		Keyword string `form:"keyword"`
+		Format  string `form:"format"`
+	}{}
+
+	if err := c.BindQuery(&q); err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	if q.Time == "" {
+		q.Time = "all"
+	}
+	start, end, ok := util.TimeRangeOf(q.Time)
+	if !ok {
+		errors.Err(c, errors.InvalidArg("time"))
+		return
+	}
+
+	transcripts, err := s.db.SearchTranscripts(start, end, q.Talker, q.Keyword)
+	if err != nil {
+		errors.Err(c, err)
+		return
+	}
+	writeTranscripts(c, transcripts, q.Format)
+}
+
+// handleTranscribe 转写语音消息，指定 seq 时转写单条，否则转写 talker 在 time（默认最近 7 天）内的全部语音
+// force=1 时忽略已有的转写记录重新转写
+func (s *Service) handleTranscribe(c *gin.Context) {
+	q := struct {
+		Talker string `form:"talker"`
+		Seq    int64  `form:"seq"`
+		Time   string `form:"time"`
+		Force  bool   `form:"force"`
+		Format string `form:"format"`
+	}{}
+
+	if err := c.BindQuery(&q); err != nil {
+		errors.Err(c, err)
+		return
+	}
+	if q.Talker == "" {
+		errors.Err(c, errors.ErrTalkerEmpty)
+		return
+	}
+
+	if q.Seq != 0 {
+		tr, err := s.db.TranscribeVoice(c.Request.Context(), q.Talker, q.Seq, q.Force)
+		if err != nil {
+			errors.Err(c, err)
+			return
+		}
+		writeTranscripts(c, []*transcribe.Transcript{tr}, q.Format)
+		return
+	}
+
+	if q.Time == "" {
+		q.Time = "last-7d"
+	}
+	start, end, ok := util.TimeRangeOf(q.Time)
+	if !ok {
+		errors.Err(c, errors.InvalidArg("time"))
+		return
+	}
+	transcripts, err := s.db.TranscribeVoices(c.Request.Context(), start, end, q.Talker, q.Force)
+	if err != nil {
+		errors.Err(c, err)
+		return
+	}
+	writeTranscripts(c, transcripts, q.Format)
+}
+
+func writeTranscripts(c *gin.Context, transcripts []*transcribe.Transcript, format string) {
+	switch strings.ToLower(format) {
+	case "csv":
+		c.Writer.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w := csv.NewWriter(c.Writer)
+		w.Write([]string{"MessageID", "Time", "Talker", "Sender", "ServerID", "Provider", "Text"})
+		for _, tr := range transcripts {
+			w.Write([]string{
+				strconv.FormatInt(tr.Seq, 10),
+				tr.Time.Format(time.DateTime),
+				tr.Talker,
+				tr.Sender,
+				tr.ServerID,
+				tr.Provider,
+				tr.Text,
+			})
+		}
+		w.Flush()
+	case "text":
+		c.Writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, tr := range transcripts {
+			c.Writer.WriteString(transcriptText(tr))
+		}
+	default:
+		c.JSON(http.StatusOK, transcripts)
+	}
+}
+
+func transcriptText(tr *transcribe.Transcript) string {
+	return fmt.Sprintf("%s [%d] %s %s\n%s\n", tr.Talker, tr.Seq, tr.Time.Format(time.DateTime), tr.Sender, tr.Text)
+}
@@ -0,0 +1,123 @@
+package http
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
+	"github.com/sjzar/chatlog/pkg/util/dat2img"
+	"github.com/sjzar/chatlog/pkg/util/video"
+)
+
+// PosterSuffix 视频封面的文件后缀，与视频文件位于同一目录
+const PosterSuffix = "_thumb.jpg"
+
+// videoMedia 带视频信息的媒体记录，用于 info 查询
+type videoMedia struct {
+	*model.Media
+	Video *video.Info `json:"video,omitempty"`
+}
+
+// wantPoster 请求是否需要视频封面，poster=1 或带有缩略图参数时返回封面
+func wantPoster(c *gin.Context) bool {
+	return c.Query("poster") != "" || wantThumbnail(c)
+}
+
+// handleVideoFile 返回视频文件，需要封面时返回 _thumb.jpg，可以配合 w、h 返回封面的缩略图
+func (s *Service) handleVideoFile(c *gin.Context, absolutePath string) {
+	base := strings.TrimSuffix(strings.TrimSuffix(absolutePath, PosterSuffix), ".mp4")
+	if !wantPoster(c) && !strings.HasSuffix(absolutePath, PosterSuffix) {
+		s.serveVideo(c, absolutePath)
+		return
+	}
+
+	poster := base + PosterSuffix
+	if _, err := os.Stat(poster); err != nil {
+		errors.Err(c, errors.ErrMediaNotFound)
+		return
+	}
+	if wantThumbnail(c) && s.handleThumbnail(c, poster) {
+		return
+	}
+	s.servePoster(c, poster)
+}
+
+// serveVideo 返回视频文件，由 http.ServeContent 处理 Range、If-Range 与条件请求，不会把整个文件读入内存
+func (s *Service) serveVideo(c *gin.Context, absolutePath string) {
+	f, err := os.Open(absolutePath)
+	if err != nil {
+		errors.Err(c, errors.ErrMediaNotFound)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		errors.Err(c, errors.ErrMediaNotFound)
+		return
+	}
+
+	// 视频文件写入后不会再修改，ETag 由大小与修改时间计算
+	c.Header("ETag", `"`+strconv.FormatInt(info.Size(), 16)+"-"+strconv.FormatInt(info.ModTime().UnixNano(), 16)+`"`)
+	c.Header("Cache-Control", "private, max-age=86400")
+	c.Header("Accept-Ranges", "bytes")
+	if strings.EqualFold(filepath.Ext(absolutePath), ".mp4") {
+		c.Header("Content-Type", "video/mp4")
+	}
+	http.ServeContent(c.Writer, c.Request, filepath.Base(absolutePath), info.ModTime(), f)
+}
+
+// servePoster 返回视频封面，封面经过加密时先解密
+func (s *Service) servePoster(c *gin.Context, poster string) {
+	b, err := os.ReadFile(poster)
+	if err != nil {
+		errors.Err(c, errors.ReadFileFailed(poster, err))
+		return
+	}
+	if !bytes.HasPrefix(b, []byte{0xFF, 0xD8}) {
+		if out, ext, err := dat2img.Dat2Image(b); err == nil {
+			c.Header("Cache-Control", "private, max-age=86400")
+			c.Data(http.StatusOK, imageContentType(ext), out)
+			return
+		}
+	}
+	c.Header("Cache-Control", "private, max-age=86400")
+	c.File(poster)
+}
+
+// videoInfo 媒体记录附带视频信息，视频文件不存在或无法解析时只返回媒体记录
+func (s *Service) videoInfo(media *model.Media) *videoMedia {
+	ret := &videoMedia{Media: media}
+	if info, err := s.db.VideoInfo(media.Path); err == nil {
+		ret.Video = info
+	}
+	return ret
+}
+
+// imageContentType 图片解码结果的 Content-Type，实况图可能解码为 mp4
+func imageContentType(ext string) string {
+	switch ext {
+	case "jpg", "jpeg":
+		return "image/jpeg"
+	case "png":
+		return "image/png"
+	case "gif":
+		return "image/gif"
+	case "bmp":
+		return "image/bmp"
+	case "heic":
+		return "image/heic"
+	case "webp":
+		return "image/webp"
+	case "mp4":
+		return "video/mp4"
+	default:
+		return "image/jpeg"
+	}
+}
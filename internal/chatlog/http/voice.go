@@ -0,0 +1,111 @@
+package http
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/pkg/util"
+	"github.com/sjzar/chatlog/pkg/util/silk"
+)
+
+// DefaultWAVSampleRate wav 默认采样率，语音识别工具通常需要 16 kHz 单声道
+const DefaultWAVSampleRate = 16000
+
+// HandleVoice 返回语音，format 可选 mp3（默认）、wav、ogg（opus）与 silk（原始数据），rate 为 wav 的采样率
+// 未指定格式时转码失败返回原始 silk 数据，显式指定的格式转码失败时返回错误
+func (s *Service) HandleVoice(c *gin.Context, key string, data []byte) {
+	explicit := c.Query("format") != ""
+	format, err := voiceFormat(c.Query("format"))
+	if err != nil {
+		errors.Err(c, err)
+		return
+	}
+	rate := 0
+	if v := c.Query("rate"); v != "" {
+		if rate, err = strconv.Atoi(v); err != nil || !slices.Contains(silk.SampleRates, rate) {
+			errors.Err(c, errors.InvalidArg("rate"))
+			return
+		}
+	}
+
+	out, err := s.convertVoice(key, data, format, rate)
+	if err != nil {
+		log.Debug().Err(err).Msgf("convert voice failed: %s", key)
+		if explicit {
+			errors.Err(c, errors.ConvertVoiceFailed(format, err))
+			return
+		}
+		c.Data(http.StatusOK, silk.ContentType(silk.FormatSILK), data)
+		return
+	}
+	c.Data(http.StatusOK, silk.ContentType(format), out)
+}
+
+// voiceFormat 规范化语音格式参数，opus 等同于 ogg，pcm 等同于 wav
+func voiceFormat(format string) (string, error) {
+	switch strings.ToLower(format) {
+	case "", silk.FormatMP3:
+		return silk.FormatMP3, nil
+	case silk.FormatWAV, "pcm":
+		return silk.FormatWAV, nil
+	case silk.FormatOGG, "opus":
+		return silk.FormatOGG, nil
+	case silk.FormatSILK, "raw":
+		return silk.FormatSILK, nil
+	default:
+		return "", errors.InvalidArg("format")
+	}
+}
+
+// convertVoice 转码语音，结果以 svr_id 命名缓存在工作目录的 cache/voice 下，再次请求时直接读取
+func (s *Service) convertVoice(key string, data []byte, format string, rate int) ([]byte, error) {
+	if format == silk.FormatSILK {
+		return data, nil
+	}
+	if format == silk.FormatWAV && rate <= 0 {
+		rate = DefaultWAVSampleRate
+	}
+
+	path := s.voiceCachePath(key, format, rate)
+	if path != "" {
+		if b, err := os.ReadFile(path); err == nil && len(b) > 0 {
+			return b, nil
+		}
+	}
+
+	out, err := silk.Convert(data, format, rate)
+	if err != nil {
+		return nil, err
+	}
+
+	if path != "" {
+		if err := util.WriteFileAtomic(path, out); err != nil {
+			log.Debug().Err(err).Msgf("write voice cache failed: %s", path)
+		}
+	}
+	return out, nil
+}
+
+// voiceCachePath 缓存文件路径，wav 文件名带采样率，key 不是 svr_id 或没有工作目录时不缓存
+func (s *Service) voiceCachePath(key, format string, rate int) string {
+	workDir := s.conf.GetWorkDir()
+	if workDir == "" || key == "" {
+		return ""
+	}
+	if _, err := strconv.ParseInt(key, 10, 64); err != nil {
+		return ""
+	}
+	name := key + "." + format
+	if format == silk.FormatWAV {
+		name = key + "_" + strconv.Itoa(rate) + "." + format
+	}
+	return filepath.Join(workDir, "cache", "voice", name)
+}
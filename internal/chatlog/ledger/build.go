@@ -0,0 +1,220 @@
+package ledger
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sjzar/chatlog/internal/model"
+)
+
+// builder 按时间顺序合并发起消息与回执消息
+type builder struct {
+	self     string
+	messages []*model.Message
+	entries  map[string]*Entry
+	order    []string
+}
+
+func newBuilder(self string) *builder {
+	return &builder{
+		self:    self,
+		entries: make(map[string]*Entry),
+	}
+}
+
+// collect 保留转账、红包与红包领取提示，未知当前账号 ID 时从自己发起的转账中记录
+func (b *builder) collect(messages []*model.Message) {
+	for _, msg := range messages {
+		switch msg.Type {
+		case model.MessageTypeShare:
+			if msg.SubType != model.MessageSubTypePay && msg.SubType != model.MessageSubTypeRedEnvelope {
+				continue
+			}
+			if msg.IsSelf && b.self == "" {
+				b.self = msg.Sender
+			}
+		case model.MessageTypeSystem:
+			if id, _ := msg.Contents["sendid"].(string); id == "" {
+				continue
+			}
+		default:
+			continue
+		}
+		b.messages = append(b.messages, msg)
+	}
+}
+
+func (b *builder) build() []*Entry {
+	sort.SliceStable(b.messages, func(i, j int) bool { return b.messages[i].Time.Before(b.messages[j].Time) })
+	for _, msg := range b.messages {
+		switch {
+		case msg.Type == model.MessageTypeSystem:
+			b.redEnvelopeReceipt(msg)
+		case msg.SubType == model.MessageSubTypePay:
+			b.transfer(msg)
+		default:
+			b.redEnvelope(msg)
+		}
+	}
+
+	entries := make([]*Entry, 0, len(b.order))
+	for _, key := range b.order {
+		e, ok := b.entries[key]
+		if !ok {
+			continue
+		}
+		// 群里他人发的红包，只有自己领取过才计入
+		if e.Kind == KindRedEnvelope && e.Direction == DirectionIn && e.Status == StatusSent && strings.HasSuffix(e.Talker, "@chatroom") {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// transfer 处理转账消息
+// paysubtype 1/7 为发起转账，3/5 为收款回执，4 为退还回执，回执的发送人是收款方
+func (b *builder) transfer(msg *model.Message) {
+	subType, _ := msg.Contents["paysubtype"].(int)
+	id, _ := msg.Contents["transcationid"].(string)
+	transferID, _ := msg.Contents["transferid"].(string)
+	if id == "" {
+		id = transferID
+	}
+	if id == "" {
+		return
+	}
+	payer, _ := msg.Contents["payer"].(string)
+	receiver, _ := msg.Contents["receiver"].(string)
+	key := KindTransfer + ":" + id
+
+	e := b.entries[key]
+	switch subType {
+	case 1, 7:
+		direction, counterparty := DirectionOut, receiver
+		if !msg.IsSelf {
+			// 群聊中他人之间的转账与自己无关
+			if msg.IsChatRoom && (b.self == "" || receiver != b.self) {
+				return
+			}
+			direction, counterparty = DirectionIn, msg.Sender
+		}
+		if e == nil {
+			e = b.add(key, KindTransfer, msg)
+		}
+		e.Time, e.Seq = msg.Time, msg.Seq
+		e.Direction = direction
+		e.Counterparty = b.counterparty(msg, counterparty)
+		if e.Status == "" {
+			e.Status = StatusSent
+		}
+	case 3, 4, 5:
+		if e == nil {
+			// 发起消息不在查询范围内，根据回执推断方向
+			direction, counterparty := DirectionIn, payer
+			if !msg.IsSelf {
+				if msg.IsChatRoom && (b.self == "" || payer != b.self) {
+					return
+				}
+				direction, counterparty = DirectionOut, msg.Sender
+			}
+			e = b.add(key, KindTransfer, msg)
+			e.Time = msg.Time
+			e.Direction = direction
+			e.Counterparty = b.counterparty(msg, counterparty)
+		}
+		e.Status = StatusReceived
+		if subType == 4 {
+			e.Status = StatusRefunded
+		}
+		settledAt := msg.Time
+		e.SettledAt = &settledAt
+		e.ReceiptSeq = msg.Seq
+	default:
+		return
+	}
+
+	if amount, _ := msg.Contents["amount"].(float64); amount != 0 {
+		e.Amount = amount
+	}
+	if currency, _ := msg.Contents["currency"].(string); currency != "" {
+		e.Currency = currency
+	}
+	if memo, _ := msg.Contents["memo"].(string); memo != "" {
+		e.Memo = memo
+	}
+	if transferID != "" {
+		e.TransferID = transferID
+	}
+}
+
+// redEnvelope 处理红包消息，未能解析出红包 ID 时无法关联领取提示
+func (b *builder) redEnvelope(msg *model.Message) {
+	id, _ := msg.Contents["sendid"].(string)
+	if id == "" {
+		id = msg.Talker + "_" + strconv.FormatInt(msg.Seq, 10)
+	}
+	key := KindRedEnvelope + ":" + id
+
+	e, ok := b.entries[key]
+	if !ok {
+		e = b.add(key, KindRedEnvelope, msg)
+		e.Status = StatusSent
+	}
+	e.Time, e.Seq = msg.Time, msg.Seq
+	e.Currency = "CNY"
+	e.Memo, _ = msg.Contents["title"].(string)
+	if msg.IsSelf {
+		e.Direction = DirectionOut
+		e.Counterparty = msg.Talker
+	} else {
+		e.Direction = DirectionIn
+		e.Counterparty = b.counterparty(msg, msg.Sender)
+	}
+}
+
+// redEnvelopeReceipt 处理红包领取提示，"你领取了..." 为自己领取，其余为他人领取了自己的红包
+func (b *builder) redEnvelopeReceipt(msg *model.Message) {
+	id, _ := msg.Contents["sendid"].(string)
+	key := KindRedEnvelope + ":" + id
+
+	e, ok := b.entries[key]
+	if !ok {
+		e = b.add(key, KindRedEnvelope, msg)
+		e.Time = msg.Time
+		e.Currency = "CNY"
+		e.Counterparty = msg.Talker
+		e.Direction = DirectionOut
+		if strings.Contains(msg.Content, "你领取了") {
+			e.Direction = DirectionIn
+		}
+	}
+	if e.Status == StatusReceived {
+		return
+	}
+	e.Status = StatusReceived
+	settledAt := msg.Time
+	e.SettledAt = &settledAt
+	e.ReceiptSeq = msg.Seq
+}
+
+func (b *builder) add(key, kind string, msg *model.Message) *Entry {
+	e := &Entry{
+		ID:         strings.TrimPrefix(key, kind+":"),
+		Kind:       kind,
+		Talker:     msg.Talker,
+		TalkerName: msg.TalkerName,
+	}
+	b.entries[key] = e
+	b.order = append(b.order, key)
+	return e
+}
+
+// counterparty 私聊的对方即为会话对象，群聊中使用消息中记录的用户
+func (b *builder) counterparty(msg *model.Message, user string) string {
+	if !msg.IsChatRoom || user == "" {
+		return msg.Talker
+	}
+	return user
+}
@@ -0,0 +1,129 @@
+package ledger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sjzar/chatlog/internal/model"
+)
+
+func transferMsg(talker, sender string, isSelf bool, seq int64, subType int, id, payer, receiver string, amount float64) *model.Message {
+	return &model.Message{
+		Seq:        seq,
+		Time:       time.Unix(seq, 0),
+		Talker:     talker,
+		IsChatRoom: len(talker) > 9 && talker[len(talker)-9:] == "@chatroom",
+		Sender:     sender,
+		IsSelf:     isSelf,
+		Type:       model.MessageTypeShare,
+		SubType:    model.MessageSubTypePay,
+		Contents: map[string]interface{}{
+			"paysubtype":    subType,
+			"transcationid": id,
+			"payer":         payer,
+			"receiver":      receiver,
+			"amount":        amount,
+			"currency":      "CNY",
+		},
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	tests := []struct {
+		name     string
+		self     string
+		messages []*model.Message
+		want     []Entry
+	}{
+		{
+			name: "private transfer out and received",
+			self: "wxid_me",
+			messages: []*model.Message{
+				transferMsg("wxid_a", "wxid_me", true, 1, 1, "t1", "wxid_me", "wxid_a", 10),
+				transferMsg("wxid_a", "wxid_a", false, 2, 3, "t1", "wxid_me", "wxid_a", 10),
+			},
+			want: []Entry{{ID: "t1", Direction: DirectionOut, Status: StatusReceived, Amount: 10, Counterparty: "wxid_a"}},
+		},
+		{
+			name: "private transfer in and refunded",
+			self: "wxid_me",
+			messages: []*model.Message{
+				transferMsg("wxid_a", "wxid_a", false, 1, 1, "t2", "wxid_a", "wxid_me", 5),
+				transferMsg("wxid_a", "wxid_me", true, 2, 4, "t2", "wxid_a", "wxid_me", 5),
+			},
+			want: []Entry{{ID: "t2", Direction: DirectionIn, Status: StatusRefunded, Amount: 5, Counterparty: "wxid_a"}},
+		},
+		{
+			name: "chatroom transfer to self without any self-sent transfer",
+			self: "wxid_me",
+			messages: []*model.Message{
+				transferMsg("1@chatroom", "wxid_b", false, 1, 1, "t3", "wxid_b", "wxid_me", 8),
+			},
+			want: []Entry{{ID: "t3", Direction: DirectionIn, Status: StatusSent, Amount: 8, Counterparty: "wxid_b"}},
+		},
+		{
+			name: "chatroom transfer between others",
+			self: "wxid_me",
+			messages: []*model.Message{
+				transferMsg("1@chatroom", "wxid_b", false, 1, 1, "t4", "wxid_b", "wxid_c", 8),
+			},
+			want: []Entry{},
+		},
+		{
+			name: "chatroom receipt without initiating message",
+			self: "wxid_me",
+			messages: []*model.Message{
+				transferMsg("1@chatroom", "wxid_b", false, 2, 3, "t5", "wxid_me", "wxid_b", 3),
+			},
+			want: []Entry{{ID: "t5", Direction: DirectionOut, Status: StatusReceived, Amount: 3, Counterparty: "wxid_b"}},
+		},
+		{
+			name: "self learned from self-sent transfer when account is unknown",
+			self: "",
+			messages: []*model.Message{
+				transferMsg("1@chatroom", "wxid_me", true, 1, 1, "t6", "wxid_me", "wxid_b", 1),
+				transferMsg("1@chatroom", "wxid_b", false, 2, 1, "t7", "wxid_b", "wxid_me", 2),
+			},
+			want: []Entry{
+				{ID: "t6", Direction: DirectionOut, Status: StatusSent, Amount: 1, Counterparty: "wxid_b"},
+				{ID: "t7", Direction: DirectionIn, Status: StatusSent, Amount: 2, Counterparty: "wxid_b"},
+			},
+		},
+		{
+			name: "red envelope received by self",
+			self: "wxid_me",
+			messages: []*model.Message{
+				{
+					Seq: 1, Time: time.Unix(1, 0), Talker: "wxid_a", Sender: "wxid_a",
+					Type: model.MessageTypeShare, SubType: model.MessageSubTypeRedEnvelope,
+					Contents: map[string]interface{}{"sendid": "r1", "title": "恭喜发财"},
+				},
+				{
+					Seq: 2, Time: time.Unix(2, 0), Talker: "wxid_a", Type: model.MessageTypeSystem,
+					Content: "你领取了a的红包", Contents: map[string]interface{}{"sendid": "r1"},
+				},
+			},
+			want: []Entry{{ID: "r1", Direction: DirectionIn, Status: StatusReceived, Counterparty: "wxid_a"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBuilder(tt.self)
+			b.collect(tt.messages)
+			got := b.build()
+			if len(got) != len(tt.want) {
+				t.Fatalf("build() returned %d entries, want %d", len(got), len(tt.want))
+			}
+			for i, want := range tt.want {
+				e := got[i]
+				if e.ID != want.ID || e.Direction != want.Direction || e.Status != want.Status ||
+					e.Amount != want.Amount || e.Counterparty != want.Counterparty {
+					t.Errorf("entry %d = {%s %s %s %v %s}, want {%s %s %s %v %s}", i,
+						e.ID, e.Direction, e.Status, e.Amount, e.Counterparty,
+						want.ID, want.Direction, want.Status, want.Amount, want.Counterparty)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,346 @@
+package ledger
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
+	"github.com/sjzar/chatlog/internal/wechatdb"
+	"github.com/sjzar/chatlog/pkg/util"
+)
+
+const (
+	KindTransfer    = "transfer"
+	KindRedEnvelope = "redenvelope"
+
+	DirectionIn  = "in"
+	DirectionOut = "out"
+
+	// 状态：sent 已发起未收款，received 已收款或已领取，refunded 已退还
+	StatusSent     = "sent"
+	StatusReceived = "received"
+	StatusRefunded = "refunded"
+
+	PeriodDay   = "day"
+	PeriodMonth = "month"
+	PeriodYear  = "year"
+)
+
+// SettleWindow 转账与红包 24 小时未收取即退还，向前后多查询该时长以关联发起与回执消息
+const SettleWindow = 48 * time.Hour
+
+// Source 构建账本所需的数据接口，由 database.Service 实现
+type Source interface {
+	GetSessions(key string, limit, offset int) (*wechatdb.GetSessionsResp, error)
+	GetMessagesByType(start, end time.Time, talker string, types ...int64) ([]*model.Message, error)
+	GetContact(key string) (*model.Contact, error)
+	GetSelfUserName() (string, error)
+}
+
+// Entry 一笔转账或红包，发起消息与收款、退还回执合并为一条记录
+type Entry struct {
+	// ID 转账为 transcationid，红包为 sendid
+	ID        string  `json:"id"`
+	Kind      string  `json:"kind"`
+	Direction string  `json:"direction"`
+	Status    string  `json:"status"`
+	Amount    float64 `json:"amount"` // 红包金额不随消息下发，为 0
+	Currency  string  `json:"currency,omitempty"`
+	Memo      string  `json:"memo,omitempty"`
+
+	Talker           string `json:"talker"`
+	TalkerName       string `json:"talkerName,omitempty"`
+	Counterparty     string `json:"counterparty"`
+	CounterpartyName string `json:"counterpartyName,omitempty"`
+
+	// Time 发起时间，未找到发起消息时为回执时间
+	Time       time.Time  `json:"time"`
+	Seq        int64      `json:"seq,omitempty"`
+	SettledAt  *time.Time `json:"settledAt,omitempty"`
+	ReceiptSeq int64      `json:"receiptSeq,omitempty"`
+	TransferID string     `json:"transferId,omitempty"`
+}
+
+// Total 收支汇总，已退还的记录只计入 Refund
+type Total struct {
+	Key          string  `json:"key"`
+	Name         string  `json:"name,omitempty"`
+	InCount      int     `json:"inCount"`
+	InAmount     float64 `json:"inAmount"`
+	OutCount     int     `json:"outCount"`
+	OutAmount    float64 `json:"outAmount"`
+	RefundCount  int     `json:"refundCount"`
+	RefundAmount float64 `json:"refundAmount"`
+	Net          float64 `json:"net"`
+}
+
+// Ledger 时间范围内的转账与红包记录及汇总
+type Ledger struct {
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Period    string    `json:"period"`
+	Entries   []*Entry  `json:"entries"`
+	Total     *Total    `json:"total"`
+	ByContact []*Total  `json:"byContact"`
+	ByPeriod  []*Total  `json:"byPeriod"`
+}
+
+// Options 构建条件，Talker 多个以英文逗号分隔，可以是 ID 或名称
+type Options struct {
+	Start  time.Time
+	End    time.Time
+	Talker string
+
+	// Period 按周期汇总的粒度，为 day/month/year，默认 month
+	Period string
+}
+
+// Build 扫描会话中的转账、红包与红包领取提示，生成账本
+func Build(src Source, opts Options) (*Ledger, error) {
+	switch opts.Period {
+	case PeriodDay, PeriodMonth, PeriodYear:
+	case "":
+		opts.Period = PeriodMonth
+	default:
+		return nil, errors.InvalidArg("period")
+	}
+
+	sessions, err := src.GetSessions("", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	talkers := util.Str2List(opts.Talker, ",")
+
+	start := opts.Start.Add(-SettleWindow)
+	end := opts.End.Add(SettleWindow)
+	if now := time.Now(); end.After(now) {
+		end = now
+	}
+
+	// 群聊中他人的转账需要按当前账号判断是否转给自己，无法获取时退回到从自己发起的转账中推断
+	self, err := src.GetSelfUserName()
+	if err != nil {
+		log.Debug().Err(err).Msg("get self user name failed")
+	}
+	b := newBuilder(self)
+	for _, session := range sessions.Items {
+		if session.UserName == "" || session.NTime.Before(start) {
+			continue
+		}
+		if len(talkers) > 0 && !slices.Contains(talkers, session.UserName) && !slices.Contains(talkers, session.NickName) {
+			continue
+		}
+		messages, err := src.GetMessagesByType(start, end, session.UserName, model.MessageTypeShare, model.MessageTypeSystem)
+		if err != nil {
+			log.Debug().Err(err).Msgf("get messages failed: %s", session.UserName)
+			continue
+		}
+		b.collect(messages)
+	}
+
+	l := &Ledger{
+		Start:   opts.Start,
+		End:     opts.End,
+		Period:  opts.Period,
+		Entries: make([]*Entry, 0),
+	}
+	for _, e := range b.build() {
+		if e.Time.Before(opts.Start) || e.Time.After(opts.End) {
+			continue
+		}
+		l.Entries = append(l.Entries, e)
+	}
+	sort.SliceStable(l.Entries, func(i, j int) bool { return l.Entries[i].Time.Before(l.Entries[j].Time) })
+
+	names := make(map[string]string)
+	for _, e := range l.Entries {
+		if e.CounterpartyName != "" || e.Counterparty == "" {
+			continue
+		}
+		name, ok := names[e.Counterparty]
+		if !ok {
+			if contact, err := src.GetContact(e.Counterparty); err == nil {
+				name = contact.DisplayName()
+			}
+			names[e.Counterparty] = name
+		}
+		e.CounterpartyName = name
+	}
+
+	l.summarize()
+	return l, nil
+}
+
+func (l *Ledger) summarize() {
+	l.Total = &Total{Key: "total"}
+	byContact := make(map[string]*Total)
+	byPeriod := make(map[string]*Total)
+	for _, e := range l.Entries {
+		l.Total.add(e)
+
+		contact, ok := byContact[e.Counterparty]
+		if !ok {
+			contact = &Total{Key: e.Counterparty}
+			byContact[e.Counterparty] = contact
+		}
+		if contact.Name == "" {
+			contact.Name = e.CounterpartyName
+		}
+		contact.add(e)
+
+		key := periodKey(e.Time, l.Period)
+		period, ok := byPeriod[key]
+		if !ok {
+			period = &Total{Key: key}
+			byPeriod[key] = period
+		}
+		period.add(e)
+	}
+
+	l.ByContact = make([]*Total, 0, len(byContact))
+	for _, t := range byContact {
+		l.ByContact = append(l.ByContact, t)
+	}
+	sort.Slice(l.ByContact, func(i, j int) bool {
+		a, b := l.ByContact[i], l.ByContact[j]
+		if a.InAmount+a.OutAmount != b.InAmount+b.OutAmount {
+			return a.InAmount+a.OutAmount > b.InAmount+b.OutAmount
+		}
+		if a.InCount+a.OutCount != b.InCount+b.OutCount {
+			return a.InCount+a.OutCount > b.InCount+b.OutCount
+		}
+		return a.Key < b.Key
+	})
+
+	l.ByPeriod = make([]*Total, 0, len(byPeriod))
+	for _, t := range byPeriod {
+		l.ByPeriod = append(l.ByPeriod, t)
+	}
+	sort.Slice(l.ByPeriod, func(i, j int) bool { return l.ByPeriod[i].Key < l.ByPeriod[j].Key })
+}
+
+func (t *Total) add(e *Entry) {
+	switch {
+	case e.Status == StatusRefunded:
+		t.RefundCount++
+		t.RefundAmount += e.Amount
+	case e.Direction == DirectionIn:
+		t.InCount++
+		t.InAmount += e.Amount
+	default:
+		t.OutCount++
+		t.OutAmount += e.Amount
+	}
+	t.Net = t.InAmount - t.OutAmount
+}
+
+func periodKey(t time.Time, period string) string {
+	switch period {
+	case PeriodDay:
+		return t.Format("2006-01-02")
+	case PeriodYear:
+		return t.Format("2006")
+	default:
+		return t.Format("2006-01")
+	}
+}
+
+// WriteCSV 以 CSV 格式输出账本明细
+func (l *Ledger) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"ID", "Kind", "Direction", "Status", "Amount", "Currency", "Memo", "Time", "SettledAt", "Talker", "TalkerName", "Counterparty", "CounterpartyName", "Seq", "ReceiptSeq", "TransferID"})
+	for _, e := range l.Entries {
+		settledAt := ""
+		if e.SettledAt != nil {
+			settledAt = e.SettledAt.Format("2006-01-02 15:04:05")
+		}
+		cw.Write([]string{
+			e.ID,
+			e.Kind,
+			e.Direction,
+			e.Status,
+			formatAmount(e.Amount),
+			e.Currency,
+			e.Memo,
+			e.Time.Format("2006-01-02 15:04:05"),
+			settledAt,
+			e.Talker,
+			e.TalkerName,
+			e.Counterparty,
+			e.CounterpartyName,
+			strconv.FormatInt(e.Seq, 10),
+			strconv.FormatInt(e.ReceiptSeq, 10),
+			e.TransferID,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteTotalsCSV 以 CSV 格式输出汇总，group 为 contact 或 period
+func (l *Ledger) WriteTotalsCSV(w io.Writer, group string) error {
+	totals := l.ByContact
+	if group == "period" {
+		totals = l.ByPeriod
+	}
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"Key", "Name", "InCount", "InAmount", "OutCount", "OutAmount", "RefundCount", "RefundAmount", "Net"})
+	for _, t := range totals {
+		cw.Write([]string{
+			t.Key,
+			t.Name,
+			strconv.Itoa(t.InCount),
+			formatAmount(t.InAmount),
+			strconv.Itoa(t.OutCount),
+			formatAmount(t.OutAmount),
+			strconv.Itoa(t.RefundCount),
+			formatAmount(t.RefundAmount),
+			formatAmount(t.Net),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// PlainText 以纯文本输出汇总与明细
+func (l *Ledger) PlainText() string {
+	buf := strings.Builder{}
+	buf.WriteString(fmt.Sprintf("%s ~ %s\n", l.Start.Format("2006-01-02"), l.End.Format("2006-01-02")))
+	buf.WriteString(fmt.Sprintf("收入 %d 笔 %s，支出 %d 笔 %s，退还 %d 笔 %s\n\n",
+		l.Total.InCount, formatAmount(l.Total.InAmount),
+		l.Total.OutCount, formatAmount(l.Total.OutAmount),
+		l.Total.RefundCount, formatAmount(l.Total.RefundAmount)))
+	for _, e := range l.Entries {
+		kind := "转账"
+		if e.Kind == KindRedEnvelope {
+			kind = "红包"
+		}
+		direction := "收"
+		if e.Direction == DirectionOut {
+			direction = "付"
+		}
+		counterparty := e.Counterparty
+		if e.CounterpartyName != "" {
+			counterparty = e.CounterpartyName + "(" + e.Counterparty + ")"
+		}
+		buf.WriteString(fmt.Sprintf("%s [%s|%s] %s %s %s", e.Time.Format("2006-01-02 15:04"), kind, direction, counterparty, formatAmount(e.Amount), e.Status))
+		if e.Memo != "" {
+			buf.WriteString(" " + e.Memo)
+		}
+		buf.WriteString("\n")
+	}
+	return buf.String()
+}
+
+func formatAmount(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', 2, 64)
+}
@@ -2,7 +2,9 @@ package chatlog
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"strings"
@@ -12,7 +14,10 @@ import (
 	"github.com/sjzar/chatlog/internal/chatlog/conf"
 	"github.com/sjzar/chatlog/internal/chatlog/ctx"
 	"github.com/sjzar/chatlog/internal/chatlog/database"
+	"github.com/sjzar/chatlog/internal/chatlog/export"
 	"github.com/sjzar/chatlog/internal/chatlog/http"
+	"github.com/sjzar/chatlog/internal/chatlog/mediastore"
+	"github.com/sjzar/chatlog/internal/chatlog/report"
 	"github.com/sjzar/chatlog/internal/chatlog/wechat"
 	"github.com/sjzar/chatlog/internal/model"
 	iwechat "github.com/sjzar/chatlog/internal/wechat"
@@ -51,6 +56,7 @@ func (m *Manager) Run(configPath string) error {
 	m.wechat = wechat.NewService(m.ctx)
 
 	m.db = database.NewService(m.ctx)
+	m.wechat.AddDecryptedHandler(m.db.TrackRecalls)
 
 	m.http = http.NewService(m.ctx, m.db)
 
@@ -555,6 +561,7 @@ func (m *Manager) CommandHTTPServer(configPath string, cmdConf map[string]any) e
 	m.wechat = wechat.NewService(m.sc)
 
 	m.db = database.NewService(m.sc)
+	m.wechat.AddDecryptedHandler(m.db.TrackRecalls)
 
 	m.http = http.NewService(m.sc, m.db)
 
@@ -598,3 +605,165 @@ func (m *Manager) CommandHTTPServer(configPath string, cmdConf map[string]any) e
 	return m.http.ListenAndServe()
 }
 
+func (m *Manager) CommandMCPServer(configPath string, cmdConf map[string]any) error {
+
+	var err error
+	m.sc, m.scm, err = conf.LoadServiceConfig(configPath, cmdConf)
+	if err != nil {
+		return err
+	}
+
+	workDir := m.sc.GetWorkDir()
+	if len(workDir) == 0 {
+		return fmt.Errorf("workDir is required")
+	}
+
+	// 如果是 4.0 版本，处理图片密钥
+	dataDir := m.sc.GetDataDir()
+	if m.sc.GetVersion() == 4 && len(dataDir) != 0 {
+		dat2img.SetAesKey(m.sc.GetImgKey())
+		go dat2img.ScanAndSetXorKey(dataDir)
+	}
+
+	m.db = database.NewService(m.sc)
+
+	// stdio 模式下直接打开已解密的工作目录，不启动 HTTP 服务
+	if err := m.db.Start(); err != nil {
+		return err
+	}
+	defer m.db.Stop()
+
+	m.http = http.NewService(m.sc, m.db)
+
+	return m.http.ServeStdio()
+}
+
+// CommandReport 打开已解密的工作目录生成回顾报告，输出到 w
+func (m *Manager) CommandReport(configPath string, cmdConf map[string]any, timeRange string, top int, format string, w io.Writer) error {
+
+	var err error
+	m.sc, m.scm, err = conf.LoadServiceConfig(configPath, cmdConf)
+	if err != nil {
+		return err
+	}
+
+	if len(m.sc.GetWorkDir()) == 0 {
+		return fmt.Errorf("workDir is required")
+	}
+
+	if timeRange == "" {
+		timeRange = time.Now().Format("2006")
+	}
+	start, end, ok := util.TimeRangeOf(timeRange)
+	if !ok {
+		return fmt.Errorf("invalid time range: %s", timeRange)
+	}
+
+	m.db = database.NewService(m.sc)
+	if err := m.db.Start(); err != nil {
+		return err
+	}
+	defer m.db.Stop()
+
+	r, err := report.Build(m.db, start, end, top)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(format) {
+	case "html":
+		return r.WriteHTML(w)
+	default:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	}
+}
+
+// CommandMediaExport 打开已解密的工作目录，将时间范围内的媒体消息导出到 opts.OutDir
+func (m *Manager) CommandMediaExport(configPath string, cmdConf map[string]any, timeRange string, opts export.Options) (*export.Summary, error) {
+	if err := m.openMedia(configPath, cmdConf, timeRange, &opts); err != nil {
+		return nil, err
+	}
+	defer m.db.Stop()
+
+	return export.Export(context.Background(), m.db, opts)
+}
+
+// CommandMediaReport 打开已解密的工作目录，统计时间范围内媒体消息的可用性，输出到 w
+func (m *Manager) CommandMediaReport(configPath string, cmdConf map[string]any, timeRange string, opts export.Options, detail bool, format string, w io.Writer) error {
+	if err := m.openMedia(configPath, cmdConf, timeRange, &opts); err != nil {
+		return err
+	}
+	defer m.db.Stop()
+
+	a, err := export.Scan(context.Background(), m.db, opts, detail)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(format) {
+	case "csv":
+		return a.WriteCSV(w)
+	case "text":
+		a.WriteText(w)
+		return nil
+	default:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(a)
+	}
+}
+
+// CommandMediaStore 打开已解密的工作目录，将时间范围内的媒体写入工作目录下的内容寻址存储，gc 为 true 时回收没有被引用的文件
+func (m *Manager) CommandMediaStore(configPath string, cmdConf map[string]any, timeRange string, opts export.Options, gc bool) (*mediastore.IngestResult, *mediastore.GCResult, error) {
+	cmdConf["media_store"] = true
+	if err := m.openMedia(configPath, cmdConf, timeRange, &opts); err != nil {
+		return nil, nil, err
+	}
+	defer m.db.Stop()
+
+	ret, err := m.db.StoreMedia(context.Background(), opts.Start, opts.End, opts.Talker)
+	if err != nil || !gc {
+		return ret, nil, err
+	}
+	freed, err := m.db.MediaGC()
+	return ret, freed, err
+}
+
+// openMedia 加载配置、设置图片密钥并启动数据库，time 默认为全部时间
+func (m *Manager) openMedia(configPath string, cmdConf map[string]any, timeRange string, opts *export.Options) error {
+
+	var err error
+	m.sc, m.scm, err = conf.LoadServiceConfig(configPath, cmdConf)
+	if err != nil {
+		return err
+	}
+
+	if len(m.sc.GetWorkDir()) == 0 {
+		return fmt.Errorf("workDir is required")
+	}
+	dataDir := m.sc.GetDataDir()
+	if len(dataDir) == 0 {
+		return fmt.Errorf("dataDir is required")
+	}
+
+	if timeRange == "" {
+		timeRange = "all"
+	}
+	start, end, ok := util.TimeRangeOf(timeRange)
+	if !ok {
+		return fmt.Errorf("invalid time range: %s", timeRange)
+	}
+	opts.Start, opts.End, opts.DataDir = start, end, dataDir
+
+	// 4.0 版本的图片需要图片密钥与 XOR 密钥才能解密
+	if m.sc.GetVersion() == 4 {
+		dat2img.SetAesKey(m.sc.GetImgKey())
+		if _, err := dat2img.ScanAndSetXorKey(dataDir); err != nil {
+			log.Warn().Err(err).Msg("scan xor key failed")
+		}
+	}
+
+	m.db = database.NewService(m.sc)
+	return m.db.Start()
+}
@@ -0,0 +1,136 @@
+package mediastore
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sjzar/chatlog/internal/errors"
+)
+
+// Stats 存储统计，Refs 为引用媒体文件的消息数，Saved 为去重节省的空间
+type Stats struct {
+	Blobs   int   `json:"blobs"`
+	Bytes   int64 `json:"bytes"`
+	Sources int   `json:"sources"`
+	Refs    int   `json:"refs"`
+	Saved   int64 `json:"saved"`
+}
+
+// IngestResult 批量写入媒体的结果，Stored 为新写入的文件，Deduplicated 为内容已存在的媒体，
+// Unchanged 为原始文件没有变化、不需要重新解码的媒体
+type IngestResult struct {
+	Total        int   `json:"total"`
+	Stored       int   `json:"stored"`
+	Deduplicated int   `json:"deduplicated"`
+	Unchanged    int   `json:"unchanged"`
+	Missing      int   `json:"missing"`
+	Failed       int   `json:"failed"`
+	Bytes        int64 `json:"bytes"`
+	Saved        int64 `json:"saved"`
+}
+
+// GCResult 回收结果
+type GCResult struct {
+	Removed int   `json:"removed"`
+	Freed   int64 `json:"freed"`
+}
+
+// Stats 统计存储中的媒体文件与引用
+func (s *Store) Stats() (*Stats, error) {
+	var st Stats
+	query := `SELECT COUNT(*), COALESCE(SUM(size), 0) FROM blob`
+	if err := s.db.QueryRow(query).Scan(&st.Blobs, &st.Bytes); err != nil {
+		return nil, errors.QueryFailed(query, err)
+	}
+	query = `SELECT COUNT(*) FROM source`
+	if err := s.db.QueryRow(query).Scan(&st.Sources); err != nil {
+		return nil, errors.QueryFailed(query, err)
+	}
+	query = `SELECT COUNT(*), COALESCE(SUM(b.size), 0) FROM ref r JOIN blob b ON b.hash = r.hash`
+	var refBytes int64
+	if err := s.db.QueryRow(query).Scan(&st.Refs, &refBytes); err != nil {
+		return nil, errors.QueryFailed(query, err)
+	}
+	query = `SELECT COALESCE(SUM(size), 0) FROM blob WHERE hash IN (SELECT hash FROM ref)`
+	var linkedBytes int64
+	if err := s.db.QueryRow(query).Scan(&linkedBytes); err != nil {
+		return nil, errors.QueryFailed(query, err)
+	}
+	st.Saved = refBytes - linkedBytes
+	return &st, nil
+}
+
+// GC 删除没有被任何消息或原始文件引用的媒体文件，以及目录中没有记录的文件
+// 一小时内写入的文件可能还没来得及记录引用，不会被删除
+func (s *Store) GC() (*GCResult, error) {
+	ret := &GCResult{}
+	cutoff := time.Now().Add(-time.Hour)
+
+	query := `SELECT hash, ext, size FROM blob
+		WHERE created_at < ? AND hash NOT IN (SELECT hash FROM ref) AND hash NOT IN (SELECT hash FROM source)`
+	rows, err := s.db.Query(query, cutoff.Unix())
+	if err != nil {
+		return nil, errors.QueryFailed(query, err)
+	}
+	blobs := make([]*Blob, 0)
+	for rows.Next() {
+		var b Blob
+		if err := rows.Scan(&b.Hash, &b.Ext, &b.Size); err != nil {
+			rows.Close()
+			return nil, errors.ScanRowFailed(err)
+		}
+		blobs = append(blobs, &b)
+	}
+	rows.Close()
+
+	for _, b := range blobs {
+		query := `DELETE FROM blob WHERE hash = ?`
+		if _, err := s.db.Exec(query, b.Hash); err != nil {
+			return ret, errors.QueryFailed(query, err)
+		}
+		if err := os.Remove(s.Path(b)); err == nil {
+			ret.Removed++
+			ret.Freed += b.Size
+		}
+	}
+
+	// 删除记录已被删除或写入中断留下的文件
+	known := make(map[string]bool)
+	query = `SELECT hash FROM blob`
+	rows, err = s.db.Query(query)
+	if err != nil {
+		return ret, errors.QueryFailed(query, err)
+	}
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			rows.Close()
+			return ret, errors.ScanRowFailed(err)
+		}
+		known[hash] = true
+	}
+	rows.Close()
+
+	filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		hash := strings.TrimSuffix(d.Name(), filepath.Ext(d.Name()))
+		if known[hash] {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			return nil
+		}
+		if os.Remove(path) == nil {
+			ret.Removed++
+			ret.Freed += info.Size()
+		}
+		return nil
+	})
+	return ret, nil
+}
@@ -0,0 +1,254 @@
+package mediastore
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/pkg/util"
+)
+
+const (
+	// StoreFile 内容寻址存储的映射表，位于工作目录下
+	StoreFile = "chatlog_media.db"
+
+	// BlobDir 解码后的媒体文件目录，位于工作目录下，按内容哈希的前两位分目录
+	BlobDir = "media"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS blob (
+	hash TEXT PRIMARY KEY,
+	ext TEXT,
+	size INTEGER,
+	created_at INTEGER
+);
+CREATE TABLE IF NOT EXISTS source (
+	path TEXT PRIMARY KEY,
+	size INTEGER,
+	mod_time INTEGER,
+	hash TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS source_hash ON source (hash);
+CREATE TABLE IF NOT EXISTS ref (
+	talker TEXT NOT NULL,
+	seq INTEGER NOT NULL,
+	kind TEXT,
+	key TEXT,
+	name TEXT,
+	hash TEXT NOT NULL,
+	sender TEXT,
+	create_time INTEGER,
+	PRIMARY KEY (talker, seq)
+);
+CREATE INDEX IF NOT EXISTS ref_hash ON ref (hash);
+CREATE INDEX IF NOT EXISTS ref_key ON ref (key);
+`
+
+// Blob 按内容哈希（sha256）保存的一份解码后的媒体文件
+type Blob struct {
+	Hash      string    `json:"hash"`
+	Ext       string    `json:"ext"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Ref 引用媒体文件的消息，Key 为消息中的媒体 key（md5 等）
+type Ref struct {
+	Talker string    `json:"talker"`
+	Seq    int64     `json:"seq"`
+	Kind   string    `json:"kind"`
+	Key    string    `json:"key,omitempty"`
+	Name   string    `json:"name,omitempty"`
+	Hash   string    `json:"hash"`
+	Sender string    `json:"sender"`
+	Time   time.Time `json:"time"`
+}
+
+// Store 内容寻址的媒体存储，相同内容的媒体只保存一份，
+// source 表记录数据目录下的原始文件对应的内容，ref 表记录引用内容的消息
+type Store struct {
+	dir string
+	db  *sql.DB
+}
+
+func New(workDir string) (*Store, error) {
+	if workDir == "" {
+		return nil, errors.InvalidArg("work_dir")
+	}
+	dir := filepath.Join(workDir, BlobDir)
+	if err := util.PrepareDir(dir); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(workDir, StoreFile)
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, errors.DBConnectFailed(path, err)
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, errors.DBInitFailed(err)
+	}
+	return &Store{dir: dir, db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Path 媒体文件的绝对路径
+func (s *Store) Path(b *Blob) string {
+	name := b.Hash
+	if b.Ext != "" {
+		name += "." + b.Ext
+	}
+	return filepath.Join(s.dir, b.Hash[:2], name)
+}
+
+// Put 保存媒体文件，内容已存在时不重复写入，created 表示是否新写入了文件
+func (s *Store) Put(data []byte, ext string) (blob *Blob, created bool, err error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	if blob, err = s.Blob(hash); err != nil {
+		return nil, false, err
+	}
+	if blob != nil {
+		if info, err := os.Stat(s.Path(blob)); err == nil && info.Size() == blob.Size {
+			return blob, false, nil
+		}
+	} else {
+		blob = &Blob{Hash: hash, Ext: strings.ToLower(ext), Size: int64(len(data)), CreatedAt: time.Now()}
+	}
+
+	// 先写入临时文件再重命名，避免读到写了一半的媒体文件
+	if err := util.WriteFileAtomic(s.Path(blob), data); err != nil {
+		return nil, false, errors.WriteOutputFailed(err)
+	}
+	query := `INSERT OR IGNORE INTO blob (hash, ext, size, created_at) VALUES (?, ?, ?, ?)`
+	if _, err := s.db.Exec(query, blob.Hash, blob.Ext, blob.Size, blob.CreatedAt.Unix()); err != nil {
+		return nil, false, errors.QueryFailed(query, err)
+	}
+	return blob, true, nil
+}
+
+// Blob 按内容哈希读取媒体文件记录，不存在时返回 nil
+func (s *Store) Blob(hash string) (*Blob, error) {
+	query := `SELECT hash, ext, size, created_at FROM blob WHERE hash = ?`
+	var b Blob
+	var ext sql.NullString
+	var size, createdAt sql.NullInt64
+	err := s.db.QueryRow(query, hash).Scan(&b.Hash, &ext, &size, &createdAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.QueryFailed(query, err)
+	}
+	b.Ext = ext.String
+	b.Size = size.Int64
+	b.CreatedAt = time.Unix(createdAt.Int64, 0)
+	return &b, nil
+}
+
+// Source 数据目录下的原始文件对应的媒体文件，原始文件大小或修改时间变化、媒体文件丢失时返回 nil
+func (s *Store) Source(path string, size, modTime int64) (*Blob, error) {
+	query := `SELECT b.hash, b.ext, b.size, b.created_at FROM source s JOIN blob b ON b.hash = s.hash
+		WHERE s.path = ? AND s.size = ? AND s.mod_time = ?`
+	var b Blob
+	var ext sql.NullString
+	var blobSize, createdAt sql.NullInt64
+	err := s.db.QueryRow(query, filepath.ToSlash(path), size, modTime).Scan(&b.Hash, &ext, &blobSize, &createdAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.QueryFailed(query, err)
+	}
+	b.Ext = ext.String
+	b.Size = blobSize.Int64
+	b.CreatedAt = time.Unix(createdAt.Int64, 0)
+	if _, err := os.Stat(s.Path(&b)); err != nil {
+		return nil, nil
+	}
+	return &b, nil
+}
+
+// SetSource 记录数据目录下的原始文件对应的媒体文件，path 为数据目录下的相对路径
+func (s *Store) SetSource(path string, size, modTime int64, hash string) error {
+	query := `INSERT OR REPLACE INTO source (path, size, mod_time, hash) VALUES (?, ?, ?, ?)`
+	if _, err := s.db.Exec(query, filepath.ToSlash(path), size, modTime, hash); err != nil {
+		return errors.QueryFailed(query, err)
+	}
+	return nil
+}
+
+// Link 记录引用媒体文件的消息，同一条消息只保留最新的记录
+func (s *Store) Link(ref *Ref) error {
+	query := `INSERT OR REPLACE INTO ref (talker, seq, kind, key, name, hash, sender, create_time) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	if _, err := s.db.Exec(query, ref.Talker, ref.Seq, ref.Kind, ref.Key, ref.Name, ref.Hash, ref.Sender, ref.Time.Unix()); err != nil {
+		return errors.QueryFailed(query, err)
+	}
+	return nil
+}
+
+// Linked 消息引用的媒体文件哈希，没有记录时返回空字符串
+func (s *Store) Linked(talker string, seq int64) (string, error) {
+	query := `SELECT hash FROM ref WHERE talker = ? AND seq = ?`
+	var hash string
+	err := s.db.QueryRow(query, talker, seq).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.QueryFailed(query, err)
+	}
+	return hash, nil
+}
+
+// HashOf 媒体 key（md5 等）对应的媒体文件哈希，没有记录时返回空字符串
+func (s *Store) HashOf(key string) (string, error) {
+	query := `SELECT hash FROM ref WHERE key = ? LIMIT 1`
+	var hash string
+	err := s.db.QueryRow(query, key).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.QueryFailed(query, err)
+	}
+	return hash, nil
+}
+
+// Refs 引用同一媒体文件的全部消息，按消息时间排序
+func (s *Store) Refs(hash string) ([]*Ref, error) {
+	query := `SELECT talker, seq, kind, key, name, hash, sender, create_time FROM ref WHERE hash = ? ORDER BY create_time, talker, seq`
+	rows, err := s.db.Query(query, hash)
+	if err != nil {
+		return nil, errors.QueryFailed(query, err)
+	}
+	defer rows.Close()
+
+	refs := make([]*Ref, 0)
+	for rows.Next() {
+		var ref Ref
+		var kind, key, name, sender sql.NullString
+		var createTime sql.NullInt64
+		if err := rows.Scan(&ref.Talker, &ref.Seq, &kind, &key, &name, &ref.Hash, &sender, &createTime); err != nil {
+			return nil, errors.ScanRowFailed(err)
+		}
+		ref.Kind = kind.String
+		ref.Key = key.String
+		ref.Name = name.String
+		ref.Sender = sender.String
+		ref.Time = time.Unix(createTime.Int64, 0)
+		refs = append(refs, &ref)
+	}
+	return refs, nil
+}
@@ -0,0 +1,18 @@
+package ocr
+
+import "time"
+
+// JobStatus 后台批量识别任务的进度，Skipped 为已有识别结果的图片
+type JobStatus struct {
+	Running    bool      `json:"running"`
+	Talker     string    `json:"talker,omitempty"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	Total      int       `json:"total"`
+	Done       int       `json:"done"`
+	Skipped    int       `json:"skipped"`
+	Failed     int       `json:"failed"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
@@ -0,0 +1,141 @@
+package ocr
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sjzar/chatlog/internal/chatlog/conf"
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
+)
+
+const (
+	ProviderTesseract = "tesseract"
+
+	DefaultLanguage = "chi_sim+eng"
+	DefaultTimeout  = time.Minute
+)
+
+// Provider 图片文字识别引擎，ext 为图片格式（jpg、png 等），返回识别出的文本
+type Provider interface {
+	Name() string
+	Recognize(ctx context.Context, image []byte, ext string) (string, error)
+}
+
+// NewProvider 按配置创建识别引擎，未配置时返回 nil
+func NewProvider(c *conf.OCR) (Provider, error) {
+	if c == nil || c.Provider == "" {
+		return nil, nil
+	}
+	switch strings.ToLower(c.Provider) {
+	case ProviderTesseract:
+		return NewTesseract(c), nil
+	default:
+		return nil, errors.InvalidArg("ocr.provider")
+	}
+}
+
+// Recognizer 识别图片消息中的文字，结果按图片 md5 保存在工作目录下
+// 没有配置识别引擎时仍可读取已有的识别结果
+type Recognizer struct {
+	provider Provider
+	store    *Store
+	timeout  time.Duration
+
+	// 识别引擎占满 CPU，同一时间只识别一张图片
+	mutex sync.Mutex
+}
+
+// New 打开识别结果存储并按配置创建识别引擎
+func New(workDir string, c *conf.OCR) (*Recognizer, error) {
+	provider, err := NewProvider(c)
+	if err != nil {
+		return nil, err
+	}
+	store, err := NewStore(workDir)
+	if err != nil {
+		return nil, err
+	}
+	timeout := DefaultTimeout
+	if c != nil && c.TimeoutSec > 0 {
+		timeout = time.Duration(c.TimeoutSec) * time.Second
+	}
+	return &Recognizer{
+		provider: provider,
+		store:    store,
+		timeout:  timeout,
+	}, nil
+}
+
+// Enabled 是否配置了识别引擎
+func (r *Recognizer) Enabled() bool {
+	return r.provider != nil
+}
+
+func (r *Recognizer) Store() *Store {
+	return r.store
+}
+
+func (r *Recognizer) Close() error {
+	return r.store.Close()
+}
+
+// Recognize 识别图片并保存结果，md5 为图片消息中的 md5
+func (r *Recognizer) Recognize(ctx context.Context, md5 string, image []byte, ext string) (*Result, error) {
+	if r.provider == nil {
+		return nil, errors.ErrOCRDisabled
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	text, err := r.provider.Recognize(ctx, image, ext)
+	if err != nil {
+		return nil, errors.OCRFailed(r.provider.Name(), err)
+	}
+
+	res := &Result{
+		MD5:       md5,
+		Text:      text,
+		Provider:  r.provider.Name(),
+		CreatedAt: time.Now(),
+	}
+	if err := r.store.Put(res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Attach 将已有的识别结果写入图片消息的 Contents["ocr"]，没有文字的图片不写入
+func (r *Recognizer) Attach(messages ...*model.Message) {
+	keys := make([]string, 0)
+	for _, msg := range messages {
+		if key := ImageKey(msg); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+	results, err := r.store.Get(keys...)
+	if err != nil || len(results) == 0 {
+		return
+	}
+	for _, msg := range messages {
+		if res, ok := results[ImageKey(msg)]; ok && res.Text != "" {
+			msg.SetContent("ocr", res.Text)
+		}
+	}
+}
+
+// ImageKey 图片消息的 md5，不是图片消息时返回空
+func ImageKey(msg *model.Message) string {
+	if msg.Type != model.MessageTypeImage {
+		return ""
+	}
+	md5, _ := msg.Contents["md5"].(string)
+	return strings.ToLower(md5)
+}
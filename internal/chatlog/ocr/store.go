@@ -0,0 +1,181 @@
+package ocr
+
+import (
+	"database/sql"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
+	"github.com/sjzar/chatlog/pkg/util"
+)
+
+// StoreFile 识别结果的存储文件，位于工作目录下
+const StoreFile = "chatlog_ocr.db"
+
+// ocr 按图片 md5 保存识别结果，同一张图片转发多次只识别一次
+// ocr_message 记录图片出现在哪些消息中，用于搜索时定位消息
+const schema = `
+CREATE TABLE IF NOT EXISTS ocr (
+	md5 TEXT PRIMARY KEY,
+	text TEXT,
+	provider TEXT,
+	created_at INTEGER
+);
+CREATE TABLE IF NOT EXISTS ocr_message (
+	talker TEXT NOT NULL,
+	seq INTEGER NOT NULL,
+	md5 TEXT NOT NULL,
+	sender TEXT,
+	create_time INTEGER,
+	PRIMARY KEY (talker, seq)
+);
+CREATE INDEX IF NOT EXISTS ocr_message_md5 ON ocr_message (md5);
+CREATE INDEX IF NOT EXISTS ocr_message_time ON ocr_message (create_time);
+`
+
+// Result 图片的识别结果，搜索时附带图片所在的消息
+type Result struct {
+	MD5       string    `json:"md5"`
+	Text      string    `json:"text"`
+	Provider  string    `json:"provider"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	Talker string    `json:"talker,omitempty"`
+	Seq    int64     `json:"seq,omitempty"`
+	Sender string    `json:"sender,omitempty"`
+	Time   time.Time `json:"time,omitempty"`
+}
+
+// Store 识别结果存储，与微信数据库分开保存，重新解密数据库不会丢失
+type Store struct {
+	db *sql.DB
+}
+
+// NewStore 打开工作目录下的识别结果存储
+func NewStore(workDir string) (*Store, error) {
+	if workDir == "" {
+		return nil, errors.InvalidArg("work_dir")
+	}
+	if err := util.PrepareDir(workDir); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(workDir, StoreFile)
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, errors.DBConnectFailed(path, err)
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, errors.DBInitFailed(err)
+	}
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Put 保存识别结果，相同 md5 的记录会被覆盖
+func (s *Store) Put(res *Result) error {
+	query := `INSERT OR REPLACE INTO ocr (md5, text, provider, created_at) VALUES (?, ?, ?, ?)`
+	if _, err := s.db.Exec(query, res.MD5, res.Text, res.Provider, res.CreatedAt.Unix()); err != nil {
+		return errors.QueryFailed(query, err)
+	}
+	return nil
+}
+
+// Link 记录图片所在的消息
+func (s *Store) Link(md5 string, msg *model.Message) error {
+	query := `INSERT OR REPLACE INTO ocr_message (talker, seq, md5, sender, create_time) VALUES (?, ?, ?, ?, ?)`
+	if _, err := s.db.Exec(query, msg.Talker, msg.Seq, md5, msg.Sender, msg.Time.Unix()); err != nil {
+		return errors.QueryFailed(query, err)
+	}
+	return nil
+}
+
+// Get 按 md5 批量读取识别结果
+func (s *Store) Get(keys ...string) (map[string]*Result, error) {
+	results := make(map[string]*Result, len(keys))
+	for i := 0; i < len(keys); i += 500 {
+		batch := keys[i:min(i+500, len(keys))]
+		args := make([]interface{}, 0, len(batch))
+		for _, key := range batch {
+			args = append(args, key)
+		}
+		query := `SELECT md5, text, provider, created_at FROM ocr WHERE md5 IN (` +
+			strings.TrimSuffix(strings.Repeat("?,", len(batch)), ",") + `)`
+		rows, err := s.db.Query(query, args...)
+		if err != nil {
+			return nil, errors.QueryFailed(query, err)
+		}
+		for rows.Next() {
+			var res Result
+			var text, provider sql.NullString
+			var createdAt sql.NullInt64
+			if err := rows.Scan(&res.MD5, &text, &provider, &createdAt); err != nil {
+				rows.Close()
+				return nil, errors.ScanRowFailed(err)
+			}
+			res.Text = text.String
+			res.Provider = provider.String
+			res.CreatedAt = time.Unix(createdAt.Int64, 0)
+			results[res.MD5] = &res
+		}
+		rows.Close()
+	}
+	return results, nil
+}
+
+// Search 在时间范围内按正则表达式搜索识别文本，talkers 为空时搜索全部对话，结果按消息时间排序
+// 文本没有全文索引，按时间索引取出范围内的记录逐条匹配；关键词为普通文本时在 SQL 中过滤子串
+func (s *Store) Search(re *regexp.Regexp, talkers []string, start, end time.Time) ([]*Result, error) {
+	query := `SELECT m.md5, m.talker, m.seq, m.sender, m.create_time, o.text, o.provider, o.created_at
+		FROM ocr_message m JOIN ocr o ON o.md5 = m.md5
+		WHERE o.text != '' AND m.create_time >= ? AND m.create_time <= ?`
+	args := []interface{}{start.Unix(), end.Unix()}
+	if re != nil {
+		if literal, complete := re.LiteralPrefix(); complete {
+			query += " AND instr(o.text, ?) > 0"
+			args = append(args, literal)
+			re = nil
+		}
+	}
+	if len(talkers) > 0 {
+		query += " AND m.talker IN (" + strings.TrimSuffix(strings.Repeat("?,", len(talkers)), ",") + ")"
+		for _, talker := range talkers {
+			args = append(args, talker)
+		}
+	}
+	query += " ORDER BY m.create_time ASC, m.seq ASC"
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, errors.QueryFailed(query, err)
+	}
+	defer rows.Close()
+
+	results := make([]*Result, 0)
+	for rows.Next() {
+		var res Result
+		var sender, text, provider sql.NullString
+		var createTime, createdAt sql.NullInt64
+		if err := rows.Scan(&res.MD5, &res.Talker, &res.Seq, &sender, &createTime, &text, &provider, &createdAt); err != nil {
+			return nil, errors.ScanRowFailed(err)
+		}
+		res.Sender = sender.String
+		res.Text = text.String
+		res.Provider = provider.String
+		res.Time = time.Unix(createTime.Int64, 0)
+		res.CreatedAt = time.Unix(createdAt.Int64, 0)
+		if re != nil && !re.MatchString(res.Text) {
+			continue
+		}
+		results = append(results, &res)
+	}
+	return results, rows.Err()
+}
@@ -0,0 +1,79 @@
+package ocr
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/sjzar/chatlog/internal/chatlog/conf"
+	"github.com/sjzar/chatlog/pkg/util"
+)
+
+// Tesseract 调用 tesseract 命令行识别，中文需要安装 chi_sim 语言包
+type Tesseract struct {
+	bin      string
+	language string
+}
+
+func NewTesseract(c *conf.OCR) *Tesseract {
+	t := &Tesseract{
+		bin:      c.Bin,
+		language: c.Language,
+	}
+	if t.bin == "" {
+		t.bin = "tesseract"
+	}
+	if t.language == "" {
+		t.language = DefaultLanguage
+	}
+	return t
+}
+
+func (t *Tesseract) Name() string {
+	return ProviderTesseract
+}
+
+// Recognize 输出文件为 stdout 时结果输出到标准输出
+func (t *Tesseract) Recognize(ctx context.Context, image []byte, ext string) (string, error) {
+	switch ext {
+	case "jpg", "jpeg", "png", "bmp", "gif", "tiff":
+	default:
+		return "", fmt.Errorf("unsupported image format: %s", ext)
+	}
+
+	f, err := os.CreateTemp("", "chatlog-ocr-*."+ext)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(image); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+
+	cmd := exec.CommandContext(ctx, t.bin, f.Name(), "stdout", "-l", t.language)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s failed: %w: %s", t.bin, err, msg)
+		}
+		return "", fmt.Errorf("%s failed: %w", t.bin, err)
+	}
+
+	// 去掉空行与中文字符间多余的空格，保留换行
+	lines := make([]string, 0)
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, util.RemoveCJKSpaces(line))
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
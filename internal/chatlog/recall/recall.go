@@ -0,0 +1,162 @@
+package recall
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
+	"github.com/sjzar/chatlog/pkg/util"
+)
+
+const (
+	// StoreFile 快照与撤回记录的存储文件，位于工作目录下
+	StoreFile = "chatlog_recall.db"
+
+	// SnapshotWindow 只保留该时间范围内的消息快照，更早的消息不再跟踪
+	SnapshotWindow = 24 * time.Hour
+
+	// 撤回原因：revoked 原消息被撤回提示替换，deleted 原消息从数据库中消失
+	ReasonRevoked = "revoked"
+	ReasonDeleted = "deleted"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS snapshot (
+	file TEXT NOT NULL,
+	tbl TEXT NOT NULL,
+	talker TEXT NOT NULL,
+	local_id INTEGER NOT NULL,
+	server_id INTEGER,
+	local_type INTEGER,
+	sort_seq INTEGER,
+	sender TEXT,
+	create_time INTEGER,
+	status INTEGER,
+	message_content BLOB,
+	source BLOB,
+	packed_info_data BLOB,
+	PRIMARY KEY (file, tbl, local_id)
+);
+CREATE INDEX IF NOT EXISTS snapshot_time ON snapshot (file, create_time);
+CREATE TABLE IF NOT EXISTS recall (
+	file TEXT NOT NULL,
+	tbl TEXT NOT NULL,
+	talker TEXT NOT NULL,
+	local_id INTEGER NOT NULL,
+	server_id INTEGER,
+	local_type INTEGER,
+	sort_seq INTEGER,
+	sender TEXT,
+	create_time INTEGER,
+	status INTEGER,
+	message_content BLOB,
+	source BLOB,
+	packed_info_data BLOB,
+	reason TEXT,
+	notice TEXT,
+	detected_at INTEGER,
+	PRIMARY KEY (file, tbl, local_id)
+);
+CREATE INDEX IF NOT EXISTS recall_time ON recall (create_time);
+`
+
+// Recall 被撤回（或从数据库中消失）的消息，Message 为撤回前保存的原始内容
+type Recall struct {
+	Message    *model.Message `json:"message"`
+	Reason     string         `json:"reason"`
+	Notice     string         `json:"notice,omitempty"`
+	DetectedAt time.Time      `json:"detectedAt"`
+}
+
+// Tracker 在自动解密更新工作目录中的消息数据库时保存新消息快照，
+// 并对比前后两次的内容，找出被撤回或被删除的消息
+type Tracker struct {
+	db    *sql.DB
+	mutex sync.Mutex
+}
+
+// New 打开工作目录下的撤回记录存储
+func New(workDir string) (*Tracker, error) {
+	if workDir == "" {
+		return nil, errors.InvalidArg("work_dir")
+	}
+	if err := util.PrepareDir(workDir); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(workDir, StoreFile)
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, errors.DBConnectFailed(path, err)
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, errors.DBInitFailed(err)
+	}
+	return &Tracker{db: db}, nil
+}
+
+func (t *Tracker) Close() error {
+	return t.db.Close()
+}
+
+// List 查询时间范围内的撤回消息，按原消息时间排序，talker 多个以英文逗号分隔
+func (t *Tracker) List(start, end time.Time, talker string, limit, offset int) ([]*Recall, error) {
+	query := `SELECT talker, local_id, server_id, local_type, sort_seq, sender, create_time, status,
+		message_content, source, packed_info_data, reason, notice, detected_at
+		FROM recall WHERE create_time >= ? AND create_time <= ?`
+	args := []interface{}{start.Unix(), end.Unix()}
+	if talkers := util.Str2List(talker, ","); len(talkers) > 0 {
+		query += " AND talker IN (" + strings.TrimSuffix(strings.Repeat("?,", len(talkers)), ",") + ")"
+		for _, talker := range talkers {
+			args = append(args, talker)
+		}
+	}
+	query += " ORDER BY create_time ASC, local_id ASC"
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d OFFSET %d", limit, offset)
+	}
+
+	rows, err := t.db.Query(query, args...)
+	if err != nil {
+		return nil, errors.QueryFailed(query, err)
+	}
+	defer rows.Close()
+
+	recalls := make([]*Recall, 0)
+	for rows.Next() {
+		var row record
+		var notice sql.NullString
+		var detectedAt int64
+		r := &Recall{}
+		if err := rows.Scan(
+			&row.talker,
+			&row.LocalID,
+			&row.ServerID,
+			&row.LocalType,
+			&row.SortSeq,
+			&row.UserName,
+			&row.CreateTime,
+			&row.Status,
+			&row.MessageContent,
+			&row.Source,
+			&row.PackedInfoData,
+			&r.Reason,
+			&notice,
+			&detectedAt,
+		); err != nil {
+			return nil, errors.ScanRowFailed(err)
+		}
+		r.Message = row.Wrap(row.talker)
+		r.Notice = notice.String
+		r.DetectedAt = time.Unix(detectedAt, 0)
+		recalls = append(recalls, r)
+	}
+	return recalls, nil
+}
@@ -0,0 +1,270 @@
+package recall
+
+import (
+	"bytes"
+	"crypto/md5"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
+)
+
+var messageFileRegexp = regexp.MustCompile(`^message_([0-9]?[0-9])?\.db$`)
+
+// record 消息表中的一行，附带所在的表与会话
+type record struct {
+	model.MessageV4
+	talker string
+}
+
+func (r *record) isSystem() bool {
+	return r.LocalType&0xFFFFFFFF == model.MessageTypeSystem
+}
+
+// sameAs 快照中的消息与当前消息内容是否一致
+func (r *record) sameAs(o *record) bool {
+	return r.ServerID == o.ServerID && r.LocalType == o.LocalType && r.SortSeq == o.SortSeq &&
+		r.UserName == o.UserName && r.CreateTime == o.CreateTime && r.Status == o.Status &&
+		bytes.Equal(r.MessageContent, o.MessageContent) && bytes.Equal(r.Source, o.Source) &&
+		bytes.Equal(r.PackedInfoData, o.PackedInfoData)
+}
+
+// Track 在消息数据库更新后调用，path 为工作目录中解密后的消息数据库
+// 与上一次保存的快照对比，返回新发现的撤回消息，并更新快照
+func (t *Tracker) Track(path string) ([]*Recall, error) {
+	file := filepath.Base(path)
+	if !messageFileRegexp.MatchString(file) {
+		return nil, nil
+	}
+
+	src, err := sql.Open("sqlite3", "file:"+filepath.ToSlash(path)+"?mode=ro")
+	if err != nil {
+		return nil, errors.DBConnectFailed(path, err)
+	}
+	defer src.Close()
+
+	tables, err := loadTables(src)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mutex.Lock()
+	cutoff := time.Now().Add(-SnapshotWindow).Unix()
+	recalls := make([]*Recall, 0)
+	for table, talker := range tables {
+		found, err := t.trackTable(src, file, table, talker, cutoff)
+		if err != nil {
+			log.Debug().Err(err).Msgf("track recall failed: %s %s", file, table)
+			continue
+		}
+		recalls = append(recalls, found...)
+	}
+	if _, err := t.db.Exec(`DELETE FROM snapshot WHERE file = ? AND create_time < ?`, file, cutoff); err != nil {
+		log.Debug().Err(err).Msgf("prune snapshot failed: %s", file)
+	}
+	t.mutex.Unlock()
+
+	if len(recalls) > 0 {
+		log.Info().Msgf("detected %d recalled messages in %s", len(recalls), file)
+	}
+	return recalls, nil
+}
+
+// loadTables 获取消息表与会话的对应关系，表名为 Msg_md5(talker)
+func loadTables(db *sql.DB) (map[string]string, error) {
+	rows, err := db.Query(`SELECT user_name FROM Name2Id`)
+	if err != nil {
+		return nil, errors.QueryFailed("SELECT user_name FROM Name2Id", err)
+	}
+	names := make(map[string]string)
+	for rows.Next() {
+		var userName string
+		if err := rows.Scan(&userName); err != nil {
+			rows.Close()
+			return nil, errors.ScanRowFailed(err)
+		}
+		sum := md5.Sum([]byte(userName))
+		names["Msg_"+hex.EncodeToString(sum[:])] = userName
+	}
+	rows.Close()
+
+	rows, err = db.Query(`SELECT name FROM sqlite_master WHERE type='table' AND name LIKE 'Msg_%'`)
+	if err != nil {
+		return nil, errors.QueryFailed("sqlite_master", err)
+	}
+	defer rows.Close()
+	tables := make(map[string]string)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, errors.ScanRowFailed(err)
+		}
+		if talker, ok := names[name]; ok {
+			tables[name] = talker
+		}
+	}
+	return tables, nil
+}
+
+// trackTable 对比单个消息表的快照
+// 快照中的消息若被替换为撤回提示，或消失且出现了相同 server_id 的撤回提示，记为 revoked；
+// 若直接消失则记为 deleted。窗口内已没有任何消息时视为清空了聊天记录，不做对比
+func (t *Tracker) trackTable(src *sql.DB, file, table, talker string, cutoff int64) ([]*Recall, error) {
+	current, err := loadRecords(src, table, talker, cutoff)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := t.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	recalls := make([]*Recall, 0)
+	if len(current) == 0 {
+		if _, err := tx.Exec(`DELETE FROM snapshot WHERE file = ? AND tbl = ?`, file, table); err != nil {
+			return nil, err
+		}
+		return recalls, tx.Commit()
+	}
+
+	byLocalID := make(map[int64]*record, len(current))
+	notices := make(map[int64]*record)
+	for _, r := range current {
+		byLocalID[r.LocalID] = r
+		if r.ServerID != 0 && r.IsRevoke() {
+			notices[r.ServerID] = r
+		}
+	}
+
+	snapshots, err := loadSnapshots(tx, file, table, talker, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	saved := make(map[int64]*record, len(snapshots))
+	now := time.Now()
+	for _, snap := range snapshots {
+		saved[snap.LocalID] = snap
+		var notice *record
+		reason := ReasonDeleted
+		if cur, ok := byLocalID[snap.LocalID]; ok {
+			if !cur.IsRevoke() {
+				continue
+			}
+			notice, reason = cur, ReasonRevoked
+		} else if n, ok := notices[snap.ServerID]; ok && snap.ServerID != 0 {
+			notice, reason = n, ReasonRevoked
+		}
+
+		r := &Recall{Message: snap.Wrap(talker), Reason: reason, DetectedAt: now}
+		if notice != nil {
+			r.Notice = notice.Wrap(talker).Content
+		}
+		if err := saveRecall(tx, file, table, snap, r); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(`DELETE FROM snapshot WHERE file = ? AND tbl = ? AND local_id = ?`, file, table, snap.LocalID); err != nil {
+			return nil, err
+		}
+		recalls = append(recalls, r)
+	}
+
+	// 只写入新增或内容变化的消息
+	for _, r := range current {
+		if r.isSystem() {
+			continue
+		}
+		if snap, ok := saved[r.LocalID]; ok && snap.sameAs(r) {
+			continue
+		}
+		if _, err := tx.Exec(`INSERT INTO snapshot (file, tbl, talker, local_id, server_id, local_type, sort_seq, sender,
+			create_time, status, message_content, source, packed_info_data) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (file, tbl, local_id) DO UPDATE SET talker = excluded.talker, server_id = excluded.server_id,
+			local_type = excluded.local_type, sort_seq = excluded.sort_seq, sender = excluded.sender,
+			create_time = excluded.create_time, status = excluded.status, message_content = excluded.message_content,
+			source = excluded.source, packed_info_data = excluded.packed_info_data`,
+			file, table, talker, r.LocalID, r.ServerID, r.LocalType, r.SortSeq, r.UserName,
+			r.CreateTime, r.Status, r.MessageContent, r.Source, r.PackedInfoData); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return recalls, nil
+}
+
+func loadRecords(db *sql.DB, table, talker string, cutoff int64) ([]*record, error) {
+	query := fmt.Sprintf(`
+		SELECT m.local_id, m.server_id, m.local_type, m.sort_seq, n.user_name, m.create_time, m.status,
+			m.message_content, m.source, m.packed_info_data
+		FROM %s m
+		LEFT JOIN Name2Id n ON m.real_sender_id = n.rowid
+		WHERE m.create_time >= ?
+	`, table)
+	rows, err := db.Query(query, cutoff)
+	if err != nil {
+		if strings.Contains(err.Error(), "no such table") {
+			return nil, nil
+		}
+		return nil, errors.QueryFailed(query, err)
+	}
+	defer rows.Close()
+	return scanRecords(rows, talker)
+}
+
+func loadSnapshots(tx *sql.Tx, file, table, talker string, cutoff int64) ([]*record, error) {
+	rows, err := tx.Query(`SELECT local_id, server_id, local_type, sort_seq, sender, create_time, status,
+		message_content, source, packed_info_data
+		FROM snapshot WHERE file = ? AND tbl = ? AND create_time >= ?`, file, table, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanRecords(rows, talker)
+}
+
+func scanRecords(rows *sql.Rows, talker string) ([]*record, error) {
+	records := make([]*record, 0)
+	for rows.Next() {
+		r := &record{talker: talker}
+		var sender sql.NullString
+		if err := rows.Scan(
+			&r.LocalID,
+			&r.ServerID,
+			&r.LocalType,
+			&r.SortSeq,
+			&sender,
+			&r.CreateTime,
+			&r.Status,
+			&r.MessageContent,
+			&r.Source,
+			&r.PackedInfoData,
+		); err != nil {
+			return nil, errors.ScanRowFailed(err)
+		}
+		r.UserName = sender.String
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+func saveRecall(tx *sql.Tx, file, table string, snap *record, r *Recall) error {
+	_, err := tx.Exec(`INSERT OR IGNORE INTO recall (file, tbl, talker, local_id, server_id, local_type, sort_seq, sender,
+		create_time, status, message_content, source, packed_info_data, reason, notice, detected_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		file, table, snap.talker, snap.LocalID, snap.ServerID, snap.LocalType, snap.SortSeq, snap.UserName,
+		snap.CreateTime, snap.Status, snap.MessageContent, snap.Source, snap.PackedInfoData,
+		r.Reason, r.Notice, r.DetectedAt.Unix())
+	return err
+}
@@ -0,0 +1,43 @@
+package report
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io"
+	"time"
+)
+
+//go:embed report.html
+var htmlTemplate string
+
+var tmpl = template.Must(template.New("report").Funcs(template.FuncMap{
+	"money": func(v float64) string {
+		return fmt.Sprintf("%.2f", v)
+	},
+	"date": func(t time.Time) string {
+		return t.Format("2006-01-02")
+	},
+	"datetime": func(t time.Time) string {
+		return t.Format("2006-01-02 15:04")
+	},
+	// percent 返回 v 相对于 max 的百分比，用于绘制条形图
+	"percent": func(v, max int64) int64 {
+		if max <= 0 {
+			return 0
+		}
+		return v * 100 / max
+	},
+	"maxHour": func(hours [24]int64) int64 {
+		var m int64
+		for _, v := range hours {
+			m = max(m, v)
+		}
+		return m
+	},
+}).Parse(htmlTemplate))
+
+// WriteHTML 将报告渲染为独立的 HTML 页面
+func (r *Report) WriteHTML(w io.Writer) error {
+	return tmpl.Execute(w, r)
+}
@@ -0,0 +1,457 @@
+package report
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/model"
+	"github.com/sjzar/chatlog/internal/wechatdb"
+)
+
+const (
+	// DefaultTop 各排行榜默认保留的条目数
+	DefaultTop = 10
+
+	// 深夜时段为 [lateNightStart, lateNightEnd) 点
+	lateNightStart = 0
+	lateNightEnd   = 5
+)
+
+// 系统消息文本特征，用于识别新增好友与群聊进出
+var (
+	newContactRegexp = regexp.MustCompile(`你已添加了.+，现在可以开始聊天了|通过了你的朋友验证请求|以上是打招呼的内容|刚刚把你添加到通讯录`)
+	joinGroupRegexp  = regexp.MustCompile(`邀请你(和.+)?加入了群聊|你通过扫描.*加入群聊|你加入了群聊`)
+	leaveGroupRegexp = regexp.MustCompile(`你被.+移出群聊|你已被移出群聊|你已退出群聊`)
+)
+
+// 不参与统计的会话
+var ignoredTalkers = map[string]bool{
+	"filehelper":                true,
+	"weixin":                    true,
+	"notifymessage":             true,
+	"brandsessionholder":        true,
+	"brandservicesessionholder": true,
+}
+
+// Source 生成报告所需的数据接口，由 database.Service 实现
+type Source interface {
+	GetSessions(key string, limit, offset int) (*wechatdb.GetSessionsResp, error)
+	GetChatStats(start, end time.Time, talker string) (*model.ChatStats, error)
+	GetMessagesByType(start, end time.Time, talker string, types ...int64) ([]*model.Message, error)
+}
+
+// Report 账号维度的年度（或任意时间段）回顾报告
+type Report struct {
+	Title         string    `json:"title"`
+	Start         time.Time `json:"start"`
+	End           time.Time `json:"end"`
+	GeneratedAt   time.Time `json:"generatedAt"`
+	Total         int64     `json:"total"`
+	ActiveDays    int       `json:"activeDays"`
+	Conversations int       `json:"conversations"`
+
+	// TopContacts 消息最多的私聊，TopGroups 消息最多的群聊
+	TopContacts []*TalkerCount `json:"topContacts"`
+	TopGroups   []*TalkerCount `json:"topGroups"`
+
+	// BusiestDays 消息最多的日期
+	BusiestDays []*model.DateCount `json:"busiestDays"`
+
+	// ByHour 按小时统计的消息数（本地时区）
+	ByHour [24]int64 `json:"byHour"`
+
+	LateNight *LateNight `json:"lateNight"`
+
+	// Media 媒体消息数量，key 与 ChatStats.Media 一致
+	Media map[string]int64 `json:"media"`
+
+	TopDomains []*model.DomainCount `json:"topDomains"`
+	TopLinks   []*LinkCount         `json:"topLinks"`
+	FileCount  int64                `json:"fileCount"`
+	TopFiles   []*LinkCount         `json:"topFiles"`
+
+	Money *Money `json:"money"`
+
+	// 以下事件通过系统消息文本识别，仅覆盖常见提示语
+	NewContacts  []*Event `json:"newContacts"`
+	GroupsJoined []*Event `json:"groupsJoined"`
+	GroupsLeft   []*Event `json:"groupsLeft"`
+}
+
+type TalkerCount struct {
+	Talker     string `json:"talker"`
+	TalkerName string `json:"talkerName,omitempty"`
+	Count      int64  `json:"count"`
+}
+
+// Name 返回用于展示的名称
+func (t *TalkerCount) Name() string {
+	if t.TalkerName != "" {
+		return t.TalkerName
+	}
+	return t.Talker
+}
+
+// LateNight 深夜（0-5 点）活跃情况
+type LateNight struct {
+	StartHour int            `json:"startHour"`
+	EndHour   int            `json:"endHour"`
+	Count     int64          `json:"count"`
+	Top       []*TalkerCount `json:"top"`
+}
+
+type LinkCount struct {
+	Title string `json:"title"`
+	URL   string `json:"url,omitempty"`
+	Count int64  `json:"count"`
+}
+
+// Money 转账与红包汇总，金额单位为元
+// 转账按发起消息统计，收款、退还回执不重复计入金额
+type Money struct {
+	TransferOutCount  int64   `json:"transferOutCount"`
+	TransferOutAmount float64 `json:"transferOutAmount"`
+	TransferInCount   int64   `json:"transferInCount"`
+	TransferInAmount  float64 `json:"transferInAmount"`
+	RefundCount       int64   `json:"refundCount"`
+	RefundAmount      float64 `json:"refundAmount"`
+
+	// 红包消息不包含金额，仅统计个数
+	RedEnvelopeSent     int64 `json:"redEnvelopeSent"`
+	RedEnvelopeReceived int64 `json:"redEnvelopeReceived"`
+}
+
+// Event 由系统消息识别出的事件
+type Event struct {
+	Time       time.Time `json:"time"`
+	Talker     string    `json:"talker"`
+	TalkerName string    `json:"talkerName,omitempty"`
+	Content    string    `json:"content"`
+}
+
+// Name 返回用于展示的名称
+func (e *Event) Name() string {
+	if e.TalkerName != "" {
+		return e.TalkerName
+	}
+	return e.Talker
+}
+
+// Build 汇总时间范围内所有会话的数据生成报告
+func Build(src Source, start, end time.Time, top int) (*Report, error) {
+	if top <= 0 {
+		top = DefaultTop
+	}
+
+	sessions, err := src.GetSessions("", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	b := newBuilder()
+	for _, session := range sessions.Items {
+		if ignoreTalker(session.UserName) || session.NTime.Before(start) {
+			continue
+		}
+		b.collect(src, session, start, end)
+	}
+
+	return b.result(start, end, top), nil
+}
+
+func ignoreTalker(talker string) bool {
+	return talker == "" || ignoredTalkers[talker] || strings.HasPrefix(talker, "@") || strings.HasPrefix(talker, "gh_")
+}
+
+type builder struct {
+	total     int64
+	contacts  []*TalkerCount
+	groups    []*TalkerCount
+	lateNight []*TalkerCount
+	lateTotal int64
+	byDay     map[string]int64
+	byHour    [24]int64
+	media     map[string]int64
+	domains   map[string]int64
+	links     map[string]*LinkCount
+	files     map[string]*LinkCount
+	fileCount int64
+
+	self     string
+	payments []*model.Message
+	money    *Money
+
+	newContacts  []*Event
+	groupsJoined []*Event
+	groupsLeft   []*Event
+}
+
+func newBuilder() *builder {
+	return &builder{
+		contacts:     make([]*TalkerCount, 0),
+		groups:       make([]*TalkerCount, 0),
+		lateNight:    make([]*TalkerCount, 0),
+		byDay:        make(map[string]int64),
+		media:        make(map[string]int64),
+		domains:      make(map[string]int64),
+		links:        make(map[string]*LinkCount),
+		files:        make(map[string]*LinkCount),
+		money:        &Money{},
+		newContacts:  make([]*Event, 0),
+		groupsJoined: make([]*Event, 0),
+		groupsLeft:   make([]*Event, 0),
+	}
+}
+
+func (b *builder) collect(src Source, session *model.Session, start, end time.Time) {
+	talker := session.UserName
+	isChatRoom := strings.HasSuffix(talker, "@chatroom")
+
+	stats, err := src.GetChatStats(start, end, talker)
+	if err != nil {
+		log.Debug().Err(err).Msgf("get chat stats failed: %s", talker)
+		return
+	}
+	if stats.Total == 0 {
+		return
+	}
+
+	name := stats.TalkerName
+	if name == "" {
+		name = session.NickName
+	}
+
+	b.total += stats.Total
+	count := &TalkerCount{Talker: talker, TalkerName: name, Count: stats.Total}
+	if isChatRoom {
+		b.groups = append(b.groups, count)
+	} else {
+		b.contacts = append(b.contacts, count)
+	}
+
+	for _, day := range stats.ByDay {
+		b.byDay[day.Date] += day.Count
+	}
+	var late int64
+	for _, hours := range stats.ByHourOfWeek {
+		for hour, n := range hours {
+			b.byHour[hour] += n
+			if hour >= lateNightStart && hour < lateNightEnd {
+				late += n
+			}
+		}
+	}
+	if late > 0 {
+		b.lateTotal += late
+		b.lateNight = append(b.lateNight, &TalkerCount{Talker: talker, TalkerName: name, Count: late})
+	}
+	for key, n := range stats.Media {
+		b.media[key] += n
+	}
+	for _, domain := range stats.LinkDomains {
+		b.domains[domain.Domain] += domain.Count
+	}
+
+	messages, err := src.GetMessagesByType(start, end, talker, model.MessageTypeShare, model.MessageTypeSystem)
+	if err != nil {
+		log.Debug().Err(err).Msgf("get messages failed: %s", talker)
+		return
+	}
+	for _, msg := range messages {
+		if msg.TalkerName == "" {
+			msg.TalkerName = name
+		}
+		b.collectMessage(msg)
+	}
+}
+
+func (b *builder) collectMessage(msg *model.Message) {
+	if msg.IsSelf && b.self == "" {
+		b.self = msg.Sender
+	}
+
+	switch msg.Type {
+	case model.MessageTypeSystem:
+		event := &Event{Time: msg.Time, Talker: msg.Talker, TalkerName: msg.TalkerName, Content: msg.Content}
+		switch {
+		case !msg.IsChatRoom && newContactRegexp.MatchString(msg.Content):
+			b.newContacts = append(b.newContacts, event)
+		case msg.IsChatRoom && joinGroupRegexp.MatchString(msg.Content):
+			b.groupsJoined = append(b.groupsJoined, event)
+		case msg.IsChatRoom && leaveGroupRegexp.MatchString(msg.Content):
+			b.groupsLeft = append(b.groupsLeft, event)
+		}
+	case model.MessageTypeShare:
+		switch msg.SubType {
+		case model.MessageSubTypeLink, model.MessageSubTypeLink2:
+			url, _ := msg.Contents["url"].(string)
+			if url == "" {
+				break
+			}
+			title, _ := msg.Contents["title"].(string)
+			addLink(b.links, url, title, url)
+		case model.MessageSubTypeFile:
+			title, _ := msg.Contents["title"].(string)
+			if title == "" {
+				break
+			}
+			b.fileCount++
+			addLink(b.files, title, title, "")
+		case model.MessageSubTypePay:
+			// 收付方向依赖当前账号 ID，全部会话处理完后再统计
+			b.payments = append(b.payments, msg)
+		case model.MessageSubTypeRedEnvelope:
+			if msg.IsSelf {
+				b.money.RedEnvelopeSent++
+			} else {
+				b.money.RedEnvelopeReceived++
+			}
+		}
+	}
+}
+
+func addLink(m map[string]*LinkCount, key, title, url string) {
+	item, ok := m[key]
+	if !ok {
+		item = &LinkCount{Title: title, URL: url}
+		m[key] = item
+	}
+	if item.Title == "" {
+		item.Title = title
+	}
+	item.Count++
+}
+
+// collectPayments 统计转账，群聊中他人发起的转账仅在收款人为自己时计入
+func (b *builder) collectPayments() {
+	for _, msg := range b.payments {
+		subType, _ := msg.Contents["paysubtype"].(int)
+		amount, _ := msg.Contents["amount"].(float64)
+		switch subType {
+		case 1, 7:
+			if msg.IsSelf {
+				b.money.TransferOutCount++
+				b.money.TransferOutAmount += amount
+				continue
+			}
+			receiver, _ := msg.Contents["receiver"].(string)
+			if msg.IsChatRoom && (b.self == "" || receiver != b.self) {
+				continue
+			}
+			b.money.TransferInCount++
+			b.money.TransferInAmount += amount
+		case 4:
+			b.money.RefundCount++
+			b.money.RefundAmount += amount
+		}
+	}
+}
+
+func (b *builder) result(start, end time.Time, top int) *Report {
+	b.collectPayments()
+
+	r := &Report{
+		Title:         title(start, end),
+		Start:         start,
+		End:           end,
+		GeneratedAt:   time.Now(),
+		Total:         b.total,
+		ActiveDays:    len(b.byDay),
+		Conversations: len(b.contacts) + len(b.groups),
+		TopContacts:   topTalkers(b.contacts, top),
+		TopGroups:     topTalkers(b.groups, top),
+		ByHour:        b.byHour,
+		LateNight: &LateNight{
+			StartHour: lateNightStart,
+			EndHour:   lateNightEnd,
+			Count:     b.lateTotal,
+			Top:       topTalkers(b.lateNight, top),
+		},
+		Media:        b.media,
+		FileCount:    b.fileCount,
+		TopLinks:     topLinks(b.links, top),
+		TopFiles:     topLinks(b.files, top),
+		Money:        b.money,
+		NewContacts:  sortEvents(b.newContacts),
+		GroupsJoined: sortEvents(b.groupsJoined),
+		GroupsLeft:   sortEvents(b.groupsLeft),
+	}
+
+	r.BusiestDays = make([]*model.DateCount, 0, len(b.byDay))
+	for date, count := range b.byDay {
+		r.BusiestDays = append(r.BusiestDays, &model.DateCount{Date: date, Count: count})
+	}
+	sort.Slice(r.BusiestDays, func(i, j int) bool {
+		if r.BusiestDays[i].Count != r.BusiestDays[j].Count {
+			return r.BusiestDays[i].Count > r.BusiestDays[j].Count
+		}
+		return r.BusiestDays[i].Date < r.BusiestDays[j].Date
+	})
+	if len(r.BusiestDays) > top {
+		r.BusiestDays = r.BusiestDays[:top]
+	}
+
+	r.TopDomains = make([]*model.DomainCount, 0, len(b.domains))
+	for domain, count := range b.domains {
+		r.TopDomains = append(r.TopDomains, &model.DomainCount{Domain: domain, Count: count})
+	}
+	sort.Slice(r.TopDomains, func(i, j int) bool {
+		if r.TopDomains[i].Count != r.TopDomains[j].Count {
+			return r.TopDomains[i].Count > r.TopDomains[j].Count
+		}
+		return r.TopDomains[i].Domain < r.TopDomains[j].Domain
+	})
+	if len(r.TopDomains) > top {
+		r.TopDomains = r.TopDomains[:top]
+	}
+
+	return r
+}
+
+// title 整年的时间范围显示为 "2024 年度报告"，其余显示起止日期
+func title(start, end time.Time) string {
+	if start.Month() == time.January && start.Day() == 1 && end.Year() == start.Year() &&
+		end.Month() == time.December && end.Day() == 31 {
+		return start.Format("2006") + " 年度报告"
+	}
+	return start.Format("2006-01-02") + " ~ " + end.Format("2006-01-02") + " 聊天报告"
+}
+
+func topTalkers(items []*TalkerCount, top int) []*TalkerCount {
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Count != items[j].Count {
+			return items[i].Count > items[j].Count
+		}
+		return items[i].Talker < items[j].Talker
+	})
+	if len(items) > top {
+		items = items[:top]
+	}
+	return items
+}
+
+func topLinks(m map[string]*LinkCount, top int) []*LinkCount {
+	items := make([]*LinkCount, 0, len(m))
+	for _, item := range m {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Count != items[j].Count {
+			return items[i].Count > items[j].Count
+		}
+		return items[i].Title < items[j].Title
+	})
+	if len(items) > top {
+		items = items[:top]
+	}
+	return items
+}
+
+func sortEvents(events []*Event) []*Event {
+	sort.Slice(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+	return events
+}
@@ -0,0 +1,150 @@
+package transcribe
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/sjzar/chatlog/internal/chatlog/conf"
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/pkg/util"
+)
+
+// Whisper 调用 whisper.cpp 的命令行程序在 CPU 上转写
+type Whisper struct {
+	bin      string
+	model    string
+	language string
+	threads  int
+}
+
+func NewWhisper(c *conf.Transcribe) (*Whisper, error) {
+	if c.Model == "" {
+		return nil, errors.InvalidArg("transcribe.model")
+	}
+	w := &Whisper{
+		bin:      c.Bin,
+		model:    c.Model,
+		language: c.Language,
+		threads:  c.Threads,
+	}
+	if w.bin == "" {
+		w.bin = "whisper-cli"
+	}
+	if w.language == "" {
+		w.language = DefaultLanguage
+	}
+	return w, nil
+}
+
+func (w *Whisper) Name() string {
+	return ProviderWhisper
+}
+
+// Transcribe -nt 不输出时间戳，-np 只输出识别结果
+func (w *Whisper) Transcribe(ctx context.Context, wav []byte) (string, error) {
+	path, err := writeTempWAV(wav)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(path)
+
+	args := []string{"-m", w.model, "-f", path, "-l", w.language, "-nt", "-np"}
+	if w.threads > 0 {
+		args = append(args, "-t", strconv.Itoa(w.threads))
+	}
+	out, err := run(ctx, w.bin, args...)
+	if err != nil {
+		return "", err
+	}
+	return joinLines(out), nil
+}
+
+// Vosk 调用 vosk-transcriber 与本地模型目录转写
+type Vosk struct {
+	bin   string
+	model string
+}
+
+func NewVosk(c *conf.Transcribe) (*Vosk, error) {
+	if c.Model == "" {
+		return nil, errors.InvalidArg("transcribe.model")
+	}
+	v := &Vosk{
+		bin:   c.Bin,
+		model: c.Model,
+	}
+	if v.bin == "" {
+		v.bin = "vosk-transcriber"
+	}
+	return v, nil
+}
+
+func (v *Vosk) Name() string {
+	return ProviderVosk
+}
+
+// Transcribe 未指定输出文件时结果输出到标准输出，中文模型的结果以空格分词，需要去掉
+func (v *Vosk) Transcribe(ctx context.Context, wav []byte) (string, error) {
+	path, err := writeTempWAV(wav)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(path)
+
+	out, err := run(ctx, v.bin, "-m", v.model, "-i", path, "-t", "txt")
+	if err != nil {
+		return "", err
+	}
+	return util.RemoveCJKSpaces(joinLines(out)), nil
+}
+
+func run(ctx context.Context, bin string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, bin, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if len(msg) > 200 {
+			msg = msg[len(msg)-200:]
+		}
+		if msg != "" {
+			return "", fmt.Errorf("%s failed: %w: %s", bin, err, msg)
+		}
+		return "", fmt.Errorf("%s failed: %w", bin, err)
+	}
+	return stdout.String(), nil
+}
+
+func writeTempWAV(wav []byte) (string, error) {
+	f, err := os.CreateTemp("", "chatlog-voice-*.wav")
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(wav); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// joinLines 合并多行输出，去掉空行
+func joinLines(s string) string {
+	lines := make([]string, 0)
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, " ")
+}
@@ -0,0 +1,169 @@
+package transcribe
+
+import (
+	"database/sql"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/pkg/util"
+)
+
+// StoreFile 转写记录的存储文件，位于工作目录下
+const StoreFile = "chatlog_transcript.db"
+
+const schema = `
+CREATE TABLE IF NOT EXISTS transcript (
+	server_id TEXT PRIMARY KEY,
+	talker TEXT NOT NULL,
+	seq INTEGER,
+	sender TEXT,
+	create_time INTEGER,
+	text TEXT,
+	provider TEXT,
+	created_at INTEGER
+);
+CREATE INDEX IF NOT EXISTS transcript_talker ON transcript (talker, create_time);
+CREATE INDEX IF NOT EXISTS transcript_time ON transcript (create_time);
+`
+
+// Transcript 语音消息的转写结果，以语音的 svr_id 为键
+type Transcript struct {
+	ServerID  string    `json:"serverId"`
+	Talker    string    `json:"talker"`
+	Seq       int64     `json:"seq"`
+	Sender    string    `json:"sender"`
+	Time      time.Time `json:"time"`
+	Text      string    `json:"text"`
+	Provider  string    `json:"provider"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Store 转写记录存储，与微信数据库分开保存，重新解密数据库不会丢失
+type Store struct {
+	db *sql.DB
+}
+
+// NewStore 打开工作目录下的转写记录存储
+func NewStore(workDir string) (*Store, error) {
+	if workDir == "" {
+		return nil, errors.InvalidArg("work_dir")
+	}
+	if err := util.PrepareDir(workDir); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(workDir, StoreFile)
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, errors.DBConnectFailed(path, err)
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, errors.DBInitFailed(err)
+	}
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Put 保存转写结果，相同 svr_id 的记录会被覆盖
+func (s *Store) Put(tr *Transcript) error {
+	query := `INSERT OR REPLACE INTO transcript
+		(server_id, talker, seq, sender, create_time, text, provider, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	if _, err := s.db.Exec(query, tr.ServerID, tr.Talker, tr.Seq, tr.Sender,
+		tr.Time.Unix(), tr.Text, tr.Provider, tr.CreatedAt.Unix()); err != nil {
+		return errors.QueryFailed(query, err)
+	}
+	return nil
+}
+
+// Get 按 svr_id 批量读取转写结果
+func (s *Store) Get(ids ...string) (map[string]*Transcript, error) {
+	transcripts := make(map[string]*Transcript, len(ids))
+	for i := 0; i < len(ids); i += 500 {
+		batch := ids[i:min(i+500, len(ids))]
+		args := make([]interface{}, 0, len(batch))
+		for _, id := range batch {
+			args = append(args, id)
+		}
+		query := `SELECT server_id, talker, seq, sender, create_time, text, provider, created_at
+			FROM transcript WHERE server_id IN (` + strings.TrimSuffix(strings.Repeat("?,", len(batch)), ",") + `)`
+		list, err := s.query(query, args...)
+		if err != nil {
+			return nil, err
+		}
+		for _, tr := range list {
+			transcripts[tr.ServerID] = tr
+		}
+	}
+	return transcripts, nil
+}
+
+// Search 在时间范围内按正则表达式搜索转写文本，talkers 为空时搜索全部对话，结果按时间排序
+// 文本没有全文索引，按时间索引取出范围内的记录逐条匹配；关键词为普通文本时在 SQL 中过滤子串
+func (s *Store) Search(re *regexp.Regexp, talkers []string, start, end time.Time) ([]*Transcript, error) {
+	query := `SELECT server_id, talker, seq, sender, create_time, text, provider, created_at
+		FROM transcript WHERE create_time >= ? AND create_time <= ?`
+	args := []interface{}{start.Unix(), end.Unix()}
+	if re != nil {
+		if literal, complete := re.LiteralPrefix(); complete {
+			query += " AND instr(text, ?) > 0"
+			args = append(args, literal)
+			re = nil
+		}
+	}
+	if len(talkers) > 0 {
+		query += " AND talker IN (" + strings.TrimSuffix(strings.Repeat("?,", len(talkers)), ",") + ")"
+		for _, talker := range talkers {
+			args = append(args, talker)
+		}
+	}
+	query += " ORDER BY create_time ASC, seq ASC"
+
+	list, err := s.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	if re == nil {
+		return list, nil
+	}
+	matched := make([]*Transcript, 0)
+	for _, tr := range list {
+		if re.MatchString(tr.Text) {
+			matched = append(matched, tr)
+		}
+	}
+	return matched, nil
+}
+
+func (s *Store) query(query string, args ...interface{}) ([]*Transcript, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, errors.QueryFailed(query, err)
+	}
+	defer rows.Close()
+
+	list := make([]*Transcript, 0)
+	for rows.Next() {
+		var tr Transcript
+		var sender, text, provider sql.NullString
+		var createTime, createdAt sql.NullInt64
+		if err := rows.Scan(&tr.ServerID, &tr.Talker, &tr.Seq, &sender, &createTime, &text, &provider, &createdAt); err != nil {
+			return nil, errors.ScanRowFailed(err)
+		}
+		tr.Sender = sender.String
+		tr.Text = text.String
+		tr.Provider = provider.String
+		tr.Time = time.Unix(createTime.Int64, 0)
+		tr.CreatedAt = time.Unix(createdAt.Int64, 0)
+		list = append(list, &tr)
+	}
+	return list, rows.Err()
+}
@@ -0,0 +1,72 @@
+package transcribe
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestStoreSearch(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local)
+	for i, tr := range []*Transcript{
+		{ServerID: "1", Talker: "wxid_a", Seq: 1, Time: base, Text: "明天下午开会"},
+		{ServerID: "2", Talker: "wxid_a", Seq: 2, Time: base.Add(time.Hour), Text: "Meeting at 3pm"},
+		{ServerID: "3", Talker: "wxid_b", Seq: 3, Time: base.Add(2 * time.Hour), Text: "开会地点在三楼"},
+	} {
+		if err := store.Put(tr); err != nil {
+			t.Fatalf("put %d: %v", i, err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		keyword string
+		talkers []string
+		start   time.Time
+		end     time.Time
+		want    []string
+	}{
+		{name: "literal", keyword: "开会", want: []string{"1", "3"}},
+		{name: "regexp", keyword: "开会|Meeting", want: []string{"1", "2", "3"}},
+		{name: "case insensitive", keyword: "(?i)meeting", want: []string{"2"}},
+		{name: "talker", keyword: "开会", talkers: []string{"wxid_b"}, want: []string{"3"}},
+		{name: "time range", keyword: "开会", end: base.Add(time.Minute), want: []string{"1"}},
+		{name: "no keyword", want: []string{"1", "2", "3"}},
+		{name: "no match", keyword: "周末", want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var re *regexp.Regexp
+			if tt.keyword != "" {
+				re = regexp.MustCompile(tt.keyword)
+			}
+			start, end := tt.start, tt.end
+			if end.IsZero() {
+				end = base.Add(24 * time.Hour)
+			}
+			got, err := store.Search(re, tt.talkers, start, end)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids := make([]string, 0, len(got))
+			for _, tr := range got {
+				ids = append(ids, tr.ServerID)
+			}
+			if len(ids) != len(tt.want) {
+				t.Fatalf("Search(%q) = %v, want %v", tt.keyword, ids, tt.want)
+			}
+			for i := range ids {
+				if ids[i] != tt.want[i] {
+					t.Fatalf("Search(%q) = %v, want %v", tt.keyword, ids, tt.want)
+				}
+			}
+		})
+	}
+}
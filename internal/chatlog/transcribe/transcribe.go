@@ -0,0 +1,162 @@
+package transcribe
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sjzar/chatlog/internal/chatlog/conf"
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
+	"github.com/sjzar/chatlog/pkg/util/silk"
+)
+
+const (
+	ProviderWhisper = "whisper"
+	ProviderVosk    = "vosk"
+
+	// SampleRate 转写引擎的输入为 16 kHz 单声道 wav
+	SampleRate = 16000
+
+	DefaultLanguage = "zh"
+	DefaultTimeout  = 2 * time.Minute
+)
+
+// Provider 语音转写引擎，输入 16 kHz 单声道 wav，返回识别出的文本
+type Provider interface {
+	Name() string
+	Transcribe(ctx context.Context, wav []byte) (string, error)
+}
+
+// NewProvider 按配置创建转写引擎，未配置时返回 nil
+func NewProvider(c *conf.Transcribe) (Provider, error) {
+	if c == nil || c.Provider == "" {
+		return nil, nil
+	}
+	switch strings.ToLower(c.Provider) {
+	case ProviderWhisper:
+		return NewWhisper(c)
+	case ProviderVosk:
+		return NewVosk(c)
+	default:
+		return nil, errors.InvalidArg("transcribe.provider")
+	}
+}
+
+// Transcriber 将语音消息转写为文本并保存到工作目录下的转写记录中
+// 没有配置转写引擎时仍可读取已有的转写记录
+type Transcriber struct {
+	provider Provider
+	store    *Store
+	timeout  time.Duration
+
+	// 转写引擎占满 CPU，同一时间只转写一条语音
+	mutex sync.Mutex
+}
+
+// New 打开转写记录存储并按配置创建转写引擎
+func New(workDir string, c *conf.Transcribe) (*Transcriber, error) {
+	provider, err := NewProvider(c)
+	if err != nil {
+		return nil, err
+	}
+	store, err := NewStore(workDir)
+	if err != nil {
+		return nil, err
+	}
+	timeout := DefaultTimeout
+	if c != nil && c.TimeoutSec > 0 {
+		timeout = time.Duration(c.TimeoutSec) * time.Second
+	}
+	return &Transcriber{
+		provider: provider,
+		store:    store,
+		timeout:  timeout,
+	}, nil
+}
+
+// Enabled 是否配置了转写引擎
+func (t *Transcriber) Enabled() bool {
+	return t.provider != nil
+}
+
+func (t *Transcriber) Store() *Store {
+	return t.store
+}
+
+func (t *Transcriber) Close() error {
+	return t.store.Close()
+}
+
+// Transcribe 调用转写引擎转写语音消息并保存结果，data 为原始 silk 数据
+func (t *Transcriber) Transcribe(ctx context.Context, msg *model.Message, data []byte) (*Transcript, error) {
+	if msg.Type != model.MessageTypeVoice {
+		return nil, errors.ErrNotVoiceMessage
+	}
+	id, _ := msg.Contents["voice"].(string)
+	if id == "" {
+		return nil, errors.ErrMediaNotFound
+	}
+	if t.provider == nil {
+		return nil, errors.ErrTranscribeDisabled
+	}
+
+	wav, err := silk.Silk2WAV(data, SampleRate)
+	if err != nil {
+		return nil, errors.TranscribeFailed(t.provider.Name(), err)
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	text, err := t.provider.Transcribe(ctx, wav)
+	if err != nil {
+		return nil, errors.TranscribeFailed(t.provider.Name(), err)
+	}
+
+	tr := &Transcript{
+		ServerID:  id,
+		Talker:    msg.Talker,
+		Seq:       msg.Seq,
+		Sender:    msg.Sender,
+		Time:      msg.Time,
+		Text:      text,
+		Provider:  t.provider.Name(),
+		CreatedAt: time.Now(),
+	}
+	if err := t.store.Put(tr); err != nil {
+		return nil, err
+	}
+	return tr, nil
+}
+
+// Attach 将已有的转写文本写入语音消息的 Contents["transcript"]
+func (t *Transcriber) Attach(messages ...*model.Message) {
+	ids := make([]string, 0)
+	for _, msg := range messages {
+		if msg.Type != model.MessageTypeVoice {
+			continue
+		}
+		if id, _ := msg.Contents["voice"].(string); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+	transcripts, err := t.store.Get(ids...)
+	if err != nil || len(transcripts) == 0 {
+		return
+	}
+	for _, msg := range messages {
+		if msg.Type != model.MessageTypeVoice {
+			continue
+		}
+		id, _ := msg.Contents["voice"].(string)
+		if tr, ok := transcripts[id]; ok {
+			msg.SetContent("transcript", tr.Text)
+		}
+	}
+}
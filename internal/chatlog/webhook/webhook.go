@@ -5,12 +5,16 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/rs/zerolog/log"
 
 	"github.com/sjzar/chatlog/internal/chatlog/conf"
+	"github.com/sjzar/chatlog/internal/chatlog/recall"
+	"github.com/sjzar/chatlog/internal/model"
 	"github.com/sjzar/chatlog/internal/wechatdb"
 )
 
@@ -50,6 +54,8 @@ func New(config Config) *Service {
 				hooks["message"] = make([]*conf.WebhookItem, 0)
 			}
 			hooks["message"] = append(hooks["message"], item)
+		case "recall":
+			hooks["recall"] = append(hooks["recall"], item)
 		default:
 			log.Error().Msgf("unknown webhook type: %s", item.Type)
 		}
@@ -67,6 +73,10 @@ func (s *Service) GetHooks(ctx context.Context, db *wechatdb.DB) []*Group {
 
 	groups := make([]*Group, 0)
 	for group, items := range s.hooks {
+		// 撤回通知由 recall.Tracker 驱动，不监听文件变更
+		if group == "recall" {
+			continue
+		}
 		hooks := make([]Webhook, 0)
 		for _, item := range items {
 			hooks = append(hooks, NewMessageWebhook(item, db, s.config.Host))
@@ -77,6 +87,15 @@ func (s *Service) GetHooks(ctx context.Context, db *wechatdb.DB) []*Group {
 	return groups
 }
 
+// GetRecallHooks 返回撤回通知 webhook，db 用于解析过滤条件中的名称，可以为 nil
+func (s *Service) GetRecallHooks(db *wechatdb.DB) []*RecallWebhook {
+	hooks := make([]*RecallWebhook, 0)
+	for _, item := range s.hooks["recall"] {
+		hooks = append(hooks, NewRecallWebhook(item, db, s.config.Host))
+	}
+	return hooks
+}
+
 type Group struct {
 	ctx     context.Context
 	group   string
@@ -169,6 +188,12 @@ func (m *MessageWebhook) Do(event fsnotify.Event) {
 
 	m.lastTime = messages[len(messages)-1].Time.Add(time.Second)
 
+	if m.conf.Mention != "" {
+		if messages = m.filterMentions(messages); len(messages) == 0 {
+			return
+		}
+	}
+
 	for _, message := range messages {
 		message.SetContent("host", m.host)
 		message.Content = message.PlainTextContent()
@@ -178,6 +203,7 @@ func (m *MessageWebhook) Do(event fsnotify.Event) {
 		"talker":   m.conf.Talker,
 		"sender":   m.conf.Sender,
 		"keyword":  m.conf.Keyword,
+		"mention":  m.conf.Mention,
 		"lastTime": m.lastTime.Format(time.DateTime),
 		"length":   len(messages),
 		"messages": messages,
@@ -197,3 +223,131 @@ func (m *MessageWebhook) Do(event fsnotify.Event) {
 		log.Error().Msgf("post messages failed, status code: %d", resp.StatusCode)
 	}
 }
+
+// filterMentions 保留 @ 了指定用户的消息
+func (m *MessageWebhook) filterMentions(messages []*model.Message) []*model.Message {
+	user := m.conf.Mention
+	if user == "me" {
+		self, err := m.db.GetSelfUserName()
+		if err != nil {
+			log.Error().Err(err).Msg("get self user name failed")
+			return nil
+		}
+		user = self
+	} else if contact, _ := m.db.GetContact(user); contact != nil {
+		user = contact.UserName
+	}
+
+	ret := make([]*model.Message, 0, len(messages))
+	for _, message := range messages {
+		if message.IsChatRoom && message.Sender != user && message.Mentioned(user) {
+			ret = append(ret, message)
+		}
+	}
+	return ret
+}
+
+// RecallWebhook 检测到撤回消息时推送撤回前的原始内容，talker/sender/keyword 用于过滤
+type RecallWebhook struct {
+	host   string
+	conf   *conf.WebhookItem
+	client *http.Client
+	db     *wechatdb.DB
+}
+
+func NewRecallWebhook(conf *conf.WebhookItem, db *wechatdb.DB, host string) *RecallWebhook {
+	return &RecallWebhook{
+		host:   host,
+		conf:   conf,
+		client: &http.Client{Timeout: time.Second * 10},
+		db:     db,
+	}
+}
+
+func (r *RecallWebhook) Do(recalls []*recall.Recall) {
+	f, err := r.filter()
+	if err != nil {
+		log.Error().Err(err).Msgf("invalid recall webhook keyword: %s", r.conf.Keyword)
+		return
+	}
+
+	items := make([]*recall.Recall, 0, len(recalls))
+	for _, item := range recalls {
+		if f.match(item.Message) {
+			item.Message.SetContent("host", r.host)
+			item.Message.Content = item.Message.PlainTextContent()
+			items = append(items, item)
+		}
+	}
+	if len(items) == 0 {
+		return
+	}
+
+	ret := map[string]any{
+		"type":    "recall",
+		"talker":  r.conf.Talker,
+		"sender":  r.conf.Sender,
+		"keyword": r.conf.Keyword,
+		"length":  len(items),
+		"recalls": items,
+	}
+	body, _ := json.Marshal(ret)
+	req, _ := http.NewRequest("POST", r.conf.URL, bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	log.Info().Msgf("post recalls to %s, body: %s", r.conf.URL, string(body))
+	resp, err := r.client.Do(req)
+	if err != nil {
+		log.Error().Err(err).Msgf("post recalls failed")
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		log.Error().Msgf("post recalls failed, status code: %d", resp.StatusCode)
+	}
+}
+
+// recallFilter 撤回通知的过滤条件，与消息 webhook 一致：talker/sender 支持名称，keyword 为正则表达式
+type recallFilter struct {
+	talker  string
+	sender  string
+	keyword *regexp.Regexp
+}
+
+func (r *RecallWebhook) filter() (*recallFilter, error) {
+	f := &recallFilter{talker: r.conf.Talker, sender: r.conf.Sender}
+	if r.db != nil {
+		f.talker, f.sender = r.db.ResolveTalkerAndSender(f.talker, f.sender)
+	}
+	if r.conf.Keyword != "" {
+		regex, err := regexp.Compile(r.conf.Keyword)
+		if err != nil {
+			return nil, err
+		}
+		f.keyword = regex
+	}
+	return f, nil
+}
+
+func (f *recallFilter) match(msg *model.Message) bool {
+	if f.talker != "" && !contains(f.talker, msg.Talker) {
+		return false
+	}
+	if f.sender != "" && !contains(f.sender, msg.Sender) {
+		return false
+	}
+	if f.keyword != nil && !f.keyword.MatchString(msg.PlainTextContent()) {
+		return false
+	}
+	return true
+}
+
+func contains(list, item string) bool {
+	for _, v := range strings.Split(list, ",") {
+		if strings.TrimSpace(v) == item {
+			return true
+		}
+	}
+	return false
+}
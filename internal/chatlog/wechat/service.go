@@ -38,6 +38,9 @@ type Service struct {
 	mutex          sync.Mutex
 	fm             *filemonitor.FileMonitor
 	errorHandler   func(error)
+
+	// 解密完成回调，参数为工作目录中更新后的数据库文件
+	decryptedHandlers []func(output string)
 }
 
 type pendingEvent struct {
@@ -81,6 +84,22 @@ func (s *Service) SetAutoDecryptErrorHandler(handler func(error)) {
 	s.errorHandler = handler
 }
 
+// AddDecryptedHandler registers a callback invoked after a database file in the work dir is updated
+func (s *Service) AddDecryptedHandler(handler func(output string)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.decryptedHandlers = append(s.decryptedHandlers, handler)
+}
+
+func (s *Service) notifyDecrypted(output string) {
+	s.mutex.Lock()
+	handlers := s.decryptedHandlers
+	s.mutex.Unlock()
+	for _, handler := range handlers {
+		handler(output)
+	}
+}
+
 // GetWeChatInstances returns all running WeChat instances
 func (s *Service) GetWeChatInstances() []*wechat.Account {
 	instances, _ := s.GetWeChatInstancesWithError()
@@ -123,7 +142,7 @@ func (s *Service) StartAutoDecrypt() error {
 	if s.conf.GetWalEnabled() {
 		pattern = `.*\.db(-wal|-shm)?$`
 	}
-	dbGroup, err := filemonitor.NewFileGroup("wechat", s.conf.GetDataDir(), pattern, []string{"fts"})
+	dbGroup, err := filemonitor.NewFileGroup("wechat", s.conf.GetDataDir(), pattern, []string{})
 	if err != nil {
 		return err
 	}
@@ -277,7 +296,7 @@ func (s *Service) waitAndProcess(dbFile string) {
 	}
 }
 
-func (s *Service) DecryptDBFile(dbFile string) error {
+func (s *Service) DecryptDBFile(dbFile string) (err error) {
 
 	decryptor, err := decrypt.NewDecryptor(s.conf.GetPlatform(), s.conf.GetVersion())
 	if err != nil {
@@ -298,6 +317,12 @@ func (s *Service) DecryptDBFile(dbFile string) error {
 	if err != nil {
 		return fmt.Errorf("failed to create output file: %v", err)
 	}
+	// 在临时文件重命名之后执行
+	defer func() {
+		if err == nil {
+			s.notifyDecrypted(output)
+		}
+	}()
 	defer func() {
 		outputFile.Close()
 		if err := os.Rename(outputTemp, output); err != nil {
@@ -415,7 +440,7 @@ func isWalFile(path string) bool {
 }
 
 func (s *Service) DecryptDBFiles() error {
-	dbGroup, err := filemonitor.NewFileGroup("wechat", s.conf.GetDataDir(), `.*\.db$`, []string{"fts"})
+	dbGroup, err := filemonitor.NewFileGroup("wechat", s.conf.GetDataDir(), `.*\.db$`, []string{})
 	if err != nil {
 		return err
 	}
@@ -617,7 +642,7 @@ func (s *Service) IncrementalDecryptDBFile(dbFile string) (bool, error) {
 	s.removeWalFiles(output)
 
 	if applied {
-		return true, nil
+		s.notifyDecrypted(output)
 	}
 	return true, nil
 }
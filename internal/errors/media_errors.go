@@ -0,0 +1,24 @@
+package errors
+
+import "net/http"
+
+var (
+	ErrTranscribeDisabled = New(nil, http.StatusServiceUnavailable, "transcribe provider not configured").WithStack()
+	ErrNotVoiceMessage    = New(nil, http.StatusBadRequest, "not a voice message").WithStack()
+	ErrOCRDisabled        = New(nil, http.StatusServiceUnavailable, "ocr provider not configured").WithStack()
+	ErrNotImageMessage    = New(nil, http.StatusBadRequest, "not an image message").WithStack()
+	ErrOCRJobRunning      = New(nil, http.StatusConflict, "ocr job is running").WithStack()
+	ErrMediaStoreDisabled = New(nil, http.StatusServiceUnavailable, "media store not enabled").WithStack()
+)
+
+func TranscribeFailed(provider string, cause error) *Error {
+	return Newf(cause, http.StatusInternalServerError, "transcribe failed: %s", provider).WithStack()
+}
+
+func OCRFailed(provider string, cause error) *Error {
+	return Newf(cause, http.StatusInternalServerError, "ocr failed: %s", provider).WithStack()
+}
+
+func ConvertVoiceFailed(format string, cause error) *Error {
+	return Newf(cause, http.StatusInternalServerError, "convert voice failed: %s", format).WithStack()
+}
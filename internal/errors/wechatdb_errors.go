@@ -10,6 +10,7 @@ var (
 	ErrKeyEmpty        = New(nil, http.StatusBadRequest, "key empty").WithStack()
 	ErrMediaNotFound   = New(nil, http.StatusNotFound, "media not found").WithStack()
 	ErrMessageNotFound = New(nil, http.StatusNotFound, "message not found").WithStack()
+	ErrSNSNotFound     = New(nil, http.StatusNotFound, "sns post not found").WithStack()
 	ErrKeyLengthMust32 = New(nil, http.StatusBadRequest, "key length must be 32 bytes").WithStack()
 )
 
@@ -4,6 +4,7 @@ import (
 	"encoding/xml"
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -11,6 +12,7 @@ type MediaMsg struct {
 	XMLName  xml.Name `xml:"msg"`
 	Image    Image    `xml:"img,omitempty"`
 	Video    Video    `xml:"videomsg,omitempty"`
+	Voice    Voice    `xml:"voicemsg,omitempty"`
 	App      App      `xml:"appmsg,omitempty"`
 	Emoji    Emoji    `xml:"emoji,omitempty"`
 	Location Location `xml:"location,omitempty"`
@@ -36,11 +38,20 @@ type Image struct {
 	// CdnThumbAesKey      string `xml:"cdnthumbaeskey,attr"`
 }
 
+type Voice struct {
+	VoiceLength string `xml:"voicelength,attr"` // 时长，单位毫秒
+	// VoiceFormat  string `xml:"voiceformat,attr"`
+	// Length       string `xml:"length,attr"`
+	// BufID        string `xml:"bufid,attr"`
+	// ClientMsgID  string `xml:"clientmsgid,attr"`
+	// FromUserName string `xml:"fromusername,attr"`
+}
+
 type Video struct {
-	Md5    string `xml:"md5,attr"`
-	RawMd5 string `xml:"rawmd5,attr"`
-	// Length            string `xml:"length,attr"`
-	// PlayLength        string `xml:"playlength,attr"`
+	Md5        string `xml:"md5,attr"`
+	RawMd5     string `xml:"rawmd5,attr"`
+	Length     string `xml:"length,attr"`
+	PlayLength string `xml:"playlength,attr"`
 	// Offset            string `xml:"offset,attr"`
 	// FromUserName      string `xml:"fromusername,attr"`
 	// Status            string `xml:"status,attr"`
@@ -74,7 +85,7 @@ type App struct {
 	PatMsg            *PatMsg     `xml:"patMsg,omitempty"`            // type 62 拍一拍
 	PatInfo           *PatInfo    `xml:"patinfo,omitempty"`           // type 62 拍一拍 v2
 	FinderLive        *FinderLive `xml:"finderLive,omitempty"`        // type 63 视频号直播
-	WCPayInfo         *WCPayInfo  `xml:"wcpayinfo,omitempty"`         // type 2000 微信转账
+	WCPayInfo         *WCPayInfo  `xml:"wcpayinfo,omitempty"`         // type 2000 微信转账, type 2001 红包
 }
 
 type Emoji struct {
@@ -348,6 +359,41 @@ type WCPayInfo struct {
 	PayMemo           string `xml:"pay_memo"`          // 支付备注
 	ReceiverUsername  string `xml:"receiver_username"` // 接收方用户名
 	PayerUsername     string `xml:"payer_username"`    // 支付方用户名
+
+	// 以下为红包字段
+	ReceiverTitle string `xml:"receivertitle"` // 红包祝福语
+	SenderTitle   string `xml:"sendertitle"`   // 发送方看到的祝福语
+	SceneID       string `xml:"sceneid"`       // 红包场景，如 1002 普通红包、1005 专属红包
+	PayMsgID      string `xml:"paymsgid"`      // 红包 ID，与领取提示中的 sendid 相同
+	NativeURL     string `xml:"nativeurl"`     // 红包详情链接，包含 sendid
+}
+
+// Amount 解析金额描述，返回以元为单位的金额，无法解析时返回 0
+func (w *WCPayInfo) Amount() float64 {
+	s := strings.Map(func(r rune) rune {
+		if (r >= '0' && r <= '9') || r == '.' {
+			return r
+		}
+		return -1
+	}, w.FeeDesc)
+	amount, _ := strconv.ParseFloat(s, 64)
+	return amount
+}
+
+// Currency 返回金额描述中的币种，目前只识别人民币，无法识别时返回空字符串
+func (w *WCPayInfo) Currency() string {
+	if strings.ContainsAny(w.FeeDesc, "¥￥") || strings.Contains(w.FeeDesc, "元") {
+		return "CNY"
+	}
+	return ""
+}
+
+// SendID 返回红包 ID，优先使用 paymsgid，否则从详情链接中解析
+func (w *WCPayInfo) SendID() string {
+	if w.PayMsgID != "" {
+		return w.PayMsgID
+	}
+	return sendID(w.NativeURL)
 }
 
 // FinderFeed 视频号信息
@@ -588,3 +634,21 @@ func (s *SysMsg) SysMsgTemplateString() string {
 
 	return result
 }
+
+var (
+	sendIDRegexp = regexp.MustCompile(`sendid=(\d+)`)
+	tagRegexp    = regexp.MustCompile(`<[^>]*>`)
+)
+
+// sendID 从红包链接或领取提示中解析红包 ID
+func sendID(s string) string {
+	if match := sendIDRegexp.FindStringSubmatch(s); len(match) == 2 {
+		return match[1]
+	}
+	return ""
+}
+
+// stripTags 去除文本中的标签
+func stripTags(s string) string {
+	return tagRegexp.ReplaceAllString(s, "")
+}
@@ -0,0 +1,27 @@
+package model
+
+import "testing"
+
+func TestWCPayInfoAmount(t *testing.T) {
+	tests := []struct {
+		name    string
+		feeDesc string
+		want    float64
+	}{
+		{name: "fullwidth yuan sign", feeDesc: "￥200.00", want: 200},
+		{name: "halfwidth yuan sign", feeDesc: "¥0.01", want: 0.01},
+		{name: "thousands separator", feeDesc: "￥1,234.56", want: 1234.56},
+		{name: "yuan suffix", feeDesc: "88.8元", want: 88.8},
+		{name: "empty", feeDesc: "", want: 0},
+		{name: "no digits", feeDesc: "转账", want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &WCPayInfo{FeeDesc: tt.feeDesc}
+			if got := w.Amount(); got != tt.want {
+				t.Errorf("Amount(%q) = %v, want %v", tt.feeDesc, got, tt.want)
+			}
+		})
+	}
+}
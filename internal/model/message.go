@@ -3,6 +3,7 @@ package model
 import (
 	"encoding/xml"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
@@ -104,20 +105,24 @@ const (
 )
 
 type Message struct {
-	Version    string                 `json:"-"`                  // 消息版本，内部判断
-	Seq        int64                  `json:"seq"`                // 唯一序列号 (timestamp * 1000000 + local_id)
-	ID         int64                  `json:"id"`                 // 冗余 ID 字段，确保某些客户端能正确解析
-	Time       time.Time              `json:"time"`               // 消息创建时间，10位时间戳
-	Talker     string                 `json:"talker"`             // 聊天对象，微信 ID or 群 ID
-	TalkerName string                 `json:"talkerName"`         // 聊天对象名称
-	IsChatRoom bool                   `json:"isChatRoom"`         // 是否为群聊消息
-	Sender     string                 `json:"sender"`             // 发送人，微信 ID
-	SenderName string                 `json:"senderName"`         // 发送人名称
-	IsSelf     bool                   `json:"isSelf"`             // 是否为自己发送的消息
-	Type       int64                  `json:"type"`               // 消息类型
-	SubType    int64                  `json:"subType"`            // 消息子类型
-	Content    string                 `json:"content"`            // 消息内容，文字聊天内容
-	Contents   map[string]interface{} `json:"contents,omitempty"` // 消息内容，多媒体消息，采用更灵活的记录方式
+	Version    string                 `json:"-"`                    // 消息版本，内部判断
+	Seq        int64                  `json:"seq"`                  // 唯一序列号 (timestamp * 1000000 + local_id)
+	ID         int64                  `json:"id"`                   // 冗余 ID 字段，确保某些客户端能正确解析
+	Time       time.Time              `json:"time"`                 // 消息创建时间，10位时间戳
+	Talker     string                 `json:"talker"`               // 聊天对象，微信 ID or 群 ID
+	TalkerName string                 `json:"talkerName"`           // 聊天对象名称
+	IsChatRoom bool                   `json:"isChatRoom"`           // 是否为群聊消息
+	Sender     string                 `json:"sender"`               // 发送人，微信 ID
+	SenderName string                 `json:"senderName"`           // 发送人名称
+	IsSelf     bool                   `json:"isSelf"`               // 是否为自己发送的消息
+	Type       int64                  `json:"type"`                 // 消息类型
+	SubType    int64                  `json:"subType"`              // 消息子类型
+	Content    string                 `json:"content"`              // 消息内容，文字聊天内容
+	Contents   map[string]interface{} `json:"contents,omitempty"`   // 消息内容，多媒体消息，采用更灵活的记录方式
+	Mentions   []string               `json:"mentions,omitempty"`   // 消息中 @ 的用户 ID
+	MentionAll bool                   `json:"mentionAll,omitempty"` // 是否 @所有人
+	ServerID   int64                  `json:"serverId,omitempty"`   // 服务端消息 ID，引用消息通过它关联原消息
+	Thread     *Thread                `json:"thread,omitempty"`     // 引用回复关系，需调用 LinkThreads 等方法补充
 
 	// Debug Info
 	MediaMsg *MediaMsg `json:"mediaMsg,omitempty"` // 原始多媒体消息，XML 格式
@@ -136,6 +141,14 @@ func (m *Message) ParseMediaInfo(data string) error {
 	if m.Type == MessageTypeSystem {
 		m.Sender = "系统消息"
 		m.SenderName = ""
+		// 红包领取提示为带链接标签的文本，保留文字并记录红包 ID
+		if strings.Contains(data, "<_wc_custom_link_") {
+			m.Content = strings.TrimSpace(stripTags(data))
+			if id := sendID(data); id != "" {
+				m.SetContent("sendid", id)
+			}
+			return nil
+		}
 		var sysMsg SysMsg
 		if err := xml.Unmarshal([]byte(data), &sysMsg); err != nil {
 			m.Content = data
@@ -172,8 +185,23 @@ func (m *Message) ParseMediaInfo(data string) error {
 		if msg.Video.RawMd5 != "" {
 			m.Contents["rawmd5"] = msg.Video.RawMd5
 		}
+		// 视频时长，单位秒，以及文件大小，单位字节
+		if length, err := strconv.ParseInt(msg.Video.PlayLength, 10, 64); err == nil && length > 0 {
+			m.Contents["playlength"] = length
+		}
+		if length, err := strconv.ParseInt(msg.Video.Length, 10, 64); err == nil && length > 0 {
+			m.Contents["length"] = length
+		}
+	case MessageTypeVoice:
+		// 语音时长，单位毫秒
+		if length, err := strconv.ParseInt(msg.Voice.VoiceLength, 10, 64); err == nil && length > 0 {
+			m.Contents["voicelength"] = length
+		}
 	case MessageTypeAnimation:
 		m.Contents["cdnurl"] = msg.Emoji.CdnURL
+		if msg.Emoji.Md5 != "" {
+			m.Contents["md5"] = strings.ToLower(msg.Emoji.Md5)
+		}
 	case MessageTypeLocation:
 		m.Contents["x"] = msg.Location.X
 		m.Contents["y"] = msg.Location.Y
@@ -191,6 +219,12 @@ func (m *Message) ParseMediaInfo(data string) error {
 			// 文件
 			m.Contents["title"] = msg.App.Title
 			m.Contents["md5"] = msg.App.MD5
+			if msg.App.AppAttach != nil {
+				if size, err := strconv.ParseInt(msg.App.AppAttach.TotalLen, 10, 64); err == nil {
+					m.Contents["size"] = size
+				}
+				m.Contents["ext"] = msg.App.AppAttach.FileExt
+			}
 		case MessageSubTypeMergeForward, MessageSubTypeNote, MessageSubTypeChatRoomNotice:
 			// 合并转发 & 笔记
 			m.Contents["title"] = msg.App.Title
@@ -207,6 +241,7 @@ func (m *Message) ParseMediaInfo(data string) error {
 		case MessageSubTypeMiniProgram, MessageSubTypeMiniProgram2:
 			// 小程序
 			m.Contents["title"] = msg.App.SourceDisplayName
+			m.Contents["desc"] = msg.App.Title
 			m.Contents["url"] = msg.App.URL
 		case MessageSubTypeChannel:
 			// 视频号
@@ -232,6 +267,7 @@ func (m *Message) ParseMediaInfo(data string) error {
 			if subMsg.Sender == "" {
 				subMsg.Sender = msg.App.ReferMsg.FromUsr
 			}
+			subMsg.ServerID, _ = strconv.ParseInt(msg.App.ReferMsg.SvrID, 10, 64)
 			if err := subMsg.ParseMediaInfo(msg.App.ReferMsg.Content); err != nil {
 				break
 			}
@@ -242,10 +278,12 @@ func (m *Message) ParseMediaInfo(data string) error {
 				if len(msg.App.PatMsg.Records.Record) != 0 {
 					m.Sender = msg.App.PatMsg.Records.Record[0].FromUser
 					m.Content = msg.App.PatMsg.Records.Record[0].Templete
+					m.Contents["patted"] = msg.App.PatMsg.Records.Record[0].PattedUser
 				}
 			}
 			if msg.App.PatInfo != nil {
 				m.Content = msg.App.Title
+				m.Contents["patted"] = msg.App.PatInfo.PattedUsername
 			}
 		case MessageSubTypeChannelLive:
 			// 视频号直播
@@ -282,6 +320,26 @@ func (m *Message) ParseMediaInfo(data string) error {
 				payMemo = "(" + msg.App.WCPayInfo.PayMemo + ")"
 			}
 			m.Content = fmt.Sprintf("[转账|%s%s]%s", _type, msg.App.WCPayInfo.FeeDesc, payMemo)
+			m.Contents["paysubtype"] = msg.App.WCPayInfo.PaySubType
+			m.Contents["feedesc"] = msg.App.WCPayInfo.FeeDesc
+			m.Contents["amount"] = msg.App.WCPayInfo.Amount()
+			m.Contents["memo"] = msg.App.WCPayInfo.PayMemo
+			m.Contents["transferid"] = msg.App.WCPayInfo.TransferID
+			m.Contents["transcationid"] = msg.App.WCPayInfo.TranscationID
+			m.Contents["currency"] = msg.App.WCPayInfo.Currency()
+			m.Contents["payer"] = msg.App.WCPayInfo.PayerUsername
+			m.Contents["receiver"] = msg.App.WCPayInfo.ReceiverUsername
+		case MessageSubTypeRedEnvelope:
+			// 红包，金额不随消息下发
+			m.Contents["title"] = msg.App.Title
+			if msg.App.WCPayInfo == nil {
+				break
+			}
+			if msg.App.WCPayInfo.ReceiverTitle != "" {
+				m.Contents["title"] = msg.App.WCPayInfo.ReceiverTitle
+			}
+			m.Contents["sendid"] = msg.App.WCPayInfo.SendID()
+			m.Contents["sceneid"] = msg.App.WCPayInfo.SceneID
 		}
 	}
 
@@ -348,8 +406,13 @@ func (m *Message) PlainTextContent() string {
 	case MessageTypeText:
 		return m.Content
 	case MessageTypeImage:
+		// 有识别文本时附在后面，便于搜索与阅读
+		ocr := ""
+		if text, _ := m.Contents["ocr"].(string); text != "" {
+			ocr = " " + strings.Join(strings.Fields(text), " ")
+		}
 		if host, _ := m.Contents["host"].(string); host == "" {
-			return "[图片]"
+			return "[图片]" + ocr
 		}
 		keylist := make([]string, 0)
 		if m.Contents["md5"] != nil {
@@ -367,15 +430,19 @@ func (m *Message) PlainTextContent() string {
 				keylist = append(keylist, thumbpath)
 			}
 		}
-		return fmt.Sprintf("![图片](http://%s/image/%s)", m.Contents["host"], strings.Join(keylist, ","))
+		return fmt.Sprintf("![图片](http://%s/image/%s)", m.Contents["host"], strings.Join(keylist, ",")) + ocr
 	case MessageTypeVoice:
-		if host, _ := m.Contents["host"].(string); host == "" {
-			return "[语音]"
+		text := "[语音]"
+		if host, _ := m.Contents["host"].(string); host != "" {
+			if voice, ok := m.Contents["voice"]; ok {
+				text = fmt.Sprintf("[语音](http://%s/voice/%s)", host, voice)
+			}
 		}
-		if voice, ok := m.Contents["voice"]; ok {
-			return fmt.Sprintf("[语音](http://%s/voice/%s)", m.Contents["host"], voice)
+		// 有转写文本时附在后面，便于搜索与阅读
+		if transcript, _ := m.Contents["transcript"].(string); transcript != "" {
+			text += " " + transcript
 		}
-		return "[语音]"
+		return text
 	case MessageTypeCard:
 		return "[名片]"
 	case MessageTypeVideo:
@@ -400,6 +467,12 @@ func (m *Message) PlainTextContent() string {
 		}
 		return fmt.Sprintf("![视频](http://%s/video/%s)", m.Contents["host"], strings.Join(keylist, ","))
 	case MessageTypeAnimation:
+		// 有本地服务时使用本地缓存的表情，CDN 链接过期后仍然可以显示
+		if host, _ := m.Contents["host"].(string); host != "" {
+			if md5, _ := m.Contents["md5"].(string); md5 != "" {
+				return fmt.Sprintf("![动画表情](http://%s/emoji/%s)", host, md5)
+			}
+		}
 		if m.Contents["cdnurl"] != nil {
 			if cdnURL, ok := m.Contents["cdnurl"].(string); ok {
 				return fmt.Sprintf("![动画表情](%s)", cdnURL)
@@ -41,6 +41,7 @@ type MessageV4 struct {
 	UserName       string `json:"user_name"`        // 发送人，通过 Join Name2Id 表获得
 	CreateTime     int64  `json:"create_time"`      // 消息创建时间，10位时间戳
 	MessageContent []byte `json:"message_content"`  // 消息内容，文字聊天内容 或 zstd 压缩内容
+	Source         []byte `json:"source"`           // 消息附加信息 msgsource，XML 或 zstd 压缩内容
 	PackedInfoData []byte `json:"packed_info_data"` // 额外数据，类似 proto，格式与 v3 有差异
 	Status         int    `json:"status"`           // 消息状态，2 是已发送，4 是已接收，可以用于判断 IsSender（FIXME 不准, 需要判断 UserName）
 }
@@ -56,6 +57,7 @@ func (m *MessageV4) Wrap(talker string) *Message {
 		IsChatRoom: strings.HasSuffix(talker, "@chatroom"),
 		Sender:     m.UserName,
 		Type:       m.LocalType,
+		ServerID:   m.ServerID,
 		Contents:   make(map[string]interface{}),
 		Version:    WeChatV4,
 	}
@@ -63,14 +65,7 @@ func (m *MessageV4) Wrap(talker string) *Message {
 	// FIXME 后续通过 UserName 判断是否是自己发送的消息，目前可能不准确
 	_m.IsSelf = m.Status == 2 || (!_m.IsChatRoom && talker != m.UserName)
 
-	content := ""
-	if bytes.HasPrefix(m.MessageContent, []byte{0x28, 0xb5, 0x2f, 0xfd}) {
-		if b, err := zstd.Decompress(m.MessageContent); err == nil {
-			content = string(b)
-		}
-	} else {
-		content = string(m.MessageContent)
-	}
+	content := decompress(m.MessageContent)
 
 	if _m.IsChatRoom {
 		split := strings.SplitN(content, ":\n", 2)
@@ -82,6 +77,10 @@ func (m *MessageV4) Wrap(talker string) *Message {
 
 	_m.ParseMediaInfo(content)
 
+	if len(m.Source) != 0 {
+		_m.ParseMsgSource(decompress(m.Source))
+	}
+
 	// 语音消息
 	if _m.Type == 34 {
 		_m.Contents["voice"] = fmt.Sprint(m.ServerID)
@@ -104,6 +103,26 @@ func (m *MessageV4) Wrap(talker string) *Message {
 	return _m
 }
 
+// IsRevoke 是否为撤回提示，撤回后原消息所在的行会被替换为该系统消息
+func (m *MessageV4) IsRevoke() bool {
+	if m.LocalType&0xFFFFFFFF != MessageTypeSystem {
+		return false
+	}
+	content := decompress(m.MessageContent)
+	return strings.Contains(content, "revokemsg") || strings.Contains(content, "撤回了一条消息")
+}
+
+// decompress 返回字段的文本内容，zstd 压缩的内容会先解压
+func decompress(b []byte) string {
+	if bytes.HasPrefix(b, []byte{0x28, 0xb5, 0x2f, 0xfd}) {
+		if d, err := zstd.Decompress(b); err == nil {
+			return string(d)
+		}
+		return ""
+	}
+	return string(b)
+}
+
 func ParsePackedInfo(b []byte) *wxproto.PackedInfo {
 	var pbMsg wxproto.PackedInfo
 	if err := proto.Unmarshal(b, &pbMsg); err != nil {
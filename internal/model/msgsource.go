@@ -0,0 +1,67 @@
+package model
+
+import (
+	"encoding/xml"
+	"strings"
+)
+
+// MentionAllUser atuserlist 中表示 @所有人 的占位用户
+const MentionAllUser = "notify@all"
+
+// MsgSource 消息附加信息
+//
+//	<msgsource>
+//		<atuserlist><![CDATA[wxid_a,wxid_b]]></atuserlist>
+//		<silence>1</silence>
+//		<membercount>200</membercount>
+//	</msgsource>
+type MsgSource struct {
+	XMLName     xml.Name `xml:"msgsource"`
+	AtUserList  string   `xml:"atuserlist"`
+	Silence     int      `xml:"silence"`
+	MemberCount int      `xml:"membercount"`
+}
+
+// ParseMsgSource 解析 msgsource 中的 @ 信息
+func (m *Message) ParseMsgSource(data string) {
+	if !strings.Contains(data, "atuserlist") {
+		return
+	}
+	var source MsgSource
+	if err := xml.Unmarshal([]byte(data), &source); err != nil {
+		return
+	}
+	for _, user := range strings.Split(source.AtUserList, ",") {
+		user = strings.TrimSpace(user)
+		switch {
+		case user == "":
+		case user == MentionAllUser:
+			m.MentionAll = true
+		default:
+			m.AddMention(user)
+		}
+	}
+}
+
+// AddMention 记录被 @ 的用户，忽略重复
+func (m *Message) AddMention(user string) {
+	for _, u := range m.Mentions {
+		if u == user {
+			return
+		}
+	}
+	m.Mentions = append(m.Mentions, user)
+}
+
+// Mentioned 判断消息是否 @ 了指定用户，@所有人 同样视为 @ 了该用户
+func (m *Message) Mentioned(user string) bool {
+	if m.MentionAll {
+		return true
+	}
+	for _, u := range m.Mentions {
+		if u == user {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMsgSource(t *testing.T) {
+	tests := []struct {
+		name           string
+		input          string
+		wantMentions   []string
+		wantMentionAll bool
+	}{
+		{
+			name:  "empty",
+			input: "",
+		},
+		{
+			name:  "no atuserlist",
+			input: "<msgsource><silence>1</silence><membercount>20</membercount></msgsource>",
+		},
+		{
+			name:         "cdata list",
+			input:        "<msgsource><atuserlist><![CDATA[wxid_a,wxid_b]]></atuserlist></msgsource>",
+			wantMentions: []string{"wxid_a", "wxid_b"},
+		},
+		{
+			name:         "plain list with spaces and empty items",
+			input:        "<msgsource><atuserlist>,wxid_a, wxid_b ,</atuserlist></msgsource>",
+			wantMentions: []string{"wxid_a", "wxid_b"},
+		},
+		{
+			name:         "duplicates",
+			input:        "<msgsource><atuserlist>wxid_a,wxid_a</atuserlist></msgsource>",
+			wantMentions: []string{"wxid_a"},
+		},
+		{
+			name:           "mention all",
+			input:          "<msgsource><atuserlist><![CDATA[notify@all]]></atuserlist></msgsource>",
+			wantMentionAll: true,
+		},
+		{
+			name:           "mention all with users",
+			input:          "<msgsource><atuserlist>notify@all,wxid_a</atuserlist></msgsource>",
+			wantMentions:   []string{"wxid_a"},
+			wantMentionAll: true,
+		},
+		{
+			name:  "invalid xml",
+			input: "<msgsource><atuserlist>wxid_a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Message{}
+			m.ParseMsgSource(tt.input)
+			if !reflect.DeepEqual(m.Mentions, tt.wantMentions) {
+				t.Errorf("Mentions = %v, want %v", m.Mentions, tt.wantMentions)
+			}
+			if m.MentionAll != tt.wantMentionAll {
+				t.Errorf("MentionAll = %v, want %v", m.MentionAll, tt.wantMentionAll)
+			}
+		})
+	}
+}
+
+func TestMentioned(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  Message
+		user string
+		want bool
+	}{
+		{name: "mentioned", msg: Message{Mentions: []string{"wxid_a"}}, user: "wxid_a", want: true},
+		{name: "not mentioned", msg: Message{Mentions: []string{"wxid_a"}}, user: "wxid_b", want: false},
+		{name: "mention all", msg: Message{MentionAll: true}, user: "wxid_b", want: true},
+		{name: "none", msg: Message{}, user: "wxid_a", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.msg.Mentioned(tt.user); got != tt.want {
+				t.Errorf("Mentioned(%q) = %v, want %v", tt.user, got, tt.want)
+			}
+		})
+	}
+}
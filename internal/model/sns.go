@@ -2,9 +2,8 @@ package model
 
 import (
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
-	"html"
-	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -12,37 +11,58 @@ import (
 
 // SNSPost 朋友圈帖子
 type SNSPost struct {
-	TID         int64     `json:"tid"`
-	UserName    string    `json:"user_name"`
-	NickName    string    `json:"nickname"`
-	CreateTime  int64     `json:"create_time"`
-	CreateTimeStr string  `json:"create_time_str"`
-	ContentDesc string    `json:"content_desc"`
-	ContentType string    `json:"content_type"` // image, video, article, finder, text
-	Location    *SNSLocation `json:"location,omitempty"`
-	MediaList   []SNSMedia `json:"media_list,omitempty"`
-	Article     *SNSArticle `json:"article,omitempty"`
-	FinderFeed  *SNSFinderFeed `json:"finder_feed,omitempty"`
-	XMLContent  string    `json:"xml_content,omitempty"` // 原始XML，用于调试
+	TID           int64          `json:"tid"`
+	ID            string         `json:"id,omitempty"`
+	UserName      string         `json:"user_name"`
+	NickName      string         `json:"nickname"`
+	CreateTime    int64          `json:"create_time"`
+	CreateTimeStr string         `json:"create_time_str"`
+	ContentDesc   string         `json:"content_desc"`
+	ContentType   string         `json:"content_type"` // image, video, article, finder, text
+	Private       bool           `json:"private"`      // 仅自己可见
+	Location      *SNSLocation   `json:"location,omitempty"`
+	MediaList     []SNSMedia     `json:"media_list,omitempty"`
+	Article       *SNSArticle    `json:"article,omitempty"`
+	FinderFeed    *SNSFinderFeed `json:"finder_feed,omitempty"`
+	Likes         []SNSLike      `json:"likes,omitempty"`
+	Comments      []SNSComment   `json:"comments,omitempty"`
+	XMLContent    string         `json:"xml_content,omitempty"` // 原始XML，用于调试
 }
 
 // SNSLocation 位置信息
 type SNSLocation struct {
-	City        string  `json:"city,omitempty"`
-	Latitude    float64 `json:"latitude,omitempty"`
-	Longitude   float64 `json:"longitude,omitempty"`
-	POIName     string  `json:"poi_name,omitempty"`
-	POIAddress  string  `json:"poi_address,omitempty"`
+	City       string  `json:"city,omitempty"`
+	Latitude   float64 `json:"latitude,omitempty"`
+	Longitude  float64 `json:"longitude,omitempty"`
+	POIName    string  `json:"poi_name,omitempty"`
+	POIAddress string  `json:"poi_address,omitempty"`
 }
 
 // SNSMedia 媒体信息
 type SNSMedia struct {
-	Type     string `json:"type"`     // image, video
+	Type     string `json:"type"` // image, video
+	ID       string `json:"id,omitempty"`
 	URL      string `json:"url,omitempty"`
 	ThumbURL string `json:"thumb_url,omitempty"`
+	MD5      string `json:"md5,omitempty"`
 	Width    int    `json:"width,omitempty"`
 	Height   int    `json:"height,omitempty"`
+	Size     int64  `json:"size,omitempty"`
 	Duration string `json:"duration,omitempty"`
+	Private  bool   `json:"private,omitempty"`
+
+	// CDN 下载参数，EncIdx 非 0 时 CDN 上的内容是加密的
+	Token      string `json:"token,omitempty"`
+	Key        string `json:"key,omitempty"`
+	EncIdx     string `json:"enc_idx,omitempty"`
+	ThumbToken string `json:"thumb_token,omitempty"`
+	ThumbKey   string `json:"thumb_key,omitempty"`
+
+	// LocalPath 本地缓存文件相对数据目录的路径，需查询时补充
+	LocalPath string `json:"local_path,omitempty"`
+
+	// MediaURL 通过 /sns/*key 访问该媒体的地址，需查询时补充
+	MediaURL string `json:"media_url,omitempty"`
 }
 
 // SNSArticle 文章信息
@@ -55,90 +75,205 @@ type SNSArticle struct {
 
 // SNSFinderFeed 视频号信息
 type SNSFinderFeed struct {
-	Nickname     string `json:"nickname"`
-	Avatar       string `json:"avatar"`
-	Desc         string `json:"desc"`
-	MediaCount   int    `json:"media_count"`
-	VideoURL     string `json:"video_url"`
-	CoverURL     string `json:"cover_url"`
-	ThumbURL     string `json:"thumb_url"`
-	Width        int    `json:"width,omitempty"`
-	Height       int    `json:"height,omitempty"`
-	Duration     string `json:"duration,omitempty"`
+	Nickname   string `json:"nickname"`
+	Avatar     string `json:"avatar"`
+	Desc       string `json:"desc"`
+	MediaCount int    `json:"media_count"`
+	VideoURL   string `json:"video_url"`
+	CoverURL   string `json:"cover_url"`
+	ThumbURL   string `json:"thumb_url"`
+	Width      int    `json:"width,omitempty"`
+	Height     int    `json:"height,omitempty"`
+	Duration   string `json:"duration,omitempty"`
 }
 
-// ParseSNSContent 解析朋友圈 XML 内容
-func ParseSNSContent(xmlContent string) (*SNSPost, error) {
-	post := &SNSPost{
-		XMLContent: xmlContent,
-	}
+// SNSLike 点赞
+type SNSLike struct {
+	UserName   string `json:"user_name"`
+	NickName   string `json:"nickname,omitempty"`
+	CreateTime int64  `json:"create_time,omitempty"`
+}
 
-	// 提取 createTime
-	createTime := extractXMLTag(xmlContent, "createTime")
-	if createTime != "" {
-		post.CreateTime, _ = strconv.ParseInt(createTime, 10, 64)
-		post.CreateTimeStr = time.Unix(post.CreateTime, 0).Format("2006-01-02 15:04:05")
-	}
+// SNSComment 评论，ReplyTo 为被回复的用户
+type SNSComment struct {
+	ID          string `json:"id,omitempty"`
+	UserName    string `json:"user_name"`
+	NickName    string `json:"nickname,omitempty"`
+	Content     string `json:"content"`
+	ReplyTo     string `json:"reply_to,omitempty"`
+	ReplyToName string `json:"reply_to_name,omitempty"`
+	CreateTime  int64  `json:"create_time,omitempty"`
+}
+
+// <TimelineObject>
+//
+//	<id>...</id>
+//	<username>wxid_xxx</username>
+//	<createTime>1700000000</createTime>
+//	<contentDesc>...</contentDesc>
+//	<private>0</private>
+//	<location poiName="" poiAddress="" city="" latitude="" longitude="" />
+//	<ContentObject>
+//	  <contentStyle>1</contentStyle>
+//	  <mediaList>
+//	    <media>
+//	      <id>...</id><type>2</type><private>0</private>
+//	      <url type="1" md5="" token="" key="" enc_idx="1">http://...</url>
+//	      <thumb type="1" token="" key="" enc_idx="1">http://...</thumb>
+//	      <size width="" height="" totalSize="" />
+//	    </media>
+//	  </mediaList>
+//	</ContentObject>
+//
+// </TimelineObject>
+type snsTimelineXML struct {
+	ID            string              `xml:"id"`
+	UserName      string              `xml:"username"`
+	NickName      string              `xml:"nickname"`
+	CreateTime    string              `xml:"createTime"`
+	ContentDesc   string              `xml:"contentDesc"`
+	Private       string              `xml:"private"`
+	Location      *snsLocationXML     `xml:"location"`
+	ContentObject snsContentXML       `xml:"ContentObject"`
+	LikeList      []snsInteractionXML `xml:"likeUserList>likeUser"`
+	CommentList   []snsInteractionXML `xml:"commentUserList>commentUser"`
+}
 
-	// 提取 username
-	post.UserName = extractXMLTag(xmlContent, "username")
+type snsLocationXML struct {
+	City       string `xml:"city,attr"`
+	Latitude   string `xml:"latitude,attr"`
+	Longitude  string `xml:"longitude,attr"`
+	POIName    string `xml:"poiName,attr"`
+	POIAddress string `xml:"poiAddress,attr"`
+	CityValue  string `xml:"city"`
+}
 
-	// 提取 nickname
-	post.NickName = extractXMLTag(xmlContent, "nickname")
+type snsContentXML struct {
+	ContentStyle string            `xml:"contentStyle"`
+	Title        string            `xml:"title"`
+	Description  string            `xml:"description"`
+	ContentURL   string            `xml:"contentUrl"`
+	MediaList    []snsMediaXML     `xml:"mediaList>media"`
+	FinderFeed   *snsFinderFeedXML `xml:"finderFeed"`
+}
 
-	// 提取 contentDesc
-	post.ContentDesc = extractXMLTag(xmlContent, "contentDesc")
+type snsMediaXML struct {
+	ID            string     `xml:"id"`
+	Type          string     `xml:"type"`
+	Private       string     `xml:"private"`
+	URL           snsURLXML  `xml:"url"`
+	Thumb         snsURLXML  `xml:"thumb"`
+	Size          snsSizeXML `xml:"size"`
+	VideoDuration string     `xml:"videoDuration"`
+}
 
-	// 提取位置信息
-	post.Location = parseSNSLocation(xmlContent)
+type snsURLXML struct {
+	MD5      string `xml:"md5,attr"`
+	VideoMD5 string `xml:"videomd5,attr"`
+	Token    string `xml:"token,attr"`
+	Key      string `xml:"key,attr"`
+	EncIdx   string `xml:"enc_idx,attr"`
+	Value    string `xml:",chardata"`
+}
 
-	// 判断内容类型并提取相应信息
-	contentType := extractXMLTag(xmlContent, "type")
-	post.ContentType = parseSNSContentType(contentType)
+type snsSizeXML struct {
+	Width     string `xml:"width,attr"`
+	Height    string `xml:"height,attr"`
+	TotalSize string `xml:"totalSize,attr"`
+}
+
+type snsFinderFeedXML struct {
+	Nickname   string `xml:"nickname"`
+	Avatar     string `xml:"avatar"`
+	Desc       string `xml:"desc"`
+	MediaCount string `xml:"mediaCount"`
+	MediaList  []struct {
+		URL               string     `xml:"url"`
+		ThumbURL          string     `xml:"thumbUrl"`
+		CoverURL          string     `xml:"coverUrl"`
+		Width             string     `xml:"width"`
+		Height            string     `xml:"height"`
+		Size              snsSizeXML `xml:"size"`
+		VideoPlayDuration string     `xml:"videoPlayDuration"`
+	} `xml:"mediaList>media"`
+}
+
+// snsInteractionXML 部分客户端导出的 XML 中会附带点赞与评论列表
+type snsInteractionXML struct {
+	ID            string `xml:"commentId"`
+	UserName      string `xml:"username"`
+	NickName      string `xml:"nickname"`
+	Content       string `xml:"content"`
+	ReplyUserName string `xml:"replyUsername"`
+	CreateTime    string `xml:"createTime"`
+}
+
+// ParseSNSContent 解析朋友圈 XML 内容
+func ParseSNSContent(xmlContent string) (*SNSPost, error) {
+	// 朋友圈 XML 中偶尔有未转义的字符，使用非严格模式解析
+	var obj snsTimelineXML
+	decoder := xml.NewDecoder(strings.NewReader(xmlContent))
+	decoder.Strict = false
+	decoder.Entity = xml.HTMLEntity
+	if err := decoder.Decode(&obj); err != nil {
+		return nil, err
+	}
+
+	post := &SNSPost{
+		ID:          obj.ID,
+		UserName:    obj.UserName,
+		NickName:    obj.NickName,
+		CreateTime:  parseInt64(obj.CreateTime),
+		ContentDesc: obj.ContentDesc,
+		ContentType: parseSNSContentType(obj.ContentObject.ContentStyle),
+		Private:     strings.TrimSpace(obj.Private) == "1",
+		Location:    obj.Location.parse(),
+		XMLContent:  xmlContent,
+	}
+	if post.CreateTime != 0 {
+		post.CreateTimeStr = time.Unix(post.CreateTime, 0).Format("2006-01-02 15:04:05")
+	}
 
 	switch post.ContentType {
-	case "image":
-		post.MediaList = parseSNSImageMedia(xmlContent)
-	case "video":
-		post.MediaList = parseSNSVideoMedia(xmlContent)
+	case "image", "video":
+		for _, m := range obj.ContentObject.MediaList {
+			post.MediaList = append(post.MediaList, m.parse(post.ContentType))
+		}
 	case "article":
-		post.Article = parseSNSArticle(xmlContent)
+		post.Article = obj.ContentObject.parseArticle()
 	case "finder":
-		post.FinderFeed = parseSNSFinderFeed(xmlContent)
+		post.FinderFeed = obj.ContentObject.FinderFeed.parse()
 	}
 
-	return post, nil
-}
-
-// extractXMLTag 提取 XML 标签内容
-func extractXMLTag(xml, tag string) string {
-	re := regexp.MustCompile(`<` + tag + `>([^<]*)</` + tag + `>`)
-	matches := re.FindStringSubmatch(xml)
-	if len(matches) > 1 {
-		return strings.TrimSpace(matches[1])
+	for _, like := range obj.LikeList {
+		post.Likes = append(post.Likes, SNSLike{
+			UserName:   like.UserName,
+			NickName:   like.NickName,
+			CreateTime: parseInt64(like.CreateTime),
+		})
 	}
-	// 处理带属性的标签
-	re = regexp.MustCompile(`<` + tag + `[^>]*>([^<]*)</` + tag + `>`)
-	matches = re.FindStringSubmatch(xml)
-	if len(matches) > 1 {
-		return strings.TrimSpace(matches[1])
+	for _, comment := range obj.CommentList {
+		post.Comments = append(post.Comments, SNSComment{
+			ID:         comment.ID,
+			UserName:   comment.UserName,
+			NickName:   comment.NickName,
+			Content:    comment.Content,
+			ReplyTo:    comment.ReplyUserName,
+			CreateTime: parseInt64(comment.CreateTime),
+		})
 	}
-	return ""
+
+	return post, nil
 }
 
-// extractXMLTagAttr 提取 XML 标签属性值
-func extractXMLTagAttr(xml, tag, attr string) string {
-	re := regexp.MustCompile(`<` + tag + `[^>]*` + attr + `="([^"]*)"`)
-	matches := re.FindStringSubmatch(xml)
-	if len(matches) > 1 {
-		return matches[1]
-	}
-	return ""
+// Time 帖子的发布时间
+func (p *SNSPost) Time() time.Time {
+	return time.Unix(p.CreateTime, 0)
 }
 
-// parseSNSContentType 解析内容类型
+// parseSNSContentType 根据 contentStyle 解析内容类型
 func parseSNSContentType(typeStr string) string {
-	switch typeStr {
+	switch strings.TrimSpace(typeStr) {
 	case "1":
 		return "image"
 	case "6":
@@ -156,28 +291,20 @@ func parseSNSContentType(typeStr string) string {
 	}
 }
 
-// parseSNSLocation 解析位置信息
-func parseSNSLocation(xml string) *SNSLocation {
-	loc := &SNSLocation{}
-
-	city := extractXMLTagAttr(xml, "location", "city")
-	if city == "" {
-		city = extractXMLTag(xmlContentLocation(xml), "city")
+func (l *snsLocationXML) parse() *SNSLocation {
+	if l == nil {
+		return nil
 	}
-	loc.City = city
-
-	lat := extractXMLTagAttr(xml, "location", "latitude")
-	if lat != "" {
-		loc.Latitude, _ = strconv.ParseFloat(lat, 64)
+	loc := &SNSLocation{
+		City:       l.City,
+		POIName:    l.POIName,
+		POIAddress: l.POIAddress,
 	}
-
-	lon := extractXMLTagAttr(xml, "location", "longitude")
-	if lon != "" {
-		loc.Longitude, _ = strconv.ParseFloat(lon, 64)
+	if loc.City == "" {
+		loc.City = strings.TrimSpace(l.CityValue)
 	}
-
-	loc.POIName = extractXMLTagAttr(xml, "location", "poiName")
-	loc.POIAddress = extractXMLTagAttr(xml, "location", "poiAddress")
+	loc.Latitude, _ = strconv.ParseFloat(l.Latitude, 64)
+	loc.Longitude, _ = strconv.ParseFloat(l.Longitude, 64)
 
 	if loc.City == "" && loc.POIName == "" {
 		return nil
@@ -185,181 +312,89 @@ func parseSNSLocation(xml string) *SNSLocation {
 	return loc
 }
 
-// xmlContentLocation 提取 location 标签内容
-func xmlContentLocation(xml string) string {
-	re := regexp.MustCompile(`<location[^>]*>([^<]*)</location>`)
-	matches := re.FindStringSubmatch(xml)
-	if len(matches) > 1 {
-		return matches[1]
+func (m *snsMediaXML) parse(contentType string) SNSMedia {
+	media := SNSMedia{
+		Type:       contentType,
+		ID:         m.ID,
+		URL:        strings.TrimSpace(m.URL.Value),
+		ThumbURL:   strings.TrimSpace(m.Thumb.Value),
+		MD5:        m.URL.MD5,
+		Width:      atoi(m.Size.Width),
+		Height:     atoi(m.Size.Height),
+		Private:    strings.TrimSpace(m.Private) == "1",
+		Token:      m.URL.Token,
+		Key:        m.URL.Key,
+		EncIdx:     m.URL.EncIdx,
+		ThumbToken: m.Thumb.Token,
+		ThumbKey:   m.Thumb.Key,
 	}
-	return ""
-}
-
-// parseSNSImageMedia 解析图片媒体
-func parseSNSImageMedia(xml string) []SNSMedia {
-	var mediaList []SNSMedia
-
-	// 查找所有 media 标签
-	re := regexp.MustCompile(`<media>(.*?)</media>`)
-	matches := re.FindAllStringSubmatch(xml, -1)
-
-	for _, match := range matches {
-		if len(match) > 1 {
-			media := SNSMedia{Type: "image"}
-			mediaXML := match[1]
-
-			// 提取 URL
-			urlTag := extractXMLTag(mediaXML, "url")
-			if urlTag == "" {
-				urlTag = extractXMLTag(mediaXML, "thumb")
-			}
-			media.URL = html.UnescapeString(urlTag)
-
-			// 提取尺寸
-			width := extractXMLTagAttr(mediaXML, "size", "width")
-			height := extractXMLTagAttr(mediaXML, "size", "height")
-			if width != "" {
-				media.Width, _ = strconv.Atoi(width)
-			}
-			if height != "" {
-				media.Height, _ = strconv.Atoi(height)
-			}
-
-			mediaList = append(mediaList, media)
+	media.Size, _ = strconv.ParseInt(m.Size.TotalSize, 10, 64)
+	if contentType == "video" {
+		if m.URL.VideoMD5 != "" {
+			media.MD5 = m.URL.VideoMD5
 		}
-	}
-
-	return mediaList
-}
-
-// parseSNSVideoMedia 解析视频媒体
-func parseSNSVideoMedia(xml string) []SNSMedia {
-	var mediaList []SNSMedia
-
-	// 查找所有 media 标签
-	re := regexp.MustCompile(`<media>(.*?)</media>`)
-	matches := re.FindAllStringSubmatch(xml, -1)
-
-	for _, match := range matches {
-		if len(match) > 1 {
-			media := SNSMedia{Type: "video"}
-			mediaXML := match[1]
-
-			// 提取 URL
-			media.URL = html.UnescapeString(extractXMLTag(mediaXML, "url"))
-			media.ThumbURL = html.UnescapeString(extractXMLTag(mediaXML, "thumb"))
-
-			// 提取尺寸
-			width := extractXMLTagAttr(mediaXML, "size", "width")
-			height := extractXMLTagAttr(mediaXML, "size", "height")
-			if width != "" {
-				media.Width, _ = strconv.Atoi(width)
-			}
-			if height != "" {
-				media.Height, _ = strconv.Atoi(height)
-			}
-
-			// 提取时长
-			duration := extractXMLTag(mediaXML, "videoDuration")
-			if duration != "" {
-				if d, err := strconv.ParseFloat(duration, 64); err == nil {
-					media.Duration = fmt.Sprintf("%.2f秒", d)
-				}
-			}
-
-			mediaList = append(mediaList, media)
+		if d, err := strconv.ParseFloat(m.VideoDuration, 64); err == nil {
+			media.Duration = fmt.Sprintf("%.2f秒", d)
 		}
+	} else if media.URL == "" {
+		media.URL = media.ThumbURL
 	}
-
-	return mediaList
+	return media
 }
 
-// parseSNSArticle 解析文章信息
-func parseSNSArticle(xml string) *SNSArticle {
-	article := &SNSArticle{}
-
-	article.Title = extractXMLTag(xml, "title")
-	article.Description = extractXMLTag(xml, "description")
-	article.URL = html.UnescapeString(extractXMLTag(xml, "contentUrl"))
-
-	// 提取封面图
-	re := regexp.MustCompile(`<media>(.*?)</media>`)
-	matches := re.FindStringSubmatch(xml)
-	if len(matches) > 1 {
-		mediaXML := matches[1]
-		article.CoverURL = html.UnescapeString(extractXMLTag(mediaXML, "thumb"))
+func (c *snsContentXML) parseArticle() *SNSArticle {
+	article := &SNSArticle{
+		Title:       c.Title,
+		Description: c.Description,
+		URL:         strings.TrimSpace(c.ContentURL),
+	}
+	if len(c.MediaList) > 0 {
+		article.CoverURL = strings.TrimSpace(c.MediaList[0].Thumb.Value)
 		if article.CoverURL == "" {
-			article.CoverURL = html.UnescapeString(extractXMLTag(mediaXML, "url"))
+			article.CoverURL = strings.TrimSpace(c.MediaList[0].URL.Value)
 		}
 	}
-
 	if article.Title == "" && article.URL == "" {
 		return nil
 	}
-
 	return article
 }
 
-// parseSNSFinderFeed 解析视频号信息
-func parseSNSFinderFeed(xml string) *SNSFinderFeed {
-	feed := &SNSFinderFeed{}
-
-	// 提取 finderFeed 标签内容
-	re := regexp.MustCompile(`<finderFeed>(.*?)</finderFeed>`)
-	matches := re.FindStringSubmatch(xml)
-	if len(matches) <= 1 {
+func (f *snsFinderFeedXML) parse() *SNSFinderFeed {
+	if f == nil || f.Nickname == "" {
 		return nil
 	}
-
-	feedXML := matches[1]
-
-	feed.Nickname = extractXMLTag(feedXML, "nickname")
-	feed.Avatar = html.UnescapeString(extractXMLTag(feedXML, "avatar"))
-	feed.Desc = extractXMLTag(feedXML, "desc")
-
-	// 提取媒体数量
-	mediaCount := extractXMLTag(feedXML, "mediaCount")
-	if mediaCount != "" {
-		feed.MediaCount, _ = strconv.Atoi(mediaCount)
+	feed := &SNSFinderFeed{
+		Nickname:   f.Nickname,
+		Avatar:     strings.TrimSpace(f.Avatar),
+		Desc:       f.Desc,
+		MediaCount: atoi(f.MediaCount),
 	}
-
-	// 提取视频信息
-	mediaRe := regexp.MustCompile(`<media>(.*?)</media>`)
-	mediaMatches := mediaRe.FindStringSubmatch(feedXML)
-	if len(mediaMatches) > 1 {
-		mediaXML := mediaMatches[1]
-		feed.VideoURL = html.UnescapeString(extractXMLTag(mediaXML, "url"))
-		feed.ThumbURL = html.UnescapeString(extractXMLTag(mediaXML, "thumbUrl"))
-		feed.CoverURL = html.UnescapeString(extractXMLTag(mediaXML, "coverUrl"))
-
-		// 提取尺寸
-		width := extractXMLTagAttr(mediaXML, "size", "width")
-		height := extractXMLTagAttr(mediaXML, "size", "height")
-		if width != "" {
-			if w, err := strconv.Atoi(width); err == nil {
-				feed.Width = w
-			}
+	if len(f.MediaList) > 0 {
+		media := f.MediaList[0]
+		feed.VideoURL = strings.TrimSpace(media.URL)
+		feed.ThumbURL = strings.TrimSpace(media.ThumbURL)
+		feed.CoverURL = strings.TrimSpace(media.CoverURL)
+		feed.Width, feed.Height = atoi(media.Width), atoi(media.Height)
+		if feed.Width == 0 {
+			feed.Width, feed.Height = atoi(media.Size.Width), atoi(media.Size.Height)
 		}
-		if height != "" {
-			if h, err := strconv.Atoi(height); err == nil {
-				feed.Height = h
-			}
-		}
-
-		// 提取时长
-		duration := extractXMLTag(mediaXML, "videoPlayDuration")
-		if duration != "" {
-			if d, err := strconv.ParseInt(duration, 10, 64); err == nil {
-				feed.Duration = fmt.Sprintf("%d秒", d/10)
-			}
+		if d := parseInt64(media.VideoPlayDuration); d > 0 {
+			feed.Duration = fmt.Sprintf("%d秒", d/10)
 		}
 	}
+	return feed
+}
 
-	if feed.Nickname == "" {
-		return nil
-	}
+// atoi 解析尺寸，兼容 "1080.000000" 这样的浮点格式
+func atoi(s string) int {
+	f, _ := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	return int(f)
+}
 
-	return feed
+func parseInt64(s string) int64 {
+	i, _ := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	return i
 }
 
 // FormatAsText 格式化为纯文本
@@ -370,6 +405,9 @@ func (p *SNSPost) FormatAsText() string {
 	if p.NickName != "" {
 		sb.WriteString(fmt.Sprintf("👤 %s\n", p.NickName))
 	}
+	if p.Private {
+		sb.WriteString("🔒 仅自己可见\n")
+	}
 
 	if p.ContentDesc != "" {
 		sb.WriteString(fmt.Sprintf("💬 %s\n", p.ContentDesc))
@@ -410,10 +448,37 @@ func (p *SNSPost) FormatAsText() string {
 			}
 		}
 	}
+	for _, media := range p.MediaList {
+		if media.MediaURL != "" {
+			sb.WriteString(fmt.Sprintf("   %s\n", media.MediaURL))
+		}
+	}
+
+	if len(p.Likes) > 0 {
+		names := make([]string, 0, len(p.Likes))
+		for _, like := range p.Likes {
+			names = append(names, displayName(like.NickName, like.UserName))
+		}
+		sb.WriteString(fmt.Sprintf("❤️ %s\n", strings.Join(names, "，")))
+	}
+	for _, comment := range p.Comments {
+		name := displayName(comment.NickName, comment.UserName)
+		if comment.ReplyTo != "" {
+			name += " 回复 " + displayName(comment.ReplyToName, comment.ReplyTo)
+		}
+		sb.WriteString(fmt.Sprintf("💭 %s: %s\n", name, comment.Content))
+	}
 
 	return sb.String()
 }
 
+func displayName(name, username string) string {
+	if name != "" {
+		return name
+	}
+	return username
+}
+
 // ToJSON 转换为 JSON
 func (p *SNSPost) ToJSON() (string, error) {
 	bytes, err := json.MarshalIndent(p, "", "  ")
@@ -0,0 +1,85 @@
+package model
+
+import (
+	"time"
+)
+
+// ChatStats 对话统计数据
+type ChatStats struct {
+	Talker       string    `json:"talker"`
+	TalkerName   string    `json:"talkerName,omitempty"`
+	Start        time.Time `json:"start"`
+	End          time.Time `json:"end"`
+	Total        int64     `json:"total"`
+	FirstMessage time.Time `json:"firstMessage"`
+	LastMessage  time.Time `json:"lastMessage"`
+
+	// ByDay 按天统计的消息数，日期格式为 2006-01-02（本地时区）
+	ByDay []*DateCount `json:"byDay"`
+
+	// ByHourOfWeek 按星期与小时统计的消息数，下标依次为星期（0 为周日）与小时
+	ByHourOfWeek [7][24]int64 `json:"byHourOfWeek"`
+
+	// ByType 按消息类型与子类型统计的消息数
+	ByType []*TypeCount `json:"byType"`
+
+	// Media 媒体消息数量，key 为 image、voice、video、animation、file 等
+	Media map[string]int64 `json:"media"`
+
+	// LinkDomains 链接分享的域名统计，按数量降序
+	LinkDomains []*DomainCount `json:"linkDomains"`
+
+	// Senders 发送者统计，按消息数降序
+	Senders []*SenderStats `json:"senders"`
+}
+
+type DateCount struct {
+	Date  string `json:"date"`
+	Count int64  `json:"count"`
+}
+
+type TypeCount struct {
+	Type    int64 `json:"type"`
+	SubType int64 `json:"subType"`
+	Count   int64 `json:"count"`
+}
+
+type DomainCount struct {
+	Domain string `json:"domain"`
+	Count  int64  `json:"count"`
+}
+
+type SenderStats struct {
+	Sender       string    `json:"sender"`
+	SenderName   string    `json:"senderName,omitempty"`
+	Count        int64     `json:"count"`
+	MediaCount   int64     `json:"mediaCount"`
+	FirstMessage time.Time `json:"firstMessage"`
+	LastMessage  time.Time `json:"lastMessage"`
+
+	// AvgReplySeconds 平均回复间隔（秒），即其他人发言后该发送者接话的平均耗时
+	AvgReplySeconds float64 `json:"avgReplySeconds"`
+	ReplyCount      int64   `json:"replyCount"`
+}
+
+// MediaTypeName 返回媒体消息对应的统计名称，非媒体消息返回空字符串
+func MediaTypeName(_type, subType int64) string {
+	switch _type {
+	case MessageTypeImage:
+		return "image"
+	case MessageTypeVoice:
+		return "voice"
+	case MessageTypeVideo:
+		return "video"
+	case MessageTypeAnimation:
+		return "animation"
+	case MessageTypeShare:
+		switch subType {
+		case MessageSubTypeFile:
+			return "file"
+		case MessageSubTypeGIF:
+			return "gif"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,13 @@
+package model
+
+// Sticker 表情数据库中记录的表情，Store 为 true 时来自表情商店，否则为收藏的自定义表情
+type Sticker struct {
+	MD5        string `json:"md5"`
+	Store      bool   `json:"store,omitempty"`
+	ProductID  string `json:"productId,omitempty"`
+	Caption    string `json:"caption,omitempty"`
+	CdnURL     string `json:"cdnUrl,omitempty"`
+	EncryptURL string `json:"encryptUrl,omitempty"`
+	ThumbURL   string `json:"thumbUrl,omitempty"`
+	AesKey     string `json:"aesKey,omitempty"`
+}
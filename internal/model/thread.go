@@ -0,0 +1,92 @@
+package model
+
+import "strings"
+
+// Thread 消息的引用回复关系
+type Thread struct {
+	// ReplyTo 被引用消息的 Seq，未能定位到原消息时为 0
+	ReplyTo int64 `json:"replyTo,omitempty"`
+
+	// ReplyToServerID 被引用消息的服务端 ID
+	ReplyToServerID int64 `json:"replyToServerId,omitempty"`
+
+	// Replies 引用了该消息的回复 Seq，仅包含同一批查询结果中的消息
+	Replies []int64 `json:"replies,omitempty"`
+}
+
+// ThreadNode 回复树中的一条消息及其回复
+type ThreadNode struct {
+	Message *Message      `json:"message"`
+	Replies []*ThreadNode `json:"replies,omitempty"`
+}
+
+// Count 返回以该节点为根的消息数量
+func (n *ThreadNode) Count() int {
+	count := 1
+	for _, reply := range n.Replies {
+		count += reply.Count()
+	}
+	return count
+}
+
+// PlainText 以纯文本输出整棵回复树，回复按层级缩进
+func (n *ThreadNode) PlainText(timeFormat string, host string) string {
+	buf := strings.Builder{}
+	n.writePlainText(&buf, 0, timeFormat, host)
+	return buf.String()
+}
+
+func (n *ThreadNode) writePlainText(buf *strings.Builder, depth int, timeFormat string, host string) {
+	indent := strings.Repeat("    ", depth)
+	for _, line := range strings.Split(strings.TrimSuffix(n.Message.PlainText(false, timeFormat, host), "\n"), "\n") {
+		buf.WriteString(indent)
+		buf.WriteString(line)
+		buf.WriteString("\n")
+	}
+	for _, reply := range n.Replies {
+		reply.writePlainText(buf, depth+1, timeFormat, host)
+	}
+}
+
+// Refer 返回引用消息中被引用的消息，非引用消息返回 nil
+func (m *Message) Refer() *Message {
+	if m.Type != MessageTypeShare || m.SubType != MessageSubTypeQuote {
+		return nil
+	}
+	refer, _ := m.Contents["refer"].(*Message)
+	return refer
+}
+
+// LinkThreads 在同一批消息中按 server_id 关联引用与被引用的消息，填充 Thread 字段
+// 返回被引用但不在本批消息中的引用消息，可由调用方进一步查询
+func LinkThreads(messages []*Message) []*Message {
+	bySvrID := make(map[int64]*Message, len(messages))
+	for _, m := range messages {
+		if m.ServerID != 0 {
+			bySvrID[m.ServerID] = m
+		}
+	}
+
+	unresolved := make([]*Message, 0)
+	for _, m := range messages {
+		refer := m.Refer()
+		if refer == nil || refer.ServerID == 0 {
+			continue
+		}
+		if m.Thread == nil {
+			m.Thread = &Thread{}
+		}
+		m.Thread.ReplyToServerID = refer.ServerID
+		parent, ok := bySvrID[refer.ServerID]
+		if !ok {
+			unresolved = append(unresolved, m)
+			continue
+		}
+		m.Thread.ReplyTo = parent.Seq
+		if parent.Thread == nil {
+			parent.Thread = &Thread{}
+		}
+		parent.Thread.Replies = append(parent.Thread.Replies, m.Seq)
+	}
+	return unresolved
+}
@@ -16,6 +16,14 @@ type DataSource interface {
 	// 消息
 	GetMessages(ctx context.Context, startTime, endTime time.Time, talker string, sender string, keyword string, limit, offset int) ([]*model.Message, error)
 	GetMessage(ctx context.Context, talker string, seq int64) (*model.Message, error)
+	GetMessageContext(ctx context.Context, talker string, seq int64, before, after int) ([]*model.Message, error)
+	GetMessageByServerID(ctx context.Context, talker string, serverID int64, createTime time.Time) (*model.Message, error)
+	GetMessagesByType(ctx context.Context, startTime, endTime time.Time, talker string, types []int64) ([]*model.Message, error)
+	GetMentionMessages(ctx context.Context, startTime, endTime time.Time, talker string) ([]*model.Message, error)
+	GetSelfUserName(ctx context.Context) (string, error)
+
+	// 统计
+	GetChatStats(ctx context.Context, startTime, endTime time.Time, talker string) (*model.ChatStats, error)
 
 	// 联系人
 	GetContacts(ctx context.Context, key string, limit, offset int) ([]*model.Contact, error)
@@ -29,8 +37,12 @@ type DataSource interface {
 	// 媒体
 	GetMedia(ctx context.Context, _type string, key string) (*model.Media, error)
 
+	// 表情
+	GetStickers(ctx context.Context) ([]*model.Sticker, error)
+
 	// 朋友圈
-	GetSNSTimeline(ctx context.Context, username string, limit, offset int) ([]map[string]interface{}, error)
+	GetSNSTimeline(ctx context.Context, username string, startTime, endTime time.Time, limit, offset int) ([]*model.SNSPost, error)
+	GetSNSPost(ctx context.Context, tid int64) (*model.SNSPost, error)
 	GetSNSCount(ctx context.Context, username string) (int, error)
 
 	// 设置回调函数
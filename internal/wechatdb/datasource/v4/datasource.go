@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -23,12 +24,14 @@ import (
 )
 
 const (
-	Message = "message"
-	Contact = "contact"
-	Session = "session"
-	Media   = "media"
-	Voice   = "voice"
-	SNS     = "sns"
+	Message  = "message"
+	Contact  = "contact"
+	Session  = "session"
+	Media    = "media"
+	Voice    = "voice"
+	SNS      = "sns"
+	Emoticon = "emoticon"
+	FTS      = "fts"
 )
 
 var Groups = []*dbm.Group{
@@ -62,6 +65,16 @@ var Groups = []*dbm.Group{
 		Pattern:   `^sns\.db(-wal|-shm)?$`,
 		BlackList: []string{},
 	},
+	{
+		Name:      Emoticon,
+		Pattern:   `^emoticon\.db(-wal|-shm)?$`,
+		BlackList: []string{},
+	},
+	{
+		Name:      FTS,
+		Pattern:   `^(message_)?fts.*\.db(-wal|-shm)?$`,
+		BlackList: []string{},
+	},
 }
 
 // MessageDBInfo 存储消息数据库的信息
@@ -218,6 +231,22 @@ func (ds *DataSource) GetMessages(ctx context.Context, startTime, endTime time.T
 		}
 	}
 
+	// 关键词为普通文本时，文本消息先在微信自带的全文索引中查找子串，索引覆盖的时间范围内只读取命中的消息
+	// 非文本消息、索引没有覆盖的会话与时间范围仍按正则扫描，全文索引不可用或只能 MATCH 时全部按正则扫描
+	var hits *ftsHits
+	if regex != nil {
+		if literal, ok := ftsLiteral(keyword); ok {
+			var err error
+			if hits, err = ds.searchFTS(ctx, talkers, literal); err != nil {
+				log.Debug().Err(err).Msg("fts search unavailable, fallback to regex scan")
+				hits = nil
+			} else if !hits.exact {
+				log.Debug().Msg("fts content table unavailable, fallback to regex scan")
+				hits = nil
+			}
+		}
+	}
+
 	// 从每个相关数据库中查询消息，并在读取时进行过滤
 	filteredMessages := []*model.Message{}
 
@@ -257,11 +286,23 @@ func (ds *DataSource) GetMessages(ctx context.Context, startTime, endTime time.T
 			// 构建查询条件
 			conditions := []string{"create_time >= ? AND create_time <= ?"}
 			args := []interface{}{startTime.Unix(), endTime.Unix()}
+			if r, ok := hits.coveredRange(talkerItem); ok {
+				seqs := make([]string, 0, len(hits.seqs[talkerItem]))
+				for _, seq := range hits.seqs[talkerItem] {
+					seqs = append(seqs, strconv.FormatInt(seq, 10))
+				}
+				cond := "m.local_type != 1 OR m.create_time < ? OR m.create_time > ?"
+				if len(seqs) > 0 {
+					cond += " OR m.create_time * 1000000 + m.local_id IN (" + strings.Join(seqs, ",") + ")"
+				}
+				conditions = append(conditions, "("+cond+")")
+				args = append(args, r.from, r.until)
+			}
 			log.Debug().Msgf("Table name: %s", tableName)
 			log.Debug().Msgf("Start time: %d, End time: %d", startTime.Unix(), endTime.Unix())
 
 			query := fmt.Sprintf(`
-				SELECT m.local_id, m.sort_seq, m.server_id, m.local_type, n.user_name, m.create_time, m.message_content, m.packed_info_data, m.status
+				SELECT m.local_id, m.sort_seq, m.server_id, m.local_type, n.user_name, m.create_time, m.message_content, m.source, m.packed_info_data, m.status
 				FROM %s m
 				LEFT JOIN Name2Id n ON m.real_sender_id = n.rowid
 				WHERE %s 
@@ -290,6 +331,7 @@ func (ds *DataSource) GetMessages(ctx context.Context, startTime, endTime time.T
 					&msg.UserName,
 					&msg.CreateTime,
 					&msg.MessageContent,
+					&msg.Source,
 					&msg.PackedInfoData,
 					&msg.Status,
 				)
@@ -397,7 +439,7 @@ func (ds *DataSource) GetMessage(ctx context.Context, talker string, seq int64)
 		}
 
 		query := fmt.Sprintf(`
-			SELECT m.local_id, m.sort_seq, m.server_id, m.local_type, n.user_name, m.create_time, m.message_content, m.packed_info_data, m.status
+			SELECT m.local_id, m.sort_seq, m.server_id, m.local_type, n.user_name, m.create_time, m.message_content, m.source, m.packed_info_data, m.status
 			FROM %s m
 			LEFT JOIN Name2Id n ON m.real_sender_id = n.rowid
 			WHERE m.local_id = ?
@@ -412,6 +454,7 @@ func (ds *DataSource) GetMessage(ctx context.Context, talker string, seq int64)
 			&msg.UserName,
 			&msg.CreateTime,
 			&msg.MessageContent,
+			&msg.Source,
 			&msg.PackedInfoData,
 			&msg.Status,
 		)
@@ -428,6 +471,327 @@ func (ds *DataSource) GetMessage(ctx context.Context, talker string, seq int64)
 	return nil, errors.ErrMessageNotFound
 }
 
+// GetMessageByServerID 按 server_id 获取消息，createTime 不为零时只查找对应时间的分片
+func (ds *DataSource) GetMessageByServerID(ctx context.Context, talker string, serverID int64, createTime time.Time) (*model.Message, error) {
+	if talker == "" {
+		return nil, errors.ErrTalkerEmpty
+	}
+
+	dbInfos := ds.messageInfos
+	if !createTime.IsZero() {
+		dbInfos = ds.getDBInfosForTimeRange(createTime, createTime.Add(time.Second))
+	}
+
+	_talkerMd5Bytes := md5.Sum([]byte(talker))
+	tableName := "Msg_" + hex.EncodeToString(_talkerMd5Bytes[:])
+
+	for i := len(dbInfos) - 1; i >= 0; i-- {
+		db, err := ds.dbm.OpenDB(dbInfos[i].FilePath)
+		if err != nil {
+			continue
+		}
+		msgs, err := ds.queryMessages(ctx, db, tableName, talker, "m.server_id = ?", "", 1, serverID)
+		if err != nil {
+			return nil, err
+		}
+		if len(msgs) > 0 {
+			return msgs[0].Message, nil
+		}
+	}
+
+	return nil, errors.ErrMessageNotFound
+}
+
+// GetMessageContext 获取指定消息前后的若干条消息，按 sort_seq 跨分片数据库向前/向后查找
+func (ds *DataSource) GetMessageContext(ctx context.Context, talker string, seq int64, before, after int) ([]*model.Message, error) {
+	if talker == "" {
+		return nil, errors.ErrTalkerEmpty
+	}
+
+	// Seq = (create_time * 1000000) + local_id
+	createTime := seq / 1000000
+	localID := seq % 1000000
+	t := time.Unix(createTime, 0)
+
+	_talkerMd5Bytes := md5.Sum([]byte(talker))
+	talkerMd5 := hex.EncodeToString(_talkerMd5Bytes[:])
+	tableName := "Msg_" + talkerMd5
+
+	// 定位目标消息所在的分片及其 sort_seq
+	var anchor *model.Message
+	var sortSeq int64
+	index := -1
+	for i, dbInfo := range ds.messageInfos {
+		if !(dbInfo.StartTime.Before(t.Add(time.Second)) && dbInfo.EndTime.After(t)) {
+			continue
+		}
+		db, err := ds.dbm.OpenDB(dbInfo.FilePath)
+		if err != nil {
+			continue
+		}
+		msgs, err := ds.queryMessages(ctx, db, tableName, talker, "m.local_id = ?", "", 1, localID)
+		if err != nil {
+			return nil, err
+		}
+		if len(msgs) == 0 {
+			continue
+		}
+		anchor, sortSeq, index = msgs[0].Message, msgs[0].SortSeq, i
+		break
+	}
+	if anchor == nil {
+		return nil, errors.ErrMessageNotFound
+	}
+
+	// 向前查找，结果为倒序
+	prev := make([]*model.Message, 0, before)
+	for i := index; i >= 0 && len(prev) < before; i-- {
+		db, err := ds.dbm.OpenDB(ds.messageInfos[i].FilePath)
+		if err != nil {
+			continue
+		}
+		msgs, err := ds.queryMessages(ctx, db, tableName, talker, "m.sort_seq < ?", "DESC", before-len(prev), sortSeq)
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range msgs {
+			prev = append(prev, msg.Message)
+		}
+	}
+
+	// 向后查找
+	next := make([]*model.Message, 0, after)
+	for i := index; i < len(ds.messageInfos) && len(next) < after; i++ {
+		db, err := ds.dbm.OpenDB(ds.messageInfos[i].FilePath)
+		if err != nil {
+			continue
+		}
+		msgs, err := ds.queryMessages(ctx, db, tableName, talker, "m.sort_seq > ?", "ASC", after-len(next), sortSeq)
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range msgs {
+			next = append(next, msg.Message)
+		}
+	}
+
+	messages := make([]*model.Message, 0, len(prev)+1+len(next))
+	for i := len(prev) - 1; i >= 0; i-- {
+		messages = append(messages, prev[i])
+	}
+	messages = append(messages, anchor)
+	messages = append(messages, next...)
+	return messages, nil
+}
+
+// GetMessagesByType 获取时间范围内指定类型的消息，types 为消息主类型（local_type 低 32 位）
+func (ds *DataSource) GetMessagesByType(ctx context.Context, startTime, endTime time.Time, talker string, types []int64) ([]*model.Message, error) {
+	if talker == "" {
+		return nil, errors.ErrTalkerEmpty
+	}
+	talkers := util.Str2List(talker, ",")
+	if len(talkers) == 0 {
+		return nil, errors.ErrTalkerEmpty
+	}
+
+	dbInfos := ds.getDBInfosForTimeRange(startTime, endTime)
+	if len(dbInfos) == 0 {
+		return nil, errors.TimeRangeNotFound(startTime, endTime)
+	}
+
+	condition := "m.create_time >= ? AND m.create_time <= ?"
+	if len(types) > 0 {
+		typeList := make([]string, 0, len(types))
+		for _, t := range types {
+			typeList = append(typeList, strconv.FormatInt(t, 10))
+		}
+		condition += fmt.Sprintf(" AND (m.local_type & 4294967295) IN (%s)", strings.Join(typeList, ","))
+	}
+
+	messages := make([]*model.Message, 0)
+	for _, dbInfo := range dbInfos {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		db, err := ds.dbm.OpenDB(dbInfo.FilePath)
+		if err != nil {
+			log.Error().Msgf("数据库 %s 未打开", dbInfo.FilePath)
+			continue
+		}
+		for _, talkerItem := range talkers {
+			_talkerMd5Bytes := md5.Sum([]byte(talkerItem))
+			tableName := "Msg_" + hex.EncodeToString(_talkerMd5Bytes[:])
+			msgs, err := ds.queryMessages(ctx, db, tableName, talkerItem, condition, "ASC", 0, startTime.Unix(), endTime.Unix())
+			if err != nil {
+				return nil, err
+			}
+			for _, msg := range msgs {
+				messages = append(messages, msg.Message)
+			}
+		}
+	}
+
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].Seq < messages[j].Seq
+	})
+	return messages, nil
+}
+
+// GetMentionMessages 获取可能包含 @ 的群聊消息（含 @ 的文本、引用消息、带 atuserlist 的消息），
+// 以及自己发送的消息，用于判断 @ 之后是否已回复
+func (ds *DataSource) GetMentionMessages(ctx context.Context, startTime, endTime time.Time, talker string) ([]*model.Message, error) {
+	if talker == "" {
+		return nil, errors.ErrTalkerEmpty
+	}
+	talkers := util.Str2List(talker, ",")
+	if len(talkers) == 0 {
+		return nil, errors.ErrTalkerEmpty
+	}
+
+	dbInfos := ds.getDBInfosForTimeRange(startTime, endTime)
+	if len(dbInfos) == 0 {
+		return nil, errors.TimeRangeNotFound(startTime, endTime)
+	}
+
+	// message_content 与 source 可能是 zstd 压缩的，SQL 只按类型粗筛，解压后再判断是否包含 @
+	quoteType := int64(model.MessageSubTypeQuote)<<32 | model.MessageTypeShare
+	condition := `m.create_time >= ? AND m.create_time <= ? AND (m.local_type = 1 OR m.local_type = ? OR m.status = 2)`
+
+	messages := make([]*model.Message, 0)
+	for _, dbInfo := range dbInfos {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		db, err := ds.dbm.OpenDB(dbInfo.FilePath)
+		if err != nil {
+			log.Error().Msgf("数据库 %s 未打开", dbInfo.FilePath)
+			continue
+		}
+		for _, talkerItem := range talkers {
+			_talkerMd5Bytes := md5.Sum([]byte(talkerItem))
+			tableName := "Msg_" + hex.EncodeToString(_talkerMd5Bytes[:])
+			msgs, err := ds.queryMessages(ctx, db, tableName, talkerItem, condition, "ASC", 0, startTime.Unix(), endTime.Unix(), quoteType)
+			if err != nil {
+				return nil, err
+			}
+			for _, msg := range msgs {
+				if mayMention(msg.Message) {
+					messages = append(messages, msg.Message)
+				}
+			}
+		}
+	}
+
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].Seq < messages[j].Seq
+	})
+	return messages, nil
+}
+
+// mayMention 消息是否可能包含 @：带 atuserlist 的消息、含 @ 的文本、引用消息，以及自己发送的消息
+func mayMention(msg *model.Message) bool {
+	switch {
+	case msg.IsSelf, msg.MentionAll, len(msg.Mentions) > 0:
+		return true
+	case msg.Type == model.MessageTypeText:
+		return strings.Contains(msg.Content, "@")
+	case msg.Type == model.MessageTypeShare:
+		return msg.SubType == model.MessageSubTypeQuote
+	}
+	return false
+}
+
+// GetSelfUserName 从最近的消息分片中查找自己发送的消息，获取当前账号的用户名
+func (ds *DataSource) GetSelfUserName(ctx context.Context) (string, error) {
+	for i := len(ds.messageInfos) - 1; i >= 0; i-- {
+		db, err := ds.dbm.OpenDB(ds.messageInfos[i].FilePath)
+		if err != nil {
+			continue
+		}
+		rows, err := db.QueryContext(ctx, "SELECT name FROM sqlite_master WHERE type='table' AND name LIKE 'Msg_%'")
+		if err != nil {
+			return "", errors.QueryFailed("", err)
+		}
+		tables := make([]string, 0)
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err == nil {
+				tables = append(tables, name)
+			}
+		}
+		rows.Close()
+
+		for _, table := range tables {
+			var userName string
+			err := db.QueryRowContext(ctx, fmt.Sprintf(`
+				SELECT n.user_name FROM %s m
+				JOIN Name2Id n ON m.real_sender_id = n.rowid
+				WHERE m.status = 2 AND n.user_name != ''
+				ORDER BY m.sort_seq DESC LIMIT 1
+			`, table)).Scan(&userName)
+			if err == nil {
+				return userName, nil
+			}
+			if err != sql.ErrNoRows {
+				log.Debug().Err(err).Msgf("query self user name from %s failed", table)
+			}
+		}
+	}
+	return "", errors.ErrMessageNotFound
+}
+
+// sortedMessage 附带 sort_seq 的消息，用于跨分片定位
+type sortedMessage struct {
+	*model.Message
+	SortSeq int64
+}
+
+// queryMessages 在单个分片中按条件查询消息，表不存在时返回空结果
+func (ds *DataSource) queryMessages(ctx context.Context, db *sql.DB, tableName, talker, condition, order string, limit int, args ...interface{}) ([]sortedMessage, error) {
+	query := fmt.Sprintf(`
+		SELECT m.local_id, m.sort_seq, m.server_id, m.local_type, n.user_name, m.create_time, m.message_content, m.source, m.packed_info_data, m.status
+		FROM %s m
+		LEFT JOIN Name2Id n ON m.real_sender_id = n.rowid
+		WHERE %s
+	`, tableName, condition)
+	if order != "" {
+		query += " ORDER BY m.sort_seq " + order
+	}
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		if strings.Contains(err.Error(), "no such table") {
+			return nil, nil
+		}
+		return nil, errors.QueryFailed(query, err)
+	}
+	defer rows.Close()
+
+	msgs := make([]sortedMessage, 0)
+	for rows.Next() {
+		var msg model.MessageV4
+		if err := rows.Scan(
+			&msg.LocalID,
+			&msg.SortSeq,
+			&msg.ServerID,
+			&msg.LocalType,
+			&msg.UserName,
+			&msg.CreateTime,
+			&msg.MessageContent,
+			&msg.Source,
+			&msg.PackedInfoData,
+			&msg.Status,
+		); err != nil {
+			return nil, errors.ScanRowFailed(err)
+		}
+		msgs = append(msgs, sortedMessage{Message: msg.Wrap(talker), SortSeq: msg.SortSeq})
+	}
+	return msgs, nil
+}
+
 // 联系人
 func (ds *DataSource) GetContacts(ctx context.Context, key string, limit, offset int) ([]*model.Contact, error) {
 	var query string
@@ -1056,119 +1420,3 @@ func (ds *DataSource) ExecuteSQL(group, file, query string) ([]map[string]interf
 func (ds *DataSource) Close() error {
 	return ds.dbm.Close()
 }
-
-// GetSNSTimeline 获取朋友圈时间线数据
-func (ds *DataSource) GetSNSTimeline(ctx context.Context, username string, limit, offset int) ([]map[string]interface{}, error) {
-	db, err := ds.dbm.GetDB(SNS)
-	if err != nil {
-		return nil, err
-	}
-
-	var query string
-	var args []interface{}
-
-	if username != "" {
-		query = `SELECT tid, user_name, content, pack_info_buf FROM SnsTimeLine WHERE user_name = ? ORDER BY tid DESC`
-		args = []interface{}{username}
-	} else {
-		query = `SELECT tid, user_name, content, pack_info_buf FROM SnsTimeLine ORDER BY tid DESC`
-	}
-
-	// 添加分页
-	if limit > 0 {
-		query += fmt.Sprintf(" LIMIT %d", limit)
-		if offset > 0 {
-			query += fmt.Sprintf(" OFFSET %d", offset)
-		}
-	}
-
-	rows, err := db.QueryContext(ctx, query, args...)
-	if err != nil {
-		return nil, errors.QueryFailed(query, err)
-	}
-	defer rows.Close()
-
-	result := make([]map[string]interface{}, 0)
-	for rows.Next() {
-		var tid int64
-		var userName string
-		var content string
-		var packInfoBuf string
-
-		err := rows.Scan(&tid, &userName, &content, &packInfoBuf)
-		if err != nil {
-			return nil, errors.ScanRowFailed(err)
-		}
-
-		// 解析 XML 内容
-		parsedPost, err := model.ParseSNSContent(content)
-		if err != nil {
-			// 如果解析失败，返回原始数据
-			result = append(result, map[string]interface{}{
-				"tid":          tid,
-				"user_name":    userName,
-				"content":      content,
-				"pack_info_buf": packInfoBuf,
-				"parse_error":  err.Error(),
-			})
-			continue
-		}
-
-		// 转换为 map[string]interface{}
-		postMap := map[string]interface{}{
-			"tid":            tid,
-			"user_name":      userName,
-			"nickname":       parsedPost.NickName,
-			"create_time":    parsedPost.CreateTime,
-			"create_time_str": parsedPost.CreateTimeStr,
-			"content_desc":   parsedPost.ContentDesc,
-			"content_type":   parsedPost.ContentType,
-		}
-
-		if parsedPost.Location != nil {
-			postMap["location"] = parsedPost.Location
-		}
-
-		if len(parsedPost.MediaList) > 0 {
-			postMap["media_list"] = parsedPost.MediaList
-		}
-
-		if parsedPost.Article != nil {
-			postMap["article"] = parsedPost.Article
-		}
-
-		if parsedPost.FinderFeed != nil {
-			postMap["finder_feed"] = parsedPost.FinderFeed
-		}
-
-		result = append(result, postMap)
-	}
-
-	return result, nil
-}
-
-// GetSNSCount 统计朋友圈数量
-func (ds *DataSource) GetSNSCount(ctx context.Context, username string) (int, error) {
-	db, err := ds.dbm.GetDB(SNS)
-	if err != nil {
-		return 0, err
-	}
-
-	var query string
-	var args []interface{}
-
-	if username != "" {
-		query = `SELECT COUNT(*) FROM SnsTimeLine WHERE user_name = ?`
-		args = []interface{}{username}
-	} else {
-		query = `SELECT COUNT(*) FROM SnsTimeLine`
-	}
-
-	var count int
-	err = db.QueryRowContext(ctx, query, args...).Scan(&count)
-	if err != nil {
-		return 0, errors.QueryFailed(query, err)
-	}
-
-	return count, nil
-}
@@ -0,0 +1,395 @@
+package v4
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/errors"
+)
+
+// 微信自带的全文索引（db_storage/fts 下的 message_fts*.db）按会话记录了文本消息，在索引中查找比逐条解压正则匹配更快。
+// 索引表是 fts4/fts5 虚表，会话、local_id 与时间可能是虚表的 UNINDEXED 列，也可能在按 rowid 关联的普通表中；
+// 不同版本的表名与列名不同，因此按 sqlite_master 中的建表语句识别，按列名读取。
+// 优先在内容表（影子表或 content= 指定的外部表）中查找子串，结果与正则扫描一致；
+// 没有内容表时使用 MATCH，分词结果与子串匹配不同，只作为参考，不能代替扫描
+
+var (
+	ftsTextColumns       = []string{"acontent", "content", "message_content", "text"}
+	ftsTalkerColumns     = []string{"session_id", "talker", "session_name", "user_name", "username"}
+	ftsLocalIDColumns    = []string{"local_id", "message_local_id", "msg_local_id"}
+	ftsCreateTimeColumns = []string{"create_time", "msg_create_time", "timestamp"}
+
+	ftsVirtualTable = regexp.MustCompile(`(?is)^CREATE\s+VIRTUAL\s+TABLE\s+.*?\bUSING\s+(fts[345])\s*\((.*)\)\s*;?\s*$`)
+)
+
+// ftsHits 全文索引命中的文本消息，seqs 按 talker 记录命中消息的 Seq
+// covered 为各会话在索引中的时间范围，范围之外的消息没有建立索引，需要继续扫描
+// exact 表示结果按子串查找得到，与正则扫描一致，可以代替覆盖范围内文本消息的扫描
+type ftsHits struct {
+	seqs    map[string][]int64
+	covered map[string]ftsRange
+	exact   bool
+}
+
+// ftsRange 会话在全文索引中的最早与最新消息时间
+type ftsRange struct {
+	from  int64
+	until int64
+}
+
+// coveredRange 会话在索引中的时间范围，hits 为 nil 或会话不在索引中时返回 false
+func (h *ftsHits) coveredRange(talker string) (ftsRange, bool) {
+	if h == nil {
+		return ftsRange{}, false
+	}
+	r, ok := h.covered[talker]
+	return r, ok
+}
+
+// ftsTable 识别出的全文索引虚表
+type ftsTable struct {
+	name     string
+	module   string
+	columns  []string
+	content  string
+	meta     string
+	metaCols []string
+}
+
+// ftsLiteral 关键词是否为普通文本（不含正则语法），只有普通文本可以使用全文索引查找
+func ftsLiteral(keyword string) (string, bool) {
+	re, err := syntax.Parse(keyword, syntax.Perl)
+	if err != nil || re.Op != syntax.OpLiteral || re.Flags&syntax.FoldCase != 0 {
+		return "", false
+	}
+	return string(re.Rune), true
+}
+
+// searchFTS 在全文索引中查找包含关键词的文本消息，只返回 talkers 中的会话
+// 全文索引不存在或无法查询时返回错误，由调用方回退到正则扫描
+func (ds *DataSource) searchFTS(ctx context.Context, talkers []string, keyword string) (*ftsHits, error) {
+	dbPaths, err := ds.dbm.GetDBPath(FTS)
+	if err != nil {
+		return nil, err
+	}
+
+	want := make(map[string]bool, len(talkers))
+	for _, talker := range talkers {
+		want[talker] = true
+	}
+
+	hits := &ftsHits{seqs: make(map[string][]int64), covered: make(map[string]ftsRange), exact: true}
+	found := false
+	for _, path := range dbPaths {
+		db, err := ds.dbm.OpenDB(path)
+		if err != nil {
+			log.Debug().Err(err).Msgf("open fts db failed: %s", path)
+			continue
+		}
+		tables, err := ftsTables(ctx, db)
+		if err != nil {
+			log.Debug().Err(err).Msgf("read fts tables failed: %s", path)
+			continue
+		}
+
+		var names map[int64]string
+		resolve := func(talker interface{}) string {
+			switch v := talker.(type) {
+			case int64:
+				if names == nil {
+					names = ftsName2ID(ctx, db)
+				}
+				return names[v]
+			case []byte:
+				return string(v)
+			case string:
+				return v
+			}
+			return ""
+		}
+		for _, t := range tables {
+			exact, err := t.search(ctx, db, keyword,
+				func(talker interface{}, localID, createTime int64) {
+					if name := resolve(talker); want[name] {
+						hits.seqs[name] = append(hits.seqs[name], createTime*1000000+localID)
+					}
+				},
+				func(talker interface{}, from, until int64) {
+					name := resolve(talker)
+					if !want[name] {
+						return
+					}
+					// 多张索引表按时间分片时合并各表的范围
+					if r, ok := hits.covered[name]; ok {
+						from, until = min(from, r.from), max(until, r.until)
+					}
+					hits.covered[name] = ftsRange{from: from, until: until}
+				})
+			if err != nil {
+				log.Debug().Err(err).Msgf("search fts table %s failed: %s", t.name, path)
+				continue
+			}
+			hits.exact = hits.exact && exact
+			found = true
+		}
+	}
+	if !found {
+		return nil, errors.DBFileNotFound(ds.path, FTS, nil)
+	}
+	return hits, nil
+}
+
+// ftsTables 读取数据库中能够定位到消息（会话、local_id、时间）的全文索引虚表
+func ftsTables(ctx context.Context, db *sql.DB) ([]*ftsTable, error) {
+	query := `SELECT type, name, sql FROM sqlite_master WHERE sql IS NOT NULL`
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, errors.QueryFailed(query, err)
+	}
+	virtuals := make([]*ftsTable, 0)
+	ordinary := make([]string, 0)
+	for rows.Next() {
+		var typ, name, stmt string
+		if err := rows.Scan(&typ, &name, &stmt); err != nil {
+			rows.Close()
+			return nil, errors.ScanRowFailed(err)
+		}
+		if typ != "table" {
+			continue
+		}
+		if t := parseFTSTable(name, stmt); t != nil {
+			virtuals = append(virtuals, t)
+			continue
+		}
+		ordinary = append(ordinary, name)
+	}
+	rows.Close()
+
+	tables := make([]*ftsTable, 0, len(virtuals))
+	for _, t := range virtuals {
+		if t.find(ftsTextColumns) == "" {
+			continue
+		}
+		// 虚表中缺少的列从名称以虚表名开头的普通表中查找（排除 fts 的影子表）
+		for _, name := range ordinary {
+			if !strings.HasPrefix(name, t.name) || isFTSShadow(t.name, name) {
+				continue
+			}
+			cols, err := tableColumns(ctx, db, name)
+			if err != nil {
+				continue
+			}
+			t.meta, t.metaCols = name, cols
+			if t.find(ftsTalkerColumns) != "" && t.find(ftsLocalIDColumns) != "" && t.find(ftsCreateTimeColumns) != "" {
+				break
+			}
+			t.meta, t.metaCols = "", nil
+		}
+		if t.find(ftsTalkerColumns) == "" || t.find(ftsLocalIDColumns) == "" || t.find(ftsCreateTimeColumns) == "" {
+			continue
+		}
+		tables = append(tables, t)
+	}
+	return tables, nil
+}
+
+// parseFTSTable 从建表语句中解析全文索引虚表的列与 content= 选项，不是全文索引时返回 nil
+func parseFTSTable(name, stmt string) *ftsTable {
+	m := ftsVirtualTable.FindStringSubmatch(stmt)
+	if m == nil {
+		return nil
+	}
+	t := &ftsTable{name: name, module: strings.ToLower(m[1])}
+	for _, arg := range strings.Split(m[2], ",") {
+		arg = strings.TrimSpace(arg)
+		if k, v, ok := strings.Cut(arg, "="); ok {
+			if strings.EqualFold(strings.TrimSpace(k), "content") {
+				t.content = strings.Trim(strings.TrimSpace(v), `'"`)
+			}
+			continue
+		}
+		if fields := strings.Fields(arg); len(fields) > 0 {
+			t.columns = append(t.columns, strings.ToLower(strings.Trim(fields[0], "`\"[]")))
+		}
+	}
+	return t
+}
+
+// isFTSShadow 是否为全文索引虚表的影子表
+func isFTSShadow(table, name string) bool {
+	switch strings.TrimPrefix(name, table) {
+	case "_content", "_data", "_idx", "_docsize", "_config", "_segments", "_segdir", "_stat":
+		return true
+	}
+	return false
+}
+
+// find 返回候选列名中第一个存在于虚表或关联表中的列
+func (t *ftsTable) find(candidates []string) string {
+	for _, col := range candidates {
+		if indexOf(t.columns, col) >= 0 || indexOf(t.metaCols, col) >= 0 {
+			return col
+		}
+	}
+	return ""
+}
+
+// expr 列在查询中的表达式，shadow 为 true 时从内容表读取虚表的列
+func (t *ftsTable) expr(col string, shadow bool) string {
+	i := indexOf(t.columns, col)
+	if i < 0 {
+		return quoteIdent(t.meta) + "." + quoteIdent(col)
+	}
+	switch {
+	case !shadow:
+		return quoteIdent(t.name) + "." + quoteIdent(col)
+	case t.content != "":
+		return quoteIdent(t.content) + "." + quoteIdent(col)
+	case t.module == "fts5":
+		return quoteIdent(t.name+"_content") + "." + quoteIdent(fmt.Sprintf("c%d", i))
+	default:
+		return quoteIdent(t.name+"_content") + "." + quoteIdent(fmt.Sprintf("c%d%s", i, col))
+	}
+}
+
+// from 查询的 FROM 子句，shadow 为 true 时从内容表读取
+func (t *ftsTable) from(shadow bool) string {
+	src, rowid := quoteIdent(t.name), quoteIdent(t.name)+".rowid"
+	if shadow {
+		switch {
+		case t.content != "":
+			src, rowid = quoteIdent(t.content), quoteIdent(t.content)+".rowid"
+		case t.module == "fts5":
+			src = quoteIdent(t.name + "_content")
+			rowid = src + ".id"
+		default:
+			src = quoteIdent(t.name + "_content")
+			rowid = src + ".docid"
+		}
+	}
+	if t.meta == "" {
+		return src
+	}
+	return fmt.Sprintf("%s LEFT JOIN %s ON %s.rowid = %s", src, quoteIdent(t.meta), quoteIdent(t.meta), rowid)
+}
+
+// search 查找包含关键词的消息，先在内容表中查找子串（exact 为 true），没有内容表时使用 MATCH
+// 子串查找时通过 cover 返回各会话在索引中的时间范围
+func (t *ftsTable) search(ctx context.Context, db *sql.DB, keyword string,
+	hit func(talker interface{}, localID, createTime int64), cover func(talker interface{}, from, until int64)) (bool, error) {
+	talker, localID, createTime := t.find(ftsTalkerColumns), t.find(ftsLocalIDColumns), t.find(ftsCreateTimeColumns)
+
+	var lastErr error
+	for _, shadow := range []bool{true, false} {
+		cond, arg := quoteIdent(t.name)+" MATCH ?", `"`+strings.ReplaceAll(keyword, `"`, `""`)+`"`
+		if shadow {
+			cond, arg = "instr("+t.expr(t.find(ftsTextColumns), true)+", ?) > 0", keyword
+		}
+
+		query := fmt.Sprintf("SELECT %s, %s, %s FROM %s WHERE %s",
+			t.expr(talker, shadow), t.expr(localID, shadow), t.expr(createTime, shadow), t.from(shadow), cond)
+		rows, err := db.QueryContext(ctx, query, arg)
+		if err != nil {
+			lastErr = errors.QueryFailed(query, err)
+			continue
+		}
+		for rows.Next() {
+			var talker interface{}
+			var localID, createTime sql.NullInt64
+			if err := rows.Scan(&talker, &localID, &createTime); err != nil {
+				rows.Close()
+				return false, errors.ScanRowFailed(err)
+			}
+			if localID.Valid && createTime.Valid {
+				hit(talker, localID.Int64, createTime.Int64)
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return false, errors.QueryFailed(query, err)
+		}
+		if !shadow {
+			return false, nil
+		}
+
+		query = fmt.Sprintf("SELECT %s, min(%s), max(%s) FROM %s GROUP BY 1",
+			t.expr(talker, true), t.expr(createTime, true), t.expr(createTime, true), t.from(true))
+		rows, err = db.QueryContext(ctx, query)
+		if err != nil {
+			return false, errors.QueryFailed(query, err)
+		}
+		for rows.Next() {
+			var talker interface{}
+			var from, until sql.NullInt64
+			if err := rows.Scan(&talker, &from, &until); err != nil {
+				rows.Close()
+				return false, errors.ScanRowFailed(err)
+			}
+			if from.Valid && until.Valid {
+				cover(talker, from.Int64, until.Int64)
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return false, errors.QueryFailed(query, err)
+		}
+		return true, nil
+	}
+	return false, lastErr
+}
+
+// ftsName2ID 全文索引中会话列为数字时，按 Name2Id 表转换为会话名
+func ftsName2ID(ctx context.Context, db *sql.DB) map[int64]string {
+	names := make(map[int64]string)
+	rows, err := db.QueryContext(ctx, `SELECT rowid, user_name FROM Name2Id`)
+	if err != nil {
+		return names
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err == nil {
+			names[id] = name
+		}
+	}
+	return names
+}
+
+// tableColumns 普通表的列名（小写）
+func tableColumns(ctx context.Context, db *sql.DB, table string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT * FROM "+quoteIdent(table)+" LIMIT 0")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	for i := range cols {
+		cols[i] = strings.ToLower(cols[i])
+	}
+	return cols, nil
+}
+
+func indexOf(list []string, s string) int {
+	for i, v := range list {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,276 @@
+package v4
+
+import (
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func execSQL(t *testing.T, path string, stmts ...string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+}
+
+func hasFTS5() bool {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		return false
+	}
+	defer db.Close()
+	_, err = db.Exec("CREATE VIRTUAL TABLE t USING fts5(a)")
+	return err == nil
+}
+
+// newFTSDataSource 创建包含会话 wxid_a、wxid_b 文本消息的数据目录，fts 为全文索引库的建表与数据语句
+func newFTSDataSource(t *testing.T, fts ...string) *DataSource {
+	t.Helper()
+	dir := t.TempDir()
+	stmts := []string{
+		"CREATE TABLE Timestamp(timestamp INTEGER)", "INSERT INTO Timestamp VALUES(1000)",
+		"CREATE TABLE Name2Id(user_name TEXT)", "INSERT INTO Name2Id VALUES('wxid_a')", "INSERT INTO Name2Id VALUES('wxid_b')",
+	}
+	messages := map[string][]string{
+		"wxid_a": {"(1,1,1,1,1,2000,'你好世界','',NULL,0)", "(2,2,2,1,1,2001,'世界和平','',NULL,0)", "(3,3,3,1,1,2002,'晚安','',NULL,0)", "(4,4,4,1,1,3000,'新的世界','',NULL,0)"},
+		"wxid_b": {"(1,1,5,1,2,2000,'世界很大','',NULL,0)"},
+	}
+	for talker, rows := range messages {
+		sum := md5.Sum([]byte(talker))
+		table := "Msg_" + hex.EncodeToString(sum[:])
+		stmts = append(stmts, "CREATE TABLE "+table+"(local_id INTEGER, sort_seq INTEGER, server_id INTEGER, local_type INTEGER, real_sender_id INTEGER, create_time INTEGER, message_content TEXT, source TEXT, packed_info_data BLOB, status INTEGER)")
+		for _, row := range rows {
+			stmts = append(stmts, "INSERT INTO "+table+" VALUES"+row)
+		}
+	}
+	execSQL(t, filepath.Join(dir, "db_storage", "message", "message_0.db"), stmts...)
+	execSQL(t, filepath.Join(dir, "db_storage", "fts", "message_fts.db"), fts...)
+
+	ds, err := New(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ds
+}
+
+// wxid_a 的前三条消息已建立索引，第四条与 wxid_b 的消息还没有建立索引
+var ftsRows = [][]string{
+	{"你好世界", "wxid_a", "1", "2000"},
+	{"世界和平", "wxid_a", "2", "2001"},
+	{"晚安", "wxid_a", "3", "2002"},
+}
+
+func ftsInserts(table, cols string, values func(i int, row []string) string) []string {
+	stmts := make([]string, 0, len(ftsRows))
+	for i, row := range ftsRows {
+		stmts = append(stmts, "INSERT INTO "+table+"("+cols+") VALUES("+values(i, row)+")")
+	}
+	return stmts
+}
+
+func TestSearchFTS(t *testing.T) {
+	inline := func(i int, row []string) string {
+		return "'" + row[0] + "','" + row[1] + "'," + row[2] + "," + row[3]
+	}
+	withRowid := func(i int, row []string) string {
+		return string(rune('1'+i)) + ",'" + row[0] + "','" + row[1] + "'," + row[2] + "," + row[3]
+	}
+	// 会话以 Name2Id 的 rowid 记录在关联表中
+	meta := func(i int, row []string) string {
+		return string(rune('1'+i)) + ",1," + row[2] + "," + row[3]
+	}
+	text := func(i int, row []string) string {
+		return string(rune('1'+i)) + ",'" + row[0] + "'"
+	}
+
+	tests := []struct {
+		name      string
+		fts5      bool
+		stmts     []string
+		keyword   string
+		wantSeqs  []int64
+		wantExact bool
+	}{
+		{
+			name: "fts4",
+			stmts: append([]string{
+				"CREATE VIRTUAL TABLE msg_fts USING fts4(acontent, session_id, local_id, create_time)",
+			}, ftsInserts("msg_fts", "acontent, session_id, local_id, create_time", inline)...),
+			keyword:   "世界",
+			wantSeqs:  []int64{2000000001, 2001000002},
+			wantExact: true,
+		},
+		{
+			name: "fts4 with content table",
+			stmts: append([]string{
+				"CREATE TABLE msg(acontent TEXT, session_id TEXT, local_id INTEGER, create_time INTEGER)",
+				"CREATE VIRTUAL TABLE msg_fts USING fts4(content=\"msg\", acontent, session_id, local_id, create_time)",
+			}, append(ftsInserts("msg", "rowid, acontent, session_id, local_id, create_time", withRowid),
+				"INSERT INTO msg_fts(msg_fts) VALUES('rebuild')")...),
+			keyword:   "世界",
+			wantSeqs:  []int64{2000000001, 2001000002},
+			wantExact: true,
+		},
+		{
+			name: "fts4 with meta table",
+			stmts: append(append([]string{
+				"CREATE TABLE Name2Id(user_name TEXT)", "INSERT INTO Name2Id VALUES('wxid_a')",
+				"CREATE VIRTUAL TABLE msg_fts USING fts4(acontent)",
+				"CREATE TABLE msg_fts_meta(session_id INTEGER, local_id INTEGER, create_time INTEGER)",
+			}, ftsInserts("msg_fts", "rowid, acontent", text)...),
+				ftsInserts("msg_fts_meta", "rowid, session_id, local_id, create_time", meta)...),
+			keyword:   "世界",
+			wantSeqs:  []int64{2000000001, 2001000002},
+			wantExact: true,
+		},
+		{
+			// 没有内容表时只能 MATCH，按分词匹配整词
+			name: "contentless fts4 with meta table uses match",
+			stmts: append([]string{
+				"CREATE TABLE Name2Id(user_name TEXT)", "INSERT INTO Name2Id VALUES('wxid_a')",
+				"CREATE VIRTUAL TABLE msg_fts USING fts4(content=\"\", acontent)",
+				"CREATE TABLE msg_fts_meta(session_id INTEGER, local_id INTEGER, create_time INTEGER)",
+				"INSERT INTO msg_fts(docid, acontent) VALUES(1, 'hello world'), (2, 'worldwide'), (3, 'good night')",
+			}, ftsInserts("msg_fts_meta", "rowid, session_id, local_id, create_time", meta)...),
+			keyword:   "world",
+			wantSeqs:  []int64{2000000001},
+			wantExact: false,
+		},
+		{
+			// 使用自定义分词器的虚表无法查询，在影子表中查找子串
+			name: "fts5 with custom tokenizer",
+			stmts: append(append([]string{
+				"CREATE TABLE Name2Id(user_name TEXT)", "INSERT INTO Name2Id VALUES('wxid_a')",
+				"CREATE TABLE msg_fts_content(id INTEGER PRIMARY KEY, c0)",
+				"CREATE TABLE msg_fts_meta(session_id INTEGER, local_id INTEGER, create_time INTEGER)",
+			}, append(ftsInserts("msg_fts_content", "id, c0", text),
+				ftsInserts("msg_fts_meta", "rowid, session_id, local_id, create_time", meta)...)...),
+				"PRAGMA writable_schema=ON",
+				"INSERT INTO sqlite_master VALUES('table','msg_fts','msg_fts',0,'CREATE VIRTUAL TABLE msg_fts USING fts5(acontent, tokenize=''MMTokenizer'')')",
+			),
+			keyword:   "世界",
+			wantSeqs:  []int64{2000000001, 2001000002},
+			wantExact: true,
+		},
+		{
+			name: "fts5 with content table and custom tokenizer",
+			stmts: append(append([]string{
+				"CREATE TABLE msg(acontent TEXT, session_id TEXT, local_id INTEGER, create_time INTEGER)",
+			}, ftsInserts("msg", "rowid, acontent, session_id, local_id, create_time", withRowid)...),
+				"PRAGMA writable_schema=ON",
+				"INSERT INTO sqlite_master VALUES('table','msg_fts','msg_fts',0,'CREATE VIRTUAL TABLE msg_fts USING fts5(acontent, session_id UNINDEXED, local_id UNINDEXED, create_time UNINDEXED, content=''msg'', tokenize=''MMTokenizer'')')",
+			),
+			keyword:   "世界",
+			wantSeqs:  []int64{2000000001, 2001000002},
+			wantExact: true,
+		},
+		{
+			name: "fts5",
+			fts5: true,
+			stmts: append([]string{
+				"CREATE VIRTUAL TABLE msg_fts USING fts5(acontent, session_id UNINDEXED, local_id UNINDEXED, create_time UNINDEXED)",
+			}, ftsInserts("msg_fts", "acontent, session_id, local_id, create_time", inline)...),
+			keyword:   "世界",
+			wantSeqs:  []int64{2000000001, 2001000002},
+			wantExact: true,
+		},
+		{
+			name: "fts5 with content table",
+			fts5: true,
+			stmts: append([]string{
+				"CREATE TABLE msg(acontent TEXT, session_id TEXT, local_id INTEGER, create_time INTEGER)",
+				"CREATE VIRTUAL TABLE msg_fts USING fts5(acontent, session_id UNINDEXED, local_id UNINDEXED, create_time UNINDEXED, content='msg')",
+			}, append(ftsInserts("msg", "rowid, acontent, session_id, local_id, create_time", withRowid),
+				"INSERT INTO msg_fts(msg_fts) VALUES('rebuild')")...),
+			keyword:   "世界",
+			wantSeqs:  []int64{2000000001, 2001000002},
+			wantExact: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.fts5 && !hasFTS5() {
+				t.Skip("sqlite3 built without fts5")
+			}
+			ds := newFTSDataSource(t, tt.stmts...)
+			hits, err := ds.searchFTS(context.Background(), []string{"wxid_a", "wxid_b"}, tt.keyword)
+			if err != nil {
+				t.Fatalf("searchFTS() error = %v", err)
+			}
+			got := hits.seqs["wxid_a"]
+			sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+			if !reflect.DeepEqual(got, tt.wantSeqs) {
+				t.Errorf("searchFTS() seqs = %v, want %v", got, tt.wantSeqs)
+			}
+			if len(hits.seqs["wxid_b"]) != 0 {
+				t.Errorf("searchFTS() wxid_b seqs = %v, want none", hits.seqs["wxid_b"])
+			}
+			if hits.exact != tt.wantExact {
+				t.Errorf("searchFTS() exact = %v, want %v", hits.exact, tt.wantExact)
+			}
+			if !tt.wantExact {
+				return
+			}
+			if r, ok := hits.covered["wxid_a"]; !ok || r.from != 2000 || r.until != 2002 {
+				t.Errorf("searchFTS() wxid_a covered = %+v, want 2000-2002", r)
+			}
+			if _, ok := hits.covered["wxid_b"]; ok {
+				t.Error("searchFTS() wxid_b should not be covered")
+			}
+		})
+	}
+}
+
+// TestGetMessagesFTS 全文索引只代替覆盖范围内文本消息的扫描，索引之外的会话与新消息仍按正则匹配
+func TestGetMessagesFTS(t *testing.T) {
+	ds := newFTSDataSource(t, append([]string{
+		"CREATE VIRTUAL TABLE msg_fts USING fts4(acontent, session_id, local_id, create_time)",
+	}, ftsInserts("msg_fts", "acontent, session_id, local_id, create_time", func(i int, row []string) string {
+		return "'" + row[0] + "','" + row[1] + "'," + row[2] + "," + row[3]
+	})...)...)
+
+	tests := []struct {
+		name    string
+		talker  string
+		keyword string
+		want    []string
+	}{
+		{name: "substring inside token", talker: "wxid_a", keyword: "世界", want: []string{"你好世界", "世界和平", "新的世界"}},
+		{name: "talker not in index", talker: "wxid_b", keyword: "世界", want: []string{"世界很大"}},
+		{name: "regexp keyword", talker: "wxid_a", keyword: "^世界", want: []string{"世界和平"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, err := ds.GetMessages(context.Background(), time.Unix(0, 0), time.Unix(5000, 0), tt.talker, "", tt.keyword, 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, 0, len(messages))
+			for _, msg := range messages {
+				got = append(got, msg.Content)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetMessages(%q) = %v, want %v", tt.keyword, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,333 @@
+package v4
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
+)
+
+// SnsTimeLine 表只有 tid、user_name、content、pack_info_buf 字段，发布时间在 content 的 XML 中。
+// tid 随发布时间递增，没有时间范围时直接在 SQL 中分页；有时间范围时按 tid 倒序分批读取解析，
+// 遇到早于 startTime 的帖子即停止
+
+// snsBatchSize 按时间范围查询时每批读取的帖子数量
+const snsBatchSize = 200
+
+// GetSNSTimeline 获取朋友圈时间线数据，按发布时间倒序，startTime/endTime 为零值时不限制
+func (ds *DataSource) GetSNSTimeline(ctx context.Context, username string, startTime, endTime time.Time, limit, offset int) ([]*model.SNSPost, error) {
+	db, err := ds.dbm.GetDB(SNS)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT tid, user_name, content FROM SnsTimeLine`
+	args := []interface{}{}
+	if username != "" {
+		query += ` WHERE user_name = ?`
+		args = append(args, username)
+	}
+	query += ` ORDER BY tid DESC`
+
+	if startTime.IsZero() && endTime.IsZero() {
+		if limit > 0 {
+			query += fmt.Sprintf(` LIMIT %d OFFSET %d`, limit, offset)
+		} else if offset > 0 {
+			query += fmt.Sprintf(` LIMIT -1 OFFSET %d`, offset)
+		}
+		posts, err := ds.querySNSPosts(ctx, db, query, args...)
+		if err != nil {
+			return nil, err
+		}
+		ds.loadSNSInteractions(ctx, db, posts)
+		return posts, nil
+	}
+
+	filtered := make([]*model.SNSPost, 0)
+	skipped := 0
+	for batch := 0; ; batch += snsBatchSize {
+		posts, err := ds.querySNSPosts(ctx, db, query+fmt.Sprintf(` LIMIT %d OFFSET %d`, snsBatchSize, batch), args...)
+		if err != nil {
+			return nil, err
+		}
+		done := len(posts) < snsBatchSize
+		for _, post := range posts {
+			// 解析失败的帖子没有发布时间，不在任何时间范围内
+			if post.CreateTime == 0 {
+				continue
+			}
+			if !endTime.IsZero() && post.Time().After(endTime) {
+				continue
+			}
+			if !startTime.IsZero() && post.Time().Before(startTime) {
+				done = true
+				break
+			}
+			if skipped < offset {
+				skipped++
+				continue
+			}
+			filtered = append(filtered, post)
+			if limit > 0 && len(filtered) >= limit {
+				done = true
+				break
+			}
+		}
+		if done {
+			break
+		}
+	}
+
+	ds.loadSNSInteractions(ctx, db, filtered)
+	return filtered, nil
+}
+
+// GetSNSPost 获取单条朋友圈
+func (ds *DataSource) GetSNSPost(ctx context.Context, tid int64) (*model.SNSPost, error) {
+	db, err := ds.dbm.GetDB(SNS)
+	if err != nil {
+		return nil, err
+	}
+
+	posts, err := ds.querySNSPosts(ctx, db, `SELECT tid, user_name, content FROM SnsTimeLine WHERE tid = ?`, tid)
+	if err != nil {
+		return nil, err
+	}
+	if len(posts) == 0 {
+		return nil, errors.ErrSNSNotFound
+	}
+	ds.loadSNSInteractions(ctx, db, posts)
+	return posts[0], nil
+}
+
+func (ds *DataSource) querySNSPosts(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]*model.SNSPost, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.QueryFailed(query, err)
+	}
+	defer rows.Close()
+
+	posts := make([]*model.SNSPost, 0)
+	for rows.Next() {
+		var tid int64
+		var userName string
+		var content string
+		if err := rows.Scan(&tid, &userName, &content); err != nil {
+			return nil, errors.ScanRowFailed(err)
+		}
+
+		post, err := model.ParseSNSContent(content)
+		if err != nil {
+			// 解析失败时保留原始 XML
+			log.Debug().Err(err).Msgf("parse sns content failed: %d", tid)
+			post = &model.SNSPost{ContentType: "text", XMLContent: content}
+		}
+		post.TID = tid
+		if post.UserName == "" {
+			post.UserName = userName
+		}
+		posts = append(posts, post)
+	}
+	return posts, nil
+}
+
+// snsInteractionColumns 互动表中可能出现的字段名，不同版本的表结构略有差异
+var snsInteractionColumns = map[string][]string{
+	"feed":      {"feed_id", "feedid", "tid", "sns_id"},
+	"id":        {"comment_id", "commentid"},
+	"type":      {"type", "comment_type"},
+	"user":      {"from_username", "from_user_name", "username", "user_name"},
+	"nick":      {"from_nickname", "from_nick_name", "nickname"},
+	"reply":     {"to_username", "to_user_name", "reply_username"},
+	"replyNick": {"to_nickname", "to_nick_name", "reply_nickname"},
+	"content":   {"content", "comment"},
+	"time":      {"create_time", "createtime"},
+}
+
+// loadSNSInteractions 从 SnsMessage 开头的互动表中读取点赞与评论，type 为 1 的记录为点赞
+// 互动表只记录与自己相关的互动（自己的帖子收到的、自己参与过的），并非全部好友的互动
+func (ds *DataSource) loadSNSInteractions(ctx context.Context, db *sql.DB, posts []*model.SNSPost) {
+	if len(posts) == 0 {
+		return
+	}
+	byTID := make(map[int64]*model.SNSPost, len(posts))
+	tids := make([]interface{}, 0, len(posts))
+	for _, post := range posts {
+		byTID[post.TID] = post
+		tids = append(tids, post.TID)
+	}
+
+	tables, err := snsInteractionTables(ctx, db)
+	if err != nil {
+		log.Debug().Err(err).Msg("get sns interaction tables failed")
+		return
+	}
+
+	seen := make(map[string]bool)
+	for _, table := range tables {
+		cols, err := snsTableColumns(ctx, db, table)
+		if err != nil {
+			log.Debug().Err(err).Msgf("get columns failed: %s", table)
+			continue
+		}
+		if cols["feed"] == "" || cols["user"] == "" {
+			continue
+		}
+
+		fields := make([]string, 0, len(snsInteractionColumns))
+		for _, key := range []string{"feed", "id", "type", "user", "nick", "reply", "replyNick", "content", "time"} {
+			zero := "''"
+			if key == "feed" || key == "time" {
+				zero = "0"
+			}
+			if cols[key] == "" {
+				fields = append(fields, zero)
+				continue
+			}
+			fields = append(fields, fmt.Sprintf("IFNULL(%s, %s)", cols[key], zero))
+		}
+
+		for i := 0; i < len(tids); i += 500 {
+			batch := tids[i:min(i+500, len(tids))]
+			query := fmt.Sprintf(`SELECT %s FROM "%s" WHERE %s IN (%s)`,
+				strings.Join(fields, ", "), table, cols["feed"], strings.TrimSuffix(strings.Repeat("?,", len(batch)), ","))
+			if cols["time"] != "" {
+				query += " ORDER BY " + cols["time"]
+			}
+			if err := scanSNSInteractions(ctx, db, query, batch, byTID, seen); err != nil {
+				log.Debug().Err(err).Msgf("load sns interactions failed: %s", table)
+				break
+			}
+		}
+	}
+}
+
+func scanSNSInteractions(ctx context.Context, db *sql.DB, query string, args []interface{}, byTID map[int64]*model.SNSPost, seen map[string]bool) error {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return errors.QueryFailed(query, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var feedID, createTime int64
+		var id, _type, user, nick, reply, replyNick, content string
+		if err := rows.Scan(&feedID, &id, &_type, &user, &nick, &reply, &replyNick, &content, &createTime); err != nil {
+			return errors.ScanRowFailed(err)
+		}
+		post, ok := byTID[feedID]
+		if !ok || user == "" {
+			continue
+		}
+		key := fmt.Sprintf("%d|%s|%s|%d|%s", feedID, _type, user, createTime, content)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		if _type == "1" {
+			post.Likes = append(post.Likes, model.SNSLike{
+				UserName:   user,
+				NickName:   nick,
+				CreateTime: createTime,
+			})
+			continue
+		}
+		comment := model.SNSComment{
+			ID:         id,
+			UserName:   user,
+			NickName:   nick,
+			Content:    content,
+			CreateTime: createTime,
+		}
+		// 回复帖子作者本身不算回复
+		if reply != post.UserName {
+			comment.ReplyTo, comment.ReplyToName = reply, replyNick
+		}
+		post.Comments = append(post.Comments, comment)
+	}
+	return rows.Err()
+}
+
+func snsInteractionTables(ctx context.Context, db *sql.DB) ([]string, error) {
+	query := `SELECT name FROM sqlite_master WHERE type='table' AND name LIKE 'SnsMessage%'`
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, errors.QueryFailed(query, err)
+	}
+	defer rows.Close()
+
+	tables := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, errors.ScanRowFailed(err)
+		}
+		tables = append(tables, name)
+	}
+	return tables, nil
+}
+
+// snsTableColumns 按 snsInteractionColumns 识别表中的字段
+func snsTableColumns(ctx context.Context, db *sql.DB, table string) (map[string]string, error) {
+	query := fmt.Sprintf(`PRAGMA table_info("%s")`, table)
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, errors.QueryFailed(query, err)
+	}
+	defer rows.Close()
+
+	names := make(map[string]string)
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, _type string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &_type, &notNull, &dflt, &pk); err != nil {
+			return nil, errors.ScanRowFailed(err)
+		}
+		names[strings.ToLower(name)] = name
+	}
+
+	cols := make(map[string]string, len(snsInteractionColumns))
+	for key, candidates := range snsInteractionColumns {
+		for _, candidate := range candidates {
+			if name, ok := names[candidate]; ok {
+				cols[key] = name
+				break
+			}
+		}
+	}
+	return cols, nil
+}
+
+// GetSNSCount 统计朋友圈数量
+func (ds *DataSource) GetSNSCount(ctx context.Context, username string) (int, error) {
+	db, err := ds.dbm.GetDB(SNS)
+	if err != nil {
+		return 0, err
+	}
+
+	var query string
+	var args []interface{}
+
+	if username != "" {
+		query = `SELECT COUNT(*) FROM SnsTimeLine WHERE user_name = ?`
+		args = []interface{}{username}
+	} else {
+		query = `SELECT COUNT(*) FROM SnsTimeLine`
+	}
+
+	var count int
+	err = db.QueryRowContext(ctx, query, args...).Scan(&count)
+	if err != nil {
+		return 0, errors.QueryFailed(query, err)
+	}
+
+	return count, nil
+}
@@ -0,0 +1,68 @@
+package v4
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetSNSTimeline(t *testing.T) {
+	dir := t.TempDir()
+	execSQL(t, filepath.Join(dir, "db_storage", "message", "message_0.db"),
+		"CREATE TABLE Timestamp(timestamp INTEGER)", "INSERT INTO Timestamp VALUES(1000)")
+
+	// tid 1..500 按小时递增发布，tid 250 的内容无法解析
+	stmts := []string{"CREATE TABLE SnsTimeLine(tid INTEGER PRIMARY KEY, user_name TEXT, content TEXT, pack_info_buf BLOB)"}
+	base := int64(1700000000)
+	for tid := 1; tid <= 500; tid++ {
+		content := fmt.Sprintf("<TimelineObject><id>%d</id><username>alice</username><createTime>%d</createTime><contentDesc>post %d</contentDesc></TimelineObject>", tid, base+int64(tid)*3600, tid)
+		if tid == 250 {
+			content = "<TimelineObject"
+		}
+		stmts = append(stmts, fmt.Sprintf("INSERT INTO SnsTimeLine VALUES(%d, 'alice', '%s', NULL)", tid, content))
+	}
+	execSQL(t, filepath.Join(dir, "db_storage", "sns", "sns.db"), stmts...)
+
+	ds, err := New(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(tid int64) time.Time { return time.Unix(base+tid*3600, 0) }
+
+	tests := []struct {
+		name   string
+		start  time.Time
+		end    time.Time
+		limit  int
+		offset int
+		want   []int64
+	}{
+		{name: "first page", limit: 3, want: []int64{500, 499, 498}},
+		{name: "second page", limit: 3, offset: 3, want: []int64{497, 496, 495}},
+		{name: "offset only", offset: 498, want: []int64{2, 1}},
+		{name: "time range", start: at(10), end: at(12), want: []int64{12, 11, 10}},
+		{name: "time range page", start: at(10), end: at(20), limit: 2, offset: 2, want: []int64{18, 17}},
+		{name: "time range across batches", start: at(248), end: at(252), want: []int64{252, 251, 249, 248}},
+		{name: "start only", start: at(499), want: []int64{500, 499}},
+		{name: "end only", end: at(2), limit: 5, want: []int64{2, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			posts, err := ds.GetSNSTimeline(context.Background(), "", tt.start, tt.end, tt.limit, tt.offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]int64, 0, len(posts))
+			for _, post := range posts {
+				got = append(got, post.TID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetSNSTimeline() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
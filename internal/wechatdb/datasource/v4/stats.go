@@ -0,0 +1,300 @@
+package v4
+
+import (
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
+	"github.com/sjzar/chatlog/pkg/util"
+)
+
+// 超过该间隔的接话不计入平均回复时间
+const replyLatencyWindow = 6 * 60 * 60
+
+// GetChatStats 使用 SQL 聚合统计对话数据，避免将全部消息加载到内存
+func (ds *DataSource) GetChatStats(ctx context.Context, startTime, endTime time.Time, talker string) (*model.ChatStats, error) {
+	if talker == "" {
+		return nil, errors.ErrTalkerEmpty
+	}
+	talkers := util.Str2List(talker, ",")
+	if len(talkers) == 0 {
+		return nil, errors.ErrTalkerEmpty
+	}
+
+	dbInfos := ds.getDBInfosForTimeRange(startTime, endTime)
+	if len(dbInfos) == 0 {
+		return nil, errors.TimeRangeNotFound(startTime, endTime)
+	}
+
+	acc := newStatsAccumulator()
+	for _, dbInfo := range dbInfos {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		db, err := ds.dbm.OpenDB(dbInfo.FilePath)
+		if err != nil {
+			log.Error().Msgf("数据库 %s 未打开", dbInfo.FilePath)
+			continue
+		}
+		for _, talkerItem := range talkers {
+			_talkerMd5Bytes := md5.Sum([]byte(talkerItem))
+			tableName := "Msg_" + hex.EncodeToString(_talkerMd5Bytes[:])
+			if err := acc.collect(ctx, db, tableName, talkerItem, startTime.Unix(), endTime.Unix()); err != nil {
+				if strings.Contains(err.Error(), "no such table") {
+					continue
+				}
+				return nil, errors.QueryFailed("", err)
+			}
+		}
+	}
+
+	stats := acc.result()
+	stats.Talker = talker
+	stats.Start = startTime
+	stats.End = endTime
+	return stats, nil
+}
+
+type statsAccumulator struct {
+	total   int64
+	first   int64
+	last    int64
+	byDay   map[string]int64
+	byHour  [7][24]int64
+	byType  map[[2]int64]int64
+	media   map[string]int64
+	domains map[string]int64
+	senders map[string]*model.SenderStats
+	replies map[string]int64 // 回复间隔总和
+}
+
+func newStatsAccumulator() *statsAccumulator {
+	return &statsAccumulator{
+		byDay:   make(map[string]int64),
+		byType:  make(map[[2]int64]int64),
+		media:   make(map[string]int64),
+		domains: make(map[string]int64),
+		senders: make(map[string]*model.SenderStats),
+		replies: make(map[string]int64),
+	}
+}
+
+func (a *statsAccumulator) sender(name string) *model.SenderStats {
+	s, ok := a.senders[name]
+	if !ok {
+		s = &model.SenderStats{Sender: name}
+		a.senders[name] = s
+	}
+	return s
+}
+
+func (a *statsAccumulator) collect(ctx context.Context, db *sql.DB, tableName, talker string, start, end int64) error {
+	where := "m.create_time >= ? AND m.create_time <= ?"
+
+	// 发送者 × 消息类型
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT IFNULL(n.user_name, ''), m.local_type, COUNT(*), MIN(m.create_time), MAX(m.create_time)
+		FROM %s m
+		LEFT JOIN Name2Id n ON m.real_sender_id = n.rowid
+		WHERE %s
+		GROUP BY m.real_sender_id, m.local_type
+	`, tableName, where), start, end)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var name string
+		var localType, count, first, last int64
+		if err := rows.Scan(&name, &localType, &count, &first, &last); err != nil {
+			rows.Close()
+			return err
+		}
+		_type, subType := util.SplitInt64ToTwoInt32(localType)
+		if _type == model.MessageTypeSystem {
+			name = "系统消息"
+		}
+		a.total += count
+		a.byType[[2]int64{_type, subType}] += count
+		if a.first == 0 || first < a.first {
+			a.first = first
+		}
+		if last > a.last {
+			a.last = last
+		}
+
+		s := a.sender(name)
+		s.Count += count
+		if s.FirstMessage.IsZero() || first < s.FirstMessage.Unix() {
+			s.FirstMessage = time.Unix(first, 0)
+		}
+		if last > s.LastMessage.Unix() {
+			s.LastMessage = time.Unix(last, 0)
+		}
+		if mediaType := model.MediaTypeName(_type, subType); mediaType != "" {
+			a.media[mediaType] += count
+			s.MediaCount += count
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// 按天与星期小时分布
+	rows, err = db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT strftime('%%Y-%%m-%%d %%w %%H', m.create_time, 'unixepoch', 'localtime') AS k, COUNT(*)
+		FROM %s m
+		WHERE %s
+		GROUP BY k
+	`, tableName, where), start, end)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var key string
+		var count int64
+		if err := rows.Scan(&key, &count); err != nil {
+			rows.Close()
+			return err
+		}
+		parts := strings.Split(key, " ")
+		if len(parts) != 3 {
+			continue
+		}
+		a.byDay[parts[0]] += count
+		weekday, _ := strconv.Atoi(parts[1])
+		hour, _ := strconv.Atoi(parts[2])
+		if weekday >= 0 && weekday < 7 && hour >= 0 && hour < 24 {
+			a.byHour[weekday][hour] += count
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// 回复间隔：与上一条消息发送者不同时，计为一次接话
+	rows, err = db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT IFNULL(n.user_name, ''), SUM(t.d), COUNT(*)
+		FROM (
+			SELECT m.real_sender_id AS s,
+				m.create_time - LAG(m.create_time) OVER w AS d,
+				LAG(m.real_sender_id) OVER w AS ps
+			FROM %s m
+			WHERE %s AND (m.local_type & 4294967295) != %d
+			WINDOW w AS (ORDER BY m.sort_seq)
+		) t
+		LEFT JOIN Name2Id n ON t.s = n.rowid
+		WHERE t.ps IS NOT NULL AND t.ps != t.s AND t.d >= 0 AND t.d <= %d
+		GROUP BY t.s
+	`, tableName, where, model.MessageTypeSystem, replyLatencyWindow), start, end)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var name string
+		var sum, count int64
+		if err := rows.Scan(&name, &sum, &count); err != nil {
+			rows.Close()
+			return err
+		}
+		a.replies[name] += sum
+		a.sender(name).ReplyCount += count
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// 链接域名需要解析消息内容，仅加载分享类消息
+	rows, err = db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT m.local_type, m.create_time, m.message_content
+		FROM %s m
+		WHERE %s AND (m.local_type & 4294967295) = %d
+	`, tableName, where, model.MessageTypeShare), start, end)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var msg model.MessageV4
+		if err := rows.Scan(&msg.LocalType, &msg.CreateTime, &msg.MessageContent); err != nil {
+			return err
+		}
+		m := msg.Wrap(talker)
+		if m.SubType != model.MessageSubTypeLink && m.SubType != model.MessageSubTypeLink2 {
+			continue
+		}
+		link, _ := m.Contents["url"].(string)
+		if u, err := url.Parse(link); err == nil && u.Host != "" {
+			a.domains[strings.ToLower(u.Hostname())]++
+		}
+	}
+	return rows.Err()
+}
+
+func (a *statsAccumulator) result() *model.ChatStats {
+	stats := &model.ChatStats{
+		Total:        a.total,
+		ByHourOfWeek: a.byHour,
+		Media:        a.media,
+		ByDay:        make([]*model.DateCount, 0, len(a.byDay)),
+		ByType:       make([]*model.TypeCount, 0, len(a.byType)),
+		LinkDomains:  make([]*model.DomainCount, 0, len(a.domains)),
+		Senders:      make([]*model.SenderStats, 0, len(a.senders)),
+	}
+	if a.total > 0 {
+		stats.FirstMessage = time.Unix(a.first, 0)
+		stats.LastMessage = time.Unix(a.last, 0)
+	}
+
+	for date, count := range a.byDay {
+		stats.ByDay = append(stats.ByDay, &model.DateCount{Date: date, Count: count})
+	}
+	sort.Slice(stats.ByDay, func(i, j int) bool { return stats.ByDay[i].Date < stats.ByDay[j].Date })
+
+	for key, count := range a.byType {
+		stats.ByType = append(stats.ByType, &model.TypeCount{Type: key[0], SubType: key[1], Count: count})
+	}
+	sort.Slice(stats.ByType, func(i, j int) bool {
+		if stats.ByType[i].Count != stats.ByType[j].Count {
+			return stats.ByType[i].Count > stats.ByType[j].Count
+		}
+		return stats.ByType[i].Type < stats.ByType[j].Type
+	})
+
+	for domain, count := range a.domains {
+		stats.LinkDomains = append(stats.LinkDomains, &model.DomainCount{Domain: domain, Count: count})
+	}
+	sort.Slice(stats.LinkDomains, func(i, j int) bool {
+		if stats.LinkDomains[i].Count != stats.LinkDomains[j].Count {
+			return stats.LinkDomains[i].Count > stats.LinkDomains[j].Count
+		}
+		return stats.LinkDomains[i].Domain < stats.LinkDomains[j].Domain
+	})
+
+	for name, s := range a.senders {
+		if s.ReplyCount > 0 {
+			s.AvgReplySeconds = float64(a.replies[name]) / float64(s.ReplyCount)
+		}
+		stats.Senders = append(stats.Senders, s)
+	}
+	sort.Slice(stats.Senders, func(i, j int) bool {
+		if stats.Senders[i].Count != stats.Senders[j].Count {
+			return stats.Senders[i].Count > stats.Senders[j].Count
+		}
+		return stats.Senders[i].Sender < stats.Senders[j].Sender
+	})
+	return stats
+}
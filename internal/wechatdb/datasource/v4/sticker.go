@@ -0,0 +1,135 @@
+package v4
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
+)
+
+// emoticon.db 中自定义表情（kNonStoreEmoticonTable）与商店表情（kStoreEmoticon*）的表结构随版本变化，
+// 列名有的带下划线后缀（md5_），因此遍历全部带 md5 列的表并按列名读取
+
+// GetStickers 获取表情数据库中的自定义表情与商店表情，相同 md5 只保留一条
+func (ds *DataSource) GetStickers(ctx context.Context) ([]*model.Sticker, error) {
+	db, err := ds.dbm.GetDB(Emoticon)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT name FROM sqlite_master WHERE type = 'table'`
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, errors.QueryFailed(query, err)
+	}
+	tables := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, errors.ScanRowFailed(err)
+		}
+		tables = append(tables, name)
+	}
+	rows.Close()
+
+	stickers := make([]*model.Sticker, 0)
+	index := make(map[string]*model.Sticker)
+	for _, table := range tables {
+		list, err := ds.queryStickers(ctx, db, table)
+		if err != nil {
+			return nil, err
+		}
+		for _, sticker := range list {
+			if exist, ok := index[sticker.MD5]; ok {
+				mergeSticker(exist, sticker)
+				continue
+			}
+			index[sticker.MD5] = sticker
+			stickers = append(stickers, sticker)
+		}
+	}
+	return stickers, nil
+}
+
+// queryStickers 读取单张表中的表情，没有 md5 列的表返回空
+func (ds *DataSource) queryStickers(ctx context.Context, db *sql.DB, table string) ([]*model.Sticker, error) {
+	query := `SELECT * FROM "` + strings.ReplaceAll(table, `"`, `""`) + `"`
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, errors.QueryFailed(query, err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, errors.QueryFailed(query, err)
+	}
+	names := make([]string, len(columns))
+	hasMD5 := false
+	for i, column := range columns {
+		names[i] = strings.TrimSuffix(strings.ToLower(column), "_")
+		hasMD5 = hasMD5 || names[i] == "md5"
+	}
+	if !hasMD5 {
+		return nil, nil
+	}
+
+	store := strings.Contains(strings.ToLower(table), "store") && !strings.Contains(strings.ToLower(table), "nonstore")
+	stickers := make([]*model.Sticker, 0)
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, errors.ScanRowFailed(err)
+		}
+
+		sticker := &model.Sticker{Store: store}
+		for i, name := range names {
+			v := strings.TrimSpace(values[i].String)
+			switch name {
+			case "md5":
+				sticker.MD5 = strings.ToLower(v)
+			case "product_id", "package_id":
+				sticker.ProductID = v
+			case "caption", "desc":
+				sticker.Caption = v
+			case "cdn_url":
+				sticker.CdnURL = v
+			case "encrypt_url":
+				sticker.EncryptURL = v
+			case "thumb_url":
+				sticker.ThumbURL = v
+			case "aes_key":
+				sticker.AesKey = v
+			}
+		}
+		if len(sticker.MD5) != 32 {
+			continue
+		}
+		stickers = append(stickers, sticker)
+	}
+	return stickers, nil
+}
+
+// mergeSticker 用 src 中的非空字段补充 dst
+func mergeSticker(dst, src *model.Sticker) {
+	dst.Store = dst.Store || src.Store
+	for _, f := range []struct{ dst, src *string }{
+		{&dst.ProductID, &src.ProductID},
+		{&dst.Caption, &src.Caption},
+		{&dst.CdnURL, &src.CdnURL},
+		{&dst.EncryptURL, &src.EncryptURL},
+		{&dst.ThumbURL, &src.ThumbURL},
+		{&dst.AesKey, &src.AesKey},
+	} {
+		if *f.dst == "" {
+			*f.dst = *f.src
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package repository
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/model"
+)
+
+// mentionRegexp 匹配文本中的 @昵称，微信在昵称后使用 U+2005 作为分隔
+var mentionRegexp = regexp.MustCompile(`@([^\s\x{2005}@]+)`)
+
+// GetSelfUserName 获取当前账号的用户名，结果会被缓存
+func (r *Repository) GetSelfUserName(ctx context.Context) (string, error) {
+	r.selfMu.Lock()
+	defer r.selfMu.Unlock()
+	if r.self != "" {
+		return r.self, nil
+	}
+	self, err := r.ds.GetSelfUserName(ctx)
+	if err != nil {
+		return "", err
+	}
+	r.self = self
+	return self, nil
+}
+
+// SetSelfUserName 设置当前账号的用户名，设置后不再从消息中推断
+func (r *Repository) SetSelfUserName(self string) {
+	r.selfMu.Lock()
+	defer r.selfMu.Unlock()
+	r.self = self
+}
+
+// GetMentions 获取群聊中 @ 指定用户（含 @所有人）的消息
+// talker 为空时查询全部群聊，user 为空时为当前账号
+// unanswered 为 true 时仅返回该用户之后未在同一群聊发言的消息
+func (r *Repository) GetMentions(ctx context.Context, startTime, endTime time.Time, talker, user string, unanswered bool) ([]*model.Message, error) {
+	if talker == "" {
+		talker = strings.Join(r.chatRoomList, ",")
+	} else {
+		talker, _ = r.parseTalkerAndSender(ctx, talker, "")
+	}
+
+	if user == "" {
+		self, err := r.GetSelfUserName(ctx)
+		if err != nil {
+			return nil, err
+		}
+		user = self
+	} else if contact, _ := r.GetContact(ctx, user); contact != nil {
+		user = contact.UserName
+	}
+
+	messages, err := r.ds.GetMentionMessages(ctx, startTime, endTime, talker)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.EnrichMessages(ctx, messages); err != nil {
+		log.Debug().Msgf("EnrichMessages failed: %v", err)
+	}
+
+	// 按群聊记录尚未回复的 @，用户在群内发言后清空
+	pending := make(map[string][]*model.Message)
+	ret := make([]*model.Message, 0)
+	for _, msg := range messages {
+		if !msg.IsChatRoom {
+			continue
+		}
+		if msg.Sender == user {
+			if unanswered {
+				delete(pending, msg.Talker)
+			}
+			continue
+		}
+		if !msg.Mentioned(user) {
+			continue
+		}
+		if unanswered {
+			pending[msg.Talker] = append(pending[msg.Talker], msg)
+		} else {
+			ret = append(ret, msg)
+		}
+	}
+
+	if unanswered {
+		for _, msgs := range pending {
+			ret = append(ret, msgs...)
+		}
+		sort.Slice(ret, func(i, j int) bool { return ret[i].Seq < ret[j].Seq })
+	}
+	return ret, nil
+}
+
+// resolveTextMentions 消息来源中没有 atuserlist 时，根据群昵称与联系人名称识别文本中的 @
+func (r *Repository) resolveTextMentions(msg *model.Message, chatRoom *model.ChatRoom) {
+	if msg.Type != model.MessageTypeText || len(msg.Mentions) != 0 || msg.MentionAll || !strings.Contains(msg.Content, "@") {
+		return
+	}
+
+	for _, match := range mentionRegexp.FindAllStringSubmatch(msg.Content, -1) {
+		name := match[1]
+		if name == "所有人" {
+			msg.MentionAll = true
+			continue
+		}
+		if user := r.findChatRoomMember(chatRoom, name); user != "" {
+			msg.AddMention(user)
+		}
+	}
+}
+
+// findChatRoomMember 按群昵称或联系人名称查找群成员
+func (r *Repository) findChatRoomMember(chatRoom *model.ChatRoom, name string) string {
+	for user, displayName := range chatRoom.User2DisplayName {
+		if displayName == name {
+			return user
+		}
+	}
+	for _, u := range chatRoom.Users {
+		if contact := r.getFullContact(u.UserName); contact != nil && (contact.NickName == name || contact.DisplayName() == name) {
+			return u.UserName
+		}
+	}
+	return ""
+}
@@ -44,6 +44,39 @@ func (r *Repository) GetMessage(ctx context.Context, talker string, seq int64) (
 	return msg, nil
 }
 
+// GetMessageContext 获取指定消息及其前后的消息
+func (r *Repository) GetMessageContext(ctx context.Context, talker string, seq int64, before, after int) ([]*model.Message, error) {
+	if contact, _ := r.GetContact(ctx, talker); contact != nil {
+		talker = contact.UserName
+	} else if chatRoom, _ := r.GetChatRoom(ctx, talker); chatRoom != nil {
+		talker = chatRoom.Name
+	}
+
+	messages, err := r.ds.GetMessageContext(ctx, talker, seq, before, after)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.EnrichMessages(ctx, messages); err != nil {
+		log.Debug().Msgf("EnrichMessages failed: %v", err)
+	}
+	return messages, nil
+}
+
+// GetMessagesByType 获取指定类型的消息
+func (r *Repository) GetMessagesByType(ctx context.Context, startTime, endTime time.Time, talker string, types []int64) ([]*model.Message, error) {
+	talker, _ = r.parseTalkerAndSender(ctx, talker, "")
+	messages, err := r.ds.GetMessagesByType(ctx, startTime, endTime, talker, types)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.EnrichMessages(ctx, messages); err != nil {
+		log.Debug().Msgf("EnrichMessages failed: %v", err)
+	}
+	return messages, nil
+}
+
 // EnrichMessages 补充消息的额外信息
 func (r *Repository) EnrichMessages(ctx context.Context, messages []*model.Message) error {
 	for _, msg := range messages {
@@ -64,6 +97,8 @@ func (r *Repository) enrichMessage(msg *model.Message) {
 			if displayName, ok := chatRoom.User2DisplayName[msg.Sender]; ok {
 				msg.SenderName = displayName
 			}
+
+			r.resolveTextMentions(msg, chatRoom)
 		}
 	}
 
@@ -76,6 +111,11 @@ func (r *Repository) enrichMessage(msg *model.Message) {
 	}
 }
 
+// ResolveTalkerAndSender 将 talker 与 sender 中的昵称、备注或群昵称转换为 ID，与 GetMessages 的查询条件一致
+func (r *Repository) ResolveTalkerAndSender(ctx context.Context, talker, sender string) (string, string) {
+	return r.parseTalkerAndSender(ctx, talker, sender)
+}
+
 func (r *Repository) parseTalkerAndSender(ctx context.Context, talker, sender string) (string, string) {
 	displayName2User := make(map[string]string)
 	users := make(map[string]bool)
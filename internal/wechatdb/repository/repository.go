@@ -2,6 +2,7 @@ package repository
 
 import (
 	"context"
+	"sync"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/rs/zerolog/log"
@@ -36,6 +37,10 @@ type Repository struct {
 
 	// 快速查找索引
 	chatRoomUserToInfo map[string]*model.Contact
+
+	// 当前账号用户名，首次使用时查询
+	self   string
+	selfMu sync.Mutex
 }
 
 // New 创建一个新的 Repository
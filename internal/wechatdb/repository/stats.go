@@ -0,0 +1,37 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/sjzar/chatlog/internal/model"
+)
+
+// GetChatStats 获取对话统计数据，并补充对话方与发送者名称
+func (r *Repository) GetChatStats(ctx context.Context, startTime, endTime time.Time, talker string) (*model.ChatStats, error) {
+	talker, _ = r.parseTalkerAndSender(ctx, talker, "")
+	stats, err := r.ds.GetChatStats(ctx, startTime, endTime, talker)
+	if err != nil {
+		return nil, err
+	}
+
+	chatRoom := r.chatRoomCache[talker]
+	if chatRoom != nil {
+		stats.TalkerName = chatRoom.DisplayName()
+	} else if contact := r.getFullContact(talker); contact != nil {
+		stats.TalkerName = contact.DisplayName()
+	}
+
+	for _, sender := range stats.Senders {
+		if chatRoom != nil {
+			if displayName, ok := chatRoom.User2DisplayName[sender.Sender]; ok && displayName != "" {
+				sender.SenderName = displayName
+				continue
+			}
+		}
+		if contact := r.getFullContact(sender.Sender); contact != nil {
+			sender.SenderName = contact.DisplayName()
+		}
+	}
+	return stats, nil
+}
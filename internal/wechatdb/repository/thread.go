@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/model"
+)
+
+const (
+	// ThreadWindow 查找回复的时间范围，从根消息的时间开始计算
+	ThreadWindow = 30 * 24 * time.Hour
+
+	// maxThreadDepth 向上追溯引用链的最大层数
+	maxThreadDepth = 100
+)
+
+// ResolveThreads 为引用消息补充被引用消息的 Seq
+// 优先在同一批消息中按 server_id 匹配，找不到时按被引用消息的时间到对应分片中查询
+func (r *Repository) ResolveThreads(ctx context.Context, messages []*model.Message) {
+	for _, m := range model.LinkThreads(messages) {
+		refer := m.Refer()
+		if refer.Time.Unix() <= 0 {
+			continue
+		}
+		parent, err := r.ds.GetMessageByServerID(ctx, m.Talker, refer.ServerID, refer.Time)
+		if err != nil {
+			continue
+		}
+		m.Thread.ReplyTo = parent.Seq
+	}
+}
+
+// GetThread 获取指定消息所在的完整回复链
+// 先沿引用关系向上找到根消息，再在 ThreadWindow 内查找引用了链上消息的回复，构建回复树
+func (r *Repository) GetThread(ctx context.Context, talker string, seq int64) (*model.ThreadNode, error) {
+	if contact, _ := r.GetContact(ctx, talker); contact != nil {
+		talker = contact.UserName
+	} else if chatRoom, _ := r.GetChatRoom(ctx, talker); chatRoom != nil {
+		talker = chatRoom.Name
+	}
+
+	root, err := r.ds.GetMessage(ctx, talker, seq)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[int64]bool{root.Seq: true}
+	for i := 0; i < maxThreadDepth; i++ {
+		refer := root.Refer()
+		if refer == nil || refer.ServerID == 0 {
+			break
+		}
+		parent, err := r.findReferred(ctx, talker, refer)
+		if err != nil || seen[parent.Seq] {
+			break
+		}
+		seen[parent.Seq] = true
+		root = parent
+	}
+
+	quotes, err := r.ds.GetMessagesByType(ctx, root.Time, root.Time.Add(ThreadWindow), talker, []int64{model.MessageTypeShare})
+	if err != nil {
+		return nil, err
+	}
+	replies := make(map[int64][]*model.Message)
+	for _, m := range quotes {
+		if refer := m.Refer(); refer != nil && refer.ServerID != 0 {
+			replies[refer.ServerID] = append(replies[refer.ServerID], m)
+		}
+	}
+
+	messages := make([]*model.Message, 0)
+	visited := make(map[int64]bool)
+	var build func(m *model.Message) *model.ThreadNode
+	build = func(m *model.Message) *model.ThreadNode {
+		visited[m.Seq] = true
+		messages = append(messages, m)
+		node := &model.ThreadNode{Message: m}
+		if m.ServerID == 0 {
+			return node
+		}
+		for _, reply := range replies[m.ServerID] {
+			if !visited[reply.Seq] {
+				node.Replies = append(node.Replies, build(reply))
+			}
+		}
+		return node
+	}
+	tree := build(root)
+
+	if err := r.EnrichMessages(ctx, messages); err != nil {
+		log.Debug().Msgf("EnrichMessages failed: %v", err)
+	}
+	model.LinkThreads(messages)
+	return tree, nil
+}
+
+// findReferred 查找被引用的消息，先按引用中记录的时间定位分片，找不到时查询全部分片
+func (r *Repository) findReferred(ctx context.Context, talker string, refer *model.Message) (*model.Message, error) {
+	if refer.Time.Unix() > 0 {
+		if msg, err := r.ds.GetMessageByServerID(ctx, talker, refer.ServerID, refer.Time); err == nil {
+			return msg, nil
+		}
+	}
+	return r.ds.GetMessageByServerID(ctx, talker, refer.ServerID, time.Time{})
+}
@@ -72,10 +72,49 @@ func (w *DB) GetMessages(start, end time.Time, talker string, sender string, key
 	return messages, nil
 }
 
+// ResolveTalkerAndSender 将 talker 与 sender 中的名称转换为 ID，多个以英文逗号分隔
+func (w *DB) ResolveTalkerAndSender(talker, sender string) (string, string) {
+	return w.repo.ResolveTalkerAndSender(context.Background(), talker, sender)
+}
+
 func (w *DB) GetMessage(talker string, seq int64) (*model.Message, error) {
 	return w.repo.GetMessage(context.Background(), talker, seq)
 }
 
+func (w *DB) GetMessageContext(talker string, seq int64, before, after int) ([]*model.Message, error) {
+	return w.repo.GetMessageContext(context.Background(), talker, seq, before, after)
+}
+
+func (w *DB) GetMessagesByType(start, end time.Time, talker string, types ...int64) ([]*model.Message, error) {
+	return w.repo.GetMessagesByType(context.Background(), start, end, talker, types)
+}
+
+func (w *DB) GetMentions(start, end time.Time, talker, user string, unanswered bool) ([]*model.Message, error) {
+	return w.repo.GetMentions(context.Background(), start, end, talker, user, unanswered)
+}
+
+// GetThread 获取指定消息所在的回复树
+func (w *DB) GetThread(talker string, seq int64) (*model.ThreadNode, error) {
+	return w.repo.GetThread(context.Background(), talker, seq)
+}
+
+// ResolveThreads 为引用消息补充被引用消息的 Seq
+func (w *DB) ResolveThreads(messages []*model.Message) {
+	w.repo.ResolveThreads(context.Background(), messages)
+}
+
+func (w *DB) GetSelfUserName() (string, error) {
+	return w.repo.GetSelfUserName(context.Background())
+}
+
+func (w *DB) SetSelfUserName(self string) {
+	w.repo.SetSelfUserName(self)
+}
+
+func (w *DB) GetChatStats(start, end time.Time, talker string) (*model.ChatStats, error) {
+	return w.repo.GetChatStats(context.Background(), start, end, talker)
+}
+
 type GetContactsResp struct {
 	Items []*model.Contact `json:"items"`
 }
@@ -140,6 +179,11 @@ func (w *DB) GetMedia(_type string, key string) (*model.Media, error) {
 	return w.repo.GetMedia(context.Background(), _type, key)
 }
 
+// GetStickers 获取表情数据库中的自定义表情与商店表情
+func (w *DB) GetStickers() ([]*model.Sticker, error) {
+	return w.ds.GetStickers(context.Background())
+}
+
 func (w *DB) SetCallback(group string, callback func(event fsnotify.Event) error) error {
 	return w.ds.SetCallback(group, callback)
 }
@@ -161,8 +205,13 @@ func (w *DB) ExecuteSQL(group, file, query string) ([]map[string]interface{}, er
 }
 
 // GetSNSTimeline 获取朋友圈时间线数据
-func (w *DB) GetSNSTimeline(username string, limit, offset int) ([]map[string]interface{}, error) {
-	return w.ds.GetSNSTimeline(context.Background(), username, limit, offset)
+func (w *DB) GetSNSTimeline(username string, start, end time.Time, limit, offset int) ([]*model.SNSPost, error) {
+	return w.ds.GetSNSTimeline(context.Background(), username, start, end, limit, offset)
+}
+
+// GetSNSPost 获取单条朋友圈
+func (w *DB) GetSNSPost(tid int64) (*model.SNSPost, error) {
+	return w.ds.GetSNSPost(context.Background(), tid)
 }
 
 // GetSNSCount 获取朋友圈数量统计
@@ -0,0 +1,38 @@
+package dat2img
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// WEBP is only used to recognize cached stickers, dat images are never WebP
+var WEBP = Format{Header: []byte("RIFF"), Ext: "webp"}
+
+// Emoji2Image decodes a cached sticker file.
+// Stickers are stored as plain GIF/PNG/JPG/WebP, as wxgf, or encrypted the same way as dat images.
+func Emoji2Image(data []byte) ([]byte, string, error) {
+	if len(data) < 12 {
+		return nil, "", fmt.Errorf("data length is too short: %d", len(data))
+	}
+
+	if bytes.HasPrefix(data, WEBP.Header) && bytes.Equal(data[8:12], []byte("WEBP")) {
+		return data, WEBP.Ext, nil
+	}
+	for _, format := range []Format{GIF, PNG, JPG} {
+		if bytes.HasPrefix(data, format.Header) {
+			return data, format.Ext, nil
+		}
+	}
+	if bytes.HasPrefix(data, WXGF.Header) {
+		return Wxam2pic(data)
+	}
+
+	out, ext, err := Dat2Image(data)
+	if err != nil {
+		return nil, "", err
+	}
+	if ext == WXGF.Ext {
+		return Wxam2pic(out)
+	}
+	return out, ext, nil
+}
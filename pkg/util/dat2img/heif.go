@@ -0,0 +1,110 @@
+package dat2img
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/Eyevinn/mp4ff/avc"
+	"github.com/Eyevinn/mp4ff/hevc"
+	"github.com/Eyevinn/mp4ff/mp4"
+)
+
+// Hevc2HEIC wraps the first frame of an Annex-B HEVC stream into a HEIC still image.
+// No decoding is involved: the parameter sets go into the hvcC property and the
+// slices are copied into mdat, so browsers and viewers with HEIF support can open it directly.
+func Hevc2HEIC(data []byte) ([]byte, error) {
+	vps, sps, pps := hevc.GetParameterSetsFromByteStream(data)
+	if len(vps) == 0 || len(sps) == 0 || len(pps) == 0 {
+		return nil, fmt.Errorf("hevc parameter sets not found")
+	}
+
+	spsInfo, err := hevc.ParseSPSNALUnit(sps[0])
+	if err != nil {
+		return nil, fmt.Errorf("parse sps failed: %w", err)
+	}
+	width, height := spsInfo.ImageSize()
+
+	hvcC, err := mp4.CreateHvcC(vps, sps, pps, true, true, true, true)
+	if err != nil {
+		return nil, fmt.Errorf("create hvcC failed: %w", err)
+	}
+	var hvcCBuf bytes.Buffer
+	if err := hvcC.Encode(&hvcCBuf); err != nil {
+		return nil, fmt.Errorf("encode hvcC failed: %w", err)
+	}
+
+	frame := data
+	if FixSliceHeaders {
+		if spsMap, ppsMap, err := createSPSPPSMaps(vps, sps, pps); err == nil {
+			if fixed, err := fixSliceHeadersInFrame(data, spsMap, ppsMap); err == nil {
+				frame = fixed
+			}
+		}
+	}
+	sample := avc.ConvertByteStreamToNaluSample(removeParameterSets(frame))
+	if len(sample) == 0 {
+		return nil, fmt.Errorf("hevc slice not found")
+	}
+
+	ftyp := heifBox("ftyp", []byte("heic"), u32(0), []byte("mif1"), []byte("heic"))
+
+	// iloc holds the absolute offset of the image data, which depends on the size of meta itself,
+	// so meta is built once to measure it and again with the final offset
+	meta := heifMeta(hvcCBuf.Bytes(), width, height, 0, uint32(len(sample)))
+	offset := uint32(len(ftyp) + len(meta) + 8)
+	meta = heifMeta(hvcCBuf.Bytes(), width, height, offset, uint32(len(sample)))
+
+	out := make([]byte, 0, int(offset)+len(sample))
+	out = append(out, ftyp...)
+	out = append(out, meta...)
+	out = append(out, heifBox("mdat", sample)...)
+	return out, nil
+}
+
+// heifMeta builds the meta box describing a single hvc1 image item with ID 1
+func heifMeta(hvcC []byte, width, height, offset, length uint32) []byte {
+	hdlr := heifFullBox("hdlr", 0, 0, u32(0), []byte("pict"), make([]byte, 12), []byte{0})
+	pitm := heifFullBox("pitm", 0, 0, u16(1))
+	infe := heifFullBox("infe", 2, 0, u16(1), u16(0), []byte("hvc1"), []byte{0})
+	iinf := heifFullBox("iinf", 0, 0, u16(1), infe)
+
+	// offset_size=4, length_size=4, base_offset_size=0, one item with one extent
+	iloc := heifFullBox("iloc", 0, 0, []byte{0x44, 0x00}, u16(1),
+		u16(1), u16(0), u16(1), u32(offset), u32(length))
+
+	ispe := heifFullBox("ispe", 0, 0, u32(width), u32(height))
+	ipco := heifBox("ipco", hvcC, ispe)
+	// property indexes are 1-based, the high bit marks hvcC as essential
+	ipma := heifFullBox("ipma", 0, 0, u32(1), u16(1), []byte{2, 0x81, 0x02})
+	iprp := heifBox("iprp", ipco, ipma)
+
+	return heifFullBox("meta", 0, 0, hdlr, pitm, iloc, iinf, iprp)
+}
+
+func heifBox(boxType string, payload ...[]byte) []byte {
+	size := 8
+	for _, p := range payload {
+		size += len(p)
+	}
+	out := make([]byte, 8, size)
+	binary.BigEndian.PutUint32(out, uint32(size))
+	copy(out[4:], boxType)
+	for _, p := range payload {
+		out = append(out, p...)
+	}
+	return out
+}
+
+func heifFullBox(boxType string, version byte, flags uint32, payload ...[]byte) []byte {
+	header := []byte{version, byte(flags >> 16), byte(flags >> 8), byte(flags)}
+	return heifBox(boxType, append([][]byte{header}, payload...)...)
+}
+
+func u16(v uint16) []byte {
+	return binary.BigEndian.AppendUint16(nil, v)
+}
+
+func u32(v uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, v)
+}
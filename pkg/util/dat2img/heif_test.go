@@ -0,0 +1,95 @@
+package dat2img
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+)
+
+// blackFrameHEVC is a single 160x120 black frame (VPS, SPS, PPS and slices) in Annex-B format
+const blackFrameHEVC = "0000000140010c01ffff01600000030090000003000003001e9598090000000142010101600000030090000003000003001ea014207965959a4932bc05a02000000300200000030321000000014401c172b462400000012801af1d44c8f702357fff7639fb1c007f6304ab280000030019a00001041a90000000010201d0294be10c638950f98290a2e94d000000010001e024f55fa2c294988e8c00071c"
+
+func TestHevc2HEIC(t *testing.T) {
+	frame, _ := hex.DecodeString(blackFrameHEVC)
+
+	tests := []struct {
+		name    string
+		input   []byte
+		wantErr bool
+	}{
+		{
+			name:  "single frame",
+			input: frame,
+		},
+		{
+			name:    "empty",
+			input:   nil,
+			wantErr: true,
+		},
+		{
+			name:    "no parameter sets",
+			input:   []byte{0, 0, 0, 1, 0x26, 0x01, 0xaf, 0x1d},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Hevc2HEIC(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Hevc2HEIC() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			// top level boxes are ftyp, meta and mdat, covering the whole output
+			boxes := make(map[string][]byte)
+			order := make([]string, 0, 3)
+			for pos := 0; pos < len(got); {
+				if pos+8 > len(got) {
+					t.Fatalf("truncated box header at %d", pos)
+				}
+				size := int(binary.BigEndian.Uint32(got[pos:]))
+				if size < 8 || pos+size > len(got) {
+					t.Fatalf("invalid box size %d at %d", size, pos)
+				}
+				typ := string(got[pos+4 : pos+8])
+				boxes[typ] = got[pos+8 : pos+size]
+				order = append(order, typ)
+				pos += size
+			}
+			if want := []string{"ftyp", "meta", "mdat"}; len(order) != len(want) || order[0] != want[0] || order[1] != want[1] || order[2] != want[2] {
+				t.Fatalf("boxes = %v, want %v", order, want)
+			}
+			if !bytes.HasPrefix(boxes["ftyp"], []byte("heic")) {
+				t.Errorf("ftyp major brand = %q, want heic", boxes["ftyp"][:4])
+			}
+			if !bytes.Contains(boxes["meta"], []byte("hvcC")) {
+				t.Error("meta has no hvcC property")
+			}
+
+			// ispe records the frame size parsed from the SPS
+			i := bytes.Index(boxes["meta"], []byte("ispe"))
+			if i < 0 {
+				t.Fatal("meta has no ispe property")
+			}
+			ispe := boxes["meta"][i+8:]
+			if w, h := binary.BigEndian.Uint32(ispe), binary.BigEndian.Uint32(ispe[4:]); w != 160 || h != 120 {
+				t.Errorf("ispe = %dx%d, want 160x120", w, h)
+			}
+
+			// iloc points at the mdat payload
+			i = bytes.Index(boxes["meta"], []byte("iloc"))
+			if i < 0 {
+				t.Fatal("meta has no iloc box")
+			}
+			iloc := boxes["meta"][i+4+4+2+2+2+2+2:]
+			offset, length := binary.BigEndian.Uint32(iloc), binary.BigEndian.Uint32(iloc[4:])
+			if int(offset+length) != len(got) || int(length) != len(boxes["mdat"]) {
+				t.Errorf("iloc extent = %d+%d, want mdat payload at %d+%d", offset, length, len(got)-len(boxes["mdat"]), len(boxes["mdat"]))
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package dat2img
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"os/exec"
+	"strconv"
+)
+
+// Thumbnail fit modes
+const (
+	// FitContain scales the image to fit inside the box, keeping the aspect ratio
+	FitContain = "contain"
+	// FitCover scales the image to fill the box and crops the overflow from the center
+	FitCover = "cover"
+	// FitFill stretches the image to exactly the box size
+	FitFill = "fill"
+)
+
+// Thumbnail output formats
+const (
+	ThumbJPEG = "jpg"
+	ThumbWebP = "webp"
+)
+
+// ThumbJPEGQuality is the JPEG quality of generated thumbnails
+var ThumbJPEGQuality = 80
+
+// ImageSize returns the dimensions of jpg/png/gif data without decoding the pixels
+func ImageSize(data []byte) (int, int, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, err
+	}
+	return cfg.Width, cfg.Height, nil
+}
+
+// Covers reports whether an image of width x height can produce a w x h thumbnail without upscaling,
+// w or h may be 0 to keep the aspect ratio
+func Covers(width, height, w, h int, fit string) bool {
+	if width <= 0 || height <= 0 {
+		return false
+	}
+	w, h = thumbBox(width, height, w, h)
+	switch fit {
+	case FitCover, FitFill:
+		return width >= w && height >= h
+	default:
+		return width >= w || height >= h
+	}
+}
+
+// Thumbnail decodes jpg/png/gif data and scales it into a w x h box, the result is never upscaled
+// except with FitFill. Transparent pixels are composed onto a white background.
+func Thumbnail(data []byte, w, h int, fit string) (image.Image, error) {
+	if w < 0 || h < 0 || (w == 0 && h == 0) {
+		return nil, fmt.Errorf("invalid thumbnail size: %dx%d", w, h)
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	b := src.Bounds()
+	if b.Dx() == 0 || b.Dy() == 0 {
+		return nil, fmt.Errorf("empty image")
+	}
+	w, h = thumbBox(b.Dx(), b.Dy(), w, h)
+
+	// crop is the source region, dw x dh the output size
+	crop := b
+	dw, dh := w, h
+	switch fit {
+	case FitFill:
+	case FitCover:
+		// largest centered region with the aspect ratio of the box
+		cw, ch := b.Dx(), b.Dx()*h/w
+		if ch > b.Dy() {
+			cw, ch = b.Dy()*w/h, b.Dy()
+		}
+		cw, ch = max(cw, 1), max(ch, 1)
+		x0 := b.Min.X + (b.Dx()-cw)/2
+		y0 := b.Min.Y + (b.Dy()-ch)/2
+		crop = image.Rect(x0, y0, x0+cw, y0+ch)
+		if dw > cw {
+			dw, dh = cw, ch
+		}
+	default:
+		dw, dh = b.Dx(), b.Dy()
+		if dw > w {
+			dw, dh = w, max(b.Dy()*w/b.Dx(), 1)
+		}
+		if dh > h {
+			dw, dh = max(b.Dx()*h/b.Dy(), 1), h
+		}
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, crop.Dx(), crop.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(rgba, rgba.Bounds(), src, crop.Min, draw.Over)
+	return resize(rgba, dw, dh), nil
+}
+
+// EncodeThumbnail encodes img as jpg or webp, webp requires ffmpeg with libwebp
+func EncodeThumbnail(img image.Image, format string) ([]byte, error) {
+	switch format {
+	case ThumbWebP:
+		return encodeWebP(img)
+	default:
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: ThumbJPEGQuality}); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+}
+
+// thumbBox fills in the missing side of the box from the aspect ratio of the image
+func thumbBox(width, height, w, h int) (int, int) {
+	if w == 0 {
+		w = max(width*h/height, 1)
+	}
+	if h == 0 {
+		h = max(height*w/width, 1)
+	}
+	return w, h
+}
+
+// resize scales src with an area-averaging box filter, applied horizontally then vertically
+func resize(src *image.RGBA, w, h int) *image.RGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	if sw == w && sh == h {
+		return src
+	}
+
+	tmp := image.NewRGBA(image.Rect(0, 0, w, sh))
+	for x := 0; x < w; x++ {
+		x0, x1 := span(x, w, sw)
+		for y := 0; y < sh; y++ {
+			var r, g, b, a int
+			for sx := x0; sx < x1; sx++ {
+				i := src.PixOffset(sx, y)
+				r += int(src.Pix[i])
+				g += int(src.Pix[i+1])
+				b += int(src.Pix[i+2])
+				a += int(src.Pix[i+3])
+			}
+			n := x1 - x0
+			j := tmp.PixOffset(x, y)
+			tmp.Pix[j], tmp.Pix[j+1], tmp.Pix[j+2], tmp.Pix[j+3] = uint8(r/n), uint8(g/n), uint8(b/n), uint8(a/n)
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := span(y, h, sh)
+		for x := 0; x < w; x++ {
+			var r, g, b, a int
+			for sy := y0; sy < y1; sy++ {
+				i := tmp.PixOffset(x, sy)
+				r += int(tmp.Pix[i])
+				g += int(tmp.Pix[i+1])
+				b += int(tmp.Pix[i+2])
+				a += int(tmp.Pix[i+3])
+			}
+			n := y1 - y0
+			j := dst.PixOffset(x, y)
+			dst.Pix[j], dst.Pix[j+1], dst.Pix[j+2], dst.Pix[j+3] = uint8(r/n), uint8(g/n), uint8(b/n), uint8(a/n)
+		}
+	}
+	return dst
+}
+
+// span returns the source pixels [s0, s1) covered by output pixel i, at least one pixel when upscaling
+func span(i, out, in int) (int, int) {
+	s0 := i * in / out
+	s1 := (i + 1) * in / out
+	if s1 <= s0 {
+		s1 = s0 + 1
+	}
+	return s0, min(s1, in)
+}
+
+func encodeWebP(img image.Image) ([]byte, error) {
+	rgba, ok := img.(*image.RGBA)
+	if !ok {
+		rgba = image.NewRGBA(img.Bounds())
+		draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	}
+	b := rgba.Bounds()
+
+	cmd := exec.Command(FFMpegPath,
+		"-f", "rawvideo",
+		"-pix_fmt", "rgba",
+		"-s", strconv.Itoa(b.Dx())+"x"+strconv.Itoa(b.Dy()),
+		"-i", "-",
+		"-frames:v", "1",
+		"-c:v", "libwebp",
+		"-quality", strconv.Itoa(ThumbJPEGQuality),
+		"-f", "webp",
+		"-")
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = bytes.NewReader(rgba.Pix)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg failed: %w", err)
+	}
+	if stdout.Len() == 0 {
+		return nil, fmt.Errorf("ffmpeg output is empty")
+	}
+
+	return stdout.Bytes(), nil
+}
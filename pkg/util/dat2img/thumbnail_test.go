@@ -0,0 +1,115 @@
+package dat2img
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func testPNG(t *testing.T, w, h int, c color.Color) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestThumbnail(t *testing.T) {
+	red := color.NRGBA{R: 255, A: 255}
+	tests := []struct {
+		name      string
+		width     int
+		height    int
+		color     color.Color
+		w         int
+		h         int
+		fit       string
+		wantW     int
+		wantH     int
+		wantColor color.RGBA
+		wantErr   bool
+	}{
+		{
+			name:  "contain landscape",
+			width: 400, height: 200, color: red,
+			w: 100, h: 100, fit: FitContain,
+			wantW: 100, wantH: 50, wantColor: color.RGBA{R: 255, A: 255},
+		},
+		{
+			name:  "contain portrait",
+			width: 200, height: 400, color: red,
+			w: 100, h: 100, fit: FitContain,
+			wantW: 50, wantH: 100, wantColor: color.RGBA{R: 255, A: 255},
+		},
+		{
+			name:  "contain width only",
+			width: 400, height: 200, color: red,
+			w: 200, h: 0, fit: FitContain,
+			wantW: 200, wantH: 100, wantColor: color.RGBA{R: 255, A: 255},
+		},
+		{
+			name:  "contain never upscales",
+			width: 40, height: 20, color: red,
+			w: 100, h: 100, fit: FitContain,
+			wantW: 40, wantH: 20, wantColor: color.RGBA{R: 255, A: 255},
+		},
+		{
+			name:  "cover crops to box",
+			width: 400, height: 200, color: red,
+			w: 100, h: 100, fit: FitCover,
+			wantW: 100, wantH: 100, wantColor: color.RGBA{R: 255, A: 255},
+		},
+		{
+			name:  "fill stretches",
+			width: 40, height: 20, color: red,
+			w: 100, h: 100, fit: FitFill,
+			wantW: 100, wantH: 100, wantColor: color.RGBA{R: 255, A: 255},
+		},
+		{
+			name:  "transparent on white",
+			width: 40, height: 40, color: color.NRGBA{},
+			w: 20, h: 20, fit: FitContain,
+			wantW: 20, wantH: 20, wantColor: color.RGBA{R: 255, G: 255, B: 255, A: 255},
+		},
+		{
+			name:  "invalid size",
+			width: 40, height: 40, color: red,
+			w: 0, h: 0, fit: FitContain,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Thumbnail(testPNG(t, tt.width, tt.height, tt.color), tt.w, tt.h, tt.fit)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Thumbnail() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if b := got.Bounds(); b.Dx() != tt.wantW || b.Dy() != tt.wantH {
+				t.Errorf("Thumbnail() size = %dx%d, want %dx%d", b.Dx(), b.Dy(), tt.wantW, tt.wantH)
+			}
+			b := got.Bounds()
+			r, g, bl, a := got.At(b.Min.X+b.Dx()/2, b.Min.Y+b.Dy()/2).RGBA()
+			c := color.RGBA{R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(bl >> 8), A: uint8(a >> 8)}
+			if c != tt.wantColor {
+				t.Errorf("Thumbnail() center = %v, want %v", c, tt.wantColor)
+			}
+		})
+	}
+
+	if _, err := Thumbnail([]byte("not an image"), 10, 10, FitContain); err == nil {
+		t.Error("Thumbnail() of invalid data should fail")
+	}
+}
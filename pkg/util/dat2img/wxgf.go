@@ -12,12 +12,17 @@ import (
 	"github.com/Eyevinn/mp4ff/hevc"
 	"github.com/Eyevinn/mp4ff/mp4"
 	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
 )
 
 const (
 	ENV_FFMPEG_PATH = "FFMPEG_PATH"
 	MinRatio        = 0.6
 	FixSliceHeaders = true
+
+	// ExtHEIC and ExtMP4 are returned when wxgf is repackaged in-process instead of transcoded by ffmpeg
+	ExtHEIC = "heic"
+	ExtMP4  = "mp4"
 )
 
 var (
@@ -59,30 +64,42 @@ func Wxam2pic(data []byte) ([]byte, string, error) {
 			}
 		}
 		if FFmpegMode {
-			mp4Data, err := ConvertAnime2GIF(animeFrames, maskFrames)
-			if err != nil {
-				return nil, "", err
+			gifData, err := ConvertAnime2GIF(animeFrames, maskFrames)
+			if err == nil {
+				return gifData, GIF.Ext, nil
 			}
-			return mp4Data, "gif", nil
+			log.Debug().Err(err).Msg("convert anime to gif failed, fallback to mp4")
 		}
-		gifData, err := ConvertAnime2GIF(animeFrames, maskFrames)
+		// without ffmpeg the alpha mask cannot be merged, serve the color frames as mp4
+		mp4Data, err := TransmuxFrames2MP4(animeFrames)
 		if err != nil {
 			return nil, "", err
 		}
-		return gifData, "gif", nil
+		return mp4Data, ExtMP4, nil
 	}
 
 	offset := partitions.Partitions[partitions.MaxIndex].Offset
 	size := partitions.Partitions[partitions.MaxIndex].Size
+	frame := data[offset : offset+size]
 
 	if FFmpegMode {
-		jpgData, err := Convert2JPG(data[offset : offset+size])
+		jpgData, err := Convert2JPG(frame)
+		if err == nil {
+			return jpgData, JPG.Ext, nil
+		}
+		log.Debug().Err(err).Msg("convert wxgf to jpg failed, fallback to heic")
+	}
+	heicData, err := Hevc2HEIC(frame)
+	if err != nil {
+		// the frame can still be played as a single-sample mp4
+		log.Debug().Err(err).Msg("convert wxgf to heic failed, fallback to mp4")
+		mp4Data, err := Transmux2MP4(frame)
 		if err != nil {
 			return nil, "", err
 		}
-		return jpgData, JPG.Ext, nil
+		return mp4Data, ExtMP4, nil
 	}
-	return nil, "", fmt.Errorf("ffmpeg is not available, cannot convert this type of wxgf image")
+	return heicData, ExtHEIC, nil
 }
 
 type Partitions struct {
@@ -328,6 +345,33 @@ func TransmuxAnime2MP4(animeFrames [][]byte, maskFrames [][]byte) ([]byte, error
 	return sw.Bytes(), nil
 }
 
+// TransmuxFrames2MP4 packs Annex-B HEVC frames into a single-track fragmented mp4
+func TransmuxFrames2MP4(frames [][]byte) ([]byte, error) {
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("no anime frame found")
+	}
+
+	init := mp4.CreateEmptyInit()
+	seg := mp4.NewMediaSegment()
+	frag, err := mp4.CreateFragment(1, mp4.DefaultTrakID)
+	if err != nil {
+		return nil, err
+	}
+	seg.AddFragment(frag)
+
+	if err := Add2Trak(init, frag, 0, frames); err != nil {
+		return nil, fmt.Errorf("add full sample to track failed: %w", err)
+	}
+
+	totalSize := init.Size() + seg.Size()
+	sw := bits.NewFixedSliceWriter(int(totalSize))
+
+	init.EncodeSW(sw)
+	seg.EncodeSW(sw)
+
+	return sw.Bytes(), nil
+}
+
 func Add2Trak(init *mp4.InitSegment, frag *mp4.Fragment, index int, data [][]byte) error {
 	videoTimescale := uint32(90000)
 	init.AddEmptyTrack(videoTimescale, "video", "und")
@@ -0,0 +1,92 @@
+package util
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSafeFileName(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		maxLen int
+		want   string
+	}{
+		{
+			name:   "plain",
+			input:  "report.pdf",
+			maxLen: 0,
+			want:   "report.pdf",
+		},
+		{
+			name:   "path separators",
+			input:  "a/b\\c.txt",
+			maxLen: 0,
+			want:   "a_b_c.txt",
+		},
+		{
+			name:   "reserved characters",
+			input:  `a:b*c?"d"<e>|f`,
+			maxLen: 0,
+			want:   "a_b_c__d__e__f",
+		},
+		{
+			name:   "control characters",
+			input:  "a\x00b\tc\x7f",
+			maxLen: 0,
+			want:   "abc",
+		},
+		{
+			name:   "trim spaces and dots",
+			input:  "  ..hidden.  ",
+			maxLen: 0,
+			want:   "hidden",
+		},
+		{
+			name:   "dots only",
+			input:  "..",
+			maxLen: 0,
+			want:   "_",
+		},
+		{
+			name:   "empty",
+			input:  "",
+			maxLen: 10,
+			want:   "_",
+		},
+		{
+			name:   "truncate ascii",
+			input:  "abcdefghij",
+			maxLen: 4,
+			want:   "abcd",
+		},
+		{
+			name:   "truncate on rune boundary",
+			input:  "群聊名称",
+			maxLen: 7,
+			want:   "群聊",
+		},
+		{
+			name:   "chinese kept",
+			input:  "群聊/名称",
+			maxLen: 0,
+			want:   "群聊_名称",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SafeFileName(tt.input, tt.maxLen)
+			if got != tt.want {
+				t.Errorf("SafeFileName(%q, %d) = %q, want %q", tt.input, tt.maxLen, got, tt.want)
+			}
+			if tt.maxLen > 0 && len(got) > tt.maxLen {
+				t.Errorf("SafeFileName(%q, %d) length = %d", tt.input, tt.maxLen, len(got))
+			}
+			if !utf8.ValidString(got) || strings.ContainsAny(got, `/\:*?"<>|`) {
+				t.Errorf("SafeFileName(%q, %d) = %q is not a safe file name", tt.input, tt.maxLen, got)
+			}
+		})
+	}
+}
@@ -1,27 +1,92 @@
 package silk
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
+	"os/exec"
+	"slices"
+	"strconv"
 
 	"github.com/sjzar/go-lame"
 	"github.com/sjzar/go-silk"
+
+	"github.com/sjzar/chatlog/pkg/util/dat2img"
 )
 
-func Silk2MP3(data []byte) ([]byte, error) {
+const (
+	// SampleRate is the default sample rate of decoded WeChat voice
+	SampleRate = 24000
+)
+
+// Supported output formats
+const (
+	FormatMP3  = "mp3"
+	FormatWAV  = "wav"
+	FormatOGG  = "ogg"
+	FormatSILK = "silk"
+)
+
+// SampleRates are the output sample rates supported by the silk decoder
+var SampleRates = []int{8000, 12000, 16000, 24000, 32000, 44100, 48000}
+
+// Convert converts silk data to the given format, sampleRate only applies to wav
+func Convert(data []byte, format string, sampleRate int) ([]byte, error) {
+	switch format {
+	case FormatMP3:
+		return Silk2MP3(data)
+	case FormatWAV:
+		return Silk2WAV(data, sampleRate)
+	case FormatOGG:
+		return Silk2OGG(data)
+	case FormatSILK:
+		return data, nil
+	default:
+		return nil, fmt.Errorf("unsupported voice format: %s", format)
+	}
+}
 
+// ContentType returns the MIME type of the given format
+func ContentType(format string) string {
+	switch format {
+	case FormatMP3:
+		return "audio/mpeg"
+	case FormatWAV:
+		return "audio/wav"
+	case FormatOGG:
+		return "audio/ogg"
+	default:
+		return "audio/silk"
+	}
+}
+
+// Decode decodes silk data to 16-bit little-endian mono PCM, sampleRate 0 means SampleRate
+func Decode(data []byte, sampleRate int) ([]byte, error) {
 	sd := silk.SilkInit()
 	defer sd.Close()
 
+	if sampleRate > 0 && sampleRate != SampleRate {
+		sd.SetSampleRate(sampleRate)
+	}
+
 	pcmdata := sd.Decode(data)
 	if len(pcmdata) == 0 {
 		return nil, fmt.Errorf("silk decode failed")
 	}
+	return pcmdata, nil
+}
+
+func Silk2MP3(data []byte) ([]byte, error) {
+	pcmdata, err := Decode(data, SampleRate)
+	if err != nil {
+		return nil, err
+	}
 
 	le := lame.Init()
 	defer le.Close()
 
-	le.SetInSamplerate(24000)
-	le.SetOutSamplerate(24000)
+	le.SetInSamplerate(SampleRate)
+	le.SetOutSamplerate(SampleRate)
 	le.SetNumChannels(1)
 	le.SetBitrate(16)
 	// IMPORTANT!
@@ -34,3 +99,69 @@ func Silk2MP3(data []byte) ([]byte, error) {
 
 	return mp3data, nil
 }
+
+// Silk2WAV converts silk data to mono PCM wav, the silk decoder resamples to sampleRate itself
+func Silk2WAV(data []byte, sampleRate int) ([]byte, error) {
+	if sampleRate <= 0 {
+		sampleRate = SampleRate
+	}
+	if !slices.Contains(SampleRates, sampleRate) {
+		return nil, fmt.Errorf("unsupported sample rate: %d", sampleRate)
+	}
+
+	pcmdata, err := Decode(data, sampleRate)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(44 + len(pcmdata))
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(36+len(pcmdata)))
+	buf.WriteString("WAVEfmt ")
+	binary.Write(&buf, binary.LittleEndian, uint32(16))
+	binary.Write(&buf, binary.LittleEndian, uint16(1)) // PCM
+	binary.Write(&buf, binary.LittleEndian, uint16(1)) // mono
+	binary.Write(&buf, binary.LittleEndian, uint32(sampleRate))
+	binary.Write(&buf, binary.LittleEndian, uint32(sampleRate*2))
+	binary.Write(&buf, binary.LittleEndian, uint16(2))
+	binary.Write(&buf, binary.LittleEndian, uint16(16))
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, uint32(len(pcmdata)))
+	buf.Write(pcmdata)
+
+	return buf.Bytes(), nil
+}
+
+// Silk2OGG converts silk data to ogg/opus, there is no opus encoder in-process so ffmpeg is required
+// the ffmpeg binary is shared with dat2img and can be set by FFMPEG_PATH
+func Silk2OGG(data []byte) ([]byte, error) {
+	pcmdata, err := Decode(data, SampleRate)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(dat2img.FFMpegPath,
+		"-f", "s16le",
+		"-ar", strconv.Itoa(SampleRate),
+		"-ac", "1",
+		"-i", "-",
+		"-c:a", "libopus",
+		"-b:a", "24k",
+		"-f", "ogg",
+		"-")
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = bytes.NewReader(pcmdata)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg failed: %w", err)
+	}
+	if stdout.Len() == 0 {
+		return nil, fmt.Errorf("ffmpeg output is empty")
+	}
+
+	return stdout.Bytes(), nil
+}
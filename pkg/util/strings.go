@@ -69,3 +69,17 @@ func Str2List(str string, sep string) []string {
 
 	return list
 }
+
+// RemoveCJKSpaces 去掉两个非 ASCII 字符之间的空格，保留英文单词之间的空格
+// 语音识别与 OCR 引擎输出的中文通常以空格分隔每个字
+func RemoveCJKSpaces(s string) string {
+	runes := []rune(s)
+	var b strings.Builder
+	for i, r := range runes {
+		if r == ' ' && i > 0 && i < len(runes)-1 && runes[i-1] > unicode.MaxASCII && runes[i+1] > unicode.MaxASCII {
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,55 @@
+package util
+
+import "testing"
+
+func TestRemoveCJKSpaces(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "empty",
+			input: "",
+			want:  "",
+		},
+		{
+			name:  "chinese separated by spaces",
+			input: "你 好 世 界",
+			want:  "你好世界",
+		},
+		{
+			name:  "english words kept",
+			input: "hello world",
+			want:  "hello world",
+		},
+		{
+			name:  "mixed chinese and english",
+			input: "打 开 chat log 看 看",
+			want:  "打开 chat log 看看",
+		},
+		{
+			name:  "chinese punctuation",
+			input: "好 的 ， 明 天 见 。",
+			want:  "好的，明天见。",
+		},
+		{
+			name:  "leading and trailing spaces",
+			input: " 你 好 ",
+			want:  " 你好 ",
+		},
+		{
+			name:  "double spaces kept",
+			input: "你  好",
+			want:  "你  好",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RemoveCJKSpaces(tt.input); got != tt.want {
+				t.Errorf("RemoveCJKSpaces(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package video
+
+import (
+	"errors"
+	"os"
+
+	"github.com/Eyevinn/mp4ff/mp4"
+)
+
+// Info holds the basic metadata of an mp4 file.
+// Duration is in seconds, Width and Height are the display size of the first video track.
+type Info struct {
+	Duration   float64 `json:"duration"`
+	Width      int     `json:"width,omitempty"`
+	Height     int     `json:"height,omitempty"`
+	VideoCodec string  `json:"videoCodec,omitempty"`
+	AudioCodec string  `json:"audioCodec,omitempty"`
+	Size       int64   `json:"size"`
+}
+
+// Probe reads the moov box of an mp4 file without loading the media data into memory.
+func Probe(path string) (*Info, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	mf, err := mp4.DecodeFile(f, mp4.WithDecodeMode(mp4.DecModeLazyMdat))
+	if err != nil {
+		return nil, err
+	}
+	if mf.Moov == nil {
+		return nil, errors.New("moov box not found")
+	}
+
+	info := &Info{Size: stat.Size()}
+	if mvhd := mf.Moov.Mvhd; mvhd != nil && mvhd.Timescale > 0 {
+		info.Duration = float64(mvhd.Duration) / float64(mvhd.Timescale)
+	}
+
+	for _, trak := range mf.Moov.Traks {
+		if trak.Mdia == nil || trak.Mdia.Hdlr == nil {
+			continue
+		}
+		codec := sampleEntry(trak)
+		switch trak.Mdia.Hdlr.HandlerType {
+		case "vide":
+			if info.VideoCodec != "" {
+				continue
+			}
+			info.VideoCodec = codec
+			if trak.Tkhd != nil {
+				info.Width = int(trak.Tkhd.Width >> 16)
+				info.Height = int(trak.Tkhd.Height >> 16)
+			}
+		case "soun":
+			if info.AudioCodec == "" {
+				info.AudioCodec = codec
+			}
+		default:
+			continue
+		}
+		// Fall back to the track duration when mvhd has none
+		if info.Duration == 0 && trak.Mdia.Mdhd != nil && trak.Mdia.Mdhd.Timescale > 0 {
+			info.Duration = float64(trak.Mdia.Mdhd.Duration) / float64(trak.Mdia.Mdhd.Timescale)
+		}
+	}
+	return info, nil
+}
+
+// sampleEntry returns the four-character code of the first sample entry, such as avc1, hvc1 or mp4a.
+func sampleEntry(trak *mp4.TrakBox) string {
+	if trak.Mdia.Minf == nil || trak.Mdia.Minf.Stbl == nil || trak.Mdia.Minf.Stbl.Stsd == nil {
+		return ""
+	}
+	if children := trak.Mdia.Minf.Stbl.Stsd.Children; len(children) > 0 {
+		return children[0].Type()
+	}
+	return ""
+}